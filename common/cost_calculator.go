@@ -134,6 +134,15 @@ var MODEL_PRICING = map[string]ModelPricing{
 	},
 }
 
+// BatchPricingRate 批处理（Message Batches API）价格为标准价格的50%
+const BatchPricingRate = 0.5
+
+// PriceAdjustment 计费价格调整：Pricing不为nil时替换官方单价，再整体乘以Multiplier
+type PriceAdjustment struct {
+	Pricing    *ModelPricing
+	Multiplier float64
+}
+
 // CostCalculator 费用计算器
 type CostCalculator struct{}
 
@@ -155,6 +164,11 @@ func (c *CostCalculator) CalculateCost(usage *TokenUsage) *CostCalculationResult
 		pricing = MODEL_PRICING["unknown"]
 	}
 
+	return c.calculateCostWithPricing(usage, model, pricing)
+}
+
+// calculateCostWithPricing 按指定单价计算费用
+func (c *CostCalculator) calculateCostWithPricing(usage *TokenUsage, model string, pricing ModelPricing) *CostCalculationResult {
 	// 计算各类型token的费用 (USD)
 	inputCost := (float64(usage.InputTokens) / 1000000) * pricing.Input
 	outputCost := (float64(usage.OutputTokens) / 1000000) * pricing.Output
@@ -190,6 +204,54 @@ func (c *CostCalculator) CalculateCost(usage *TokenUsage) *CostCalculationResult
 	}
 }
 
+// CalculateBatchCost 按批处理价格计算单条批处理结果的费用
+func (c *CostCalculator) CalculateBatchCost(usage *TokenUsage) *CostCalculationResult {
+	return c.scaleCost(c.CalculateCost(usage), BatchPricingRate)
+}
+
+// CalculateBilledCost 在官方价格的基础上按价格调整计算计费费用，adjustment为nil时按官方价格计费
+func (c *CostCalculator) CalculateBilledCost(usage *TokenUsage, adjustment *PriceAdjustment) *CostCalculationResult {
+	if adjustment == nil {
+		return c.CalculateCost(usage)
+	}
+
+	result := c.CalculateCost(usage)
+	if adjustment.Pricing != nil {
+		result = c.calculateCostWithPricing(usage, result.Model, *adjustment.Pricing)
+	}
+	return c.scaleCost(result, adjustment.Multiplier)
+}
+
+// CalculateBilledBatchCost 按批处理价格和价格调整计算单条批处理结果的计费费用
+func (c *CostCalculator) CalculateBilledBatchCost(usage *TokenUsage, adjustment *PriceAdjustment) *CostCalculationResult {
+	return c.scaleCost(c.CalculateBilledCost(usage, adjustment), BatchPricingRate)
+}
+
+// scaleCost 按倍率调整费用计算结果（单价和各项费用同步调整）
+func (c *CostCalculator) scaleCost(result *CostCalculationResult, rate float64) *CostCalculationResult {
+	result.Pricing = ModelPricing{
+		Input:      result.Pricing.Input * rate,
+		Output:     result.Pricing.Output * rate,
+		CacheWrite: result.Pricing.CacheWrite * rate,
+		CacheRead:  result.Pricing.CacheRead * rate,
+	}
+	result.Costs = CostDetails{
+		Input:      result.Costs.Input * rate,
+		Output:     result.Costs.Output * rate,
+		CacheWrite: result.Costs.CacheWrite * rate,
+		CacheRead:  result.Costs.CacheRead * rate,
+		Total:      result.Costs.Total * rate,
+	}
+	result.Formatted = FormattedCosts{
+		Input:      c.FormatCost(result.Costs.Input),
+		Output:     c.FormatCost(result.Costs.Output),
+		CacheWrite: c.FormatCost(result.Costs.CacheWrite),
+		CacheRead:  c.FormatCost(result.Costs.CacheRead),
+		Total:      c.FormatCost(result.Costs.Total),
+	}
+	return result
+}
+
 // CalculateAggregatedCost 计算聚合使用量的费用
 func (c *CostCalculator) CalculateAggregatedCost(inputTokens, outputTokens, cacheCreateTokens, cacheReadTokens int, model string) *CostCalculationResult {
 	usage := &TokenUsage{
@@ -279,6 +341,18 @@ func CalculateCost(usage *TokenUsage) *CostCalculationResult {
 	return GlobalCostCalculator.CalculateCost(usage)
 }
 
+func CalculateBatchCost(usage *TokenUsage) *CostCalculationResult {
+	return GlobalCostCalculator.CalculateBatchCost(usage)
+}
+
+func CalculateBilledCost(usage *TokenUsage, adjustment *PriceAdjustment) *CostCalculationResult {
+	return GlobalCostCalculator.CalculateBilledCost(usage, adjustment)
+}
+
+func CalculateBilledBatchCost(usage *TokenUsage, adjustment *PriceAdjustment) *CostCalculationResult {
+	return GlobalCostCalculator.CalculateBilledBatchCost(usage, adjustment)
+}
+
 func CalculateAggregatedCost(inputTokens, outputTokens, cacheCreateTokens, cacheReadTokens int, model string) *CostCalculationResult {
 	return GlobalCostCalculator.CalculateAggregatedCost(inputTokens, outputTokens, cacheCreateTokens, cacheReadTokens, model)
 }
@@ -32,6 +32,7 @@ const (
 	Forbidden              = 40003
 	InsufficientPrivileges = 40004
 	NotFound               = 40005
+	InsufficientBalance    = 40201
 	TooManyRequests        = 42901
 	InternalServerError    = 50000
 	ServiceUnavailable     = 50003
@@ -41,6 +42,9 @@ const (
 	PlatformClaudeConsole = "claude_console"
 	PlatformOpenAI        = "openai"
 	PlatformGemini        = "gemini"
+	PlatformBedrock       = "bedrock"
+	PlatformVertex        = "vertex"
+	PlatformAzureOpenAI   = "azure_openai"
 
 	// 用户友好的错误消息
 	ErrServiceUnavailable = "服务暂时不可用，请稍后重试"
@@ -48,6 +52,8 @@ const (
 	ErrRateLimitExceeded  = "请求过于频繁，请稍后重试"
 	ErrInvalidRequest     = "请求格式错误"
 	ErrAuthenticationFail = "身份验证失败"
+	ErrConcurrencyLimit   = "所有账号并发已满，请稍后重试"
+	ErrBalanceExhausted   = "账户余额不足，请充值后重试"
 
 	ClaudeCodeSystemPrompt = "You are Claude Code, Anthropic's official CLI for Claude."
 )
@@ -78,6 +78,9 @@ func CreateAccount(c *gin.Context) {
 		constant.PlatformClaudeConsole: true,
 		constant.PlatformOpenAI:        true,
 		constant.PlatformGemini:        true,
+		constant.PlatformBedrock:       true,
+		constant.PlatformVertex:        true,
+		constant.PlatformAzureOpenAI:   true,
 	}
 	if !validPlatformTypes[req.PlatformType] {
 		c.JSON(http.StatusBadRequest, gin.H{
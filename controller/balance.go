@@ -0,0 +1,210 @@
+package controller
+
+import (
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type BalanceChangeRequest struct {
+	Amount float64 `json:"amount" binding:"required"`
+	Remark string  `json:"remark" binding:"max=255"`
+}
+
+type BalanceRefundRequest struct {
+	LogID  string  `json:"log_id"` // 关联的日志ID，金额为0时退还该日志的全部费用
+	Amount float64 `json:"amount" binding:"min=0"`
+	Remark string  `json:"remark" binding:"max=255"`
+}
+
+// GetMyBalance 获取当前用户的余额
+func GetMyBalance(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+
+	balanceService := service.NewBalanceService()
+	result, err := balanceService.GetBalance(user.ID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+			"code":  constant.InternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取成功",
+		"code":    constant.Success,
+		"data":    result,
+	})
+}
+
+// GetMyBalanceLedgers 获取当前用户的余额流水
+func GetMyBalanceLedgers(c *gin.Context) {
+	user := c.MustGet("user").(*model.User)
+	respondBalanceLedgers(c, user.ID)
+}
+
+// AdminGetUserBalance 管理员获取用户余额
+func AdminGetUserBalance(c *gin.Context) {
+	userID, ok := parseBalanceUserID(c)
+	if !ok {
+		return
+	}
+
+	balanceService := service.NewBalanceService()
+	result, err := balanceService.GetBalance(userID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+			"code":  constant.InternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取成功",
+		"code":    constant.Success,
+		"data":    result,
+	})
+}
+
+// AdminGetUserBalanceLedgers 管理员获取用户余额流水
+func AdminGetUserBalanceLedgers(c *gin.Context) {
+	userID, ok := parseBalanceUserID(c)
+	if !ok {
+		return
+	}
+	respondBalanceLedgers(c, userID)
+}
+
+// AdminTopUpBalance 管理员为用户充值
+func AdminTopUpBalance(c *gin.Context) {
+	userID, ok := parseBalanceUserID(c)
+	if !ok {
+		return
+	}
+
+	var req BalanceChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	operator := c.MustGet("user").(*model.User)
+	balance, err := service.NewBalanceService().TopUp(userID, req.Amount, operator.ID, req.Remark)
+	respondBalanceChange(c, balance, err, "充值成功")
+}
+
+// AdminAdjustBalance 管理员调整用户余额
+func AdminAdjustBalance(c *gin.Context) {
+	userID, ok := parseBalanceUserID(c)
+	if !ok {
+		return
+	}
+
+	var req BalanceChangeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	operator := c.MustGet("user").(*model.User)
+	balance, err := service.NewBalanceService().Adjust(userID, req.Amount, operator.ID, req.Remark)
+	respondBalanceChange(c, balance, err, "调整成功")
+}
+
+// AdminRefundBalance 管理员为用户退款
+func AdminRefundBalance(c *gin.Context) {
+	userID, ok := parseBalanceUserID(c)
+	if !ok {
+		return
+	}
+
+	var req BalanceRefundRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	operator := c.MustGet("user").(*model.User)
+	balance, err := service.NewBalanceService().Refund(userID, req.LogID, req.Amount, operator.ID, req.Remark)
+	respondBalanceChange(c, balance, err, "退款成功")
+}
+
+// parseBalanceUserID 解析路径中的用户ID
+func parseBalanceUserID(c *gin.Context) (uint, bool) {
+	userID, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "用户ID参数无效",
+			"code":  constant.InvalidParams,
+		})
+		return 0, false
+	}
+	return uint(userID), true
+}
+
+// respondBalanceLedgers 返回用户的余额流水，支持按类型筛选
+func respondBalanceLedgers(c *gin.Context, userID uint) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	balanceService := service.NewBalanceService()
+	result, err := balanceService.GetLedgers(userID, c.Query("type"), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+			"code":  constant.InternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取成功",
+		"code":    constant.Success,
+		"data":    result,
+	})
+}
+
+// respondBalanceChange 返回余额变动结果
+func respondBalanceChange(c *gin.Context, balance *model.UserBalance, err error, message string) {
+	if err != nil {
+		var statusCode int
+		var code int
+		switch err.Error() {
+		case "用户不存在", "日志不存在":
+			statusCode = http.StatusNotFound
+			code = constant.NotFound
+		case "变动余额失败":
+			statusCode = http.StatusInternalServerError
+			code = constant.InternalServerError
+		default:
+			statusCode = http.StatusBadRequest
+			code = constant.InvalidParams
+		}
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+			"code":  code,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"code":    constant.Success,
+		"data":    balance,
+	})
+}
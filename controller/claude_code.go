@@ -10,8 +10,10 @@ import (
 	"github.com/tidwall/gjson"
 	"io"
 	"net/http"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type ExchangeRequest struct {
@@ -111,7 +113,63 @@ func GetMessages(c *gin.Context) {
 		})
 	}
 
-	// 根据API Key的分组ID查询可用账号列表
+	filteredAccounts := selectAccountsForModel(c, keyInfo, modelName)
+	if filteredAccounts == nil {
+		return
+	}
+
+	// 使用公共fallback机制处理请求
+	result := relay.HandleWithFallback(c, filteredAccounts, body, handleRelayRequest)
+	
+	// 记录性能数据
+	if result.Account != nil {
+		relay.UpdateAccountPerformance(keyInfo.GroupID, result.Account.ID, result.Success, result.Duration)
+	}
+	
+	// 如果所有账号都失败，返回用户友好的错误信息（请求本身的错误原样返回）
+	if !result.Success {
+		relay.RespondFallbackError(c, result)
+	}
+}
+
+// CountTokens 计算请求的输入token数（不计费、不记录日志）
+// 复用GetMessages的账号选择，只请求单个账号，不走fallback机制
+func CountTokens(c *gin.Context) {
+	apiKey, _ := c.Get("api_key")
+	keyInfo := apiKey.(*model.ApiKey)
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"message": "请求参数异常",
+			"code":    constant.InternalServerError,
+		})
+		return
+	}
+
+	modelName := gjson.GetBytes(body, "model").String()
+	filteredAccounts := selectAccountsForModel(c, keyInfo, modelName)
+	if filteredAccounts == nil {
+		return
+	}
+
+	relay.HandleCountTokens(c, filteredAccounts, body)
+}
+
+// ModelInfo Anthropic格式的模型信息
+type ModelInfo struct {
+	Type        string `json:"type"`
+	ID          string `json:"id"`
+	DisplayName string `json:"display_name"`
+	CreatedAt   string `json:"created_at"`
+}
+
+// GetModels 获取当前API Key可用的模型列表（Anthropic list格式）
+// 综合API Key的模型限制与分组内可用账号的模型限制、模型映射和定价表
+func GetModels(c *gin.Context) {
+	apiKey, _ := c.Get("api_key")
+	keyInfo := apiKey.(*model.ApiKey)
+
 	accounts, err := model.GetAvailableAccountsByGroupID(keyInfo.GroupID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -121,6 +179,178 @@ func GetMessages(c *gin.Context) {
 		return
 	}
 
+	modelIDs := collectAvailableModels(accounts, keyInfo)
+
+	data := make([]ModelInfo, 0, len(modelIDs))
+	for _, modelID := range modelIDs {
+		data = append(data, ModelInfo{
+			Type:        "model",
+			ID:          modelID,
+			DisplayName: modelDisplayName(modelID),
+			CreatedAt:   modelCreatedAt(modelID).Format(time.RFC3339),
+		})
+	}
+
+	// 与Anthropic保持一致，按发布时间倒序
+	sort.SliceStable(data, func(i, j int) bool {
+		if data[i].CreatedAt != data[j].CreatedAt {
+			return data[i].CreatedAt > data[j].CreatedAt
+		}
+		return data[i].ID < data[j].ID
+	})
+
+	var firstID, lastID interface{}
+	if len(data) > 0 {
+		firstID = data[0].ID
+		lastID = data[len(data)-1].ID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":     data,
+		"has_more": false,
+		"first_id": firstID,
+		"last_id":  lastID,
+	})
+}
+
+// collectAvailableModels 计算API Key实际可访问的模型
+func collectAvailableModels(accounts []model.Account, keyInfo *model.ApiKey) []string {
+	// API Key有模型限制时，只保留至少有一个账号能服务的模型（与请求时的过滤规则一致）
+	if keyInfo.ModelRestriction != "" {
+		var result []string
+		seen := make(map[string]bool)
+		for _, modelName := range strings.Split(keyInfo.ModelRestriction, ",") {
+			modelName = strings.TrimSpace(modelName)
+			if modelName == "" || seen[strings.ToLower(modelName)] {
+				continue
+			}
+			seen[strings.ToLower(modelName)] = true
+			if len(filterAccountsByModelPermission(accounts, keyInfo, modelName)) > 0 {
+				result = append(result, modelName)
+			}
+		}
+		return result
+	}
+
+	knownModels := make([]string, 0, len(common.GetAllModelPricing()))
+	for modelName := range common.GetAllModelPricing() {
+		if modelName != "unknown" {
+			knownModels = append(knownModels, modelName)
+		}
+	}
+
+	var result []string
+	seen := make(map[string]bool)
+	addModel := func(modelName string) {
+		modelName = strings.TrimSpace(modelName)
+		if modelName != "" && !seen[strings.ToLower(modelName)] {
+			seen[strings.ToLower(modelName)] = true
+			result = append(result, modelName)
+		}
+	}
+
+	for _, account := range accounts {
+		if account.ModelRestriction != "" {
+			for _, modelName := range strings.Split(account.ModelRestriction, ",") {
+				addModel(modelName)
+			}
+			continue
+		}
+
+		// 未限制模型的账号可服务定价表中的所有模型
+		for _, modelName := range knownModels {
+			addModel(modelName)
+		}
+
+		// 模型映射的源名称如果是独立别名（不是已知模型的关键字），同样可用
+		for _, mapping := range strings.Split(account.ModelMapping, ",") {
+			parts := strings.SplitN(strings.TrimSpace(mapping), ":", 2)
+			if len(parts) != 2 {
+				continue
+			}
+			sourceModel := strings.TrimSpace(parts[0])
+			isKeyword := false
+			for _, knownModel := range knownModels {
+				if strings.Contains(knownModel, sourceModel) {
+					isKeyword = true
+					break
+				}
+			}
+			if !isKeyword {
+				addModel(sourceModel)
+			}
+		}
+	}
+
+	return result
+}
+
+// modelDisplayName 根据模型ID生成展示名称，如 claude-sonnet-4-20250514 -> Claude Sonnet 4
+func modelDisplayName(modelID string) string {
+	var words []string
+	var version []string
+	for _, part := range strings.Split(modelID, "-") {
+		if part == "" || (len(part) == 8 && isDigits(part)) {
+			continue // 日期后缀
+		}
+		if isDigits(part) {
+			version = append(version, part)
+			continue
+		}
+		if len(version) > 0 {
+			words = append(words, strings.Join(version, "."))
+			version = nil
+		}
+		words = append(words, strings.ToUpper(part[:1])+part[1:])
+	}
+	if len(version) > 0 {
+		words = append(words, strings.Join(version, "."))
+	}
+	if len(words) == 0 {
+		return modelID
+	}
+	return strings.Join(words, " ")
+}
+
+// modelCreatedAt 从模型ID的日期后缀解析发布时间，无日期时返回零点时间
+func modelCreatedAt(modelID string) time.Time {
+	parts := strings.Split(modelID, "-")
+	for i := len(parts) - 1; i >= 0; i-- {
+		if len(parts[i]) == 8 && isDigits(parts[i]) {
+			if t, err := time.Parse("20060102", parts[i]); err == nil {
+				return t
+			}
+		}
+	}
+	return time.Unix(0, 0).UTC()
+}
+
+// isDigits 判断字符串是否全为数字
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// selectAccountsForModel 查询API Key分组下可访问指定模型的账号列表
+// 无可用账号时直接写入错误响应并返回nil
+func selectAccountsForModel(c *gin.Context, keyInfo *model.ApiKey, modelName string) []model.Account {
+	// 根据API Key的分组ID查询可用账号列表
+	accounts, err := model.GetAvailableAccountsByGroupID(keyInfo.GroupID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"message": "查询账号列表失败",
+			"code":    constant.InternalServerError,
+		})
+		return nil
+	}
+
 	// 根据模型权限过滤账号
 	filteredAccounts := filterAccountsByModelPermission(accounts, keyInfo, modelName)
 
@@ -136,38 +366,34 @@ func GetMessages(c *gin.Context) {
 				"code":    constant.Forbidden,
 			})
 		}
-		return
+		return nil
 	}
 
-	// 使用公共fallback机制处理请求
-	result := relay.HandleWithFallback(c, filteredAccounts, body, handleRelayRequest)
-	
-	// 记录性能数据
-	if result.Account != nil {
-		relay.UpdateAccountPerformance(keyInfo.GroupID, result.Account.ID, result.Success, result.Duration)
-	}
-	
-	// 如果所有账号都失败，返回用户友好的通用错误信息
-	if !result.Success {
-		c.JSON(http.StatusServiceUnavailable, gin.H{
-			"error": gin.H{
-				"message": constant.ErrServiceUnavailable,
-				"type":    "service_unavailable",
-			},
-		})
-	}
+	return filteredAccounts
 }
 
 // handleRelayRequest 处理中继请求的包装函数
 // 根据账号平台类型调用相应的处理函数
 func handleRelayRequest(c *gin.Context, account *model.Account, requestBody []byte) {
+	// Anthropic原生接口会拒绝其他平台转换出的没有签名的thinking内容块
+	switch account.PlatformType {
+	case constant.PlatformClaude, constant.PlatformClaudeConsole, constant.PlatformBedrock, constant.PlatformVertex:
+		requestBody = relay.StripUnsignedThinkingBlocks(requestBody)
+	}
+
 	switch account.PlatformType {
 	case constant.PlatformClaude:
 		relay.HandleClaudeRequest(c, account, requestBody)
 	case constant.PlatformClaudeConsole:
 		relay.HandleClaudeConsoleRequest(c, account, requestBody)
-	case constant.PlatformOpenAI:
+	case constant.PlatformOpenAI, constant.PlatformAzureOpenAI:
 		relay.HandleOpenAIRequest(c, account, requestBody)
+	case constant.PlatformGemini:
+		relay.HandleGeminiRequest(c, account, requestBody)
+	case constant.PlatformBedrock:
+		relay.HandleBedrockRequest(c, account, requestBody)
+	case constant.PlatformVertex:
+		relay.HandleVertexRequest(c, account, requestBody)
 	default:
 		c.JSON(http.StatusBadRequest, gin.H{
 			"message": "不支持的平台类型: " + account.PlatformType,
@@ -253,8 +479,14 @@ func executeAccountTest(account *model.Account) TestAccountResponse {
 		statusCode, errorMsg = relay.TestsHandleClaudeRequest(account)
 	case constant.PlatformClaudeConsole:
 		statusCode, errorMsg = relay.TestHandleClaudeConsoleRequest(account)
-	case constant.PlatformOpenAI:
+	case constant.PlatformOpenAI, constant.PlatformAzureOpenAI:
 		statusCode, errorMsg = relay.TestHandleOpenAIRequest(account)
+	case constant.PlatformGemini:
+		statusCode, errorMsg = relay.TestHandleGeminiRequest(account)
+	case constant.PlatformBedrock:
+		statusCode, errorMsg = relay.TestHandleBedrockRequest(account)
+	case constant.PlatformVertex:
+		statusCode, errorMsg = relay.TestHandleVertexRequest(account)
 	default:
 		return TestAccountResponse{
 			Success:      false,
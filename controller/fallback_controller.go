@@ -89,6 +89,10 @@ func validateFallbackConfig(config *relay.FallbackConfig) error {
 		return fmt.Errorf("RetryDelay必须在0-5分钟之间")
 	}
 
+	if config.MaxRetryDelay < 0 || config.MaxRetryDelay > time.Minute*5 {
+		return fmt.Errorf("MaxRetryDelay必须在0-5分钟之间")
+	}
+
 	if config.CircuitBreakerThreshold < 0 || config.CircuitBreakerThreshold > 100 {
 		return fmt.Errorf("CircuitBreakerThreshold必须在0-100之间")
 	}
@@ -105,11 +109,37 @@ func validateFallbackConfig(config *relay.FallbackConfig) error {
 		return fmt.Errorf("HealthCheckInterval必须在1分钟-1小时之间")
 	}
 
+	if config.EnableHealthProbe && (config.HealthProbeConcurrency < 1 || config.HealthProbeConcurrency > 20) {
+		return fmt.Errorf("HealthProbeConcurrency必须在1-20之间")
+	}
+
+	if config.EnableStickySession && (config.StickySessionTTL < time.Minute || config.StickySessionTTL > time.Hour*24) {
+		return fmt.Errorf("StickySessionTTL必须在1分钟-24小时之间")
+	}
+
+	if config.StreamHoldMaxBytes < 0 || config.StreamHoldMaxBytes > 10*1024*1024 {
+		return fmt.Errorf("StreamHoldMaxBytes必须在0-10MB之间")
+	}
+
+	if config.StreamHoldTimeout < 0 || config.StreamHoldTimeout > time.Minute {
+		return fmt.Errorf("StreamHoldTimeout必须在0-1分钟之间")
+	}
+
+	if config.ConcurrencyQueueSize < 0 || config.ConcurrencyQueueSize > 10000 {
+		return fmt.Errorf("ConcurrencyQueueSize必须在0-10000之间")
+	}
+
+	if config.ConcurrencyQueueSize > 0 && (config.ConcurrencyQueueTimeout < time.Second || config.ConcurrencyQueueTimeout > time.Minute*10) {
+		return fmt.Errorf("ConcurrencyQueueTimeout必须在1秒-10分钟之间")
+	}
+
 	validStrategies := map[relay.FallbackStrategy]bool{
 		relay.StrategyPriorityFirst: true,
 		relay.StrategyWeighted:      true,
 		relay.StrategyRoundRobin:    true,
 		relay.StrategyLeastUsed:     true,
+		relay.StrategyLatencyAware:  true,
+		relay.StrategyCostAware:     true,
 	}
 
 	if !validStrategies[config.Strategy] {
@@ -231,6 +261,28 @@ func GetAccountHealth(c *gin.Context) {
 	})
 }
 
+// GetStickySessionStats 获取会话粘性命中统计
+func GetStickySessionStats(c *gin.Context) {
+	groupID := -1
+	if groupIDStr := c.Query("group_id"); groupIDStr != "" {
+		id, err := strconv.Atoi(groupIDStr)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"message": "group_id格式错误",
+				"code":    constant.InvalidParams,
+			})
+			return
+		}
+		groupID = id
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取会话粘性统计成功",
+		"code":    constant.Success,
+		"data":    relay.GetStickySessionStats(groupID),
+	})
+}
+
 // ResetMetrics 重置指标
 func ResetMetrics(c *gin.Context) {
 	relay.ResetMetrics()
@@ -0,0 +1,200 @@
+package controller
+
+import (
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"claude-code-relay/relay"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"gorm.io/gorm"
+)
+
+const (
+	defaultBatchListLimit = 20
+	maxBatchListLimit     = 100
+)
+
+// CreateMessageBatch 创建消息批处理（只使用支持Message Batches API的账号）
+func CreateMessageBatch(c *gin.Context) {
+	apiKey, _ := c.Get("api_key")
+	keyInfo := apiKey.(*model.ApiKey)
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		respondBatchError(c, http.StatusBadRequest, "invalid_request_error", "请求参数异常")
+		return
+	}
+
+	requests := gjson.GetBytes(body, "requests")
+	if !requests.IsArray() || len(requests.Array()) == 0 {
+		respondBatchError(c, http.StatusBadRequest, "invalid_request_error", "requests 不能为空")
+		return
+	}
+
+	// 每条请求的模型都需要有权限，并且只使用能服务所有模型的账号
+	models := batchRequestModels(requests)
+	filteredAccounts := selectAccountsForModel(c, keyInfo, models[0])
+	if filteredAccounts == nil {
+		return
+	}
+	filteredAccounts, deniedModel := filterAccountsByModels(filteredAccounts, keyInfo, models[1:])
+	if len(filteredAccounts) == 0 {
+		c.JSON(http.StatusForbidden, gin.H{
+			"message": "没有权限访问模型: " + deniedModel,
+			"code":    constant.Forbidden,
+		})
+		return
+	}
+
+	var batchAccounts []model.Account
+	for _, account := range filteredAccounts {
+		if relay.SupportsMessageBatches(&account) {
+			batchAccounts = append(batchAccounts, account)
+		}
+	}
+	if len(batchAccounts) == 0 {
+		respondBatchError(c, http.StatusBadRequest, "invalid_request_error", "没有支持批处理的可用账号")
+		return
+	}
+
+	result := relay.HandleWithFallback(c, batchAccounts, body, relay.HandleCreateMessageBatch)
+
+	// 记录性能数据
+	if result.Account != nil {
+		relay.UpdateAccountPerformance(keyInfo.GroupID, result.Account.ID, result.Success, result.Duration)
+	}
+
+	if !result.Success {
+		relay.RespondFallbackError(c, result)
+	}
+}
+
+// batchRequestModels 获取批处理中所有请求使用的模型（去重，保持出现顺序）
+func batchRequestModels(requests gjson.Result) []string {
+	var models []string
+	seen := make(map[string]bool)
+	for _, request := range requests.Array() {
+		modelName := request.Get("params.model").String()
+		if !seen[modelName] {
+			seen[modelName] = true
+			models = append(models, modelName)
+		}
+	}
+	return models
+}
+
+// filterAccountsByModels 依次按模型权限过滤账号，返回能服务所有模型的账号
+// 没有账号时同时返回导致过滤为空的模型
+func filterAccountsByModels(accounts []model.Account, keyInfo *model.ApiKey, models []string) ([]model.Account, string) {
+	for _, modelName := range models {
+		accounts = filterAccountsByModelPermission(accounts, keyInfo, modelName)
+		if len(accounts) == 0 {
+			return nil, modelName
+		}
+	}
+	return accounts, ""
+}
+
+// ListMessageBatches 获取当前API Key创建的批处理列表
+func ListMessageBatches(c *gin.Context) {
+	apiKey, _ := c.Get("api_key")
+	keyInfo := apiKey.(*model.ApiKey)
+
+	limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultBatchListLimit)))
+	if err != nil || limit < 1 || limit > maxBatchListLimit {
+		respondBatchError(c, http.StatusBadRequest, "invalid_request_error", "limit 取值范围为1-100")
+		return
+	}
+
+	batches, hasMore, err := model.GetMessageBatchesByApiKey(keyInfo.ID, limit, c.Query("after_id"), c.Query("before_id"))
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			respondBatchError(c, http.StatusNotFound, "not_found_error", "游标对应的批处理不存在")
+			return
+		}
+		respondBatchError(c, http.StatusInternalServerError, "api_error", "查询批处理列表失败")
+		return
+	}
+
+	data := make([]json.RawMessage, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, json.RawMessage(batch.Snapshot))
+	}
+
+	var firstID, lastID interface{}
+	if len(batches) > 0 {
+		firstID = batches[0].BatchID
+		lastID = batches[len(batches)-1].BatchID
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"data":     data,
+		"has_more": hasMore,
+		"first_id": firstID,
+		"last_id":  lastID,
+	})
+}
+
+// GetMessageBatch 查询批处理状态
+func GetMessageBatch(c *gin.Context) {
+	batch, account := loadMessageBatch(c)
+	if batch == nil {
+		return
+	}
+	relay.HandleMessageBatchRequest(c, account, batch, http.MethodGet, "")
+}
+
+// CancelMessageBatch 取消批处理
+func CancelMessageBatch(c *gin.Context) {
+	batch, account := loadMessageBatch(c)
+	if batch == nil {
+		return
+	}
+	relay.HandleMessageBatchRequest(c, account, batch, http.MethodPost, "/cancel")
+}
+
+// GetMessageBatchResults 获取批处理结果
+func GetMessageBatchResults(c *gin.Context) {
+	batch, account := loadMessageBatch(c)
+	if batch == nil {
+		return
+	}
+	relay.HandleMessageBatchResults(c, account, batch)
+}
+
+// loadMessageBatch 加载当前API Key的批处理记录及其绑定的账号，失败时写入错误响应并返回nil
+func loadMessageBatch(c *gin.Context) (*model.MessageBatch, *model.Account) {
+	apiKey, _ := c.Get("api_key")
+	keyInfo := apiKey.(*model.ApiKey)
+
+	batch, err := model.GetMessageBatchByBatchID(c.Param("batch_id"), keyInfo.ID)
+	if err != nil {
+		respondBatchError(c, http.StatusNotFound, "not_found_error", "批处理不存在")
+		return nil, nil
+	}
+
+	// 批处理ID只在创建它的账号下有效，不能切换到其他账号
+	account, err := model.GetAccountByID(batch.AccountID)
+	if err != nil {
+		respondBatchError(c, http.StatusServiceUnavailable, "api_error", "批处理绑定的账号不可用")
+		return nil, nil
+	}
+
+	return batch, account
+}
+
+// respondBatchError 返回批处理接口的错误响应
+func respondBatchError(c *gin.Context, status int, errorType, message string) {
+	c.JSON(status, gin.H{
+		"error": gin.H{
+			"type":    errorType,
+			"message": message,
+		},
+	})
+}
@@ -0,0 +1,39 @@
+package controller
+
+import (
+	"claude-code-relay/model"
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// TestFilterAccountsByBatchModels 批处理中每条请求的模型都要校验，只保留能服务所有模型的账号
+func TestFilterAccountsByBatchModels(t *testing.T) {
+	requests := gjson.Parse(`[
+		{"custom_id":"a","params":{"model":"claude-sonnet-4"}},
+		{"custom_id":"b","params":{"model":"claude-opus-4"}},
+		{"custom_id":"c","params":{"model":"claude-sonnet-4"}}
+	]`)
+	models := batchRequestModels(requests)
+	if len(models) != 2 || models[0] != "claude-sonnet-4" || models[1] != "claude-opus-4" {
+		t.Fatalf("应按出现顺序去重模型，实际: %v", models)
+	}
+
+	accounts := []model.Account{
+		{ID: 1, ModelRestriction: "claude-sonnet-4"},
+		{ID: 2, ModelRestriction: "claude-sonnet-4,claude-opus-4"},
+		{ID: 3},
+	}
+
+	filtered, denied := filterAccountsByModels(accounts, &model.ApiKey{}, models)
+	if denied != "" || len(filtered) != 2 || filtered[0].ID != 2 || filtered[1].ID != 3 {
+		t.Errorf("应只保留能服务所有模型的账号2和3，实际: %v, denied=%q", filtered, denied)
+	}
+
+	// API Key只允许其中一个模型时整个批处理被拒绝
+	keyInfo := &model.ApiKey{ModelRestriction: "claude-sonnet-4"}
+	filtered, denied = filterAccountsByModels(accounts, keyInfo, models)
+	if len(filtered) != 0 || denied != "claude-opus-4" {
+		t.Errorf("API Key不允许的模型应拒绝整个批处理，实际: %v, denied=%q", filtered, denied)
+	}
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"claude-code-relay/model"
+	"claude-code-relay/relay"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+)
+
+// ChatCompletions OpenAI Chat Completions 兼容接口
+// 将OpenAI格式请求转换为Claude格式后复用fallback机制，响应再转换回OpenAI格式
+func ChatCompletions(c *gin.Context) {
+	apiKey, _ := c.Get("api_key")
+	keyInfo := apiKey.(*model.ApiKey)
+
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": "请求参数异常",
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+
+	openaiReq, claudeBody, err := relay.ConvertOpenAIRequestToClaude(body)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": "请求参数异常: " + err.Error(),
+				"type":    "invalid_request_error",
+			},
+		})
+		return
+	}
+
+	filteredAccounts := selectAccountsForModel(c, keyInfo, openaiReq.Model)
+	if filteredAccounts == nil {
+		return
+	}
+
+	relayChatCompletions(c, keyInfo, filteredAccounts, openaiReq, claudeBody, handleRelayRequest)
+}
+
+// relayChatCompletions 通过fallback机制转发已转换为Claude格式的请求，并将响应转换回OpenAI格式
+// 格式转换放在fallback响应捕获器之外：捕获器看到的仍是Claude SSE事件，能识别首个内容前的错误并切换账号，
+// 失败尝试的输出也不会进入转换器
+func relayChatCompletions(c *gin.Context, keyInfo *model.ApiKey, accounts []model.Account, openaiReq *relay.OpenAIChatCompletionRequest, claudeBody []byte, handlerFunc relay.RequestHandlerFunc) {
+	includeUsage := openaiReq.StreamOptions != nil && openaiReq.StreamOptions.IncludeUsage
+	// 各平台处理函数仍输出Claude格式并负责计费和日志，这里只替换Writer做格式转换
+	writer := relay.NewOpenAICompatWriter(c.Writer, openaiReq.Model, includeUsage)
+	c.Writer = writer
+	defer func() {
+		writer.Finish()
+		c.Writer = writer.ResponseWriter
+	}()
+
+	result := relay.HandleWithFallback(c, accounts, claudeBody, handlerFunc)
+
+	// 记录性能数据
+	if result.Account != nil {
+		relay.UpdateAccountPerformance(keyInfo.GroupID, result.Account.ID, result.Success, result.Duration)
+	}
+
+	if !result.Success {
+		relay.RespondFallbackError(c, result)
+	}
+}
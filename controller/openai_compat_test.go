@@ -0,0 +1,113 @@
+package controller
+
+import (
+	"claude-code-relay/model"
+	"claude-code-relay/relay"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// TestRelayChatCompletionsStreamFallback OpenAI兼容接口的流在首个内容前中断时切换账号，收到首个内容后立即输出
+func TestRelayChatCompletionsStreamFallback(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	relay.InitFallbackManager(&relay.FallbackConfig{
+		MaxRetries:         3,
+		Strategy:           relay.StrategyPriorityFirst,
+		StreamHoldMaxBytes: 64 * 1024,
+		StreamHoldTimeout:  10 * time.Second,
+	})
+	defer relay.GlobalFallbackManager.Cleanup()
+
+	messageStart := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":10}}}\n\n"
+	overloaded := "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"
+	content := "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"hi\"}}\n\n"
+	messageDelta := "event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"end_turn\"},\"usage\":{\"output_tokens\":1}}\n\n"
+	messageStop := "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+
+	openaiReq, claudeBody, err := relay.ConvertOpenAIRequestToClaude([]byte(`{"model":"gpt-4o","stream":true,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("转换请求失败: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	keyInfo := &model.ApiKey{ID: 1, GroupID: 1}
+	c.Set("api_key", keyInfo)
+
+	accounts := []model.Account{{ID: 1, Name: "overloaded", Priority: 1}, {ID: 2, Name: "truncated", Priority: 2}, {ID: 3, Name: "ok", Priority: 3}}
+	streamedBeforeEnd := false
+	relayChatCompletions(c, keyInfo, accounts, openaiReq, claudeBody, func(c *gin.Context, account *model.Account, requestBody []byte) {
+		c.Status(http.StatusOK)
+		c.Header("Content-Type", "text/event-stream")
+		c.Writer.Flush()
+
+		events := []string{messageStart, content, messageDelta, messageStop}
+		switch account.ID {
+		case 1:
+			events = []string{messageStart, overloaded}
+		case 2:
+			events = []string{messageStart}
+		}
+		for _, event := range events {
+			if _, err := c.Writer.Write([]byte(event)); err != nil {
+				return
+			}
+			if event == content {
+				streamedBeforeEnd = strings.Contains(recorder.Body.String(), `"content":"hi"`)
+			}
+		}
+	})
+
+	body := recorder.Body.String()
+	if strings.Contains(body, "overloaded_error") {
+		t.Errorf("客户端不应收到失败账号的错误事件: %s", body)
+	}
+	if strings.Count(body, `"role":"assistant"`) != 1 || !strings.Contains(body, `"content":"hi"`) {
+		t.Errorf("客户端应只收到成功账号的完整流: %s", body)
+	}
+	if strings.Count(body, "data: [DONE]") != 1 || !strings.HasSuffix(strings.TrimSpace(body), "data: [DONE]") {
+		t.Errorf("应以唯一的[DONE]结束: %s", body)
+	}
+	if !streamedBeforeEnd {
+		t.Error("收到首个内容后应立即输出，而不是暂存到流结束")
+	}
+}
+
+// TestRelayChatCompletionsAllAccountsFail 所有账号失败时按OpenAI错误格式返回
+func TestRelayChatCompletionsAllAccountsFail(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	relay.InitFallbackManager(&relay.FallbackConfig{MaxRetries: 2, Strategy: relay.StrategyPriorityFirst})
+	defer relay.GlobalFallbackManager.Cleanup()
+
+	openaiReq, claudeBody, err := relay.ConvertOpenAIRequestToClaude([]byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("转换请求失败: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	keyInfo := &model.ApiKey{ID: 1, GroupID: 1}
+	c.Set("api_key", keyInfo)
+
+	accounts := []model.Account{{ID: 1, Name: "a1", Priority: 1}, {ID: 2, Name: "a2", Priority: 2}}
+	relayChatCompletions(c, keyInfo, accounts, openaiReq, claudeBody, func(c *gin.Context, account *model.Account, requestBody []byte) {
+		c.Data(http.StatusInternalServerError, "application/json", []byte(`{"type":"error","error":{"type":"api_error","message":"Internal server error"}}`))
+	})
+
+	body := recorder.Body.String()
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Errorf("状态码应为503, 实际: %d %s", recorder.Code, body)
+	}
+	if gjson.Get(body, "error.type").String() != "server_error" || gjson.Get(body, "error.code").String() != "service_unavailable" ||
+		gjson.Get(body, "error.message").String() == "" || gjson.Get(body, "type").Exists() {
+		t.Errorf("应返回OpenAI错误格式: %s", body)
+	}
+}
@@ -0,0 +1,153 @@
+package controller
+
+import (
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetPriceRules 管理员获取价格规则列表
+func GetPriceRules(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+	scopeID, _ := strconv.ParseUint(c.DefaultQuery("scope_id", "0"), 10, 32)
+
+	result, err := service.NewPriceRuleService().GetRules(c.Query("scope"), uint(scopeID), page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+			"code":  constant.InternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取成功",
+		"code":    constant.Success,
+		"data":    result,
+	})
+}
+
+// CreatePriceRule 管理员创建价格规则
+func CreatePriceRule(c *gin.Context) {
+	var req model.CreatePriceRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	rule, err := service.NewPriceRuleService().CreateRule(&req)
+	if err != nil {
+		var statusCode int
+		var code int
+		switch err.Error() {
+		case "用户不存在", "分组不存在":
+			statusCode = http.StatusNotFound
+			code = constant.NotFound
+		case "规则范围无效", "该范围和模型的价格规则已存在":
+			statusCode = http.StatusBadRequest
+			code = constant.InvalidParams
+		default:
+			statusCode = http.StatusInternalServerError
+			code = constant.InternalServerError
+		}
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+			"code":  code,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "价格规则创建成功",
+		"code":    constant.Success,
+		"data":    rule,
+	})
+}
+
+// UpdatePriceRule 管理员更新价格规则
+func UpdatePriceRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "价格规则ID参数无效",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	var req model.UpdatePriceRuleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	rule, err := service.NewPriceRuleService().UpdateRule(uint(id), &req)
+	if err != nil {
+		var statusCode int
+		var code int
+		switch err.Error() {
+		case "价格规则不存在":
+			statusCode = http.StatusNotFound
+			code = constant.NotFound
+		case "该范围和模型的价格规则已存在":
+			statusCode = http.StatusBadRequest
+			code = constant.InvalidParams
+		default:
+			statusCode = http.StatusInternalServerError
+			code = constant.InternalServerError
+		}
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+			"code":  code,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "价格规则更新成功",
+		"code":    constant.Success,
+		"data":    rule,
+	})
+}
+
+// DeletePriceRule 管理员删除价格规则
+func DeletePriceRule(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "价格规则ID参数无效",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	if err := service.NewPriceRuleService().DeleteRule(uint(id)); err != nil {
+		statusCode := http.StatusInternalServerError
+		code := constant.InternalServerError
+		if err.Error() == "价格规则不存在" {
+			statusCode = http.StatusNotFound
+			code = constant.NotFound
+		}
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+			"code":  code,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "价格规则删除成功",
+		"code":    constant.Success,
+	})
+}
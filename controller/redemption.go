@@ -0,0 +1,206 @@
+package controller
+
+import (
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type RedeemRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type UpdateRedemptionStatusRequest struct {
+	Status *int `json:"status" binding:"required"`
+}
+
+// Redeem 用户兑换兑换码
+func Redeem(c *gin.Context) {
+	var req RedeemRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	user := c.MustGet("user").(*model.User)
+	result, err := service.NewRedemptionService().Redeem(req.Code, user.ID)
+	if err != nil {
+		var statusCode int
+		var code int
+		switch err.Error() {
+		case model.ErrRedemptionNotFound.Error():
+			statusCode = http.StatusNotFound
+			code = constant.NotFound
+		case "兑换码不能为空", model.ErrRedemptionDisabled.Error(), model.ErrRedemptionExpired.Error(), model.ErrRedemptionExhausted.Error():
+			statusCode = http.StatusBadRequest
+			code = constant.InvalidParams
+		default:
+			statusCode = http.StatusInternalServerError
+			code = constant.InternalServerError
+		}
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+			"code":  code,
+		})
+		return
+	}
+
+	message := "兑换成功"
+	if result.Duplicate {
+		message = "已兑换过该兑换码"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"message": message,
+		"code":    constant.Success,
+		"data":    result,
+	})
+}
+
+// CreateRedemptionCodes 管理员批量生成兑换码
+func CreateRedemptionCodes(c *gin.Context) {
+	var req model.CreateRedemptionCodesRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	operator := c.MustGet("user").(*model.User)
+	batchNo, codes, err := service.NewRedemptionService().CreateCodes(&req, operator.ID)
+	if err != nil {
+		statusCode := http.StatusInternalServerError
+		code := constant.InternalServerError
+		if err.Error() == "过期时间不能早于当前时间" {
+			statusCode = http.StatusBadRequest
+			code = constant.InvalidParams
+		}
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+			"code":  code,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "生成成功",
+		"code":    constant.Success,
+		"data": gin.H{
+			"batch_no": batchNo,
+			"codes":    codes,
+		},
+	})
+}
+
+// GetRedemptionCodes 管理员获取兑换码列表
+func GetRedemptionCodes(c *gin.Context) {
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
+
+	var status *int
+	if statusStr := c.Query("status"); statusStr != "" {
+		if value, err := strconv.Atoi(statusStr); err == nil {
+			status = &value
+		}
+	}
+
+	result, err := service.NewRedemptionService().GetCodes(c.Query("batch_no"), status, page, limit)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": err.Error(),
+			"code":  constant.InternalServerError,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "获取成功",
+		"code":    constant.Success,
+		"data":    result,
+	})
+}
+
+// UpdateRedemptionCodeStatus 管理员启用或停用兑换码
+func UpdateRedemptionCodeStatus(c *gin.Context) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "兑换码ID参数无效",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	var req UpdateRedemptionStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "请求参数错误",
+			"code":  constant.InvalidParams,
+		})
+		return
+	}
+
+	if err := service.NewRedemptionService().UpdateStatus(uint(id), *req.Status); err != nil {
+		var statusCode int
+		var code int
+		switch err.Error() {
+		case model.ErrRedemptionNotFound.Error():
+			statusCode = http.StatusNotFound
+			code = constant.NotFound
+		case "状态参数无效":
+			statusCode = http.StatusBadRequest
+			code = constant.InvalidParams
+		default:
+			statusCode = http.StatusInternalServerError
+			code = constant.InternalServerError
+		}
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+			"code":  code,
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message": "兑换码状态更新成功",
+		"code":    constant.Success,
+	})
+}
+
+// ExportRedemptionCodes 管理员导出批次兑换码为CSV
+func ExportRedemptionCodes(c *gin.Context) {
+	batchNo := c.Query("batch_no")
+	data, err := service.NewRedemptionService().ExportCSV(batchNo)
+	if err != nil {
+		var statusCode int
+		var code int
+		switch err.Error() {
+		case "批次号不能为空":
+			statusCode = http.StatusBadRequest
+			code = constant.InvalidParams
+		case "批次不存在":
+			statusCode = http.StatusNotFound
+			code = constant.NotFound
+		default:
+			statusCode = http.StatusInternalServerError
+			code = constant.InternalServerError
+		}
+		c.JSON(statusCode, gin.H{
+			"error": err.Error(),
+			"code":  code,
+		})
+		return
+	}
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=redemption_codes_%s.csv", batchNo))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+}
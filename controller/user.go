@@ -581,6 +581,26 @@ func buildMenuByRole(role string) []MenuItem {
 				},
 			},
 		},
+		{
+			Path:      "/wallet",
+			Name:      "wallet",
+			Component: "LAYOUT",
+			Redirect:  "/wallet/index",
+			Meta: MenuMeta{
+				Title: "我的钱包",
+				Icon:  "wallet",
+			},
+			Children: []MenuItem{
+				{
+					Path:      "index",
+					Name:      "Wallet",
+					Component: "/wallet/index",
+					Meta: MenuMeta{
+						Title: "余额与兑换",
+					},
+				},
+			},
+		},
 	}
 
 	// 如果是管理员，添加管理员专属菜单
@@ -612,6 +632,22 @@ func buildMenuByRole(role string) []MenuItem {
 							Title: "系统日志",
 						},
 					},
+					{
+						Path:      "redemption",
+						Name:      "AdminRedemption",
+						Component: "/admin/redemption/index",
+						Meta: MenuMeta{
+							Title: "兑换码管理",
+						},
+					},
+					{
+						Path:      "pricing",
+						Name:      "AdminPricing",
+						Component: "/admin/pricing/index",
+						Meta: MenuMeta{
+							Title: "价格规则",
+						},
+					},
 					{
 						Path:      "fallback",
 						Name:      "AdminFallback",
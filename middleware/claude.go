@@ -1,15 +1,23 @@
 package middleware
 
 import (
+	"bytes"
 	"claude-code-relay/constant"
 	"claude-code-relay/model"
+	"claude-code-relay/relay"
+	"claude-code-relay/service"
 	"encoding/json"
+	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
 )
 
 // SystemMessage 系统消息结构体
@@ -58,6 +66,28 @@ func ClaudeCodeAuth() gin.HandlerFunc {
 			return
 		}
 
+		// 判断预付费余额是否已用完，未启用预付费的用户不限制
+		if balance, err := model.GetUserBalance(keyInfo.UserID); err == nil && balance.Balance <= 0 {
+			c.JSON(http.StatusPaymentRequired, gin.H{
+				"error": constant.ErrBalanceExhausted,
+				"code":  constant.InsufficientBalance,
+			})
+			c.Abort()
+			return
+		}
+
+		// 判断是否超出API Key和分组的滚动预算
+		if !checkBudgets(c, keyInfo) {
+			c.Abort()
+			return
+		}
+
+		// 判断是否达到每分钟请求数和tokens限制
+		if !checkApiKeyRateLimit(c, keyInfo) {
+			c.Abort()
+			return
+		}
+
 		// API Key已经在model层验证了状态和过期时间
 		// 将API Key信息存储到上下文中供后续使用
 		c.Set("api_key_id", keyInfo.ID)
@@ -66,7 +96,93 @@ func ClaudeCodeAuth() gin.HandlerFunc {
 		c.Set("group_id", keyInfo.GroupID)
 
 		c.Next()
+
+		// 请求没有成功结算时退还预扣的输入tokens，已结算时不会重复处理
+		service.SettleApiKeyRateLimit(keyInfo, nil)
+	}
+}
+
+// checkBudgets 检查API Key及其分组的滚动周预算和月预算，通过响应头返回当前消费和预算，
+// 如 X-Budget-Key-Weekly-Spent / X-Budget-Key-Weekly-Limit、X-Budget-Group-Monthly-Spent / X-Budget-Group-Monthly-Limit
+func checkBudgets(c *gin.Context, keyInfo *model.ApiKey) bool {
+	statuses := service.CheckBudgets(keyInfo)
+	for _, status := range statuses {
+		prefix := fmt.Sprintf("X-Budget-%s-%s", status.Scope, status.Window)
+		c.Header(prefix+"-Spent", strconv.FormatFloat(status.Spent, 'f', 4, 64))
+		c.Header(prefix+"-Limit", strconv.FormatFloat(status.Limit, 'f', 2, 64))
+	}
+
+	for _, status := range statuses {
+		if !status.Exceeded() {
+			continue
+		}
+		scopeName := "API Key"
+		if status.Scope == service.BudgetScopeGroup {
+			scopeName = "API Key所属分组"
+		}
+		windowName := "最近7天"
+		if status.Window == "monthly" {
+			windowName = "最近30天"
+		}
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": fmt.Sprintf("%s已超出%s预算($%.2f)", scopeName, windowName, status.Limit),
+			"code":  40004,
+		})
+		return false
+	}
+	return true
+}
+
+// checkApiKeyRateLimit 检查API Key的每分钟请求数和tokens限制，
+// 被限流时返回429并附带anthropic-ratelimit-*和retry-after响应头，便于客户端退避
+func checkApiKeyRateLimit(c *gin.Context, keyInfo *model.ApiKey) bool {
+	if keyInfo.RpmLimit <= 0 && keyInfo.ItpmLimit <= 0 && keyInfo.OtpmLimit <= 0 {
+		return true
+	}
+	// 只限制发往上游的请求，计算token数不计费也不计入限制
+	if c.Request.Method != http.MethodPost || strings.HasSuffix(c.Request.URL.Path, "/count_tokens") {
+		return true
+	}
+
+	estimatedInputTokens := 0
+	if keyInfo.ItpmLimit > 0 {
+		estimatedInputTokens = estimateRequestInputTokens(c)
+	}
+
+	result := service.CheckApiKeyRateLimit(keyInfo, estimatedInputTokens)
+	if result == nil || result.Allowed {
+		return true
+	}
+
+	for _, status := range result.Statuses {
+		prefix := "anthropic-ratelimit-" + status.Name
+		c.Header(prefix+"-limit", strconv.Itoa(status.Limit))
+		c.Header(prefix+"-remaining", strconv.Itoa(status.Remaining))
+		c.Header(prefix+"-reset", status.Reset.UTC().Format(time.RFC3339))
+	}
+	c.Header("retry-after", strconv.Itoa(int(math.Ceil(result.RetryAfter.Seconds()))))
+
+	c.JSON(http.StatusTooManyRequests, gin.H{
+		"error": gin.H{
+			"type":    "rate_limit_error",
+			"message": constant.ErrRateLimitExceeded,
+		},
+	})
+	return false
+}
+
+// estimateRequestInputTokens 估算消息请求的输入tokens，读取后恢复请求体供后续处理
+func estimateRequestInputTokens(c *gin.Context) int {
+	bodyBytes, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return 0
+	}
+	c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+
+	if !gjson.GetBytes(bodyBytes, "messages").Exists() {
+		return 0
 	}
+	return relay.EstimateInputTokens(bodyBytes)
 }
 
 // getApiKeyFromHeaders 从多个可能的请求头中提取API Key
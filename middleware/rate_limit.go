@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"claude-code-relay/common"
+	"claude-code-relay/model"
 	"context"
 	"fmt"
 	"net/http"
@@ -13,44 +14,63 @@ import (
 
 func RateLimit(maxRequests int, window time.Duration) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if common.RDB == nil {
-			c.Next()
-			return
-		}
-
 		// 使用IP作为限流键
-		key := fmt.Sprintf("rate_limit:%s", c.ClientIP())
-		ctx := context.Background()
-
-		// 获取当前计数
-		count, err := common.RDB.Get(ctx, key).Int()
-		if err != nil && err.Error() != "redis: nil" {
-			c.Next()
+		if !checkRateLimit(c, fmt.Sprintf("rate_limit:%s", c.ClientIP()), maxRequests, window) {
+			c.Abort()
 			return
 		}
 
-		if count >= maxRequests {
-			c.JSON(http.StatusTooManyRequests, gin.H{
-				"error": "请求过于频繁，请稍后再试",
-				"code":  42901,
-			})
+		c.Next()
+	}
+}
+
+// UserRateLimit 按登录用户限流，name区分不同接口的计数，需要在Auth之后使用
+func UserRateLimit(name string, maxRequests int, window time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user := c.MustGet("user").(*model.User)
+		if !checkRateLimit(c, fmt.Sprintf("rate_limit:%s:user:%d", name, user.ID), maxRequests, window) {
 			c.Abort()
 			return
 		}
 
-		// 增加计数
-		pipe := common.RDB.Pipeline()
-		pipe.Incr(ctx, key)
-		pipe.Expire(ctx, key, window)
-		_, err = pipe.Exec(ctx)
-		if err != nil {
-			common.SysError("Rate limit pipeline error: " + err.Error())
-		}
+		c.Next()
+	}
+}
+
+// checkRateLimit 检查并累加限流计数，超过限制时返回429并返回false，未配置Redis时不限制
+func checkRateLimit(c *gin.Context, key string, maxRequests int, window time.Duration) bool {
+	if common.RDB == nil {
+		return true
+	}
 
-		// 设置响应头
-		c.Header("X-RateLimit-Limit", strconv.Itoa(maxRequests))
-		c.Header("X-RateLimit-Remaining", strconv.Itoa(maxRequests-count-1))
+	ctx := context.Background()
 
-		c.Next()
+	// 获取当前计数
+	count, err := common.RDB.Get(ctx, key).Int()
+	if err != nil && err.Error() != "redis: nil" {
+		return true
 	}
+
+	if count >= maxRequests {
+		c.JSON(http.StatusTooManyRequests, gin.H{
+			"error": "请求过于频繁，请稍后再试",
+			"code":  42901,
+		})
+		return false
+	}
+
+	// 增加计数
+	pipe := common.RDB.Pipeline()
+	pipe.Incr(ctx, key)
+	pipe.Expire(ctx, key, window)
+	_, err = pipe.Exec(ctx)
+	if err != nil {
+		common.SysError("Rate limit pipeline error: " + err.Error())
+	}
+
+	// 设置响应头
+	c.Header("X-RateLimit-Limit", strconv.Itoa(maxRequests))
+	c.Header("X-RateLimit-Remaining", strconv.Itoa(maxRequests-count-1))
+
+	return true
 }
@@ -8,9 +8,13 @@ import (
 type Account struct {
 	ID                            uint           `json:"id" gorm:"primaryKey"`
 	Name                          string         `json:"name" gorm:"type:varchar(100);not null;comment:账号名称"`
-	PlatformType                  string         `json:"platform_type" gorm:"type:varchar(50);not null;comment:平台类型(claude/claude_console)"`
+	PlatformType                  string         `json:"platform_type" gorm:"type:varchar(50);not null;comment:平台类型(claude/claude_console/openai/gemini/bedrock/vertex)"`
 	RequestURL                    string         `json:"request_url" gorm:"type:varchar(500);comment:请求地址"`
 	SecretKey                     string         `json:"secret_key" gorm:"type:text;comment:请求秘钥"`
+	Region                        string         `json:"region" gorm:"type:varchar(50);comment:云厂商区域(bedrock/vertex)"`
+	AccessKeyID                   string         `json:"access_key_id" gorm:"type:varchar(255);comment:云厂商访问密钥ID(bedrock)"`
+	ApiVersion                    string         `json:"api_version" gorm:"type:varchar(50);comment:API版本(azure_openai)"`
+	UseResponsesAPI               bool           `json:"use_responses_api" gorm:"default:false;comment:是否使用Responses API(openai/azure_openai)"`
 	AccessToken                   string         `json:"access_token" gorm:"type:text;comment:claude的官方token"`
 	RefreshToken                  string         `json:"refresh_token" gorm:"type:text;comment:claude的官方刷新token"`
 	ExpiresAt                     int            `json:"expires_at" gorm:"default:0;comment:token过期时间戳"`
@@ -18,6 +22,8 @@ type Account struct {
 	GroupID                       int            `json:"group_id" gorm:"default:0;comment:分组ID"`
 	Priority                      int            `json:"priority" gorm:"default:100;comment:优先级(数字越小越高)"`
 	Weight                        int            `json:"weight" gorm:"default:100;comment:权重(数字越大越高)"`
+	CostMultiplier                float64        `json:"cost_multiplier" gorm:"default:1;comment:成本系数(实际单价相对官方标价的倍数,MAX账号按固定费用不计)"`
+	MaxConcurrency                int            `json:"max_concurrency" gorm:"default:0;comment:最大并发请求数(0表示不限制)"`
 	TodayUsageCount               int            `json:"today_usage_count" gorm:"default:0;comment:今日使用次数"`
 	TodayInputTokens              int            `json:"today_input_tokens" gorm:"default:0;comment:今日输入tokens"`
 	TodayOutputTokens             int            `json:"today_output_tokens" gorm:"default:0;comment:今日输出tokens"`
@@ -63,43 +69,55 @@ type AccountListResponse struct {
 
 // 账号创建请求参数
 type CreateAccountRequest struct {
-	Name             string `json:"name" binding:"required,min=1,max=100"`
-	PlatformType     string `json:"platform_type" binding:"required,oneof=claude claude_console gemini openai"`
-	RequestURL       string `json:"request_url"`
-	SecretKey        string `json:"secret_key"`
-	GroupID          int    `json:"group_id"`
-	Priority         int    `json:"priority"`
-	Weight           int    `json:"weight" binding:"min=1"`
-	EnableProxy      bool   `json:"enable_proxy"`
-	ProxyURI         string `json:"proxy_uri"`
-	ModelMapping     string `json:"model_mapping"`
-	ModelRestriction string `json:"model_restriction"`
-	ActiveStatus     int    `json:"active_status" binding:"oneof=1 2"`
-	IsMax            bool   `json:"is_max"` // 是否是max账号
-	AccessToken      string `json:"access_token"`
-	RefreshToken     string `json:"refresh_token"`
-	ExpiresAt        int    `json:"expires_at" binding:"min=0"`
-	TodayUsageCount  int    `json:"today_usage_count"` // 今日使用次数
+	Name             string   `json:"name" binding:"required,min=1,max=100"`
+	PlatformType     string   `json:"platform_type" binding:"required,oneof=claude claude_console gemini openai bedrock vertex azure_openai"`
+	RequestURL       string   `json:"request_url"`
+	SecretKey        string   `json:"secret_key"`
+	Region           string   `json:"region"`
+	AccessKeyID      string   `json:"access_key_id"`
+	ApiVersion       string   `json:"api_version"`
+	UseResponsesAPI  bool     `json:"use_responses_api"`
+	GroupID          int      `json:"group_id"`
+	Priority         int      `json:"priority"`
+	Weight           int      `json:"weight" binding:"min=1"`
+	CostMultiplier   *float64 `json:"cost_multiplier" binding:"omitempty,min=0,max=100"` // 成本系数，不传默认为1
+	MaxConcurrency   int      `json:"max_concurrency" binding:"min=0"`                   // 最大并发请求数，0表示不限制
+	EnableProxy      bool     `json:"enable_proxy"`
+	ProxyURI         string   `json:"proxy_uri"`
+	ModelMapping     string   `json:"model_mapping"`
+	ModelRestriction string   `json:"model_restriction"`
+	ActiveStatus     int      `json:"active_status" binding:"oneof=1 2"`
+	IsMax            bool     `json:"is_max"` // 是否是max账号
+	AccessToken      string   `json:"access_token"`
+	RefreshToken     string   `json:"refresh_token"`
+	ExpiresAt        int      `json:"expires_at" binding:"min=0"`
+	TodayUsageCount  int      `json:"today_usage_count"` // 今日使用次数
 }
 
 // 账号更新请求参数
 type UpdateAccountRequest struct {
-	Name             string `json:"name" binding:"required,min=1,max=100"`
-	PlatformType     string `json:"platform_type" binding:"required,oneof=claude claude_console openai gemini"`
-	RequestURL       string `json:"request_url"`
-	SecretKey        string `json:"secret_key"`
-	GroupID          *int   `json:"group_id" binding:"omitempty,min=0"`
-	Priority         int    `json:"priority" binding:"min=1"`
-	Weight           int    `json:"weight" binding:"min=1"`
-	EnableProxy      bool   `json:"enable_proxy"`
-	ProxyURI         string `json:"proxy_uri"`
-	ModelMapping     string `json:"model_mapping"`
-	ModelRestriction string `json:"model_restriction"`
-	ActiveStatus     int    `json:"active_status" binding:"oneof=1 2"`
-	IsMax            bool   `json:"is_max"` // 是否是max账号
-	AccessToken      string `json:"access_token"`
-	RefreshToken     string `json:"refresh_token"`
-	TodayUsageCount  int    `json:"today_usage_count"` // 今日使用次数
+	Name             string   `json:"name" binding:"required,min=1,max=100"`
+	PlatformType     string   `json:"platform_type" binding:"required,oneof=claude claude_console openai gemini bedrock vertex azure_openai"`
+	RequestURL       string   `json:"request_url"`
+	SecretKey        string   `json:"secret_key"`
+	Region           string   `json:"region"`
+	AccessKeyID      string   `json:"access_key_id"`
+	ApiVersion       string   `json:"api_version"`
+	UseResponsesAPI  bool     `json:"use_responses_api"`
+	GroupID          *int     `json:"group_id" binding:"omitempty,min=0"`
+	Priority         int      `json:"priority" binding:"min=1"`
+	Weight           int      `json:"weight" binding:"min=1"`
+	CostMultiplier   *float64 `json:"cost_multiplier" binding:"omitempty,min=0,max=100"` // 成本系数，不传时保持不变
+	MaxConcurrency   int      `json:"max_concurrency" binding:"min=0"`                   // 最大并发请求数，0表示不限制
+	EnableProxy      bool     `json:"enable_proxy"`
+	ProxyURI         string   `json:"proxy_uri"`
+	ModelMapping     string   `json:"model_mapping"`
+	ModelRestriction string   `json:"model_restriction"`
+	ActiveStatus     int      `json:"active_status" binding:"oneof=1 2"`
+	IsMax            bool     `json:"is_max"` // 是否是max账号
+	AccessToken      string   `json:"access_token"`
+	RefreshToken     string   `json:"refresh_token"`
+	TodayUsageCount  int      `json:"today_usage_count"` // 今日使用次数
 }
 
 // 账号激活状态更新请求参数
@@ -117,9 +135,20 @@ func (a *Account) TableName() string {
 }
 
 // 创建账号
+// 成本系数为0时GORM会使用字段默认值，在同一事务中更新为0
 func CreateAccount(account *Account) error {
 	account.ID = 0
-	return DB.Create(account).Error
+	costMultiplier := account.CostMultiplier
+	return DB.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(account).Error; err != nil {
+			return err
+		}
+		if costMultiplier != 0 {
+			return nil
+		}
+		account.CostMultiplier = 0
+		return tx.Model(account).Update("cost_multiplier", 0).Error
+	})
 }
 
 // 根据ID获取账号
@@ -0,0 +1,23 @@
+package model
+
+import "testing"
+
+// TestCreateAccountCostMultiplier 成本系数为0时不应被字段默认值覆盖
+func TestCreateAccountCostMultiplier(t *testing.T) {
+	setupTestDB(t)
+
+	for _, costMultiplier := range []float64{0, 0.5, 1} {
+		account := &Account{Name: "account", PlatformType: "claude", CostMultiplier: costMultiplier}
+		if err := CreateAccount(account); err != nil {
+			t.Fatalf("创建账号失败: %v", err)
+		}
+
+		var saved Account
+		if err := DB.First(&saved, account.ID).Error; err != nil {
+			t.Fatalf("查询账号失败: %v", err)
+		}
+		if saved.CostMultiplier != costMultiplier || account.CostMultiplier != costMultiplier {
+			t.Errorf("成本系数应为 %v, 实际保存 %v, 返回 %v", costMultiplier, saved.CostMultiplier, account.CostMultiplier)
+		}
+	}
+}
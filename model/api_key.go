@@ -24,6 +24,13 @@ type ApiKey struct {
 	TodayTotalCost                float64        `json:"today_total_cost" gorm:"default:0;comment:今日使用总费用(USD)"`
 	ModelRestriction              string         `json:"model_restriction" gorm:"type:text;comment:模型限制,逗号分隔"`
 	DailyLimit                    float64        `json:"daily_limit" gorm:"default:0;comment:日限额(美元),0表示不限制"`
+	HedgeDelay                    int            `json:"hedge_delay" gorm:"default:0;comment:对冲请求延迟(毫秒),0表示不启用"`
+	RpmLimit                      int            `json:"rpm_limit" gorm:"default:0;comment:每分钟请求数限制,0表示不限制"`
+	ItpmLimit                     int            `json:"itpm_limit" gorm:"default:0;comment:每分钟输入tokens限制,0表示不限制"`
+	OtpmLimit                     int            `json:"otpm_limit" gorm:"default:0;comment:每分钟输出tokens限制,0表示不限制"`
+	WeeklyBudget                  float64        `json:"weekly_budget" gorm:"default:0;comment:最近7天预算(美元),0表示不限制"`
+	MonthlyBudget                 float64        `json:"monthly_budget" gorm:"default:0;comment:最近30天预算(美元),0表示不限制"`
+	BudgetAlertPercent            int            `json:"budget_alert_percent" gorm:"default:0;comment:预算提醒阈值(百分比),0表示不提醒"`
 	LastUsedTime                  *Time          `json:"last_used_time" gorm:"comment:最后使用时间;type:datetime"`
 	CreatedAt                     Time           `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
 	UpdatedAt                     Time           `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
@@ -44,6 +51,13 @@ type CreateApiKeyRequest struct {
 	GroupID          int     `json:"group_id"`
 	ModelRestriction string  `json:"model_restriction"`
 	DailyLimit       float64 `json:"daily_limit"`
+	HedgeDelay       int     `json:"hedge_delay" binding:"min=0"`
+	RpmLimit         int     `json:"rpm_limit" binding:"min=0"`
+	ItpmLimit        int     `json:"itpm_limit" binding:"min=0"`
+	OtpmLimit        int     `json:"otpm_limit" binding:"min=0"`
+	WeeklyBudget       float64 `json:"weekly_budget" binding:"min=0"`
+	MonthlyBudget      float64 `json:"monthly_budget" binding:"min=0"`
+	BudgetAlertPercent int     `json:"budget_alert_percent" binding:"min=0,max=100"`
 }
 
 type UpdateApiKeyRequest struct {
@@ -53,6 +67,13 @@ type UpdateApiKeyRequest struct {
 	GroupID          *int     `json:"group_id"`
 	ModelRestriction *string  `json:"model_restriction"`
 	DailyLimit       *float64 `json:"daily_limit"`
+	HedgeDelay       *int     `json:"hedge_delay" binding:"omitempty,min=0"`
+	RpmLimit         *int     `json:"rpm_limit" binding:"omitempty,min=0"`
+	ItpmLimit        *int     `json:"itpm_limit" binding:"omitempty,min=0"`
+	OtpmLimit        *int     `json:"otpm_limit" binding:"omitempty,min=0"`
+	WeeklyBudget       *float64 `json:"weekly_budget" binding:"omitempty,min=0"`
+	MonthlyBudget      *float64 `json:"monthly_budget" binding:"omitempty,min=0"`
+	BudgetAlertPercent *int     `json:"budget_alert_percent" binding:"omitempty,min=0,max=100"`
 }
 
 type ApiKeyListResult struct {
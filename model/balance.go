@@ -0,0 +1,189 @@
+package model
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 余额流水类型
+const (
+	LedgerTypeTopup   = "topup"   // 充值
+	LedgerTypeAdjust  = "adjust"  // 调整
+	LedgerTypeRefund  = "refund"  // 退款
+	LedgerTypeConsume = "consume" // 请求消费
+	LedgerTypeRedeem  = "redeem"  // 兑换码充值
+)
+
+// ErrLogAlreadyRefunded 日志已退款
+var ErrLogAlreadyRefunded = errors.New("该日志已退款")
+
+// UserBalance 用户预付费余额 - 没有余额记录的用户不启用预付费，不扣费也不限制请求
+type UserBalance struct {
+	ID             uint    `json:"id" gorm:"primaryKey"`
+	UserID         uint    `json:"user_id" gorm:"not null;uniqueIndex;comment:用户ID"`
+	Balance        float64 `json:"balance" gorm:"type:decimal(20,8);default:0;comment:当前余额(USD)"`
+	TotalRecharged float64 `json:"total_recharged" gorm:"type:decimal(20,8);default:0;comment:累计充值(USD)"`
+	TotalConsumed  float64 `json:"total_consumed" gorm:"type:decimal(20,8);default:0;comment:累计消费(USD)，扣除已退款部分"`
+	CreatedAt      Time    `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
+	UpdatedAt      Time    `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
+}
+
+// BalanceLedger 余额流水 - 只追加不修改，每次余额变动记录一条
+type BalanceLedger struct {
+	ID           uint    `json:"id" gorm:"primaryKey"`
+	UserID       uint    `json:"user_id" gorm:"not null;index:idx_balance_ledgers_user_type;comment:用户ID"`
+	Type         string  `json:"type" gorm:"type:varchar(20);not null;index:idx_balance_ledgers_user_type;comment:流水类型(topup/adjust/refund/consume/redeem)"`
+	Amount       float64 `json:"amount" gorm:"type:decimal(20,8);not null;comment:变动金额(USD)，正数增加负数扣减"`
+	BalanceAfter float64 `json:"balance_after" gorm:"type:decimal(20,8);not null;comment:变动后余额(USD)"`
+	LogID        string  `json:"log_id" gorm:"type:varchar(19);index;comment:消费或退款关联的日志ID"`
+	OperatorID   uint    `json:"operator_id" gorm:"default:0;comment:操作的管理员ID，请求消费为0"`
+	Remark       string  `json:"remark" gorm:"type:varchar(255);comment:备注"`
+	CreatedAt    Time    `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
+}
+
+// BalanceLedgerListResult 余额流水列表响应结构
+type BalanceLedgerListResult struct {
+	Ledgers []BalanceLedger `json:"ledgers"`
+	Total   int64           `json:"total"`
+	Page    int             `json:"page"`
+	Limit   int             `json:"limit"`
+}
+
+func (b *UserBalance) TableName() string {
+	return "user_balances"
+}
+
+func (l *BalanceLedger) TableName() string {
+	return "balance_ledgers"
+}
+
+// GetUserBalance 获取用户余额，用户未启用预付费时返回gorm.ErrRecordNotFound
+func GetUserBalance(userID uint) (*UserBalance, error) {
+	var balance UserBalance
+	err := DB.Where("user_id = ?", userID).First(&balance).Error
+	if err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// ChangeUserBalance 变动用户余额并记录流水，用户没有余额记录时自动创建（启用预付费）
+// 退款关联日志时同一日志只能退款一次
+func ChangeUserBalance(entry *BalanceLedger) (*UserBalance, error) {
+	var balance *UserBalance
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if err := ensureUserBalance(tx, entry.UserID); err != nil {
+			return err
+		}
+
+		var err error
+		balance, err = applyBalanceChange(tx, entry, func(tx *gorm.DB) error {
+			if entry.Type != LedgerTypeRefund || entry.LogID == "" {
+				return nil
+			}
+			var count int64
+			if err := tx.Model(&BalanceLedger{}).
+				Where("log_id = ? AND type = ?", entry.LogID, LedgerTypeRefund).
+				Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return ErrLogAlreadyRefunded
+			}
+			return nil
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return balance, nil
+}
+
+// ensureUserBalance 用户没有余额记录时创建，并发创建时只会成功一条
+func ensureUserBalance(tx *gorm.DB, userID uint) error {
+	return tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&UserBalance{UserID: userID}).Error
+}
+
+// consumeUserBalance 在事务中扣除请求费用，用户未启用预付费时不处理
+// 请求已经完成，余额不足时也照常扣除，余额可以变为负数
+func consumeUserBalance(tx *gorm.DB, userID uint, cost float64, logID string) error {
+	if cost <= 0 {
+		return nil
+	}
+
+	_, err := applyBalanceChange(tx, &BalanceLedger{
+		UserID: userID,
+		Type:   LedgerTypeConsume,
+		Amount: -cost,
+		LogID:  logID,
+	}, nil)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil
+	}
+	return err
+}
+
+// applyBalanceChange 锁定余额记录后更新余额和累计金额，并追加流水
+// check 在持有行锁时执行，用于同一用户的余额变动之间需要串行的校验
+func applyBalanceChange(tx *gorm.DB, entry *BalanceLedger, check func(tx *gorm.DB) error) (*UserBalance, error) {
+	var balance UserBalance
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("user_id = ?", entry.UserID).
+		First(&balance).Error; err != nil {
+		return nil, err
+	}
+
+	if check != nil {
+		if err := check(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	updates := map[string]interface{}{
+		"balance": gorm.Expr("balance + ?", entry.Amount),
+	}
+	switch entry.Type {
+	case LedgerTypeTopup, LedgerTypeRedeem:
+		updates["total_recharged"] = gorm.Expr("total_recharged + ?", entry.Amount)
+	case LedgerTypeConsume, LedgerTypeRefund:
+		updates["total_consumed"] = gorm.Expr("total_consumed - ?", entry.Amount)
+	}
+	if err := tx.Model(&UserBalance{}).Where("id = ?", balance.ID).Updates(updates).Error; err != nil {
+		return nil, err
+	}
+	if err := tx.First(&balance, balance.ID).Error; err != nil {
+		return nil, err
+	}
+
+	entry.ID = 0
+	entry.BalanceAfter = balance.Balance
+	if err := tx.Create(entry).Error; err != nil {
+		return nil, err
+	}
+	return &balance, nil
+}
+
+// GetBalanceLedgers 分页获取用户的余额流水（按时间倒序），ledgerType为空时不筛选类型
+func GetBalanceLedgers(userID uint, ledgerType string, page, limit int) ([]BalanceLedger, int64, error) {
+	var ledgers []BalanceLedger
+	var total int64
+
+	query := DB.Model(&BalanceLedger{}).Where("user_id = ?", userID)
+	if ledgerType != "" {
+		query = query.Where("type = ?", ledgerType)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&ledgers).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return ledgers, total, nil
+}
@@ -0,0 +1,126 @@
+package model
+
+import (
+	"claude-code-relay/common"
+	"errors"
+	"math"
+	"testing"
+
+	"gorm.io/gorm"
+)
+
+// assertLedgerMatchesBalance 最后一条流水的变动后余额与当前余额一致
+func assertLedgerMatchesBalance(t *testing.T, userID uint) *UserBalance {
+	t.Helper()
+
+	balance, err := GetUserBalance(userID)
+	if err != nil {
+		t.Fatalf("获取余额失败: %v", err)
+	}
+	var ledger BalanceLedger
+	if err := DB.Where("user_id = ?", userID).Order("id DESC").First(&ledger).Error; err != nil {
+		t.Fatalf("获取流水失败: %v", err)
+	}
+	if math.Abs(ledger.BalanceAfter-balance.Balance) > 1e-9 {
+		t.Errorf("流水变动后余额 %v 与当前余额 %v 不一致", ledger.BalanceAfter, balance.Balance)
+	}
+	return balance
+}
+
+// TestChangeUserBalance 每次变动记录流水，变动后余额与当前余额一致，累计金额按类型更新
+func TestChangeUserBalance(t *testing.T) {
+	setupTestDB(t)
+
+	changes := []struct {
+		ledgerType string
+		amount     float64
+		expected   float64
+	}{
+		{LedgerTypeTopup, 10, 10},
+		{LedgerTypeAdjust, -2.5, 7.5},
+		{LedgerTypeRedeem, 5, 12.5},
+		{LedgerTypeRefund, 1.25, 13.75},
+	}
+	for _, change := range changes {
+		balance, err := ChangeUserBalance(&BalanceLedger{UserID: 1, Type: change.ledgerType, Amount: change.amount})
+		if err != nil {
+			t.Fatalf("%s 变动余额失败: %v", change.ledgerType, err)
+		}
+		if balance.Balance != change.expected {
+			t.Errorf("%s 后余额应为 %v, 实际: %v", change.ledgerType, change.expected, balance.Balance)
+		}
+		assertLedgerMatchesBalance(t, 1)
+	}
+
+	balance := assertLedgerMatchesBalance(t, 1)
+	if balance.TotalRecharged != 15 || balance.TotalConsumed != -1.25 {
+		t.Errorf("累计充值应为15, 累计消费应为-1.25, 实际: %+v", balance)
+	}
+}
+
+// TestConsumeUserBalance 请求日志扣除预付费用户余额，未启用预付费的用户不扣费也不创建余额记录
+func TestConsumeUserBalance(t *testing.T) {
+	setupTestDB(t)
+	if _, err := ChangeUserBalance(&BalanceLedger{UserID: 1, Type: LedgerTypeTopup, Amount: 1}); err != nil {
+		t.Fatalf("充值失败: %v", err)
+	}
+
+	// 余额不足时照常扣除，余额变为负数
+	usage := &common.TokenUsage{Model: "claude-sonnet-4-20250514", InputTokens: 1000000, OutputTokens: 100000}
+	log, err := CreateLogFromTokenUsage(usage, 1, 0, 0, 1000, false)
+	if err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+	if log.TotalCost <= 1 {
+		t.Fatalf("日志费用应大于余额: %v", log.TotalCost)
+	}
+	balance := assertLedgerMatchesBalance(t, 1)
+	if math.Abs(balance.Balance-(1-log.TotalCost)) > 1e-9 || math.Abs(balance.TotalConsumed-log.TotalCost) > 1e-9 {
+		t.Errorf("扣费后余额不正确: 费用 %v, 余额 %+v", log.TotalCost, balance)
+	}
+	var consume BalanceLedger
+	if err := DB.Where("log_id = ? AND type = ?", log.ID, LedgerTypeConsume).First(&consume).Error; err != nil || consume.Amount != -log.TotalCost {
+		t.Errorf("扣费流水不正确: %+v, %v", consume, err)
+	}
+
+	if _, err := CreateLogFromTokenUsage(usage, 2, 0, 0, 1000, false); err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+	if _, err := GetUserBalance(2); !errors.Is(err, gorm.ErrRecordNotFound) {
+		t.Errorf("未启用预付费的用户不应创建余额记录: %v", err)
+	}
+	var ledgerCount int64
+	DB.Model(&BalanceLedger{}).Where("user_id = ?", 2).Count(&ledgerCount)
+	if ledgerCount != 0 {
+		t.Errorf("未启用预付费的用户不应记录流水, 实际 %d 条", ledgerCount)
+	}
+}
+
+// TestRefundLogOnce 同一日志只能退款一次，重复退款不变动余额
+func TestRefundLogOnce(t *testing.T) {
+	setupTestDB(t)
+	if _, err := ChangeUserBalance(&BalanceLedger{UserID: 1, Type: LedgerTypeTopup, Amount: 10}); err != nil {
+		t.Fatalf("充值失败: %v", err)
+	}
+
+	if _, err := ChangeUserBalance(&BalanceLedger{UserID: 1, Type: LedgerTypeRefund, Amount: 2, LogID: "1001"}); err != nil {
+		t.Fatalf("退款失败: %v", err)
+	}
+	if _, err := ChangeUserBalance(&BalanceLedger{UserID: 1, Type: LedgerTypeRefund, Amount: 2, LogID: "1001"}); !errors.Is(err, ErrLogAlreadyRefunded) {
+		t.Errorf("重复退款应返回 ErrLogAlreadyRefunded, 实际: %v", err)
+	}
+	// 不同日志可以分别退款
+	if _, err := ChangeUserBalance(&BalanceLedger{UserID: 1, Type: LedgerTypeRefund, Amount: 1, LogID: "1002"}); err != nil {
+		t.Fatalf("退款失败: %v", err)
+	}
+
+	balance := assertLedgerMatchesBalance(t, 1)
+	if balance.Balance != 13 {
+		t.Errorf("余额应为13, 实际: %v", balance.Balance)
+	}
+	var refundCount int64
+	DB.Model(&BalanceLedger{}).Where("log_id = ? AND type = ?", "1001", LedgerTypeRefund).Count(&refundCount)
+	if refundCount != 1 {
+		t.Errorf("同一日志应只有1条退款流水, 实际 %d 条", refundCount)
+	}
+}
@@ -105,6 +105,12 @@ func InitDB() error {
 		&Group{},
 		&ApiKey{},
 		&Log{},
+		&MessageBatch{},
+		&UserBalance{},
+		&BalanceLedger{},
+		&RedemptionCode{},
+		&RedemptionRecord{},
+		&PriceRule{},
 	)
 	if err != nil {
 		return err
@@ -5,14 +5,17 @@ import (
 )
 
 type Group struct {
-	ID        uint           `json:"id" gorm:"primaryKey"`
-	Name      string         `json:"name" gorm:"type:varchar(100);not null;uniqueIndex:idx_groups_user_name"`
-	Remark    string         `json:"remark" gorm:"type:text"`
-	Status    int            `json:"status" gorm:"default:1"` // 1:启用 0:禁用
-	UserID    uint           `json:"user_id" gorm:"not null;uniqueIndex:idx_groups_user_name"`
-	CreatedAt Time           `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
-	UpdatedAt Time           `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
-	DeletedAt gorm.DeletedAt `json:"-" gorm:"uniqueIndex:idx_groups_user_name"`
+	ID                 uint           `json:"id" gorm:"primaryKey"`
+	Name               string         `json:"name" gorm:"type:varchar(100);not null;uniqueIndex:idx_groups_user_name"`
+	Remark             string         `json:"remark" gorm:"type:text"`
+	Status             int            `json:"status" gorm:"default:1"` // 1:启用 0:禁用
+	UserID             uint           `json:"user_id" gorm:"not null;uniqueIndex:idx_groups_user_name"`
+	WeeklyBudget       float64        `json:"weekly_budget" gorm:"default:0;comment:分组最近7天预算(美元),0表示不限制"`
+	MonthlyBudget      float64        `json:"monthly_budget" gorm:"default:0;comment:分组最近30天预算(美元),0表示不限制"`
+	BudgetAlertPercent int            `json:"budget_alert_percent" gorm:"default:0;comment:预算提醒阈值(百分比),0表示不提醒"`
+	CreatedAt          Time           `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
+	UpdatedAt          Time           `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
+	DeletedAt          gorm.DeletedAt `json:"-" gorm:"uniqueIndex:idx_groups_user_name"`
 
 	// 统计字段，不存储在数据库中
 	ApiKeyCount  int `json:"api_key_count" gorm:"-"`
@@ -20,15 +23,21 @@ type Group struct {
 }
 
 type CreateGroupRequest struct {
-	Name   string `json:"name" binding:"required"`
-	Remark string `json:"remark"`
-	Status int    `json:"status"`
+	Name               string  `json:"name" binding:"required"`
+	Remark             string  `json:"remark"`
+	Status             int     `json:"status"`
+	WeeklyBudget       float64 `json:"weekly_budget" binding:"min=0"`
+	MonthlyBudget      float64 `json:"monthly_budget" binding:"min=0"`
+	BudgetAlertPercent int     `json:"budget_alert_percent" binding:"min=0,max=100"`
 }
 
 type UpdateGroupRequest struct {
-	Name   string `json:"name"`
-	Remark string `json:"remark"`
-	Status *int   `json:"status"`
+	Name               string   `json:"name"`
+	Remark             string   `json:"remark"`
+	Status             *int     `json:"status"`
+	WeeklyBudget       *float64 `json:"weekly_budget" binding:"omitempty,min=0"`
+	MonthlyBudget      *float64 `json:"monthly_budget" binding:"omitempty,min=0"`
+	BudgetAlertPercent *int     `json:"budget_alert_percent" binding:"omitempty,min=0,max=100"`
 }
 
 type GroupListResult struct {
@@ -7,27 +7,32 @@ import (
 	"time"
 
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 // Log 日志记录表 - 记录Claude Code调用的详细日志
 type Log struct {
-	ID                       string  `json:"id" gorm:"primaryKey;type:varchar(19)"`                     // 雪花算法ID，支持排序
-	ModelName                string  `json:"model_name" gorm:"type:varchar(100);not null;index"`        // 模型名称，如claude-3-5-sonnet-20241022
-	AccountID                uint    `json:"account_id" gorm:"index"`                                   // 账户ID
-	UserID                   uint    `json:"user_id" gorm:"index"`                                      // 用户ID
-	ApiKeyID                 uint    `json:"api_key_id" gorm:"index"`                                   // API Key ID
-	InputTokens              int     `json:"input_tokens" gorm:"default:0"`                             // 输入tokens数量
-	OutputTokens             int     `json:"output_tokens" gorm:"default:0"`                            // 输出tokens数量
-	CacheReadInputTokens     int     `json:"cache_read_input_tokens" gorm:"default:0"`                  // 缓存读取输入tokens数量
-	CacheCreationInputTokens int     `json:"cache_creation_input_tokens" gorm:"default:0"`              // 缓存创建输入tokens数量
-	InputCost                float64 `json:"input_cost" gorm:"default:0"`                               // 输入费用(USD)
-	OutputCost               float64 `json:"output_cost" gorm:"default:0"`                              // 输出费用(USD)
-	CacheWriteCost           float64 `json:"cache_write_cost" gorm:"default:0"`                         // 缓存写入费用(USD)
-	CacheReadCost            float64 `json:"cache_read_cost" gorm:"default:0"`                          // 缓存读取费用(USD)
-	TotalCost                float64 `json:"total_cost" gorm:"default:0"`                               // 总费用(USD)
-	IsStream                 bool    `json:"is_stream" gorm:"default:false"`                            // 是否为流式输出
-	Duration                 int64   `json:"duration"`                                                  // 请求总耗时(毫秒)
-	CreatedAt                Time    `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"` // 创建时间
+	ID                       string   `json:"id" gorm:"primaryKey;type:varchar(19)"`                     // 雪花算法ID，支持排序
+	ModelName                string   `json:"model_name" gorm:"type:varchar(100);not null;index"`        // 模型名称，如claude-3-5-sonnet-20241022
+	AccountID                uint     `json:"account_id" gorm:"index"`                                   // 账户ID
+	UserID                   uint     `json:"user_id" gorm:"index"`                                      // 用户ID
+	ApiKeyID                 uint     `json:"api_key_id" gorm:"index"`                                   // API Key ID
+	InputTokens              int      `json:"input_tokens" gorm:"default:0"`                             // 输入tokens数量
+	OutputTokens             int      `json:"output_tokens" gorm:"default:0"`                            // 输出tokens数量
+	CacheReadInputTokens     int      `json:"cache_read_input_tokens" gorm:"default:0"`                  // 缓存读取输入tokens数量
+	CacheCreationInputTokens int      `json:"cache_creation_input_tokens" gorm:"default:0"`              // 缓存创建输入tokens数量
+	InputCost                float64  `json:"input_cost" gorm:"default:0"`                               // 输入费用(USD)
+	OutputCost               float64  `json:"output_cost" gorm:"default:0"`                              // 输出费用(USD)
+	CacheWriteCost           float64  `json:"cache_write_cost" gorm:"default:0"`                         // 缓存写入费用(USD)
+	CacheReadCost            float64  `json:"cache_read_cost" gorm:"default:0"`                          // 缓存读取费用(USD)
+	TotalCost                float64  `json:"total_cost" gorm:"default:0"`                               // 总费用(USD)，按价格规则调整后的计费费用
+	RawCost                  *float64 `json:"raw_cost"`                                                  // 官方标价费用(USD)，为空表示与total_cost相同
+	AccountCost              *float64 `json:"account_cost"`                                              // 账号实际成本(USD)，按账号成本系数折算，MAX账号为0
+	IsStream                 bool     `json:"is_stream" gorm:"default:false"`                            // 是否为流式输出
+	IsBatch                  bool     `json:"is_batch" gorm:"default:false"`                             // 是否为批处理请求
+	Duration                 int64    `json:"duration"`                                                  // 请求总耗时(毫秒)
+	BatchItemKey             *string  `json:"-" gorm:"type:varchar(191);uniqueIndex"`                    // 批处理条目标识(批处理ID:custom_id)，保证同一条目只计费一次
+	CreatedAt                Time     `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"` // 创建时间
 
 	// 关联关系
 	User   User   `json:"user,omitempty" gorm:"foreignKey:UserID"`
@@ -36,21 +41,25 @@ type Log struct {
 
 // LogCreateRequest 创建日志请求结构
 type LogCreateRequest struct {
-	ModelName                string  `json:"model_name" binding:"required"`
-	AccountID                uint    `json:"account_id"`
-	UserID                   uint    `json:"user_id" binding:"required"`
-	ApiKeyID                 uint    `json:"api_key_id"`
-	InputTokens              int     `json:"input_tokens"`
-	OutputTokens             int     `json:"output_tokens"`
-	CacheReadInputTokens     int     `json:"cache_read_input_tokens"`
-	CacheCreationInputTokens int     `json:"cache_creation_input_tokens"`
-	InputCost                float64 `json:"input_cost"`
-	OutputCost               float64 `json:"output_cost"`
-	CacheWriteCost           float64 `json:"cache_write_cost"`
-	CacheReadCost            float64 `json:"cache_read_cost"`
-	TotalCost                float64 `json:"total_cost"`
-	IsStream                 bool    `json:"is_stream"`
-	Duration                 int64   `json:"duration"`
+	ModelName                string   `json:"model_name" binding:"required"`
+	AccountID                uint     `json:"account_id"`
+	UserID                   uint     `json:"user_id" binding:"required"`
+	ApiKeyID                 uint     `json:"api_key_id"`
+	InputTokens              int      `json:"input_tokens"`
+	OutputTokens             int      `json:"output_tokens"`
+	CacheReadInputTokens     int      `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int      `json:"cache_creation_input_tokens"`
+	InputCost                float64  `json:"input_cost"`
+	OutputCost               float64  `json:"output_cost"`
+	CacheWriteCost           float64  `json:"cache_write_cost"`
+	CacheReadCost            float64  `json:"cache_read_cost"`
+	TotalCost                float64  `json:"total_cost"`
+	RawCost                  *float64 `json:"raw_cost"`
+	AccountCost              *float64 `json:"account_cost"`
+	IsStream                 bool     `json:"is_stream"`
+	IsBatch                  bool     `json:"is_batch"`
+	Duration                 int64    `json:"duration"`
+	BatchItemKey             *string  `json:"-"`
 }
 
 // LogListResult 日志列表响应结构
@@ -156,7 +165,20 @@ func generateSnowflakeID() string {
 
 // CreateLog 创建日志记录
 func CreateLog(logReq *LogCreateRequest) (*Log, error) {
-	log := &Log{
+	log := newLogFromRequest(logReq)
+
+	err := DB.Create(log).Error
+	if err != nil {
+		common.SysError("创建日志记录失败: " + err.Error())
+		return nil, err
+	}
+
+	return log, nil
+}
+
+// newLogFromRequest 根据创建请求构建日志记录
+func newLogFromRequest(logReq *LogCreateRequest) *Log {
+	return &Log{
 		ID:                       generateSnowflakeID(),
 		ModelName:                logReq.ModelName,
 		AccountID:                logReq.AccountID,
@@ -171,24 +193,36 @@ func CreateLog(logReq *LogCreateRequest) (*Log, error) {
 		CacheWriteCost:           logReq.CacheWriteCost,
 		CacheReadCost:            logReq.CacheReadCost,
 		TotalCost:                logReq.TotalCost,
+		RawCost:                  logReq.RawCost,
+		AccountCost:              logReq.AccountCost,
 		IsStream:                 logReq.IsStream,
+		IsBatch:                  logReq.IsBatch,
 		Duration:                 logReq.Duration,
+		BatchItemKey:             logReq.BatchItemKey,
 	}
-
-	err := DB.Create(log).Error
-	if err != nil {
-		common.SysError("创建日志记录失败: " + err.Error())
-		return nil, err
-	}
-
-	return log, nil
 }
 
 // CreateLogFromTokenUsage 根据TokenUsage创建日志记录
 func CreateLogFromTokenUsage(usage *common.TokenUsage, userID, apiKeyID, accountID uint, duration int64, isStream bool) (*Log, error) {
-	// 使用费用计算器计算详细费用
-	costResult := common.CalculateCost(usage)
+	// 使用费用计算器计算官方标价费用和按价格规则调整后的计费费用
+	rawCost := common.CalculateCost(usage).Costs.Total
+	costResult := common.CalculateBilledCost(usage, GetPriceAdjustment(userID, apiKeyID, usage.Model))
+
+	return createLogWithCost(usage, costResult, rawCost, userID, apiKeyID, accountID, duration, isStream, nil)
+}
+
+// CreateBatchLogFromTokenUsage 根据批处理单条结果的TokenUsage创建日志记录（按批处理价格计费）
+// itemKey 唯一标识批处理条目，计费重试时已写入的条目直接返回已有日志，不会重复扣费
+func CreateBatchLogFromTokenUsage(usage *common.TokenUsage, userID, apiKeyID, accountID uint, itemKey string) (*Log, error) {
+	rawCost := common.CalculateBatchCost(usage).Costs.Total
+	costResult := common.CalculateBilledBatchCost(usage, GetPriceAdjustment(userID, apiKeyID, usage.Model))
 
+	return createLogWithCost(usage, costResult, rawCost, userID, apiKeyID, accountID, 0, false, &itemKey)
+}
+
+// createLogWithCost 使用已计算的计费费用创建日志记录，rawCost为官方标价费用，账号实际成本按官方标价折算
+// batchItemKey 不为空时为批处理条目日志
+func createLogWithCost(usage *common.TokenUsage, costResult *common.CostCalculationResult, rawCost float64, userID, apiKeyID, accountID uint, duration int64, isStream bool, batchItemKey *string) (*Log, error) {
 	logReq := &LogCreateRequest{
 		ModelName:                usage.Model,
 		AccountID:                accountID,
@@ -203,11 +237,82 @@ func CreateLogFromTokenUsage(usage *common.TokenUsage, userID, apiKeyID, account
 		CacheWriteCost:           costResult.Costs.CacheWrite,
 		CacheReadCost:            costResult.Costs.CacheRead,
 		TotalCost:                costResult.Costs.Total,
+		RawCost:                  &rawCost,
+		AccountCost:              calculateAccountCost(accountID, rawCost),
 		IsStream:                 isStream,
+		IsBatch:                  batchItemKey != nil,
 		Duration:                 duration,
+		BatchItemKey:             batchItemKey,
+	}
+
+	// 日志和预付费余额扣费在同一事务中完成，每条日志的费用只扣一次
+	log := newLogFromRequest(logReq)
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		if batchItemKey != nil {
+			result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(log)
+			if result.Error != nil {
+				return result.Error
+			}
+			// 批处理条目已在之前的计费中写入，返回已有日志
+			if result.RowsAffected == 0 {
+				var existing Log
+				if err := tx.Where("batch_item_key = ?", *batchItemKey).First(&existing).Error; err != nil {
+					return err
+				}
+				*log = existing
+				return nil
+			}
+		} else if err := tx.Create(log).Error; err != nil {
+			return err
+		}
+		return consumeUserBalance(tx, userID, log.TotalCost, log.ID)
+	})
+	if err != nil {
+		common.SysError("创建日志记录失败: " + err.Error())
+		return nil, err
 	}
 
-	return CreateLog(logReq)
+	return log, nil
+}
+
+// calculateAccountCost 按账号的成本系数将官方标价费用折算为账号实际成本，MAX账号按固定费用计费记为0
+// 账号不存在时返回nil，不参与成本节省统计
+func calculateAccountCost(accountID uint, totalCost float64) *float64 {
+	if accountID == 0 {
+		return nil
+	}
+
+	var account Account
+	if err := DB.Select("id", "is_max", "cost_multiplier").First(&account, accountID).Error; err != nil {
+		return nil
+	}
+
+	cost := 0.0
+	if !account.IsMax {
+		cost = totalCost * account.CostMultiplier
+	}
+	return &cost
+}
+
+// GetApiKeyCostSince 统计API Key从指定时间起的总费用
+func GetApiKeyCostSince(apiKeyID uint, since time.Time) (float64, error) {
+	var total float64
+	err := DB.Model(&Log{}).
+		Select("COALESCE(SUM(total_cost), 0)").
+		Where("api_key_id = ? AND created_at >= ?", apiKeyID, since).
+		Scan(&total).Error
+	return total, err
+}
+
+// GetGroupCostSince 统计分组下所有API Key从指定时间起的总费用
+func GetGroupCostSince(groupID uint, since time.Time) (float64, error) {
+	var total float64
+	err := DB.Model(&Log{}).
+		Select("COALESCE(SUM(logs.total_cost), 0)").
+		Joins("JOIN api_keys ON api_keys.id = logs.api_key_id").
+		Where("api_keys.group_id = ? AND logs.created_at >= ?", groupID, since).
+		Scan(&total).Error
+	return total, err
 }
 
 // GetLogById 根据ID获取日志
@@ -711,6 +816,28 @@ type DashboardStats struct {
 	// 今日vs昨日数据对比
 	TodayStats     *DayStatsItem `json:"today_stats"`     // 今日统计
 	YesterdayStats *DayStatsItem `json:"yesterday_stats"` // 昨日统计
+
+	// 按账号成本系数折算后相对官方标价的节省
+	CostSavings *CostSavingsItem `json:"cost_savings"` // 成本节省统计
+
+	// 按价格规则计费相对官方标价的差额
+	PricingMargin *PricingMarginItem `json:"pricing_margin"` // 计费差额统计
+}
+
+// CostSavingsItem 成本节省统计项，只统计记录了账号实际成本的日志
+type CostSavingsItem struct {
+	ListCost    float64 `json:"list_cost"`    // 官方标价费用(USD)
+	ActualCost  float64 `json:"actual_cost"`  // 账号实际成本(USD)
+	Savings     float64 `json:"savings"`      // 节省金额(USD)
+	SavingsRate float64 `json:"savings_rate"` // 节省比例(%)
+}
+
+// PricingMarginItem 计费差额统计项，计费费用高于官方标价时差额为正
+type PricingMarginItem struct {
+	RawCost    float64 `json:"raw_cost"`    // 官方标价费用(USD)
+	BilledCost float64 `json:"billed_cost"` // 计费费用(USD)
+	Margin     float64 `json:"margin"`      // 差额(USD)
+	MarginRate float64 `json:"margin_rate"` // 差额比例(%)
 }
 
 // ModelUsageItem 模型使用统计项
@@ -801,9 +928,75 @@ func GetDashboardStats() (*DashboardStats, error) {
 	stats.TodayStats = todayStats
 	stats.YesterdayStats = yesterdayStats
 
+	// 获取相对官方标价的成本节省
+	costSavings, err := getCostSavings()
+	if err != nil {
+		return nil, err
+	}
+	stats.CostSavings = costSavings
+
+	// 获取按价格规则计费相对官方标价的差额
+	pricingMargin, err := getPricingMargin()
+	if err != nil {
+		return nil, err
+	}
+	stats.PricingMargin = pricingMargin
+
 	return stats, nil
 }
 
+// getCostSavings 统计官方标价费用与账号实际成本的差额
+func getCostSavings() (*CostSavingsItem, error) {
+	var result struct {
+		ListCost   float64
+		ActualCost float64
+	}
+
+	err := DB.Model(&Log{}).Select(
+		"COALESCE(SUM(COALESCE(raw_cost, total_cost)), 0) as list_cost",
+		"COALESCE(SUM(account_cost), 0) as actual_cost",
+	).Where("account_cost IS NOT NULL").Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	savings := &CostSavingsItem{
+		ListCost:   result.ListCost,
+		ActualCost: result.ActualCost,
+		Savings:    result.ListCost - result.ActualCost,
+	}
+	if result.ListCost > 0 {
+		savings.SavingsRate = savings.Savings / result.ListCost * 100
+	}
+	return savings, nil
+}
+
+// getPricingMargin 统计计费费用与官方标价费用的差额
+func getPricingMargin() (*PricingMarginItem, error) {
+	var result struct {
+		RawCost    float64
+		BilledCost float64
+	}
+
+	err := DB.Model(&Log{}).Select(
+		"COALESCE(SUM(COALESCE(raw_cost, total_cost)), 0) as raw_cost",
+		"COALESCE(SUM(total_cost), 0) as billed_cost",
+	).Scan(&result).Error
+	if err != nil {
+		return nil, err
+	}
+
+	margin := &PricingMarginItem{
+		RawCost:    result.RawCost,
+		BilledCost: result.BilledCost,
+		Margin:     result.BilledCost - result.RawCost,
+	}
+	if result.RawCost > 0 {
+		margin.MarginRate = margin.Margin / result.RawCost * 100
+	}
+	return margin, nil
+}
+
 // getBaseStats 获取基础统计数据
 func getBaseStats() (*struct {
 	TotalCost   float64
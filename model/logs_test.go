@@ -0,0 +1,37 @@
+package model
+
+import (
+	"claude-code-relay/common"
+	"testing"
+)
+
+// TestCreateBatchLogIdempotent 同一批处理条目重复计费时只写入一条日志并只扣费一次
+func TestCreateBatchLogIdempotent(t *testing.T) {
+	setupTestDB(t)
+	if _, err := ChangeUserBalance(&BalanceLedger{UserID: 1, Type: LedgerTypeTopup, Amount: 10}); err != nil {
+		t.Fatalf("充值失败: %v", err)
+	}
+
+	usage := &common.TokenUsage{Model: "claude-sonnet-4-20250514", InputTokens: 1000000, OutputTokens: 100000}
+	first, err := CreateBatchLogFromTokenUsage(usage, 1, 0, 0, "msgbatch_1:req-1")
+	if err != nil {
+		t.Fatalf("写入批处理日志失败: %v", err)
+	}
+	second, err := CreateBatchLogFromTokenUsage(usage, 1, 0, 0, "msgbatch_1:req-1")
+	if err != nil {
+		t.Fatalf("重试写入批处理日志失败: %v", err)
+	}
+	if second.ID != first.ID || second.TotalCost != first.TotalCost {
+		t.Errorf("重试应返回已有日志: 首次 %+v, 重试 %+v", first, second)
+	}
+	if _, err := CreateBatchLogFromTokenUsage(usage, 1, 0, 0, "msgbatch_1:req-2"); err != nil {
+		t.Fatalf("写入批处理日志失败: %v", err)
+	}
+
+	var logCount, consumeCount int64
+	DB.Model(&Log{}).Where("is_batch = ?", true).Count(&logCount)
+	DB.Model(&BalanceLedger{}).Where("type = ?", LedgerTypeConsume).Count(&consumeCount)
+	if logCount != 2 || consumeCount != 2 {
+		t.Errorf("应写入2条日志和2条扣费流水, 实际 %d 条日志, %d 条流水", logCount, consumeCount)
+	}
+}
@@ -0,0 +1,201 @@
+package model
+
+import (
+	"time"
+)
+
+// 批处理计费状态
+const (
+	BatchBillPending = 0 // 待计费
+	BatchBillRunning = 1 // 计费中
+	BatchBillDone    = 2 // 已计费
+	BatchBillFailed  = 3 // 计费失败，连续同步失败达到上限后不再重试
+)
+
+// 批处理同步失败重试策略
+const (
+	MaxBatchSyncFailures  = 10              // 连续失败次数上限
+	batchSyncRetryBackoff = 5 * time.Minute // 首次失败后的重试间隔，之后每次翻倍
+	maxBatchSyncBackoff   = 6 * time.Hour   // 最大重试间隔
+)
+
+// batchBillClaimTimeout 计费中的批处理超过该时间未完成时视为计费进程已中断，允许重新抢占
+// 条目日志按批处理条目幂等写入，重新计费不会重复扣费
+const batchBillClaimTimeout = 30 * time.Minute
+
+// MessageBatch 消息批处理记录 - 批处理ID只在创建它的账号下有效，因此需要固定账号
+type MessageBatch struct {
+	ID               uint    `json:"id" gorm:"primaryKey"`
+	BatchID          string  `json:"batch_id" gorm:"type:varchar(100);not null;uniqueIndex;comment:上游批处理ID"`
+	AccountID        uint    `json:"account_id" gorm:"index;comment:创建批处理的账号ID"`
+	UserID           uint    `json:"user_id" gorm:"index;comment:用户ID"`
+	ApiKeyID         uint    `json:"api_key_id" gorm:"index;comment:API Key ID"`
+	ProcessingStatus string  `json:"processing_status" gorm:"type:varchar(20);default:in_progress;comment:处理状态(in_progress/canceling/ended)"`
+	RequestCount     int     `json:"request_count" gorm:"default:0;comment:请求条数"`
+	Snapshot         string  `json:"-" gorm:"type:text;comment:最近一次上游批处理对象JSON"`
+	BillStatus       int     `json:"bill_status" gorm:"default:0;index;comment:计费状态(0:待计费,1:计费中,2:已计费,3:计费失败)"`
+	BillClaimedAt    *Time   `json:"bill_claimed_at" gorm:"type:datetime;comment:抢占计费权的时间"`
+	SyncFailures     int     `json:"sync_failures" gorm:"default:0;comment:连续同步失败次数"`
+	NextSyncAt       *Time   `json:"next_sync_at" gorm:"type:datetime;index;comment:下次同步时间，为空表示尚未同步"`
+	BilledCount      int     `json:"billed_count" gorm:"default:0;comment:已计费条数"`
+	TotalCost        float64 `json:"total_cost" gorm:"default:0;comment:批处理总费用(USD)"`
+	BilledAt         *Time   `json:"billed_at" gorm:"type:datetime;comment:计费时间"`
+	CreatedAt        Time    `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
+	UpdatedAt        Time    `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
+}
+
+func (b *MessageBatch) TableName() string {
+	return "message_batches"
+}
+
+// CreateMessageBatch 创建批处理记录
+func CreateMessageBatch(batch *MessageBatch) error {
+	batch.ID = 0
+	return DB.Create(batch).Error
+}
+
+// UpdateMessageBatchStatus 更新批处理的处理状态和上游对象快照
+// 只更新这两个字段，避免覆盖并发写入的计费状态
+func UpdateMessageBatchStatus(batch *MessageBatch) error {
+	return DB.Model(&MessageBatch{}).Where("id = ?", batch.ID).Updates(map[string]interface{}{
+		"processing_status": batch.ProcessingStatus,
+		"snapshot":          batch.Snapshot,
+	}).Error
+}
+
+// GetMessageBatchByBatchID 根据上游批处理ID和API Key获取批处理记录
+func GetMessageBatchByBatchID(batchID string, apiKeyID uint) (*MessageBatch, error) {
+	var batch MessageBatch
+	err := DB.Where("batch_id = ? AND api_key_id = ?", batchID, apiKeyID).First(&batch).Error
+	if err != nil {
+		return nil, err
+	}
+	return &batch, nil
+}
+
+// GetMessageBatchesByApiKey 分页获取API Key的批处理记录（按创建时间倒序）
+// afterID/beforeID 为上游批处理ID游标，分别获取游标之后（更早）和之前（更新）的记录
+func GetMessageBatchesByApiKey(apiKeyID uint, limit int, afterID, beforeID string) ([]MessageBatch, bool, error) {
+	var batches []MessageBatch
+	query := DB.Where("api_key_id = ?", apiKeyID)
+
+	order := "id DESC"
+	if afterID != "" {
+		cursor, err := GetMessageBatchByBatchID(afterID, apiKeyID)
+		if err != nil {
+			return nil, false, err
+		}
+		query = query.Where("id < ?", cursor.ID)
+	} else if beforeID != "" {
+		cursor, err := GetMessageBatchByBatchID(beforeID, apiKeyID)
+		if err != nil {
+			return nil, false, err
+		}
+		query = query.Where("id > ?", cursor.ID)
+		order = "id ASC"
+	}
+
+	// 多查询一条用于判断是否还有更多数据
+	if err := query.Order(order).Limit(limit + 1).Find(&batches).Error; err != nil {
+		return nil, false, err
+	}
+
+	hasMore := len(batches) > limit
+	if hasMore {
+		batches = batches[:limit]
+	}
+	if order == "id ASC" {
+		for i, j := 0, len(batches)-1; i < j; i, j = i+1, j-1 {
+			batches[i], batches[j] = batches[j], batches[i]
+		}
+	}
+
+	return batches, hasMore, nil
+}
+
+// GetUnbilledMessageBatches 获取已到同步时间的未计费批处理记录，以及计费超时的批处理记录
+// 从未同步的记录优先，其余按下次同步时间排序，避免反复失败的批处理占满每次同步的名额
+func GetUnbilledMessageBatches(limit int) ([]MessageBatch, error) {
+	var batches []MessageBatch
+	now := time.Now()
+	err := DB.Where("(bill_status = ? AND (next_sync_at IS NULL OR next_sync_at <= ?)) OR (bill_status = ? AND (bill_claimed_at IS NULL OR bill_claimed_at < ?))",
+		BatchBillPending, now, BatchBillRunning, now.Add(-batchBillClaimTimeout)).
+		Order("next_sync_at IS NOT NULL, next_sync_at ASC, id ASC").
+		Limit(limit).
+		Find(&batches).Error
+	return batches, err
+}
+
+// RecordMessageBatchSyncResult 记录一次定时同步的结果
+// 成功时清零失败次数并排到下一轮末尾；失败时按失败次数退避，达到上限后标记为计费失败
+func RecordMessageBatchSyncResult(batch *MessageBatch, syncErr error) error {
+	now := time.Now()
+	if syncErr == nil {
+		next := Time(now)
+		return DB.Model(&MessageBatch{}).Where("id = ?", batch.ID).Updates(map[string]interface{}{
+			"sync_failures": 0,
+			"next_sync_at":  &next,
+		}).Error
+	}
+
+	failures := batch.SyncFailures + 1
+	next := Time(now.Add(batchSyncBackoff(failures)))
+	updates := map[string]interface{}{
+		"sync_failures": failures,
+		"next_sync_at":  &next,
+	}
+	if failures >= MaxBatchSyncFailures {
+		updates["bill_status"] = BatchBillFailed
+	}
+	return DB.Model(&MessageBatch{}).
+		Where("id = ? AND bill_status = ?", batch.ID, BatchBillPending).
+		Updates(updates).Error
+}
+
+// batchSyncBackoff 第failures次失败后的重试间隔
+func batchSyncBackoff(failures int) time.Duration {
+	backoff := batchSyncRetryBackoff
+	for i := 1; i < failures && backoff < maxBatchSyncBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > maxBatchSyncBackoff {
+		backoff = maxBatchSyncBackoff
+	}
+	return backoff
+}
+
+// ClaimMessageBatchBilling 抢占批处理的计费权，避免多个实例或协程重复计费
+// 计费超时的批处理可以被重新抢占，避免计费进程中断后一直停留在计费中
+func ClaimMessageBatchBilling(id uint) (bool, error) {
+	now := time.Now()
+	claimedAt := Time(now)
+	result := DB.Model(&MessageBatch{}).
+		Where("id = ? AND (bill_status = ? OR (bill_status = ? AND (bill_claimed_at IS NULL OR bill_claimed_at < ?)))",
+			id, BatchBillPending, BatchBillRunning, now.Add(-batchBillClaimTimeout)).
+		Updates(map[string]interface{}{
+			"bill_status":     BatchBillRunning,
+			"bill_claimed_at": &claimedAt,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// ReleaseMessageBatchBilling 计费失败时释放计费权，等待下次重试
+func ReleaseMessageBatchBilling(id uint) error {
+	return DB.Model(&MessageBatch{}).
+		Where("id = ? AND bill_status = ?", id, BatchBillRunning).
+		Updates(map[string]interface{}{
+			"bill_status":     BatchBillPending,
+			"bill_claimed_at": nil,
+		}).Error
+}
+
+// CompleteMessageBatchBilling 记录计费结果
+func CompleteMessageBatchBilling(id uint, billedCount int, totalCost float64) error {
+	now := Time(time.Now())
+	return DB.Model(&MessageBatch{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"bill_status":  BatchBillDone,
+		"billed_count": billedCount,
+		"total_cost":   totalCost,
+		"billed_at":    &now,
+	}).Error
+}
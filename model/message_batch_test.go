@@ -0,0 +1,137 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestGetUnbilledMessageBatchesOrder 未同步的批处理优先，退避中的批处理不返回，其余按下次同步时间排序
+func TestGetUnbilledMessageBatchesOrder(t *testing.T) {
+	setupTestDB(t)
+
+	past := Time(time.Now().Add(-time.Hour))
+	earlier := Time(time.Now().Add(-2 * time.Hour))
+	future := Time(time.Now().Add(time.Hour))
+	batches := []MessageBatch{
+		{BatchID: "stuck", NextSyncAt: &past},
+		{BatchID: "backoff", NextSyncAt: &future},
+		{BatchID: "oldest", NextSyncAt: &earlier},
+		{BatchID: "new"},
+		{BatchID: "billed", BillStatus: BatchBillDone},
+	}
+	for i := range batches {
+		if err := CreateMessageBatch(&batches[i]); err != nil {
+			t.Fatalf("创建批处理失败: %v", err)
+		}
+	}
+
+	result, err := GetUnbilledMessageBatches(10)
+	if err != nil {
+		t.Fatalf("查询失败: %v", err)
+	}
+	var got []string
+	for _, batch := range result {
+		got = append(got, batch.BatchID)
+	}
+	expected := []string{"new", "oldest", "stuck"}
+	if len(got) != len(expected) {
+		t.Fatalf("返回的批处理不正确: %v", got)
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("批处理顺序不正确: 期望 %v, 实际 %v", expected, got)
+		}
+	}
+}
+
+// TestRecordMessageBatchSyncResult 失败时退避，达到上限后标记为计费失败，成功时清零失败次数
+func TestRecordMessageBatchSyncResult(t *testing.T) {
+	setupTestDB(t)
+
+	batch := &MessageBatch{BatchID: "msgbatch_1"}
+	if err := CreateMessageBatch(batch); err != nil {
+		t.Fatalf("创建批处理失败: %v", err)
+	}
+
+	reload := func() *MessageBatch {
+		var current MessageBatch
+		if err := DB.First(&current, batch.ID).Error; err != nil {
+			t.Fatalf("查询批处理失败: %v", err)
+		}
+		return &current
+	}
+
+	if err := RecordMessageBatchSyncResult(reload(), errors.New("上游错误")); err != nil {
+		t.Fatalf("记录同步结果失败: %v", err)
+	}
+	current := reload()
+	if current.SyncFailures != 1 || current.NextSyncAt == nil || !time.Time(*current.NextSyncAt).After(time.Now()) {
+		t.Errorf("失败后应退避: %+v", current)
+	}
+
+	if err := RecordMessageBatchSyncResult(current, nil); err != nil {
+		t.Fatalf("记录同步结果失败: %v", err)
+	}
+	if current = reload(); current.SyncFailures != 0 || current.BillStatus != BatchBillPending {
+		t.Errorf("成功后应清零失败次数: %+v", current)
+	}
+
+	for i := 0; i < MaxBatchSyncFailures; i++ {
+		if err := RecordMessageBatchSyncResult(reload(), errors.New("上游错误")); err != nil {
+			t.Fatalf("记录同步结果失败: %v", err)
+		}
+	}
+	if current = reload(); current.BillStatus != BatchBillFailed || current.SyncFailures != MaxBatchSyncFailures {
+		t.Errorf("连续失败达到上限后应标记为计费失败: %+v", current)
+	}
+}
+
+// TestBatchSyncBackoff 重试间隔逐次翻倍且不超过上限
+func TestBatchSyncBackoff(t *testing.T) {
+	cases := map[int]time.Duration{1: 5 * time.Minute, 2: 10 * time.Minute, 4: 40 * time.Minute, 20: maxBatchSyncBackoff}
+	for failures, expected := range cases {
+		if got := batchSyncBackoff(failures); got != expected {
+			t.Errorf("第 %d 次失败的重试间隔应为 %s, 实际: %s", failures, expected, got)
+		}
+	}
+}
+
+// TestReclaimStaleMessageBatchBilling 计费中断超时的批处理会被重新同步并允许重新抢占计费权
+func TestReclaimStaleMessageBatchBilling(t *testing.T) {
+	setupTestDB(t)
+
+	staleAt := Time(time.Now().Add(-batchBillClaimTimeout - time.Minute))
+	batches := []MessageBatch{
+		{BatchID: "stale", BillStatus: BatchBillRunning, BillClaimedAt: &staleAt},
+		{BatchID: "running"},
+	}
+	for i := range batches {
+		if err := CreateMessageBatch(&batches[i]); err != nil {
+			t.Fatalf("创建批处理失败: %v", err)
+		}
+	}
+	stale, running := &batches[0], &batches[1]
+
+	if claimed, err := ClaimMessageBatchBilling(running.ID); err != nil || !claimed {
+		t.Fatalf("待计费的批处理应能抢占计费权: claimed=%v, err=%v", claimed, err)
+	}
+	if claimed, _ := ClaimMessageBatchBilling(running.ID); claimed {
+		t.Error("计费中且未超时的批处理不应被重复抢占")
+	}
+
+	result, err := GetUnbilledMessageBatches(10)
+	if err != nil {
+		t.Fatalf("查询失败: %v", err)
+	}
+	if len(result) != 1 || result[0].BatchID != "stale" {
+		t.Fatalf("应只返回计费超时的批处理，实际: %v", result)
+	}
+
+	if claimed, err := ClaimMessageBatchBilling(stale.ID); err != nil || !claimed {
+		t.Fatalf("计费超时的批处理应能重新抢占: claimed=%v, err=%v", claimed, err)
+	}
+	if claimed, _ := ClaimMessageBatchBilling(stale.ID); claimed {
+		t.Error("重新抢占后应刷新抢占时间，不应再次被抢占")
+	}
+}
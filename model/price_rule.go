@@ -0,0 +1,177 @@
+package model
+
+import (
+	"claude-code-relay/common"
+)
+
+// 价格规则范围
+const (
+	PriceRuleScopeUser  = "user"
+	PriceRuleScopeGroup = "group"
+)
+
+// PriceRule 计费价格规则 - 在官方价格的基础上按用户或分组调整计费价格
+// 同一请求匹配多条规则时只使用最具体的一条：用户+模型 > 用户 > 分组+模型 > 分组
+// 模型名称与官方价格表一样按请求用量中的模型名称精确匹配（如claude-sonnet-4-20250514），不匹配别名或前缀
+type PriceRule struct {
+	ID              uint     `json:"id" gorm:"primaryKey"`
+	Scope           string   `json:"scope" gorm:"type:varchar(10);not null;uniqueIndex:idx_price_rules_scope_model;comment:规则范围(user/group)"`
+	ScopeID         uint     `json:"scope_id" gorm:"not null;uniqueIndex:idx_price_rules_scope_model;comment:用户ID或分组ID"`
+	ModelName       string   `json:"model_name" gorm:"type:varchar(100);not null;uniqueIndex:idx_price_rules_scope_model;comment:模型名称，为空表示所有模型"`
+	Multiplier      float64  `json:"multiplier" gorm:"type:decimal(10,4);not null;comment:价格倍率，在单价的基础上整体调整"`
+	InputPrice      *float64 `json:"input_price" gorm:"comment:覆盖的输入单价(USD/1M tokens)，为空使用官方价格"`
+	OutputPrice     *float64 `json:"output_price" gorm:"comment:覆盖的输出单价(USD/1M tokens)，为空使用官方价格"`
+	CacheWritePrice *float64 `json:"cache_write_price" gorm:"comment:覆盖的缓存写入单价(USD/1M tokens)，为空使用官方价格"`
+	CacheReadPrice  *float64 `json:"cache_read_price" gorm:"comment:覆盖的缓存读取单价(USD/1M tokens)，为空使用官方价格"`
+	Remark          string   `json:"remark" gorm:"type:varchar(255);comment:备注"`
+	CreatedAt       Time     `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
+	UpdatedAt       Time     `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
+}
+
+// CreatePriceRuleRequest 创建价格规则请求，倍率为空时默认为1
+type CreatePriceRuleRequest struct {
+	Scope           string   `json:"scope" binding:"required,oneof=user group"`
+	ScopeID         uint     `json:"scope_id" binding:"required"`
+	ModelName       string   `json:"model_name" binding:"max=100"`
+	Multiplier      *float64 `json:"multiplier" binding:"omitempty,min=0,max=100"`
+	InputPrice      *float64 `json:"input_price" binding:"omitempty,min=0"`
+	OutputPrice     *float64 `json:"output_price" binding:"omitempty,min=0"`
+	CacheWritePrice *float64 `json:"cache_write_price" binding:"omitempty,min=0"`
+	CacheReadPrice  *float64 `json:"cache_read_price" binding:"omitempty,min=0"`
+	Remark          string   `json:"remark" binding:"max=255"`
+}
+
+// UpdatePriceRuleRequest 更新价格规则，覆盖单价为空时恢复使用官方价格
+type UpdatePriceRuleRequest struct {
+	ModelName       string   `json:"model_name" binding:"max=100"`
+	Multiplier      *float64 `json:"multiplier" binding:"omitempty,min=0,max=100"`
+	InputPrice      *float64 `json:"input_price" binding:"omitempty,min=0"`
+	OutputPrice     *float64 `json:"output_price" binding:"omitempty,min=0"`
+	CacheWritePrice *float64 `json:"cache_write_price" binding:"omitempty,min=0"`
+	CacheReadPrice  *float64 `json:"cache_read_price" binding:"omitempty,min=0"`
+	Remark          string   `json:"remark" binding:"max=255"`
+}
+
+// PriceRuleListResult 价格规则列表响应结构
+type PriceRuleListResult struct {
+	Rules []PriceRule `json:"rules"`
+	Total int64       `json:"total"`
+	Page  int         `json:"page"`
+	Limit int         `json:"limit"`
+}
+
+func (r *PriceRule) TableName() string {
+	return "price_rules"
+}
+
+// CreatePriceRule 创建价格规则
+func CreatePriceRule(rule *PriceRule) error {
+	rule.ID = 0
+	return DB.Create(rule).Error
+}
+
+// GetPriceRuleByID 根据ID获取价格规则
+func GetPriceRuleByID(id uint) (*PriceRule, error) {
+	var rule PriceRule
+	err := DB.First(&rule, id).Error
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// UpdatePriceRule 更新价格规则
+func UpdatePriceRule(rule *PriceRule) error {
+	return DB.Save(rule).Error
+}
+
+// DeletePriceRule 删除价格规则
+func DeletePriceRule(id uint) error {
+	return DB.Delete(&PriceRule{}, id).Error
+}
+
+// GetPriceRules 分页获取价格规则，scope为空时不筛选范围，scopeID为0时不筛选用户或分组
+func GetPriceRules(scope string, scopeID uint, page, limit int) ([]PriceRule, int64, error) {
+	var rules []PriceRule
+	var total int64
+
+	query := DB.Model(&PriceRule{})
+	if scope != "" {
+		query = query.Where("scope = ?", scope)
+	}
+	if scopeID > 0 {
+		query = query.Where("scope_id = ?", scopeID)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	if err := query.Order("scope ASC, scope_id ASC, model_name ASC").Offset(offset).Limit(limit).Find(&rules).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return rules, total, nil
+}
+
+// GetPriceAdjustment 获取用户通过API Key调用模型时的计费价格调整，没有匹配的规则时返回nil（按官方价格计费）
+// modelName为用量中的模型名称，只匹配名称完全相同的规则和所有模型的规则
+func GetPriceAdjustment(userID, apiKeyID uint, modelName string) *common.PriceAdjustment {
+	var rules []PriceRule
+	err := DB.Where("(scope = ? AND scope_id = ?) OR (scope = ? AND scope_id = (SELECT group_id FROM api_keys WHERE id = ?))",
+		PriceRuleScopeUser, userID, PriceRuleScopeGroup, apiKeyID).
+		Where("model_name IN ?", []string{"", modelName}).
+		Find(&rules).Error
+	if err != nil {
+		common.SysError("查询价格规则失败: " + err.Error())
+		return nil
+	}
+
+	var matched *PriceRule
+	for i := range rules {
+		if matched == nil || priceRuleRank(&rules[i]) > priceRuleRank(matched) {
+			matched = &rules[i]
+		}
+	}
+	if matched == nil {
+		return nil
+	}
+	return matched.adjustment(modelName)
+}
+
+// priceRuleRank 规则的优先级，用户规则优先于分组规则，指定模型的规则优先于所有模型的规则
+func priceRuleRank(rule *PriceRule) int {
+	rank := 0
+	if rule.Scope == PriceRuleScopeUser {
+		rank += 2
+	}
+	if rule.ModelName != "" {
+		rank++
+	}
+	return rank
+}
+
+// adjustment 将规则转换为费用计算器使用的价格调整
+func (r *PriceRule) adjustment(modelName string) *common.PriceAdjustment {
+	adjustment := &common.PriceAdjustment{Multiplier: r.Multiplier}
+	if r.InputPrice == nil && r.OutputPrice == nil && r.CacheWritePrice == nil && r.CacheReadPrice == nil {
+		return adjustment
+	}
+
+	pricing := common.GetModelPricing(modelName)
+	if r.InputPrice != nil {
+		pricing.Input = *r.InputPrice
+	}
+	if r.OutputPrice != nil {
+		pricing.Output = *r.OutputPrice
+	}
+	if r.CacheWritePrice != nil {
+		pricing.CacheWrite = *r.CacheWritePrice
+	}
+	if r.CacheReadPrice != nil {
+		pricing.CacheRead = *r.CacheReadPrice
+	}
+	adjustment.Pricing = &pricing
+	return adjustment
+}
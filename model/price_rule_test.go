@@ -0,0 +1,104 @@
+package model
+
+import (
+	"claude-code-relay/common"
+	"math"
+	"testing"
+)
+
+// TestGetPriceAdjustmentPrecedence 用户+模型 > 用户 > 分组+模型 > 分组，模型名称精确匹配
+func TestGetPriceAdjustmentPrecedence(t *testing.T) {
+	setupTestDB(t)
+	const modelName = "claude-sonnet-4-20250514"
+	if err := DB.Create(&ApiKey{ID: 1, Name: "k1", Key: "sk-1", UserID: 1, GroupID: 10}).Error; err != nil {
+		t.Fatalf("创建API Key失败: %v", err)
+	}
+
+	if adjustment := GetPriceAdjustment(1, 1, modelName); adjustment != nil {
+		t.Fatalf("没有规则时应返回nil: %+v", adjustment)
+	}
+
+	// 按从宽到窄的顺序添加规则，每次都应使用最具体的规则
+	rules := []PriceRule{
+		{Scope: PriceRuleScopeGroup, ScopeID: 10, Multiplier: 0.9},
+		{Scope: PriceRuleScopeGroup, ScopeID: 10, ModelName: modelName, Multiplier: 0.8},
+		{Scope: PriceRuleScopeUser, ScopeID: 1, Multiplier: 0.7},
+		{Scope: PriceRuleScopeUser, ScopeID: 1, ModelName: modelName, Multiplier: 0.6},
+	}
+	for i := range rules {
+		if err := CreatePriceRule(&rules[i]); err != nil {
+			t.Fatalf("创建价格规则失败: %v", err)
+		}
+		adjustment := GetPriceAdjustment(1, 1, modelName)
+		if adjustment == nil || adjustment.Multiplier != rules[i].Multiplier {
+			t.Errorf("添加 %s/%q 规则后应使用倍率 %v, 实际: %+v", rules[i].Scope, rules[i].ModelName, rules[i].Multiplier, adjustment)
+		}
+	}
+
+	cases := []struct {
+		name      string
+		userID    uint
+		apiKeyID  uint
+		modelName string
+		expected  float64
+	}{
+		{"其他模型使用用户规则", 1, 1, "claude-opus-4-20250514", 0.7},
+		{"模型别名不匹配指定模型的规则", 1, 1, "claude-sonnet-4", 0.7},
+		{"其他用户使用分组+模型规则", 2, 1, modelName, 0.8},
+		{"其他用户其他模型使用分组规则", 2, 1, "claude-opus-4-20250514", 0.9},
+	}
+	for _, tc := range cases {
+		adjustment := GetPriceAdjustment(tc.userID, tc.apiKeyID, tc.modelName)
+		if adjustment == nil || adjustment.Multiplier != tc.expected {
+			t.Errorf("%s: 应使用倍率 %v, 实际: %+v", tc.name, tc.expected, adjustment)
+		}
+	}
+	if adjustment := GetPriceAdjustment(2, 0, modelName); adjustment != nil {
+		t.Errorf("不属于分组且没有用户规则时应返回nil: %+v", adjustment)
+	}
+}
+
+// TestPriceRuleBilledCost 覆盖单价后再乘倍率，倍率为0时免费，批处理在调整后的价格上减半
+func TestPriceRuleBilledCost(t *testing.T) {
+	setupTestDB(t)
+	const modelName = "claude-sonnet-4-20250514"
+	inputPrice := 1.0
+	rules := []PriceRule{
+		{Scope: PriceRuleScopeUser, ScopeID: 1, ModelName: modelName, Multiplier: 2, InputPrice: &inputPrice},
+		{Scope: PriceRuleScopeUser, ScopeID: 2, Multiplier: 0},
+	}
+	for i := range rules {
+		if err := CreatePriceRule(&rules[i]); err != nil {
+			t.Fatalf("创建价格规则失败: %v", err)
+		}
+	}
+
+	usage := &common.TokenUsage{Model: modelName, InputTokens: 1000000, OutputTokens: 1000000}
+	cases := []struct {
+		name     string
+		userID   uint
+		batch    bool
+		expected float64
+	}{
+		{"覆盖输入单价并乘倍率", 1, false, (1 + 15) * 2},
+		{"批处理在调整后的价格上减半", 1, true, (1 + 15) * 2 * common.BatchPricingRate},
+		{"倍率为0免费", 2, false, 0},
+		{"倍率为0的批处理免费", 2, true, 0},
+		{"没有规则按官方价格", 3, false, 3 + 15},
+	}
+	for _, tc := range cases {
+		var log *Log
+		var err error
+		if tc.batch {
+			log, err = CreateBatchLogFromTokenUsage(usage, tc.userID, 0, 0, tc.name)
+		} else {
+			log, err = CreateLogFromTokenUsage(usage, tc.userID, 0, 0, 1000, false)
+		}
+		if err != nil {
+			t.Fatalf("%s: 写入日志失败: %v", tc.name, err)
+		}
+		if math.Abs(log.TotalCost-tc.expected) > 1e-9 {
+			t.Errorf("%s: 计费费用应为 %v, 实际: %v", tc.name, tc.expected, log.TotalCost)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// 兑换码状态
+const (
+	RedemptionStatusDisabled = 0 // 停用
+	RedemptionStatusEnabled  = 1 // 启用
+)
+
+var (
+	ErrRedemptionNotFound  = errors.New("兑换码不存在")
+	ErrRedemptionDisabled  = errors.New("兑换码已停用")
+	ErrRedemptionExpired   = errors.New("兑换码已过期")
+	ErrRedemptionExhausted = errors.New("兑换码已达到使用次数上限")
+)
+
+// RedemptionCode 兑换码 - 同一批次的兑换码面值、次数上限和过期时间相同
+type RedemptionCode struct {
+	ID        uint    `json:"id" gorm:"primaryKey"`
+	Code      string  `json:"code" gorm:"type:varchar(32);not null;uniqueIndex;comment:兑换码"`
+	BatchNo   string  `json:"batch_no" gorm:"type:varchar(32);not null;index;comment:批次号"`
+	Name      string  `json:"name" gorm:"type:varchar(100);comment:批次名称"`
+	Value     float64 `json:"value" gorm:"type:decimal(20,8);not null;comment:面值(USD)"`
+	MaxUses   int     `json:"max_uses" gorm:"default:1;comment:使用次数上限，每个用户只能兑换一次"`
+	UsedCount int     `json:"used_count" gorm:"default:0;comment:已使用次数"`
+	ExpiresAt *Time   `json:"expires_at" gorm:"type:datetime;comment:过期时间，为空表示不过期"`
+	Status    int     `json:"status" gorm:"default:1;comment:状态(1:启用,0:停用)"`
+	CreatedBy uint    `json:"created_by" gorm:"comment:创建的管理员ID"`
+	CreatedAt Time    `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
+	UpdatedAt Time    `json:"updated_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP"`
+}
+
+// RedemptionRecord 兑换记录 - 兑换码和用户唯一，重复兑换返回已有记录
+type RedemptionRecord struct {
+	ID        uint    `json:"id" gorm:"primaryKey"`
+	CodeID    uint    `json:"code_id" gorm:"not null;uniqueIndex:idx_redemption_records_code_user;comment:兑换码ID"`
+	UserID    uint    `json:"user_id" gorm:"not null;uniqueIndex:idx_redemption_records_code_user;index;comment:用户ID"`
+	Amount    float64 `json:"amount" gorm:"type:decimal(20,8);not null;comment:兑换金额(USD)"`
+	LedgerID  uint    `json:"ledger_id" gorm:"comment:对应的余额流水ID"`
+	CreatedAt Time    `json:"created_at" gorm:"type:datetime;default:CURRENT_TIMESTAMP"`
+}
+
+// CreateRedemptionCodesRequest 批量生成兑换码请求
+type CreateRedemptionCodesRequest struct {
+	Name      string  `json:"name" binding:"max=100"`
+	Value     float64 `json:"value" binding:"required,gt=0"`
+	Count     int     `json:"count" binding:"required,min=1,max=1000"`
+	MaxUses   int     `json:"max_uses" binding:"omitempty,min=1"`
+	ExpiresAt *Time   `json:"expires_at"`
+}
+
+// RedemptionCodeListResult 兑换码列表响应结构
+type RedemptionCodeListResult struct {
+	Codes []RedemptionCode `json:"codes"`
+	Total int64            `json:"total"`
+	Page  int              `json:"page"`
+	Limit int              `json:"limit"`
+}
+
+func (r *RedemptionCode) TableName() string {
+	return "redemption_codes"
+}
+
+func (r *RedemptionRecord) TableName() string {
+	return "redemption_records"
+}
+
+// CreateRedemptionCodes 批量创建兑换码
+func CreateRedemptionCodes(codes []RedemptionCode) error {
+	return DB.CreateInBatches(codes, 100).Error
+}
+
+// GetRedemptionCodes 分页获取兑换码列表，batchNo为空时不筛选批次，status为nil时不筛选状态
+func GetRedemptionCodes(batchNo string, status *int, page, limit int) ([]RedemptionCode, int64, error) {
+	var codes []RedemptionCode
+	var total int64
+
+	query := DB.Model(&RedemptionCode{})
+	if batchNo != "" {
+		query = query.Where("batch_no = ?", batchNo)
+	}
+	if status != nil {
+		query = query.Where("status = ?", *status)
+	}
+
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	offset := (page - 1) * limit
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&codes).Error; err != nil {
+		return nil, 0, err
+	}
+
+	return codes, total, nil
+}
+
+// GetRedemptionCodesByBatch 获取批次下的所有兑换码，用于导出
+func GetRedemptionCodesByBatch(batchNo string) ([]RedemptionCode, error) {
+	var codes []RedemptionCode
+	err := DB.Where("batch_no = ?", batchNo).Order("id ASC").Find(&codes).Error
+	return codes, err
+}
+
+// UpdateRedemptionCodeStatus 更新兑换码状态
+func UpdateRedemptionCodeStatus(id uint, status int) error {
+	result := DB.Model(&RedemptionCode{}).Where("id = ?", id).Update("status", status)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRedemptionNotFound
+	}
+	return nil
+}
+
+// RedeemCode 兑换兑换码并充值到用户余额，锁定兑换码后依次校验并写入兑换记录、余额流水和使用次数
+// 用户已兑换过该兑换码时不重复充值，返回已有记录且duplicate为true
+func RedeemCode(code string, userID uint) (record *RedemptionRecord, balance *UserBalance, duplicate bool, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		var redemption RedemptionCode
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("code = ?", code).
+			First(&redemption).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRedemptionNotFound
+			}
+			return err
+		}
+
+		var existing RedemptionRecord
+		err := tx.Where("code_id = ? AND user_id = ?", redemption.ID, userID).First(&existing).Error
+		if err == nil {
+			record = &existing
+			duplicate = true
+			balance = &UserBalance{}
+			return tx.Where("user_id = ?", userID).First(balance).Error
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		switch {
+		case redemption.Status != RedemptionStatusEnabled:
+			return ErrRedemptionDisabled
+		case redemption.ExpiresAt != nil && time.Time(*redemption.ExpiresAt).Before(time.Now()):
+			return ErrRedemptionExpired
+		case redemption.UsedCount >= redemption.MaxUses:
+			return ErrRedemptionExhausted
+		}
+
+		if err := ensureUserBalance(tx, userID); err != nil {
+			return err
+		}
+		entry := &BalanceLedger{
+			UserID: userID,
+			Type:   LedgerTypeRedeem,
+			Amount: redemption.Value,
+			Remark: "兑换码 " + redemption.Code,
+		}
+		balance, err = applyBalanceChange(tx, entry, nil)
+		if err != nil {
+			return err
+		}
+
+		record = &RedemptionRecord{
+			CodeID:   redemption.ID,
+			UserID:   userID,
+			Amount:   redemption.Value,
+			LedgerID: entry.ID,
+		}
+		if err := tx.Create(record).Error; err != nil {
+			return err
+		}
+		return tx.Model(&RedemptionCode{}).Where("id = ?", redemption.ID).
+			Update("used_count", gorm.Expr("used_count + 1")).Error
+	})
+	if err != nil {
+		return nil, nil, false, err
+	}
+	return record, balance, duplicate, nil
+}
@@ -0,0 +1,75 @@
+package model
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestRedeemCode 重复兑换不重复充值，使用次数上限跨用户生效，停用和过期的兑换码不能兑换
+func TestRedeemCode(t *testing.T) {
+	setupTestDB(t)
+
+	expiredAt := Time(time.Now().Add(-time.Hour))
+	codes := []RedemptionCode{
+		{Code: "SHARED", BatchNo: "b1", Value: 5, MaxUses: 2},
+		{Code: "EXPIRED", BatchNo: "b1", Value: 5, MaxUses: 1, ExpiresAt: &expiredAt},
+		{Code: "DISABLED", BatchNo: "b1", Value: 5, MaxUses: 1},
+	}
+	if err := CreateRedemptionCodes(codes); err != nil {
+		t.Fatalf("创建兑换码失败: %v", err)
+	}
+	if err := UpdateRedemptionCodeStatus(codes[2].ID, RedemptionStatusDisabled); err != nil {
+		t.Fatalf("停用兑换码失败: %v", err)
+	}
+
+	record, balance, duplicate, err := RedeemCode("SHARED", 1)
+	if err != nil || duplicate {
+		t.Fatalf("首次兑换应成功: %v, duplicate=%v", err, duplicate)
+	}
+	if balance.Balance != 5 || record.Amount != 5 || record.LedgerID == 0 {
+		t.Errorf("兑换结果不正确: 记录 %+v, 余额 %+v", record, balance)
+	}
+
+	// 同一用户重复兑换返回已有记录，不重复充值也不增加使用次数
+	again, balance, duplicate, err := RedeemCode("SHARED", 1)
+	if err != nil || !duplicate {
+		t.Fatalf("重复兑换应返回已有记录: %v, duplicate=%v", err, duplicate)
+	}
+	if again.ID != record.ID || balance.Balance != 5 {
+		t.Errorf("重复兑换不应再次充值: 记录 %+v, 余额 %+v", again, balance)
+	}
+
+	if _, _, _, err := RedeemCode("SHARED", 2); err != nil {
+		t.Fatalf("第二个用户兑换应成功: %v", err)
+	}
+	if _, _, _, err := RedeemCode("SHARED", 3); !errors.Is(err, ErrRedemptionExhausted) {
+		t.Errorf("超过使用次数上限应返回 ErrRedemptionExhausted, 实际: %v", err)
+	}
+	// 已兑换过的用户在达到上限后仍返回已有记录
+	if _, _, duplicate, err := RedeemCode("SHARED", 2); err != nil || !duplicate {
+		t.Errorf("已兑换用户应返回已有记录: %v, duplicate=%v", err, duplicate)
+	}
+
+	for code, expected := range map[string]error{
+		"EXPIRED":  ErrRedemptionExpired,
+		"DISABLED": ErrRedemptionDisabled,
+		"MISSING":  ErrRedemptionNotFound,
+	} {
+		if _, _, _, err := RedeemCode(code, 3); !errors.Is(err, expected) {
+			t.Errorf("兑换 %s 应返回 %v, 实际: %v", code, expected, err)
+		}
+	}
+
+	var shared RedemptionCode
+	DB.Where("code = ?", "SHARED").First(&shared)
+	var recordCount, ledgerCount int64
+	DB.Model(&RedemptionRecord{}).Count(&recordCount)
+	DB.Model(&BalanceLedger{}).Where("type = ?", LedgerTypeRedeem).Count(&ledgerCount)
+	if shared.UsedCount != 2 || recordCount != 2 || ledgerCount != 2 {
+		t.Errorf("应使用2次并记录2条兑换记录和流水, 实际使用 %d 次, %d 条记录, %d 条流水", shared.UsedCount, recordCount, ledgerCount)
+	}
+	if _, err := GetUserBalance(3); err == nil {
+		t.Error("兑换失败的用户不应创建余额记录")
+	}
+}
@@ -0,0 +1,517 @@
+package relay
+
+import (
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Bedrock 账号字段约定：
+// Region 为AWS区域，AccessKeyID 为访问密钥ID，SecretKey 为私有访问密钥，
+// AccessToken 可选，使用临时凭证时存放 session token；RequestURL 可选，用于覆盖默认的 bedrock-runtime 地址
+
+const (
+	bedrockServiceName      = "bedrock"
+	bedrockAnthropicVersion = "bedrock-2023-05-31"
+	awsSigningAlgorithm     = "AWS4-HMAC-SHA256"
+	awsTimeFormat           = "20060102T150405Z"
+	awsDateFormat           = "20060102"
+)
+
+// HandleBedrockRequest 处理AWS Bedrock平台的请求
+func HandleBedrockRequest(c *gin.Context, account *model.Account, requestBody []byte) {
+	startTime := time.Now()
+
+	apiKey := extractAPIKey(c)
+
+	claudeModel := gjson.GetBytes(requestBody, "model").String()
+	isStream := gjson.GetBytes(requestBody, "stream").Bool()
+
+	body, err := buildBedrockRequestBody(requestBody)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]interface{}{
+				"type":    "json_parse_error",
+				"message": "Failed to build Bedrock request: " + err.Error(),
+			},
+		})
+		return
+	}
+
+	client := createHTTPClient(account)
+	if client == nil {
+		c.JSON(http.StatusInternalServerError, errProxyConfig)
+		return
+	}
+
+	modelID := resolveBedrockModelID(claudeModel, account.ModelMapping)
+	req, err := createBedrockRequest(c.Request.Context(), account, modelID, body, isStream, time.Now())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errCreateRequest, err.Error()))
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		handleRequestError(c, err)
+		return
+	}
+	defer common.CloseIO(resp.Body)
+
+	var usageTokens *common.TokenUsage
+	if resp.StatusCode < statusBadRequest {
+		c.Status(resp.StatusCode)
+		if isStream {
+			setStreamResponseHeaders(c)
+			c.Header("Content-Type", "text/event-stream")
+			c.Writer.Flush()
+
+			// 将AWS event-stream二进制帧还原为Anthropic SSE后复用通用的流式解析
+			usageTokens, err = common.ParseStreamResponse(c.Writer, NewBedrockEventStreamReader(resp.Body))
+			if err != nil {
+				log.Println("bedrock stream copy and parse failed:", err.Error())
+			}
+		} else {
+			responseData, err := io.ReadAll(resp.Body)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, appendErrorMessage(errResponseRead, err.Error()))
+				return
+			}
+			c.Data(resp.StatusCode, "application/json", responseData)
+			usageTokens, _ = common.ParseJSONResponse(responseData)
+		}
+
+		// 计费按客户端请求的模型名称，Bedrock返回的模型ID可能无法匹配定价表
+		if usageTokens != nil && claudeModel != "" {
+			usageTokens.Model = claudeModel
+		}
+	} else {
+		handleErrorResponse(c, resp, resp.Body, account)
+	}
+
+	// 对冲请求中落败的一方不计费也不记录日志
+	if isHedgeLoser(c) {
+		return
+	}
+
+	// 流在首个内容前被fallback判定失败时按失败记录
+	statusCode := fallbackStatusCode(c, resp.StatusCode)
+
+	updateAccountAndStats(account, statusCode, usageTokens)
+
+	if apiKey != nil {
+		go service.UpdateApiKeyStatus(apiKey, statusCode, usageTokens)
+	}
+
+	saveRequestLog(startTime, apiKey, account, statusCode, usageTokens, isStream)
+}
+
+// buildBedrockRequestBody 将Anthropic请求体转换为Bedrock InvokeModel请求体
+// Bedrock通过URL指定模型和流式模式，请求体中不能携带model、stream和metadata字段
+func buildBedrockRequestBody(requestBody []byte) ([]byte, error) {
+	if !gjson.ValidBytes(requestBody) {
+		return nil, errors.New("invalid JSON body")
+	}
+
+	body := requestBody
+	var err error
+	for _, field := range []string{"model", "stream", "metadata"} {
+		if body, err = sjson.DeleteBytes(body, field); err != nil {
+			return nil, err
+		}
+	}
+	return sjson.SetBytes(body, "anthropic_version", bedrockAnthropicVersion)
+}
+
+// resolveBedrockModelID 解析Bedrock模型ID，优先使用账号的模型映射
+// 未配置映射时按 anthropic.{model}-v1:0 规则生成，已经是Bedrock模型ID的直接使用
+func resolveBedrockModelID(claudeModel, modelMapping string) string {
+	defaultModelID := claudeModel
+	if !strings.Contains(claudeModel, "anthropic.") {
+		defaultModelID = "anthropic." + claudeModel + "-v1:0"
+	}
+	return applyModelMapping(claudeModel, modelMapping, defaultModelID)
+}
+
+// bedrockEndpoint 获取Bedrock运行时地址
+func bedrockEndpoint(account *model.Account) string {
+	if account.RequestURL != "" {
+		return strings.TrimRight(account.RequestURL, "/")
+	}
+	return fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", account.Region)
+}
+
+// createBedrockRequest 创建并签名Bedrock请求
+func createBedrockRequest(ctx context.Context, account *model.Account, modelID string, body []byte, stream bool, signTime time.Time) (*http.Request, error) {
+	if account.Region == "" || account.AccessKeyID == "" || account.SecretKey == "" {
+		return nil, errors.New("bedrock账号缺少region或访问密钥")
+	}
+
+	action := "invoke"
+	if stream {
+		action = "invoke-with-response-stream"
+	}
+
+	// 模型ID中包含冒号等字符，需要按AWS规则编码后放入路径
+	escapedPath := "/model/" + awsURIEncode(modelID) + "/" + action
+	req, err := http.NewRequestWithContext(ctx, "POST", bedrockEndpoint(account)+escapedPath, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	if stream {
+		req.Header.Set("Accept", "application/vnd.amazon.eventstream")
+	} else {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	signAWSRequest(req, body, account.AccessKeyID, account.SecretKey, account.AccessToken, account.Region, bedrockServiceName, signTime)
+	return req, nil
+}
+
+// signAWSRequest 使用AWS Signature Version 4对请求签名
+func signAWSRequest(req *http.Request, body []byte, accessKeyID, secretAccessKey, sessionToken, region, service string, signTime time.Time) {
+	signTime = signTime.UTC()
+	amzDate := signTime.Format(awsTimeFormat)
+	dateStamp := signTime.Format(awsDateFormat)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	if sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", sessionToken)
+	}
+
+	payloadHash := sha256Hex(body)
+
+	// 规范化请求头：host 与所有已设置的请求头（小写、排序）
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	headerNames := make([]string, 0, len(headers))
+	for name := range headers {
+		headerNames = append(headerNames, name)
+	}
+	sort.Strings(headerNames)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range headerNames {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(headerNames, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		awsCanonicalURI(req.URL.EscapedPath()),
+		awsCanonicalQuery(req),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	credentialScope := strings.Join([]string{dateStamp, region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{
+		awsSigningAlgorithm,
+		amzDate,
+		credentialScope,
+		sha256Hex([]byte(canonicalRequest)),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+secretAccessKey), dateStamp)
+	signingKey = hmacSHA256(signingKey, region)
+	signingKey = hmacSHA256(signingKey, service)
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		awsSigningAlgorithm, accessKeyID, credentialScope, signedHeaders, signature))
+}
+
+// awsCanonicalURI 生成规范URI，非S3服务需要对已编码的路径段再编码一次
+func awsCanonicalURI(escapedPath string) string {
+	if escapedPath == "" {
+		return "/"
+	}
+	segments := strings.Split(escapedPath, "/")
+	for i, segment := range segments {
+		segments[i] = awsURIEncode(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// awsCanonicalQuery 生成规范查询字符串
+func awsCanonicalQuery(req *http.Request) string {
+	query := req.URL.Query()
+	if len(query) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var pairs []string
+	for _, key := range keys {
+		values := query[key]
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, awsURIEncode(key)+"="+awsURIEncode(value))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode 按AWS规则进行URI编码，仅保留 A-Z a-z 0-9 - _ . ~
+func awsURIEncode(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		ch := s[i]
+		if (ch >= 'A' && ch <= 'Z') || (ch >= 'a' && ch <= 'z') || (ch >= '0' && ch <= '9') ||
+			ch == '-' || ch == '_' || ch == '.' || ch == '~' {
+			builder.WriteByte(ch)
+		} else {
+			fmt.Fprintf(&builder, "%%%02X", ch)
+		}
+	}
+	return builder.String()
+}
+
+// sha256Hex 计算SHA256并返回十六进制字符串
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// hmacSHA256 计算HMAC-SHA256
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// BedrockEventStreamReader 将AWS event-stream二进制帧解码为Anthropic SSE文本的Reader
+type BedrockEventStreamReader struct {
+	src     io.Reader
+	pending bytes.Buffer
+	err     error
+}
+
+// NewBedrockEventStreamReader 创建event-stream解码器
+func NewBedrockEventStreamReader(src io.Reader) *BedrockEventStreamReader {
+	return &BedrockEventStreamReader{src: src}
+}
+
+// Read 每次解码一帧，输出对应的SSE事件
+func (r *BedrockEventStreamReader) Read(p []byte) (int, error) {
+	for r.pending.Len() == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		if err := r.decodeNextMessage(); err != nil {
+			r.err = err
+		}
+	}
+	return r.pending.Read(p)
+}
+
+// decodeNextMessage 读取并解码一条event-stream消息
+// 帧格式：总长度(4) 头部长度(4) 前导CRC(4) 头部 负载 消息CRC(4)
+func (r *BedrockEventStreamReader) decodeNextMessage() error {
+	prelude := make([]byte, 12)
+	if _, err := io.ReadFull(r.src, prelude); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return fmt.Errorf("event-stream prelude truncated: %w", err)
+		}
+		return err
+	}
+
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[0:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return errors.New("event-stream prelude checksum mismatch")
+	}
+	if totalLength < 16 || headersLength > totalLength-16 {
+		return fmt.Errorf("invalid event-stream message length: %d", totalLength)
+	}
+
+	message := make([]byte, totalLength-12)
+	if _, err := io.ReadFull(r.src, message); err != nil {
+		return fmt.Errorf("event-stream message truncated: %w", err)
+	}
+
+	messageCRC := crc32.ChecksumIEEE(prelude)
+	messageCRC = crc32.Update(messageCRC, crc32.IEEETable, message[:len(message)-4])
+	if messageCRC != binary.BigEndian.Uint32(message[len(message)-4:]) {
+		return errors.New("event-stream message checksum mismatch")
+	}
+
+	headers, err := parseEventStreamHeaders(message[:headersLength])
+	if err != nil {
+		return err
+	}
+	payload := message[headersLength : len(message)-4]
+
+	switch headers[":message-type"] {
+	case "event":
+		if headers[":event-type"] != "chunk" {
+			return nil
+		}
+		// chunk负载为 {"bytes":"base64编码的Anthropic事件JSON"}
+		encoded := gjson.GetBytes(payload, "bytes").String()
+		eventData, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return fmt.Errorf("decode chunk bytes failed: %w", err)
+		}
+		eventType := gjson.GetBytes(eventData, "type").String()
+		fmt.Fprintf(&r.pending, "event: %s\ndata: %s\n\n", eventType, eventData)
+	case "exception", "error":
+		errorType := headers[":exception-type"]
+		if errorType == "" {
+			errorType = headers[":error-code"]
+		}
+		errorMessage := gjson.GetBytes(payload, "message").String()
+		if errorMessage == "" {
+			errorMessage = headers[":error-message"]
+		}
+		errorData, _ := json.Marshal(map[string]interface{}{
+			"type": "error",
+			"error": map[string]interface{}{
+				"type":    mapBedrockExceptionType(errorType),
+				"message": errorMessage,
+			},
+		})
+		fmt.Fprintf(&r.pending, "event: error\ndata: %s\n\n", errorData)
+	}
+
+	return nil
+}
+
+// mapBedrockExceptionType 将Bedrock异常名称映射为Anthropic错误类型，便于fallback按错误类型判断状态码
+func mapBedrockExceptionType(exceptionType string) string {
+	switch exceptionType {
+	case "throttlingException", "serviceQuotaExceededException":
+		return "rate_limit_error"
+	case "serviceUnavailableException", "modelNotReadyException":
+		return "overloaded_error"
+	case "validationException":
+		return "invalid_request_error"
+	case "accessDeniedException":
+		return "permission_error"
+	case "resourceNotFoundException":
+		return "not_found_error"
+	default:
+		// internalServerException、modelStreamErrorException、modelTimeoutException等
+		return "api_error"
+	}
+}
+
+// parseEventStreamHeaders 解析event-stream头部，只保留字符串类型的值
+func parseEventStreamHeaders(data []byte) (map[string]string, error) {
+	headers := make(map[string]string)
+	errTruncated := errors.New("event-stream header truncated")
+
+	for len(data) > 0 {
+		nameLength := int(data[0])
+		if len(data) < 1+nameLength+1 {
+			return nil, errTruncated
+		}
+		name := string(data[1 : 1+nameLength])
+		valueType := data[1+nameLength]
+		data = data[2+nameLength:]
+
+		// 各类型值的长度：0/1布尔无值，2字节，3短整型，4整型，5长整型，6字节数组，7字符串，8时间戳，9 UUID
+		var valueLength int
+		switch valueType {
+		case 0, 1:
+			valueLength = 0
+		case 2:
+			valueLength = 1
+		case 3:
+			valueLength = 2
+		case 4:
+			valueLength = 4
+		case 5, 8:
+			valueLength = 8
+		case 9:
+			valueLength = 16
+		case 6, 7:
+			if len(data) < 2 {
+				return nil, errTruncated
+			}
+			valueLength = int(binary.BigEndian.Uint16(data[0:2]))
+			data = data[2:]
+		default:
+			return nil, fmt.Errorf("unknown event-stream header type: %d", valueType)
+		}
+
+		if len(data) < valueLength {
+			return nil, errTruncated
+		}
+		if valueType == 7 {
+			headers[name] = string(data[:valueLength])
+		}
+		data = data[valueLength:]
+	}
+
+	return headers, nil
+}
+
+// TestHandleBedrockRequest 测试Bedrock账号连通性，返回状态码和错误信息
+func TestHandleBedrockRequest(account *model.Account) (int, string) {
+	return testBedrockRequest(account, []byte(GetTestRequestBody(100)))
+}
+
+// testBedrockRequest 使用指定请求体测试Bedrock账号连通性
+func testBedrockRequest(account *model.Account, requestBody []byte) (int, string) {
+	body, err := buildBedrockRequestBody(requestBody)
+	if err != nil {
+		return http.StatusBadRequest, "Failed to build Bedrock request: " + err.Error()
+	}
+
+	modelID := resolveBedrockModelID(gjson.GetBytes(requestBody, "model").String(), account.ModelMapping)
+	req, err := createBedrockRequest(context.Background(), account, modelID, body, false, time.Now())
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to create request: " + err.Error()
+	}
+
+	client := createHTTPClient(account)
+	if client == nil {
+		return http.StatusInternalServerError, "Failed to create HTTP client"
+	}
+	client.Timeout = 30 * time.Second
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return http.StatusInternalServerError, "Request failed: " + err.Error()
+	}
+	defer common.CloseIO(resp.Body)
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(bodyBytes)
+	}
+
+	return resp.StatusCode, ""
+}
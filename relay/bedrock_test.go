@@ -0,0 +1,193 @@
+package relay
+
+import (
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"context"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tidwall/gjson"
+)
+
+// encodeEventStreamMessage 按AWS event-stream格式编码一帧（仅字符串头部）
+func encodeEventStreamMessage(headers [][2]string, payload []byte) []byte {
+	var headerBuf bytes.Buffer
+	for _, header := range headers {
+		headerBuf.WriteByte(byte(len(header[0])))
+		headerBuf.WriteString(header[0])
+		headerBuf.WriteByte(7)
+		binary.Write(&headerBuf, binary.BigEndian, uint16(len(header[1])))
+		headerBuf.WriteString(header[1])
+	}
+
+	totalLength := uint32(12 + headerBuf.Len() + len(payload) + 4)
+	var message bytes.Buffer
+	binary.Write(&message, binary.BigEndian, totalLength)
+	binary.Write(&message, binary.BigEndian, uint32(headerBuf.Len()))
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+	message.Write(headerBuf.Bytes())
+	message.Write(payload)
+	binary.Write(&message, binary.BigEndian, crc32.ChecksumIEEE(message.Bytes()))
+	return message.Bytes()
+}
+
+// encodeBedrockChunk 编码一个携带Anthropic事件的chunk帧
+func encodeBedrockChunk(event string) []byte {
+	payload, _ := json.Marshal(map[string]string{
+		"bytes": base64.StdEncoding.EncodeToString([]byte(event)),
+	})
+	return encodeEventStreamMessage([][2]string{
+		{":event-type", "chunk"},
+		{":content-type", "application/json"},
+		{":message-type", "event"},
+	}, payload)
+}
+
+// TestSignAWSRequestVanilla 使用AWS官方SigV4测试用例（get-vanilla）校验签名
+func TestSignAWSRequestVanilla(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://example.amazonaws.com/", nil)
+	signTime, _ := time.Parse(awsTimeFormat, "20150830T123600Z")
+
+	signAWSRequest(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "", "us-east-1", "service", signTime)
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, " +
+		"Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("签名不正确:\n期望: %s\n实际: %s", expected, got)
+	}
+}
+
+// TestBedrockStreamAgainstFakeEndpoint 使用本地模拟的Bedrock端点验证签名请求与event-stream解码
+func TestBedrockStreamAgainstFakeEndpoint(t *testing.T) {
+	events := []string{
+		`{"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","model":"claude-sonnet-4-20250514","content":[],"usage":{"input_tokens":12,"output_tokens":1}}}`,
+		`{"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		`{"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+		`{"type":"content_block_stop","index":0}`,
+		`{"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":6}}`,
+		`{"type":"message_stop","amazon-bedrock-invocationMetrics":{"inputTokenCount":12,"outputTokenCount":7}}`,
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/model/anthropic.claude-sonnet-4-20250514-v1%3A0/invoke-with-response-stream" {
+			t.Errorf("请求路径不正确: %s", r.URL.EscapedPath())
+		}
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "AWS4-HMAC-SHA256 Credential=AKIDTEST/") || !strings.Contains(auth, "/us-west-2/bedrock/aws4_request") {
+			t.Errorf("Authorization头不正确: %s", auth)
+		}
+		if r.Header.Get("X-Amz-Date") == "" {
+			t.Error("缺少X-Amz-Date头")
+		}
+
+		body, _ := io.ReadAll(r.Body)
+		if gjson.GetBytes(body, "model").Exists() || gjson.GetBytes(body, "stream").Exists() {
+			t.Errorf("请求体不应包含model和stream字段: %s", body)
+		}
+		if gjson.GetBytes(body, "anthropic_version").String() != bedrockAnthropicVersion {
+			t.Errorf("请求体缺少anthropic_version: %s", body)
+		}
+
+		w.Header().Set("Content-Type", "application/vnd.amazon.eventstream")
+		w.WriteHeader(http.StatusOK)
+		for _, event := range events {
+			w.Write(encodeBedrockChunk(event))
+		}
+	}))
+	defer server.Close()
+
+	account := &model.Account{
+		RequestURL:  server.URL,
+		Region:      "us-west-2",
+		AccessKeyID: "AKIDTEST",
+		SecretKey:   "secret",
+	}
+
+	body, err := buildBedrockRequestBody([]byte(`{"model":"claude-sonnet-4-20250514","stream":true,"max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatalf("构建请求体失败: %v", err)
+	}
+
+	modelID := resolveBedrockModelID("claude-sonnet-4-20250514", "")
+	req, err := createBedrockRequest(context.Background(), account, modelID, body, true, time.Now())
+	if err != nil {
+		t.Fatalf("创建请求失败: %v", err)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("请求模拟端点失败: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var output bytes.Buffer
+	usage, err := common.ParseStreamResponse(&output, NewBedrockEventStreamReader(resp.Body))
+	if err != nil {
+		t.Fatalf("解析流式响应失败: %v", err)
+	}
+
+	if usage.InputTokens != 12 || usage.OutputTokens != 7 {
+		t.Errorf("token统计不正确: input=%d output=%d", usage.InputTokens, usage.OutputTokens)
+	}
+	if !strings.Contains(output.String(), "event: content_block_delta\ndata: ") {
+		t.Errorf("输出不是Anthropic SSE格式: %s", output.String())
+	}
+}
+
+// TestBedrockEventStreamException 异常帧应转换为SSE error事件
+func TestBedrockEventStreamException(t *testing.T) {
+	frame := encodeEventStreamMessage([][2]string{
+		{":exception-type", "throttlingException"},
+		{":content-type", "application/json"},
+		{":message-type", "exception"},
+	}, []byte(`{"message":"Too many requests"}`))
+
+	output, err := io.ReadAll(NewBedrockEventStreamReader(bytes.NewReader(frame)))
+	if err != nil {
+		t.Fatalf("解码失败: %v", err)
+	}
+	if !strings.Contains(string(output), `"type":"rate_limit_error"`) || !strings.HasPrefix(string(output), "event: error\n") {
+		t.Errorf("异常帧转换不正确: %s", output)
+	}
+
+	// 校验和错误的帧应返回错误
+	frame[len(frame)-1] ^= 0xFF
+	if _, err := io.ReadAll(NewBedrockEventStreamReader(bytes.NewReader(frame))); err == nil {
+		t.Error("校验和错误的帧应返回错误")
+	}
+}
+
+// TestMapBedrockExceptionType Bedrock异常映射为Anthropic错误类型及对应的fallback状态码
+func TestMapBedrockExceptionType(t *testing.T) {
+	cases := []struct {
+		exception  string
+		errorType  string
+		statusCode int
+	}{
+		{"throttlingException", "rate_limit_error", http.StatusTooManyRequests},
+		{"serviceUnavailableException", "overloaded_error", 529},
+		{"modelNotReadyException", "overloaded_error", 529},
+		{"internalServerException", "api_error", http.StatusInternalServerError},
+		{"modelStreamErrorException", "api_error", http.StatusInternalServerError},
+	}
+	for _, tc := range cases {
+		errorType := mapBedrockExceptionType(tc.exception)
+		if errorType != tc.errorType {
+			t.Errorf("%s 应映射为 %s, 实际: %s", tc.exception, tc.errorType, errorType)
+		}
+		if got := streamErrorStatusCode(errorType); got != tc.statusCode {
+			t.Errorf("%s 状态码应为 %d, 实际: %d", tc.exception, tc.statusCode, got)
+		}
+	}
+}
@@ -16,6 +16,11 @@ const (
 	CircuitHalfOpen CircuitBreakerState = 2 // 熔断器半开（探测恢复）
 )
 
+const (
+	circuitHalfOpenMaxProbes   = 2               // 半开状态每个探测窗口内最多放行的请求数
+	circuitHalfOpenProbeWindow = time.Second * 5 // 半开状态的探测窗口
+)
+
 // NewCircuitBreaker 创建新的熔断器
 func NewCircuitBreaker(threshold int, failureWindow, recoveryWindow time.Duration) *CircuitBreaker {
 	return &CircuitBreaker{
@@ -34,11 +39,15 @@ func (cb *CircuitBreaker) IsOpen() bool {
 		return false
 	case CircuitOpen:
 		// 检查是否可以转为半开状态
+		cb.mu.Lock()
+		defer cb.mu.Unlock()
 		if time.Since(cb.lastFailureTime) > cb.recoveryWindow {
 			if atomic.CompareAndSwapInt32(&cb.state, int32(CircuitOpen), int32(CircuitHalfOpen)) {
 				cb.consecutiveSuccess = 0
-				return false
+				cb.probeCount = 0
+				cb.probeWindowStart = time.Time{}
 			}
+			return false
 		}
 		return true
 	case CircuitHalfOpen:
@@ -48,6 +57,30 @@ func (cb *CircuitBreaker) IsOpen() bool {
 	}
 }
 
+// AllowRequest 判断是否放行请求：关闭状态全部放行，开启状态全部拒绝，
+// 半开状态每个探测窗口内只放行有限的探测请求
+func (cb *CircuitBreaker) AllowRequest() bool {
+	if cb.IsOpen() {
+		return false
+	}
+	if cb.GetState() != CircuitHalfOpen {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if time.Since(cb.probeWindowStart) > circuitHalfOpenProbeWindow {
+		cb.probeWindowStart = time.Now()
+		cb.probeCount = 0
+	}
+	if cb.probeCount >= circuitHalfOpenMaxProbes {
+		return false
+	}
+	cb.probeCount++
+	return true
+}
+
 // RecordSuccess 记录成功
 func (cb *CircuitBreaker) RecordSuccess() {
 	cb.mu.Lock()
@@ -91,6 +124,38 @@ func (cb *CircuitBreaker) RecordFailure() {
 	}
 }
 
+// circuitBreakerSnapshot 熔断器状态快照，用于跨副本同步
+type circuitBreakerSnapshot struct {
+	State              CircuitBreakerState `json:"state"`
+	FailureCount       int64               `json:"failure_count"`
+	LastFailureTime    time.Time           `json:"last_failure_time"`
+	ConsecutiveSuccess int64               `json:"consecutive_success"`
+}
+
+// snapshot 获取熔断器状态快照
+func (cb *CircuitBreaker) snapshot() circuitBreakerSnapshot {
+	cb.mu.RLock()
+	defer cb.mu.RUnlock()
+
+	return circuitBreakerSnapshot{
+		State:              cb.GetState(),
+		FailureCount:       cb.failureCount,
+		LastFailureTime:    cb.lastFailureTime,
+		ConsecutiveSuccess: cb.consecutiveSuccess,
+	}
+}
+
+// restore 用其他副本同步的快照覆盖熔断器状态
+func (cb *CircuitBreaker) restore(snapshot circuitBreakerSnapshot) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	cb.failureCount = snapshot.FailureCount
+	cb.lastFailureTime = snapshot.LastFailureTime
+	cb.consecutiveSuccess = snapshot.ConsecutiveSuccess
+	atomic.StoreInt32(&cb.state, int32(snapshot.State))
+}
+
 // GetState 获取熔断器状态
 func (cb *CircuitBreaker) GetState() CircuitBreakerState {
 	return CircuitBreakerState(atomic.LoadInt32(&cb.state))
@@ -101,8 +166,16 @@ func (cb *CircuitBreaker) GetStats() map[string]interface{} {
 	cb.mu.RLock()
 	defer cb.mu.RUnlock()
 	
+	state := cb.GetState()
+	stateNames := map[CircuitBreakerState]string{
+		CircuitClosed:   "closed",
+		CircuitOpen:     "open",
+		CircuitHalfOpen: "half_open",
+	}
+
 	return map[string]interface{}{
-		"state":             cb.GetState(),
+		"state":             state,
+		"state_name":        stateNames[state],
 		"failure_count":     cb.failureCount,
 		"last_failure_time": cb.lastFailureTime,
 		"consecutive_success": cb.consecutiveSuccess,
@@ -143,11 +216,21 @@ func (hm *HealthMonitor) Stop() {
 	}
 }
 
-// performHealthCheck 执行健康检查
+// performHealthCheck 执行健康检查：先根据统计刷新健康状态，再主动探测异常账号
 func (hm *HealthMonitor) performHealthCheck() {
+	accountIDs := hm.refreshHealthStatus()
+
+	if hm.config.EnableHealthProbe {
+		hm.probeAccounts(accountIDs)
+	}
+}
+
+// refreshHealthStatus 根据统计刷新所有账号的健康状态，返回已监控的账号ID用于筛选探测对象
+func (hm *HealthMonitor) refreshHealthStatus() []uint {
 	hm.mu.Lock()
 	defer hm.mu.Unlock()
 
+	accountIDs := make([]uint, 0, len(hm.accountHealth))
 	for accountID, health := range hm.accountHealth {
 		// 更新健康状态
 		health.LastCheckTime = time.Now()
@@ -164,12 +247,17 @@ func (hm *HealthMonitor) performHealthCheck() {
 
 		// 如果状态发生变化，记录日志
 		if previousStatus != health.Status {
+			hm.notifyChange(accountID)
 			account, _ := model.GetAccountByID(accountID)
 			if account != nil {
 				log.Printf("🏥 账号 %s 健康状态变化: %s -> %s", account.Name, previousStatus, health.Status)
 			}
 		}
+
+		accountIDs = append(accountIDs, accountID)
 	}
+
+	return accountIDs
 }
 
 // determineHealthStatus 确定健康状态
@@ -248,6 +336,8 @@ func (hm *HealthMonitor) UpdateHealthStatus(accountID uint, result *FallbackResu
 	if totalRequests > 0 {
 		health.ErrorRate = float64(health.FailureCount) / float64(totalRequests)
 	}
+
+	hm.notifyChange(accountID)
 }
 
 // shouldTemporarilyDisable 判断是否应该临时禁用账号
@@ -325,10 +415,27 @@ func (hm *HealthMonitor) SetAccountDisabled(accountID uint, duration time.Durati
 	health.DisabledUntil = &disabledUntil
 	health.Status = "disabled"
 	health.FailureReason = reason
+	hm.notifyChange(accountID)
 	
 	log.Printf("🚫 账号 %d 被手动禁用至 %s，原因: %s", accountID, disabledUntil.Format(time.RFC3339), reason)
 }
 
+// notifyChange 通知账号健康状态发生变化，调用方需持有锁
+func (hm *HealthMonitor) notifyChange(accountID uint) {
+	if hm.onChange != nil {
+		hm.onChange(accountID)
+	}
+}
+
+// restoreAccountHealth 用其他副本同步的健康状态覆盖本地数据，不触发变化回调
+func (hm *HealthMonitor) restoreAccountHealth(health *AccountHealth) {
+	hm.mu.Lock()
+	defer hm.mu.Unlock()
+
+	health.CircuitBreaker = nil
+	hm.accountHealth[health.AccountID] = health
+}
+
 // EnableAccount 手动启用账号
 func (hm *HealthMonitor) EnableAccount(accountID uint) {
 	hm.mu.Lock()
@@ -339,6 +446,7 @@ func (hm *HealthMonitor) EnableAccount(accountID uint) {
 		health.DisabledUntil = nil
 		health.Status = "healthy"
 		health.FailureReason = ""
+		hm.notifyChange(accountID)
 		log.Printf("✅ 账号 %d 被手动启用", accountID)
 	}
 }
\ No newline at end of file
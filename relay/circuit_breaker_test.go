@@ -0,0 +1,87 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestPerAccountCircuitBreaker 测试单个账号熔断不影响同组其他账号
+func TestPerAccountCircuitBreaker(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewFallbackHandler(&FallbackConfig{
+		MaxRetries:              2,
+		Strategy:                StrategyPriorityFirst,
+		EnableCircuitBreaker:    true,
+		CircuitBreakerThreshold: 2,
+		FailureWindow:           time.Minute,
+		RecoveryWindow:          time.Minute,
+		HealthCheckInterval:     time.Minute,
+	})
+	defer handler.Stop()
+
+	accounts := []model.Account{{ID: 1, Name: "flaky"}, {ID: 2, Name: "healthy"}}
+	var attempts []uint
+	requestFunc := func(c *gin.Context, account *model.Account, requestBody []byte) {
+		attempts = append(attempts, account.ID)
+		if account.ID == 1 {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": gin.H{"type": "api_error", "message": "upstream error"}})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"id": "msg_test"})
+	}
+
+	for i := 0; i < 3; i++ {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		result := handler.HandleRequestWithFallback(c, accounts, []byte(`{}`), requestFunc)
+		if !result.Success || result.Account.ID != 2 {
+			t.Fatalf("第%d次请求应由健康账号处理，实际: %+v", i+1, result)
+		}
+	}
+
+	if len(attempts) != 5 || attempts[4] != 2 {
+		t.Errorf("故障账号熔断后应直接跳过，实际尝试: %v", attempts)
+	}
+	if !handler.getBreaker(1).IsOpen() {
+		t.Error("故障账号的熔断器应开启")
+	}
+	if handler.getBreaker(2).GetState() != CircuitClosed {
+		t.Error("健康账号的熔断器不应受影响")
+	}
+	if stats := handler.getBreakerStats(1); stats["state_name"] != "open" {
+		t.Errorf("账号健康信息应包含熔断器状态，实际: %v", stats)
+	}
+}
+
+// TestCircuitBreakerHalfOpenProbes 测试半开状态只放行有限的探测请求
+func TestCircuitBreakerHalfOpenProbes(t *testing.T) {
+	breaker := NewCircuitBreaker(1, time.Minute, time.Millisecond*10)
+	breaker.RecordFailure()
+	if breaker.AllowRequest() {
+		t.Fatal("熔断器开启时不应放行请求")
+	}
+
+	time.Sleep(time.Millisecond * 20)
+
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if breaker.AllowRequest() {
+			allowed++
+		}
+	}
+	if allowed != circuitHalfOpenMaxProbes {
+		t.Errorf("半开状态应放行%d个探测请求，实际: %d", circuitHalfOpenMaxProbes, allowed)
+	}
+
+	// 探测连续成功后恢复关闭状态
+	for i := 0; i < 5; i++ {
+		breaker.RecordSuccess()
+	}
+	if breaker.GetState() != CircuitClosed || !breaker.AllowRequest() {
+		t.Error("探测成功后熔断器应关闭")
+	}
+}
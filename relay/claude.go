@@ -116,13 +116,21 @@ func HandleClaudeRequest(c *gin.Context, account *model.Account, requestBody []b
 		handleErrorResponse(c, resp, responseReader, account)
 	}
 
-	updateAccountAndStats(account, resp.StatusCode, usageTokens)
+	// 对冲请求中落败的一方不计费也不记录日志
+	if isHedgeLoser(c) {
+		return
+	}
+
+	// 流在首个内容前被fallback判定失败时按失败记录
+	statusCode := fallbackStatusCode(c, resp.StatusCode)
+
+	updateAccountAndStats(account, statusCode, usageTokens)
 
 	if apiKey != nil {
-		go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+		go service.UpdateApiKeyStatus(apiKey, statusCode, usageTokens)
 	}
 
-	saveRequestLog(startTime, apiKey, account, resp.StatusCode, usageTokens, true)
+	saveRequestLog(startTime, apiKey, account, statusCode, usageTokens, true)
 }
 
 // requestData 封装请求数据
@@ -468,7 +476,12 @@ func appendErrorMessage(baseError gin.H, message string) gin.H {
 // TestsHandleClaudeRequest 用于测试的Claude请求处理函数，功能同HandleClaudeRequest但不更新日志和账号状态
 // 主要用于单元测试和集成测试，避免对数据库和日志系统的
 func TestsHandleClaudeRequest(account *model.Account) (int, string) {
-	body, _ := sjson.SetBytes([]byte(TestRequestBody), "stream", true)
+	return testClaudeRequest(account, TestRequestBody)
+}
+
+// testClaudeRequest 使用指定请求体测试Claude账号连通性
+func testClaudeRequest(account *model.Account, requestBody string) (int, string) {
+	body, _ := sjson.SetBytes([]byte(requestBody), "stream", true)
 
 	// 获取有效的访问token
 	accessToken, err := getValidAccessToken(account)
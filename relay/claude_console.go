@@ -83,9 +83,17 @@ func HandleClaudeConsoleRequest(c *gin.Context, account *model.Account, requestB
 	
 	usageTokens := handleConsoleSuccessResponse(c, resp, responseReader, isStream)
 
+	// 对冲请求中落败的一方不计费也不记录日志
+	if isHedgeLoser(c) {
+		return
+	}
+
+	// 流在首个内容前被fallback判定失败时按失败记录
+	statusCode := fallbackStatusCode(c, resp.StatusCode)
+
 	// 更新账号状态
 	accountService := service.NewAccountService()
-	accountService.UpdateAccountStatus(account, resp.StatusCode, usageTokens)
+	accountService.UpdateAccountStatus(account, statusCode, usageTokens)
 
 	// 处理错误响应
 	if resp.StatusCode >= consoleStatusBadRequest {
@@ -95,11 +103,11 @@ func HandleClaudeConsoleRequest(c *gin.Context, account *model.Account, requestB
 
 	// 更新API Key状态
 	if apiKey != nil {
-		go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+		go service.UpdateApiKeyStatus(apiKey, statusCode, usageTokens)
 	}
 
 	// 保存请求日志
-	saveConsoleRequestLog(startTime, apiKey, account, resp.StatusCode, usageTokens)
+	saveConsoleRequestLog(startTime, apiKey, account, statusCode, usageTokens)
 }
 
 // extractConsoleAPIKey 从上下文中提取API Key
@@ -370,7 +378,12 @@ func handleConsoleErrorResponse(c *gin.Context, resp *http.Response, responseRea
 
 // TestHandleClaudeConsoleRequest 测试处理Claude Console请求的函数
 func TestHandleClaudeConsoleRequest(account *model.Account) (int, string) {
-	body, _ := sjson.SetBytes([]byte(TestRequestBody), "stream", true)
+	return testClaudeConsoleRequest(account, TestRequestBody)
+}
+
+// testClaudeConsoleRequest 使用指定请求体测试Claude Console账号连通性
+func testClaudeConsoleRequest(account *model.Account, requestBody string) (int, string) {
+	body, _ := sjson.SetBytes([]byte(requestBody), "stream", true)
 
 	req, err := http.NewRequest("POST", account.RequestURL+"/v1/messages?beta=true", bytes.NewBuffer(body))
 	if err != nil {
@@ -0,0 +1,420 @@
+package relay
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+)
+
+// 账号并发槽位的Redis键: account_concurrency:{账号ID}（有序集合，member为槽位ID，score为租约过期时间）
+const accountConcurrencyKeyPrefix = "account_concurrency"
+
+const (
+	concurrencyLeaseTTL   = 2 * time.Minute        // 槽位租约有效期，副本异常退出时占用的槽位在过期后自动回收
+	concurrencyLeaseRenew = 30 * time.Second       // 持有槽位期间续约的间隔，长时间的流式请求不会被回收
+	concurrencyQueuePoll  = 200 * time.Millisecond // 排队的请求检查其他副本是否释放槽位的间隔
+)
+
+var (
+	errConcurrencyQueueFull    = errors.New("所有账号并发已满，等待队列已满")
+	errConcurrencyQueueTimeout = errors.New("所有账号并发已满，排队超时")
+)
+
+// acquireSlotScript 清理过期的租约后，在未达到并发上限时占用一个槽位
+// KEYS[1]: 槽位键 ARGV: 当前时间(毫秒)、并发上限、租约过期时间(毫秒)、槽位ID、键有效期(毫秒)
+var acquireSlotScript = redis.NewScript(`
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', ARGV[1])
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[2]) then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[4])
+redis.call('PEXPIRE', KEYS[1], ARGV[5])
+return 1
+`)
+
+// slotSequence 槽位ID的自增序号，与副本标识组合保证全局唯一
+var slotSequence uint64
+
+// localInFlight 未配置Redis时在本进程内统计账号正在处理的请求数
+var localInFlight = struct {
+	sync.Mutex
+	counts map[uint]int
+}{counts: make(map[uint]int)}
+
+// accountSlot 账号的一个并发槽位，请求结束后必须释放
+type accountSlot struct {
+	accountID uint
+	leaseID   string // 为空表示使用本进程计数
+	stop      chan struct{}
+	once      sync.Once
+	onRelease func()
+}
+
+// concurrencyKey 获取账号并发槽位的Redis键
+func concurrencyKey(accountID uint) string {
+	return fmt.Sprintf("%s:%d", accountConcurrencyKeyPrefix, accountID)
+}
+
+// acquireAccountSlot 尝试占用账号的一个并发槽位，账号未限制并发时返回nil和true
+// Redis不可用时退化为本进程内计数
+func acquireAccountSlot(account *model.Account, onRelease func()) (*accountSlot, bool) {
+	if account.MaxConcurrency <= 0 {
+		return nil, true
+	}
+
+	slot := &accountSlot{accountID: account.ID, onRelease: onRelease}
+	if common.RDB != nil {
+		leaseID := fmt.Sprintf("%s:%d", sharedStateInstanceID, atomic.AddUint64(&slotSequence, 1))
+		acquired, err := acquireRedisSlot(account, leaseID)
+		if err == nil {
+			if !acquired {
+				return nil, false
+			}
+			slot.leaseID = leaseID
+			slot.stop = make(chan struct{})
+			go slot.renew()
+			return slot, true
+		}
+		common.SysError(fmt.Sprintf("占用账号 %s 并发槽位失败，改用本地计数: %v", account.Name, err))
+	}
+
+	localInFlight.Lock()
+	defer localInFlight.Unlock()
+	if localInFlight.counts[account.ID] >= account.MaxConcurrency {
+		return nil, false
+	}
+	localInFlight.counts[account.ID]++
+	return slot, true
+}
+
+// acquireRedisSlot 通过Redis占用账号的并发槽位
+func acquireRedisSlot(account *model.Account, leaseID string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	now := time.Now()
+	result, err := acquireSlotScript.Run(ctx, common.RDB, []string{concurrencyKey(account.ID)},
+		now.UnixMilli(),
+		account.MaxConcurrency,
+		now.Add(concurrencyLeaseTTL).UnixMilli(),
+		leaseID,
+		(concurrencyLeaseTTL * 2).Milliseconds(),
+	).Int()
+	if err != nil {
+		return false, err
+	}
+	return result == 1, nil
+}
+
+// renew 持有槽位期间定期延长租约
+func (s *accountSlot) renew() {
+	ticker := time.NewTicker(concurrencyLeaseRenew)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+			expireAt := float64(time.Now().Add(concurrencyLeaseTTL).UnixMilli())
+			err := common.RDB.ZAddXX(ctx, concurrencyKey(s.accountID), &redis.Z{Score: expireAt, Member: s.leaseID}).Err()
+			if err == nil {
+				err = common.RDB.PExpire(ctx, concurrencyKey(s.accountID), concurrencyLeaseTTL*2).Err()
+			}
+			cancel()
+			if err != nil {
+				common.SysError(fmt.Sprintf("续约账号 %d 并发槽位失败: %v", s.accountID, err))
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// release 释放槽位，可重复调用
+func (s *accountSlot) release() {
+	if s == nil {
+		return
+	}
+
+	s.once.Do(func() {
+		if s.leaseID != "" {
+			close(s.stop)
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+			if err := common.RDB.ZRem(ctx, concurrencyKey(s.accountID), s.leaseID).Err(); err != nil {
+				common.SysError(fmt.Sprintf("释放账号 %d 并发槽位失败: %v", s.accountID, err))
+			}
+			cancel()
+		} else {
+			localInFlight.Lock()
+			if localInFlight.counts[s.accountID] <= 1 {
+				delete(localInFlight.counts, s.accountID)
+			} else {
+				localInFlight.counts[s.accountID]--
+			}
+			localInFlight.Unlock()
+		}
+
+		if s.onRelease != nil {
+			s.onRelease()
+		}
+	})
+}
+
+// accountsInFlight 批量获取限制了并发的账号当前正在处理的请求数
+func accountsInFlight(accounts []model.Account) map[uint]int {
+	result := make(map[uint]int)
+
+	var limited []uint
+	for _, account := range accounts {
+		if account.MaxConcurrency > 0 {
+			limited = append(limited, account.ID)
+		}
+	}
+	if len(limited) == 0 {
+		return result
+	}
+
+	if common.RDB != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+		defer cancel()
+
+		now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+		pipe := common.RDB.Pipeline()
+		counts := make(map[uint]*redis.IntCmd, len(limited))
+		for _, accountID := range limited {
+			counts[accountID] = pipe.ZCount(ctx, concurrencyKey(accountID), "("+now, "+inf")
+		}
+		_, err := pipe.Exec(ctx)
+		if err == nil {
+			for accountID, count := range counts {
+				result[accountID] = int(count.Val())
+			}
+			return result
+		}
+		common.SysError("查询账号并发数失败，改用本地计数: " + err.Error())
+	}
+
+	localInFlight.Lock()
+	defer localInFlight.Unlock()
+	for _, accountID := range limited {
+		result[accountID] = localInFlight.counts[accountID]
+	}
+	return result
+}
+
+// filterSaturated 过滤掉并发已满的账号
+func filterSaturated(accounts []model.Account) []model.Account {
+	inFlight := accountsInFlight(accounts)
+	if len(inFlight) == 0 {
+		return accounts
+	}
+
+	result := make([]model.Account, 0, len(accounts))
+	for _, account := range accounts {
+		if account.MaxConcurrency > 0 && inFlight[account.ID] >= account.MaxConcurrency {
+			continue
+		}
+		result = append(result, account)
+	}
+	return result
+}
+
+// reservedSlotKey 上下文中保存排队期间为请求预留的槽位的键
+const reservedSlotKey = "reserved_account_slot"
+
+// reservedSlot 排队的请求在队首占用的槽位，由fallback在尝试对应账号时直接使用
+type reservedSlot struct {
+	accountID uint
+	slot      *accountSlot
+	taken     bool
+}
+
+// acquireSlot 占用账号的并发槽位，优先使用排队时为该账号预留的槽位
+func (h *FallbackHandler) acquireSlot(c *gin.Context, account *model.Account) (*accountSlot, bool) {
+	if value, exists := c.Get(reservedSlotKey); exists {
+		if reserved, ok := value.(*reservedSlot); ok && !reserved.taken && reserved.accountID == account.ID {
+			reserved.taken = true
+			return reserved.slot, true
+		}
+	}
+	return acquireAccountSlot(account, h.waitQueue.notify)
+}
+
+// releaseReservedSlot 释放请求结束时仍未使用的预留槽位
+func releaseReservedSlot(c *gin.Context) {
+	value, exists := c.Get(reservedSlotKey)
+	if !exists {
+		return
+	}
+	if reserved, ok := value.(*reservedSlot); ok && !reserved.taken {
+		reserved.taken = true
+		reserved.slot.release()
+	}
+}
+
+// concurrencyQueue 候选账号并发已满时的等待队列，按账号先到先得：
+// 请求只能占用排在它前面的请求都不使用的账号的槽位，等待不同账号的请求互不阻塞
+type concurrencyQueue struct {
+	mu      sync.Mutex
+	waiters []*queueWaiter
+}
+
+// queueWaiter 排队中的请求及其候选账号
+type queueWaiter struct {
+	wake     chan struct{}
+	accounts map[uint]bool
+}
+
+// enqueue 加入队尾，队列已满时返回false
+func (q *concurrencyQueue) enqueue(maxSize int, accounts []model.Account) (*queueWaiter, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiters) >= maxSize {
+		return nil, false
+	}
+	waiter := &queueWaiter{wake: make(chan struct{}, 1), accounts: make(map[uint]bool, len(accounts))}
+	for _, account := range accounts {
+		waiter.accounts[account.ID] = true
+	}
+	q.waiters = append(q.waiters, waiter)
+	return waiter, true
+}
+
+// remove 离开队列，并唤醒其余请求重新检查可用的账号
+func (q *concurrencyQueue) remove(waiter *queueWaiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	for i, w := range q.waiters {
+		if w == waiter {
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			q.wakeAll()
+			return
+		}
+	}
+}
+
+// claim 按顺序对排在前面的请求都不使用的候选账号调用acquire占用槽位，成功后离开队列
+// 占用槽位时持有队列锁，释放的槽位直接交给最早等待该账号的请求，不会被后来的请求抢占
+func (q *concurrencyQueue) claim(waiter *queueWaiter, accounts []model.Account, acquire func(account *model.Account) bool) (*model.Account, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	blocked := make(map[uint]bool)
+	for i, w := range q.waiters {
+		if w != waiter {
+			for accountID := range w.accounts {
+				blocked[accountID] = true
+			}
+			continue
+		}
+
+		for j := range accounts {
+			if blocked[accounts[j].ID] || !acquire(&accounts[j]) {
+				continue
+			}
+			q.waiters = append(q.waiters[:i], q.waiters[i+1:]...)
+			q.wakeAll()
+			return &accounts[j], true
+		}
+		return nil, false
+	}
+	return nil, false
+}
+
+// unqueued 过滤掉有请求正在排队等待的账号，新请求不能越过排队的请求占用这些账号
+func (q *concurrencyQueue) unqueued(accounts []model.Account) []model.Account {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiters) == 0 {
+		return accounts
+	}
+
+	result := make([]model.Account, 0, len(accounts))
+	for _, account := range accounts {
+		queued := false
+		for _, waiter := range q.waiters {
+			if waiter.accounts[account.ID] {
+				queued = true
+				break
+			}
+		}
+		if !queued {
+			result = append(result, account)
+		}
+	}
+	return result
+}
+
+// notify 有槽位释放时唤醒排队的请求
+func (q *concurrencyQueue) notify() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.wakeAll()
+}
+
+// wakeAll 唤醒所有排队的请求，调用方需持有锁
+func (q *concurrencyQueue) wakeAll() {
+	for _, waiter := range q.waiters {
+		select {
+		case waiter.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Len 获取排队中的请求数
+func (q *concurrencyQueue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.waiters)
+}
+
+// waitForCapacity 排队等待空闲账号，直到占用到槽位、超时或客户端断开
+// 返回占用到槽位的账号和槽位，账号未限制并发时槽位为nil
+func (h *FallbackHandler) waitForCapacity(ctx context.Context, accounts []model.Account) (*model.Account, *accountSlot, error) {
+	waiter, ok := h.waitQueue.enqueue(h.config.ConcurrencyQueueSize, accounts)
+	if !ok {
+		return nil, nil, errConcurrencyQueueFull
+	}
+	defer h.waitQueue.remove(waiter)
+
+	timeout := time.NewTimer(h.config.ConcurrencyQueueTimeout)
+	defer timeout.Stop()
+	poll := time.NewTicker(concurrencyQueuePoll)
+	defer poll.Stop()
+
+	for {
+		var slot *accountSlot
+		account, claimed := h.waitQueue.claim(waiter, accounts, func(account *model.Account) bool {
+			acquired, ok := acquireAccountSlot(account, h.waitQueue.notify)
+			slot = acquired
+			return ok
+		})
+		if claimed {
+			return account, slot, nil
+		}
+
+		select {
+		case <-waiter.wake:
+		case <-poll.C:
+		case <-timeout.C:
+			return nil, nil, errConcurrencyQueueTimeout
+		case <-ctx.Done():
+			return nil, nil, ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestAccountSlotLimit 测试账号并发槽位的占用和释放
+func TestAccountSlotLimit(t *testing.T) {
+	account := &model.Account{ID: 9001, Name: "limited", MaxConcurrency: 2}
+
+	first, ok1 := acquireAccountSlot(account, nil)
+	second, ok2 := acquireAccountSlot(account, nil)
+	if !ok1 || !ok2 {
+		t.Fatal("未达到并发上限时应能占用槽位")
+	}
+	if _, ok := acquireAccountSlot(account, nil); ok {
+		t.Fatal("达到并发上限时不应再占用槽位")
+	}
+	if available := filterSaturated([]model.Account{*account}); len(available) != 0 {
+		t.Error("并发已满的账号应被过滤")
+	}
+
+	released := 0
+	first.onRelease = func() { released++ }
+	first.release()
+	first.release()
+	if released != 1 {
+		t.Errorf("重复释放只应生效一次，实际: %d", released)
+	}
+
+	third, ok := acquireAccountSlot(account, nil)
+	if !ok {
+		t.Fatal("释放后应能重新占用槽位")
+	}
+	second.release()
+	third.release()
+
+	if slot, ok := acquireAccountSlot(&model.Account{ID: 9002}, nil); !ok || slot != nil {
+		t.Error("未限制并发的账号不应占用槽位")
+	}
+}
+
+// TestConcurrencyWaitQueue 测试所有账号并发已满时排队等待，槽位释放后按顺序继续
+func TestConcurrencyWaitQueue(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewFallbackHandler(&FallbackConfig{
+		MaxRetries:              1,
+		Strategy:                StrategyPriorityFirst,
+		HealthCheckInterval:     time.Minute,
+		ConcurrencyQueueSize:    1,
+		ConcurrencyQueueTimeout: time.Second,
+	})
+	defer handler.Stop()
+
+	account := model.Account{ID: 9003, Name: "busy", MaxConcurrency: 1}
+	slot, _ := acquireAccountSlot(&account, handler.waitQueue.notify)
+
+	done := make(chan *FallbackResult, 1)
+	go func() {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		done <- handler.HandleRequestWithFallback(c, []model.Account{account}, []byte(`{}`), func(c *gin.Context, account *model.Account, requestBody []byte) {
+			c.JSON(http.StatusOK, gin.H{"id": "msg_test"})
+		})
+	}()
+
+	// 等待队列只有1个位置，已有请求排队时直接拒绝
+	deadline := time.Now().Add(time.Second)
+	for handler.waitQueue.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+	if _, _, err := handler.waitForCapacity(context.Background(), []model.Account{account}); err != errConcurrencyQueueFull {
+		t.Errorf("等待队列已满时应直接拒绝，实际: %v", err)
+	}
+
+	slot.release()
+	select {
+	case result := <-done:
+		if !result.Success {
+			t.Errorf("槽位释放后排队的请求应成功，实际: %+v", result)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("槽位释放后排队的请求应被唤醒")
+	}
+
+	// 没有槽位释放时排队超时
+	slot, _ = acquireAccountSlot(&account, handler.waitQueue.notify)
+	defer slot.release()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	result := handler.HandleRequestWithFallback(c, []model.Account{account}, []byte(`{}`), func(c *gin.Context, account *model.Account, requestBody []byte) {
+		t.Error("排队超时的请求不应发往账号")
+	})
+	if result.Success || result.FailureReason != "concurrency_limit" {
+		t.Errorf("排队超时应返回并发限制错误，实际: %+v", result)
+	}
+}
+
+// TestConcurrencyQueueHandoff 同一账号按排队顺序交给等待的请求，等待其他账号的请求不被队首阻塞
+func TestConcurrencyQueueHandoff(t *testing.T) {
+	accountA := []model.Account{{ID: 1}}
+	accountB := []model.Account{{ID: 2}}
+	queue := &concurrencyQueue{}
+	first, _ := queue.enqueue(3, accountA)
+	second, _ := queue.enqueue(3, accountA)
+	other, _ := queue.enqueue(3, accountB)
+
+	if _, ok := queue.claim(second, accountA, func(account *model.Account) bool {
+		t.Error("排在后面的请求不应尝试占用前面的请求等待的账号")
+		return true
+	}); ok {
+		t.Fatal("排在后面的请求不应越过前面的请求占用同一账号")
+	}
+	if account, ok := queue.claim(other, accountB, func(account *model.Account) bool { return true }); !ok || account.ID != 2 {
+		t.Fatal("等待其他账号的请求应能直接占用空闲的账号")
+	}
+	if unqueued := queue.unqueued(append(accountA, accountB...)); len(unqueued) != 1 || unqueued[0].ID != 2 {
+		t.Errorf("新请求应跳过有请求排队的账号，实际: %v", unqueued)
+	}
+	if _, ok := queue.claim(first, accountA, func(account *model.Account) bool { return true }); !ok {
+		t.Fatal("队首应占用成功")
+	}
+	select {
+	case <-second.wake:
+	default:
+		t.Error("前面的请求离开后应唤醒等待的请求")
+	}
+	if _, ok := queue.claim(second, accountA, func(account *model.Account) bool { return true }); !ok || queue.Len() != 0 {
+		t.Error("新的队首应占用成功并离开队列")
+	}
+
+	gin.SetMode(gin.TestMode)
+	handler := NewFallbackHandler(&FallbackConfig{
+		MaxRetries:              1,
+		Strategy:                StrategyPriorityFirst,
+		HealthCheckInterval:     time.Minute,
+		ConcurrencyQueueSize:    2,
+		ConcurrencyQueueTimeout: time.Second * 2,
+	})
+	defer handler.Stop()
+
+	account := model.Account{ID: 9004, Name: "busy", MaxConcurrency: 1}
+	slot, _ := acquireAccountSlot(&account, handler.waitQueue.notify)
+
+	served := make(chan string, 2)
+	request := func(name string) {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		handler.HandleRequestWithFallback(c, []model.Account{account}, []byte(`{}`), func(c *gin.Context, account *model.Account, requestBody []byte) {
+			served <- name
+			c.JSON(http.StatusOK, gin.H{"id": "msg_" + name})
+		})
+	}
+	waitQueueLen := func(n int) {
+		deadline := time.Now().Add(time.Second)
+		for handler.waitQueue.Len() < n && time.Now().Before(deadline) {
+			time.Sleep(time.Millisecond * 5)
+		}
+	}
+
+	go request("first")
+	waitQueueLen(1)
+	go request("second")
+	waitQueueLen(2)
+	slot.release()
+
+	for _, expected := range []string{"first", "second"} {
+		select {
+		case name := <-served:
+			if name != expected {
+				t.Fatalf("排队的请求应按顺序获得槽位, 期望 %s, 实际 %s", expected, name)
+			}
+		case <-time.After(time.Second * 2):
+			t.Fatalf("请求 %s 未获得槽位", expected)
+		}
+	}
+}
+
+// TestConcurrencyQueueNoHeadOfLineBlocking 有请求排队等待已满的账号时，使用其他空闲账号的请求不用排队
+func TestConcurrencyQueueNoHeadOfLineBlocking(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewFallbackHandler(&FallbackConfig{
+		MaxRetries:              1,
+		Strategy:                StrategyPriorityFirst,
+		HealthCheckInterval:     time.Minute,
+		ConcurrencyQueueSize:    2,
+		ConcurrencyQueueTimeout: time.Second,
+	})
+	defer handler.Stop()
+
+	busy := model.Account{ID: 9005, Name: "busy", MaxConcurrency: 1}
+	idle := model.Account{ID: 9006, Name: "idle", MaxConcurrency: 1}
+	slot, _ := acquireAccountSlot(&busy, handler.waitQueue.notify)
+	defer slot.release()
+
+	respond := func(c *gin.Context, account *model.Account, requestBody []byte) {
+		c.JSON(http.StatusOK, gin.H{"id": "msg_" + account.Name})
+	}
+	go func() {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		handler.HandleRequestWithFallback(c, []model.Account{busy}, []byte(`{}`), respond)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for handler.waitQueue.Len() == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond * 5)
+	}
+
+	start := time.Now()
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	result := handler.HandleRequestWithFallback(c, []model.Account{idle}, []byte(`{}`), respond)
+	if !result.Success || result.Account.ID != idle.ID {
+		t.Fatalf("使用空闲账号的请求应成功，实际: %+v", result)
+	}
+	if elapsed := time.Since(start); elapsed > concurrencyQueuePoll {
+		t.Errorf("使用空闲账号的请求不应排在等待其他账号的请求后面，耗时: %v", elapsed)
+	}
+}
@@ -0,0 +1,41 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"sort"
+)
+
+// CostAwareSelector 成本感知选择器：
+// 优先消耗按固定费用计费的MAX账号，其次按成本系数从低到高使用按量计费的账号，
+// 成本相同时按优先级和今日使用次数排序，状态异常的账号排在最后作为fallback
+type CostAwareSelector struct{}
+
+// Select 按成本从低到高排序账号
+func (s *CostAwareSelector) Select(accounts []model.Account) []model.Account {
+	sort.SliceStable(accounts, func(i, j int) bool {
+		a, b := accounts[i], accounts[j]
+		activeA, activeB := a.CurrentStatus == accountStatusActive, b.CurrentStatus == accountStatusActive
+		if activeA != activeB {
+			return activeA
+		}
+		if costA, costB := accountCostMultiplier(&a), accountCostMultiplier(&b); costA != costB {
+			return costA < costB
+		}
+		if a.Priority != b.Priority {
+			return a.Priority < b.Priority
+		}
+		return a.TodayUsageCount < b.TodayUsageCount
+	})
+	return accounts
+}
+
+// accountCostMultiplier 获取账号的实际成本系数，MAX账号按固定费用计费，边际成本视为0
+func accountCostMultiplier(account *model.Account) float64 {
+	if account.IsMax {
+		return 0
+	}
+	if account.CostMultiplier < 0 {
+		return 0
+	}
+	return account.CostMultiplier
+}
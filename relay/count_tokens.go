@@ -0,0 +1,241 @@
+package relay
+
+import (
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"io"
+	"log"
+	"net/http"
+	"unicode/utf8"
+)
+
+const (
+	// 本地估算时单张图片/文档按固定token数计算
+	estimatedImageTokens    = 1600
+	estimatedDocumentTokens = 3000
+	// 每条消息的格式开销
+	estimatedMessageOverhead = 4
+)
+
+// HandleCountTokens 处理count_tokens请求
+// 按分组的选择策略依次尝试健康且未熔断的Anthropic协议账号，不占用并发槽位，不影响会话粘性、熔断和性能统计，也不计费记录日志
+// 请求本身的错误（400/413/422）原样返回；认证、限流、5xx、网络错误或非Anthropic格式的响应换下一个账号，没有账号可用时使用本地估算
+func HandleCountTokens(c *gin.Context, accounts []model.Account, requestBody []byte) {
+	for _, account := range selectCountTokensAccounts(c, accounts) {
+		statusCode, responseBody, err := requestUpstreamTokenCount(c, &account, requestBody)
+		switch {
+		case err != nil:
+			log.Printf("账号 %s count_tokens请求失败: %v", account.Name, err)
+		case statusCode >= 200 && statusCode < 300 && gjson.GetBytes(responseBody, "input_tokens").Exists():
+			c.Data(statusCode, "application/json", responseBody)
+			return
+		case isCountTokensClientError(statusCode, responseBody):
+			// 请求本身的错误（如模型不存在、请求体格式错误）换账号也不会成功，原样返回
+			c.Data(statusCode, "application/json", responseBody)
+			return
+		default:
+			// 认证失败、限流、5xx或非Anthropic格式的响应（如第三方中转不支持该接口返回的404页面）
+			log.Printf("账号 %s count_tokens状态码: %d，尝试下一个账号", account.Name, statusCode)
+		}
+
+		if requestContext(c).Err() != nil {
+			break
+		}
+	}
+
+	RespondLocalTokenCount(c, requestBody)
+}
+
+// isCountTokensClientError 判断是否为请求本身的错误，账号相关的400错误（如余额不足）不算
+func isCountTokensClientError(statusCode int, responseBody []byte) bool {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return gjson.GetBytes(responseBody, "type").String() == "error" &&
+			classifyFailure(statusCode, responseBody) != ErrorClassAuth
+	default:
+		return false
+	}
+}
+
+// selectCountTokensAccounts 选择支持count_tokens接口的账号
+// 按API Key所在分组的选择策略排序，跳过健康检查不通过、被临时禁用或熔断的账号
+func selectCountTokensAccounts(c *gin.Context, accounts []model.Account) []model.Account {
+	var candidates []model.Account
+	for _, account := range accounts {
+		switch account.PlatformType {
+		case constant.PlatformClaude, constant.PlatformClaudeConsole:
+			candidates = append(candidates, account)
+		}
+	}
+
+	apiKey, exists := c.Get("api_key")
+	if !exists || GlobalFallbackManager == nil || len(candidates) == 0 {
+		return candidates
+	}
+	keyInfo, ok := apiKey.(*model.ApiKey)
+	if !ok {
+		return candidates
+	}
+
+	handler := GlobalFallbackManager.GetHandler(keyInfo.GroupID)
+	var available []model.Account
+	for _, account := range handler.selector.Select(candidates) {
+		if handler.isAccountAvailable(account.ID) {
+			available = append(available, account)
+		}
+	}
+	return available
+}
+
+// requestUpstreamTokenCount 向账号的count_tokens接口发送请求，返回状态码和响应体
+func requestUpstreamTokenCount(c *gin.Context, account *model.Account, requestBody []byte) (int, []byte, error) {
+	var requestURL string
+	var headers map[string]string
+
+	switch account.PlatformType {
+	case constant.PlatformClaude:
+		accessToken, err := getValidAccessToken(account)
+		if err != nil {
+			return 0, nil, fmt.Errorf("获取有效访问token失败: %w", err)
+		}
+		requestURL = ClaudeAPIURL + "/count_tokens"
+		headers = buildClaudeAPIHeaders(accessToken)
+	default:
+		requestURL = account.RequestURL + "/v1/messages/count_tokens"
+		headers = buildConsoleAPIHeaders(account.SecretKey)
+	}
+
+	client := createHTTPClient(account)
+	if client == nil {
+		return 0, nil, fmt.Errorf("代理配置错误")
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), "POST", requestURL, bytes.NewBuffer(StripUnsignedThinkingBlocks(requestBody)))
+	if err != nil {
+		return 0, nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	if beta := c.GetHeader("anthropic-beta"); beta != "" {
+		req.Header.Set("anthropic-beta", beta)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer common.CloseIO(resp.Body)
+
+	responseReader, err := createResponseReader(resp)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	responseBody, err := io.ReadAll(responseReader)
+	if err != nil {
+		return 0, nil, err
+	}
+	return resp.StatusCode, responseBody, nil
+}
+
+// RespondLocalTokenCount 返回本地估算的输入token数
+func RespondLocalTokenCount(c *gin.Context, requestBody []byte) {
+	c.JSON(http.StatusOK, gin.H{
+		"input_tokens": EstimateInputTokens(requestBody),
+	})
+}
+
+// EstimateInputTokens 本地近似估算Claude请求的输入token数
+// 英文等ASCII字符按约4字符/token，中日韩等非ASCII字符按约1字符/token计算
+func EstimateInputTokens(requestBody []byte) int {
+	request := gjson.ParseBytes(requestBody)
+	total := 0
+
+	// system 支持字符串和数组格式
+	system := request.Get("system")
+	if system.IsArray() {
+		for _, block := range system.Array() {
+			total += estimateTextTokens(block.Get("text").String())
+		}
+	} else {
+		total += estimateTextTokens(system.String())
+	}
+
+	for _, message := range request.Get("messages").Array() {
+		total += estimatedMessageOverhead
+		content := message.Get("content")
+		if !content.IsArray() {
+			total += estimateTextTokens(content.String())
+			continue
+		}
+		for _, block := range content.Array() {
+			total += estimateContentBlockTokens(block)
+		}
+	}
+
+	for _, tool := range request.Get("tools").Array() {
+		total += estimateTextTokens(tool.Get("name").String())
+		total += estimateTextTokens(tool.Get("description").String())
+		total += estimateTextTokens(tool.Get("input_schema").Raw)
+	}
+
+	if total == 0 {
+		return 1
+	}
+	return total
+}
+
+// estimateContentBlockTokens 估算单个内容块的token数
+func estimateContentBlockTokens(block gjson.Result) int {
+	switch block.Get("type").String() {
+	case "text":
+		return estimateTextTokens(block.Get("text").String())
+	case "thinking":
+		return estimateTextTokens(block.Get("thinking").String())
+	case "image":
+		return estimatedImageTokens
+	case "document":
+		return estimatedDocumentTokens
+	case "tool_use":
+		return estimateTextTokens(block.Get("name").String()) + estimateTextTokens(block.Get("input").Raw)
+	case "tool_result":
+		content := block.Get("content")
+		if !content.IsArray() {
+			return estimateTextTokens(content.String())
+		}
+		total := 0
+		for _, item := range content.Array() {
+			total += estimateContentBlockTokens(item)
+		}
+		return total
+	default:
+		return estimateTextTokens(block.Raw)
+	}
+}
+
+// estimateTextTokens 估算文本的token数
+func estimateTextTokens(text string) int {
+	if text == "" {
+		return 0
+	}
+
+	asciiChars := 0
+	otherChars := 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			asciiChars++
+		} else {
+			otherChars++
+		}
+	}
+
+	return (asciiChars+3)/4 + otherChars
+}
@@ -0,0 +1,122 @@
+package relay
+
+import (
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// TestHandleCountTokens 请求错误原样返回，认证、限流、5xx和不支持的接口换下一个账号，没有账号可用时使用本地估算
+func TestHandleCountTokens(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	requestBody := []byte(`{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"hello world"}]}`)
+
+	cases := []struct {
+		name             string
+		platform         string
+		upstreamStatus   int
+		upstreamBody     string
+		expectedStatus   int
+		expectEstimate   bool
+		expectedRequests int
+	}{
+		{"上游成功", constant.PlatformClaudeConsole, http.StatusOK, `{"input_tokens":42}`, http.StatusOK, false, 1},
+		{"请求错误原样返回", constant.PlatformClaudeConsole, http.StatusBadRequest, `{"type":"error","error":{"type":"invalid_request_error","message":"bad model"}}`, http.StatusBadRequest, false, 1},
+		{"账号余额不足换账号", constant.PlatformClaudeConsole, http.StatusBadRequest, `{"type":"error","error":{"type":"invalid_request_error","message":"Your credit balance is too low"}}`, http.StatusOK, true, 2},
+		{"认证失败换账号", constant.PlatformClaudeConsole, http.StatusUnauthorized, `{"type":"error","error":{"type":"authentication_error","message":"invalid x-api-key"}}`, http.StatusOK, true, 2},
+		{"上游5xx换账号", constant.PlatformClaudeConsole, http.StatusInternalServerError, `{"type":"error","error":{"type":"api_error"}}`, http.StatusOK, true, 2},
+		{"上游不支持接口换账号", constant.PlatformClaudeConsole, http.StatusNotFound, `404 page not found`, http.StatusOK, true, 2},
+		{"非Anthropic平台使用估算", constant.PlatformOpenAI, http.StatusOK, `{"input_tokens":42}`, http.StatusOK, true, 0},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			requests := 0
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				if r.URL.Path != "/v1/messages/count_tokens" {
+					t.Errorf("请求路径不正确: %s", r.URL.Path)
+				}
+				w.WriteHeader(tc.upstreamStatus)
+				w.Write([]byte(tc.upstreamBody))
+			}))
+			defer server.Close()
+
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+			c.Request = httptest.NewRequest("POST", "/v1/messages/count_tokens", nil)
+
+			accounts := []model.Account{
+				{ID: 1, Name: "a1", PlatformType: tc.platform, RequestURL: server.URL, SecretKey: "sk"},
+				{ID: 2, Name: "a2", PlatformType: tc.platform, RequestURL: server.URL, SecretKey: "sk"},
+			}
+			HandleCountTokens(c, accounts, requestBody)
+
+			if recorder.Code != tc.expectedStatus {
+				t.Errorf("状态码不正确: 期望 %d, 实际 %d", tc.expectedStatus, recorder.Code)
+			}
+			if tc.expectEstimate {
+				if got := gjson.Get(recorder.Body.String(), "input_tokens").Int(); got != int64(EstimateInputTokens(requestBody)) {
+					t.Errorf("应返回本地估算结果: %s", recorder.Body.String())
+				}
+			} else if strings.TrimSpace(recorder.Body.String()) != tc.upstreamBody {
+				t.Errorf("应原样返回上游响应: %s", recorder.Body.String())
+			}
+			if requests != tc.expectedRequests {
+				t.Errorf("上游请求次数不正确: 期望 %d, 实际 %d", tc.expectedRequests, requests)
+			}
+		})
+	}
+}
+
+// TestHandleCountTokensAccountOrder 按分组的选择策略排序，跳过被禁用的账号，限流时使用下一个账号的结果
+func TestHandleCountTokensAccountOrder(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	InitFallbackManager(&FallbackConfig{
+		MaxRetries:          3,
+		Strategy:            StrategyPriorityFirst,
+		EnableHealthCheck:   true,
+		HealthCheckInterval: time.Minute,
+	})
+	defer GlobalFallbackManager.Cleanup()
+	DisableAccount(1, 1, time.Minute, "测试")
+
+	var requested []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("x-api-key")
+		requested = append(requested, key)
+		if key == "limited" {
+			w.WriteHeader(http.StatusTooManyRequests)
+			w.Write([]byte(`{"type":"error","error":{"type":"rate_limit_error","message":"rate limited"}}`))
+			return
+		}
+		w.Write([]byte(`{"input_tokens":42}`))
+	}))
+	defer server.Close()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest("POST", "/v1/messages/count_tokens", nil)
+	c.Set("api_key", &model.ApiKey{ID: 1, GroupID: 1})
+
+	accounts := []model.Account{
+		{ID: 3, Name: "ok", Priority: 3, PlatformType: constant.PlatformClaudeConsole, RequestURL: server.URL, SecretKey: "ok"},
+		{ID: 1, Name: "disabled", Priority: 1, PlatformType: constant.PlatformClaudeConsole, RequestURL: server.URL, SecretKey: "disabled"},
+		{ID: 2, Name: "limited", Priority: 2, PlatformType: constant.PlatformClaudeConsole, RequestURL: server.URL, SecretKey: "limited"},
+	}
+	HandleCountTokens(c, accounts, []byte(`{"model":"claude-sonnet-4-20250514","messages":[{"role":"user","content":"hi"}]}`))
+
+	if recorder.Code != http.StatusOK || gjson.Get(recorder.Body.String(), "input_tokens").Int() != 42 {
+		t.Errorf("应返回下一个账号的上游结果: %d %s", recorder.Code, recorder.Body.String())
+	}
+	if strings.Join(requested, ",") != "limited,ok" {
+		t.Errorf("应跳过被禁用的账号并按优先级请求，实际: %v", requested)
+	}
+}
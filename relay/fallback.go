@@ -4,6 +4,7 @@ import (
 	"claude-code-relay/common"
 	"claude-code-relay/model"
 	"bytes"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,6 +15,7 @@ import (
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
 )
 
 // FallbackStrategy 定义fallback策略
@@ -24,6 +26,8 @@ const (
 	StrategyWeighted      FallbackStrategy = "weighted"       // 加权策略
 	StrategyRoundRobin    FallbackStrategy = "round_robin"    // 轮询策略
 	StrategyLeastUsed     FallbackStrategy = "least_used"     // 最少使用策略
+	StrategyLatencyAware  FallbackStrategy = "latency_aware"  // 延迟感知策略（EWMA TTFB + power-of-two-choices）
+	StrategyCostAware     FallbackStrategy = "cost_aware"     // 成本感知策略（优先MAX账号，其次成本系数最低的账号）
 )
 
 // AccountSelector 账号选择器接口
@@ -34,7 +38,8 @@ type AccountSelector interface {
 // FallbackConfig Fallback配置
 type FallbackConfig struct {
 	MaxRetries          int               `json:"max_retries"`           // 最大重试次数
-	RetryDelay          time.Duration     `json:"retry_delay"`           // 重试延迟
+	RetryDelay          time.Duration     `json:"retry_delay"`           // 重试延迟（指数退避的基数）
+	MaxRetryDelay       time.Duration     `json:"max_retry_delay"`       // 最大重试延迟
 	Strategy            FallbackStrategy  `json:"strategy"`              // 选择策略
 	EnableCircuitBreaker bool              `json:"enable_circuit_breaker"` // 启用熔断器
 	CircuitBreakerThreshold int            `json:"circuit_breaker_threshold"` // 熔断器阈值
@@ -42,6 +47,15 @@ type FallbackConfig struct {
 	RecoveryWindow      time.Duration     `json:"recovery_window"`       // 恢复窗口时间
 	EnableHealthCheck   bool              `json:"enable_health_check"`   // 启用健康检查
 	HealthCheckInterval time.Duration     `json:"health_check_interval"` // 健康检查间隔
+	EnableHealthProbe   bool              `json:"enable_health_probe"`   // 启用异常账号主动探测
+	HealthProbeConcurrency int            `json:"health_probe_concurrency"` // 主动探测的最大并发数
+	EnableStickySession bool              `json:"enable_sticky_session"` // 启用会话粘性
+	StickySessionTTL    time.Duration     `json:"sticky_session_ttl"`    // 会话粘性有效期
+	StreamHoldMaxBytes  int               `json:"stream_hold_max_bytes"` // 流式响应在首个内容前最多暂存的字节数
+	StreamHoldTimeout   time.Duration     `json:"stream_hold_timeout"`   // 流式响应在首个内容前最长暂存时间
+	EnableSharedState   bool              `json:"enable_shared_state"`   // 通过Redis在多个副本间共享健康、熔断和性能状态
+	ConcurrencyQueueSize    int           `json:"concurrency_queue_size"`    // 所有账号并发已满时等待队列的最大长度，0表示不排队
+	ConcurrencyQueueTimeout time.Duration `json:"concurrency_queue_timeout"` // 在等待队列中的最长等待时间
 }
 
 // FallbackResult Fallback结果
@@ -55,6 +69,9 @@ type FallbackResult struct {
 	Duration      time.Duration     `json:"duration"`
 	StrategyUsed  FallbackStrategy  `json:"strategy_used"`
 	FailureReason string            `json:"failure_reason,omitempty"`
+	ErrorClass    ErrorClass        `json:"error_class,omitempty"`
+	ErrorBody     []byte            `json:"-"` // 上游返回的原始错误内容
+	RetryAfter    time.Duration     `json:"-"` // 上游要求的重试等待时间
 }
 
 // CircuitBreaker 熔断器
@@ -67,6 +84,8 @@ type CircuitBreaker struct {
 	threshold         int64
 	failureWindow     time.Duration
 	recoveryWindow    time.Duration
+	probeWindowStart  time.Time // 半开状态当前探测窗口的开始时间
+	probeCount        int64     // 半开状态当前探测窗口内已放行的请求数
 }
 
 // AccountHealth 账号健康状态
@@ -74,6 +93,8 @@ type AccountHealth struct {
 	AccountID        uint        `json:"account_id"`
 	Status           string      `json:"status"`           // healthy, unhealthy, degraded
 	LastCheckTime    time.Time   `json:"last_check_time"`
+	LastProbeTime    *time.Time  `json:"last_probe_time,omitempty"`   // 最近一次主动探测时间
+	LastProbeStatus  int         `json:"last_probe_status,omitempty"` // 最近一次主动探测的状态码
 	SuccessCount     int64       `json:"success_count"`
 	FailureCount     int64       `json:"failure_count"`
 	AvgResponseTime  time.Duration `json:"avg_response_time"`
@@ -82,6 +103,7 @@ type AccountHealth struct {
 	LastSuccess      time.Time   `json:"last_success"`
 	DisabledUntil    *time.Time  `json:"disabled_until,omitempty"`
 	FailureReason    string      `json:"failure_reason,omitempty"`
+	CircuitBreaker   map[string]interface{} `json:"circuit_breaker,omitempty"` // 账号熔断器状态（查询时填充）
 }
 
 // HealthMonitor 健康监控器
@@ -93,18 +115,23 @@ type HealthMonitor struct {
 	config          *FallbackConfig
 	stopChan        chan struct{} // 停止信号通道
 	ticker          *time.Ticker  // 定时器
+	onChange        func(accountID uint) // 账号健康状态变化回调（用于跨副本同步）
 }
 
 // FallbackHandler Fallback处理器
 type FallbackHandler struct {
 	config         *FallbackConfig
 	selector       AccountSelector
-	circuitBreaker *CircuitBreaker
+	breakers       map[uint]*CircuitBreaker // 账号熔断器
+	breakersMu     sync.Mutex
 	healthMonitor  *HealthMonitor
 	mu             sync.RWMutex
 	requestHistory map[uint][]time.Time // 账号请求历史
 	stopChan       chan struct{}        // 停止信号通道
 	cleanupTicker  *time.Ticker         // 清理定时器
+	stickyStats    *StickySessionStats  // 会话粘性统计
+	shared         *sharedState         // 跨副本共享状态，未启用时为nil
+	waitQueue      *concurrencyQueue    // 候选账号并发已满时的等待队列
 }
 
 // NewFallbackHandler 创建新的Fallback处理器
@@ -116,10 +143,12 @@ func NewFallbackHandler(config *FallbackConfig) *FallbackHandler {
 	handler := &FallbackHandler{
 		config:         config,
 		selector:       createAccountSelector(config.Strategy),
-		circuitBreaker: NewCircuitBreaker(config.CircuitBreakerThreshold, config.FailureWindow, config.RecoveryWindow),
+		breakers:       make(map[uint]*CircuitBreaker),
 		healthMonitor:  NewHealthMonitor(config.HealthCheckInterval, config),
 		requestHistory: make(map[uint][]time.Time),
 		stopChan:       make(chan struct{}),
+		stickyStats:    &StickySessionStats{},
+		waitQueue:      &concurrencyQueue{},
 	}
 
 	// 启动健康检查
@@ -138,6 +167,7 @@ func getDefaultConfig() *FallbackConfig {
 	config := &FallbackConfig{
 		MaxRetries:           3,
 		RetryDelay:           0, // 默认无延迟，立即重试
+		MaxRetryDelay:        time.Second * 10,
 		Strategy:             StrategyPriorityFirst,
 		EnableCircuitBreaker: true,
 		CircuitBreakerThreshold: 5,
@@ -145,6 +175,15 @@ func getDefaultConfig() *FallbackConfig {
 		RecoveryWindow:      time.Minute * 10,
 		EnableHealthCheck:   true,
 		HealthCheckInterval: time.Minute * 2,
+		EnableHealthProbe:   false, // 主动探测会向上游发送请求，需显式开启
+		HealthProbeConcurrency: 3,
+		EnableStickySession: false, // 会话粘性会绕过选择策略把同一会话固定到一个账号，需显式开启
+		StickySessionTTL:    DefaultStickySessionTTL,
+		StreamHoldMaxBytes:  64 * 1024,
+		StreamHoldTimeout:   time.Second * 10,
+		EnableSharedState:   false, // 共享状态会向Redis持续写入和订阅，多副本部署时显式开启
+		ConcurrencyQueueSize:    100,
+		ConcurrencyQueueTimeout: time.Second * 30,
 	}
 	
 	// 从环境变量读取配置
@@ -160,6 +199,12 @@ func getDefaultConfig() *FallbackConfig {
 		}
 	}
 	
+	if maxDelay := os.Getenv("FALLBACK_MAX_RETRY_DELAY"); maxDelay != "" {
+		if val, err := time.ParseDuration(maxDelay); err == nil {
+			config.MaxRetryDelay = val
+		}
+	}
+	
 	if strategy := os.Getenv("FALLBACK_STRATEGY"); strategy != "" {
 		config.Strategy = FallbackStrategy(strategy)
 	}
@@ -196,6 +241,54 @@ func getDefaultConfig() *FallbackConfig {
 		config.EnableHealthCheck = health == "true" || health == "1"
 	}
 	
+	if probe := os.Getenv("FALLBACK_ENABLE_HEALTH_PROBE"); probe != "" {
+		config.EnableHealthProbe = probe == "true" || probe == "1"
+	}
+	
+	if concurrency := os.Getenv("FALLBACK_HEALTH_PROBE_CONCURRENCY"); concurrency != "" {
+		if val, err := strconv.Atoi(concurrency); err == nil && val > 0 {
+			config.HealthProbeConcurrency = val
+		}
+	}
+	
+	if sticky := os.Getenv("FALLBACK_ENABLE_STICKY_SESSION"); sticky != "" {
+		config.EnableStickySession = sticky == "true" || sticky == "1"
+	}
+	
+	if ttl := os.Getenv("FALLBACK_STICKY_SESSION_TTL"); ttl != "" {
+		if val, err := time.ParseDuration(ttl); err == nil && val > 0 {
+			config.StickySessionTTL = val
+		}
+	}
+	
+	if holdBytes := os.Getenv("FALLBACK_STREAM_HOLD_MAX_BYTES"); holdBytes != "" {
+		if val, err := strconv.Atoi(holdBytes); err == nil && val >= 0 {
+			config.StreamHoldMaxBytes = val
+		}
+	}
+	
+	if holdTimeout := os.Getenv("FALLBACK_STREAM_HOLD_TIMEOUT"); holdTimeout != "" {
+		if val, err := time.ParseDuration(holdTimeout); err == nil && val >= 0 {
+			config.StreamHoldTimeout = val
+		}
+	}
+	
+	if shared := os.Getenv("FALLBACK_ENABLE_SHARED_STATE"); shared != "" {
+		config.EnableSharedState = shared == "true" || shared == "1"
+	}
+	
+	if queueSize := os.Getenv("FALLBACK_CONCURRENCY_QUEUE_SIZE"); queueSize != "" {
+		if val, err := strconv.Atoi(queueSize); err == nil && val >= 0 {
+			config.ConcurrencyQueueSize = val
+		}
+	}
+	
+	if queueTimeout := os.Getenv("FALLBACK_CONCURRENCY_QUEUE_TIMEOUT"); queueTimeout != "" {
+		if val, err := time.ParseDuration(queueTimeout); err == nil && val > 0 {
+			config.ConcurrencyQueueTimeout = val
+		}
+	}
+	
 	return config
 }
 
@@ -206,18 +299,34 @@ func (h *FallbackHandler) HandleRequestWithFallback(c *gin.Context, accounts []m
 	// 记录请求开始
 	log.Printf("🚀 开始处理fallback请求，账号数量: %d，策略: %s", len(accounts), h.config.Strategy)
 
-	// 检查熔断器状态
-	if h.config.EnableCircuitBreaker && h.circuitBreaker.IsOpen() {
-		return &FallbackResult{
-			Success:      false,
-			ErrorMessage: "熔断器已开启，暂时停止请求",
-			FailureReason: "circuit_breaker_open",
-			Duration:     time.Since(startTime),
+	// 跳过并发已满和已有请求在排队等待的账号，没有账号可用时排队等待空闲账号，同一账号先到先得
+	availableAccounts := filterSaturated(h.waitQueue.unqueued(accounts))
+	if len(availableAccounts) == 0 && len(accounts) > 0 {
+		log.Printf("⏳ 候选账号并发已满或有请求在排队，进入等待队列，当前排队: %d", h.waitQueue.Len())
+		reserved, slot, err := h.waitForCapacity(requestContext(c), accounts)
+		if err != nil {
+			log.Printf("🚦 等待空闲账号失败: %v", err)
+			return &FallbackResult{
+				Success:       false,
+				ErrorMessage:  err.Error(),
+				FailureReason: "concurrency_limit",
+				Duration:      time.Since(startTime),
+			}
+		}
+
+		// 排队时占用的槽位留给该账号的尝试使用，未使用时在请求结束后释放
+		c.Set(reservedSlotKey, &reservedSlot{accountID: reserved.ID, slot: slot})
+		defer releaseReservedSlot(c)
+		availableAccounts = []model.Account{*reserved}
+		for _, account := range filterSaturated(accounts) {
+			if account.ID != reserved.ID {
+				availableAccounts = append(availableAccounts, account)
+			}
 		}
 	}
 
 	// 应用选择策略排序账号
-	sortedAccounts := h.selector.Select(accounts)
+	sortedAccounts := h.selector.Select(availableAccounts)
 	if len(sortedAccounts) == 0 {
 		return &FallbackResult{
 			Success:      false,
@@ -227,17 +336,25 @@ func (h *FallbackHandler) HandleRequestWithFallback(c *gin.Context, accounts []m
 		}
 	}
 
+	// 会话粘性：同一会话优先使用上次成功的账号，以复用上游的提示词缓存
+	var sessionKey string
+	var boundAccountID uint
+	if h.config.EnableStickySession {
+		sessionKey = buildStickySessionKey(c, requestBody)
+		sortedAccounts, boundAccountID = h.applyStickySession(sessionKey, sortedAccounts)
+	}
+
 	// 执行fallback逻辑
 	result := h.executeFallback(c, sortedAccounts, requestBody, requestFunc, startTime)
 	result.StrategyUsed = h.config.Strategy
 
-	// 更新熔断器状态
-	if h.config.EnableCircuitBreaker {
-		if result.Success {
-			h.circuitBreaker.RecordSuccess()
-		} else {
-			h.circuitBreaker.RecordFailure()
-		}
+	if result.Success && result.Account != nil && sessionKey != "" {
+		h.bindStickySession(sessionKey, boundAccountID, result.Account.ID)
+	}
+
+	// 请求本身的错误与账号无关，不计入健康状态
+	if result.ErrorClass == ErrorClassClient {
+		return result
 	}
 
 	// 更新健康状态
@@ -252,9 +369,15 @@ func (h *FallbackHandler) HandleRequestWithFallback(c *gin.Context, accounts []m
 func (h *FallbackHandler) executeFallback(c *gin.Context, accounts []model.Account, requestBody []byte, requestFunc RequestFunc, startTime time.Time) *FallbackResult {
 	var lastError string
 	var lastResult *FallbackResult
+	retryCount := 0
+	breakerSkipped := false
+	saturatedSkipped := false
 
 	// 限制最大重试次数
 	maxAttempts := min(h.config.MaxRetries, len(accounts))
+
+	// API Key启用对冲时，主账号迟迟没有首字节会同时请求下一个可用账号
+	hedgeDelay := hedgeDelayFor(c)
 	
 	for i := 0; i < maxAttempts; i++ {
 		account := accounts[i]
@@ -264,21 +387,46 @@ func (h *FallbackHandler) executeFallback(c *gin.Context, accounts []model.Accou
 			i+1, maxAttempts, account.Name, account.PlatformType, account.Priority)
 
 		// 检查账号健康状态
-		if h.config.EnableHealthCheck {
-			if health := h.healthMonitor.GetAccountHealth(account.ID); health != nil {
-				if health.Status == "unhealthy" || (health.DisabledUntil != nil && time.Now().Before(*health.DisabledUntil)) {
-					log.Printf("⚠️ 跳过不健康的账号: %s, 状态: %s", account.Name, health.Status)
-					continue
-				}
-			}
+		if !h.isAccountAvailable(account.ID) {
+			log.Printf("⚠️ 跳过不健康的账号: %s", account.Name)
+			breakerSkipped = breakerSkipped || (h.config.EnableCircuitBreaker && h.getBreaker(account.ID).IsOpen())
+			continue
+		}
+
+		// 熔断器半开时只放行有限的探测请求
+		if h.config.EnableCircuitBreaker && !h.getBreaker(account.ID).AllowRequest() {
+			log.Printf("⚠️ 账号 %s 熔断器半开，探测名额已满，跳过", account.Name)
+			breakerSkipped = true
+			continue
+		}
+
+		// 占用账号的并发槽位，选择账号后其他请求可能已占满
+		slot, acquired := h.acquireSlot(c, &account)
+		if !acquired {
+			log.Printf("⚠️ 账号 %s 并发已满(上限: %d)，跳过", account.Name, account.MaxConcurrency)
+			saturatedSkipped = true
+			continue
 		}
 
-		// 执行请求
-		result := h.executeSingleRequest(c, &account, requestBody, requestFunc, attemptStartTime)
+		// 执行请求，对冲请求在executeHedged中记录每个尝试的结果
+		var result *FallbackResult
+		hedged := false
+		if partner := h.nextHedgePartner(accounts, i, maxAttempts); hedgeDelay > 0 && partner > 0 {
+			result = h.executeHedged(c, &account, &accounts[partner], requestBody, requestFunc, hedgeDelay)
+			hedged = true
+			i = partner
+		} else {
+			result = h.executeSingleRequest(c, &account, requestBody, requestFunc, attemptStartTime)
+		}
+		slot.release()
 		
+		if !hedged {
+			h.recordBreakerResult(result)
+		}
+
 		if result.Success {
 			// 记录成功的日志，TTFB信息已经在executeSingleRequest中记录
-			log.Printf("✅ 账号 %s fallback请求成功，总耗时: %v，流式输出已完成", account.Name, result.Duration)
+			log.Printf("✅ 账号 %s fallback请求成功，总耗时: %v，流式输出已完成", result.Account.Name, result.Duration)
 			// 成功时，HTTP响应已经通过流式方式实时写入客户端，直接返回结果
 			return result
 		}
@@ -287,13 +435,34 @@ func (h *FallbackHandler) executeFallback(c *gin.Context, accounts []model.Accou
 		lastError = result.ErrorMessage
 		lastResult = result
 		
-		log.Printf("❌ 账号 %s 请求失败: %s", account.Name, result.ErrorMessage)
+		log.Printf("❌ 账号 %s 请求失败 [%s]: %s", result.Account.Name, result.ErrorClass, result.ErrorMessage)
 
-		// 如果不是最后一次尝试，添加延迟
-		if i < maxAttempts-1 && h.config.RetryDelay > 0 {
-			log.Printf("⏳ 等待 %v 后重试...", h.config.RetryDelay)
-			time.Sleep(h.config.RetryDelay)
+		// 客户端已断开，不再尝试其他账号
+		if requestContext(c).Err() != nil {
+			break
+		}
+
+		if result.ErrorClass == ErrorClassClient {
+			// 请求本身有问题，换账号也不会成功
+			log.Printf("🛑 请求参数错误，不再尝试其他账号")
+			result.Duration = time.Since(startTime)
+			result.FailureReason = string(ErrorClassClient)
+			return result
 		}
+		if !hedged {
+			h.disableFailedAccount(result)
+		}
+
+		// 如果不是最后一次尝试，按指数退避等待
+		if i < maxAttempts-1 {
+			if delay := h.retryBackoff(retryCount, result.ErrorClass, result.RetryAfter); delay > 0 {
+				log.Printf("⏳ 等待 %v 后重试...", delay)
+				if !waitForRetry(requestContext(c), delay) {
+					break
+				}
+			}
+		}
+		retryCount++
 	}
 
 	// 所有账号都失败了，记录失败信息但不写入HTTP响应（由controller处理）
@@ -305,6 +474,28 @@ func (h *FallbackHandler) executeFallback(c *gin.Context, accounts []model.Accou
 		return lastResult
 	}
 
+	// 没有实际发起请求且有账号被熔断器拦截
+	if breakerSkipped {
+		return &FallbackResult{
+			Success:       false,
+			ErrorMessage:  "账号熔断器已开启，暂时停止请求",
+			AttemptCount:  maxAttempts,
+			Duration:      time.Since(startTime),
+			FailureReason: "circuit_breaker_open",
+		}
+	}
+
+	// 没有实际发起请求且有账号并发已满
+	if saturatedSkipped {
+		return &FallbackResult{
+			Success:       false,
+			ErrorMessage:  errConcurrencyQueueFull.Error(),
+			AttemptCount:  maxAttempts,
+			Duration:      time.Since(startTime),
+			FailureReason: "concurrency_limit",
+		}
+	}
+
 	return &FallbackResult{
 		Success:       false,
 		ErrorMessage:  lastError,
@@ -314,20 +505,126 @@ func (h *FallbackHandler) executeFallback(c *gin.Context, accounts []model.Accou
 	}
 }
 
+// disableFailedAccount 按失败类型临时禁用账号
+func (h *FallbackHandler) disableFailedAccount(result *FallbackResult) {
+	switch result.ErrorClass {
+	case ErrorClassAuth:
+		// 账号凭证无效，在恢复窗口内不再使用该账号
+		h.healthMonitor.SetAccountDisabled(result.Account.ID, h.config.RecoveryWindow, "认证失败: "+result.ErrorMessage)
+	case ErrorClassRateLimit:
+		// 上游给出了重试时间时，在此之前不再使用该账号
+		if result.RetryAfter > 0 {
+			h.healthMonitor.SetAccountDisabled(result.Account.ID, result.RetryAfter, "限流: "+result.ErrorMessage)
+		}
+	}
+}
+
+// isAccountAvailable 检查账号是否可用（熔断器未开启，且在健康监控中未标记为不健康、未被临时禁用）
+func (h *FallbackHandler) isAccountAvailable(accountID uint) bool {
+	if h.config.EnableCircuitBreaker && h.getBreaker(accountID).IsOpen() {
+		return false
+	}
+	if !h.config.EnableHealthCheck {
+		return true
+	}
+	health := h.healthMonitor.GetAccountHealth(accountID)
+	if health == nil {
+		return true
+	}
+	return health.Status != "unhealthy" && (health.DisabledUntil == nil || !time.Now().Before(*health.DisabledUntil))
+}
+
+// getBreaker 获取账号的熔断器，不存在时按当前配置创建
+func (h *FallbackHandler) getBreaker(accountID uint) *CircuitBreaker {
+	h.breakersMu.Lock()
+	defer h.breakersMu.Unlock()
+
+	breaker, exists := h.breakers[accountID]
+	if !exists {
+		breaker = NewCircuitBreaker(h.config.CircuitBreakerThreshold, h.config.FailureWindow, h.config.RecoveryWindow)
+		h.breakers[accountID] = breaker
+	}
+	return breaker
+}
+
+// recordBreakerResult 将单次尝试的结果计入对应账号的熔断器，请求本身的错误不计入
+func (h *FallbackHandler) recordBreakerResult(result *FallbackResult) {
+	if !h.config.EnableCircuitBreaker || result.Account == nil || result.ErrorClass == ErrorClassClient {
+		return
+	}
+
+	breaker := h.getBreaker(result.Account.ID)
+	defer h.shared.markDirty(sharedStateBreaker, result.Account.ID)
+
+	if result.Success {
+		breaker.RecordSuccess()
+		return
+	}
+
+	previous := breaker.GetState()
+	breaker.RecordFailure()
+	if previous != CircuitOpen && breaker.GetState() == CircuitOpen {
+		log.Printf("🔌 账号 %s 熔断器开启，%v 内不再使用", result.Account.Name, h.config.RecoveryWindow)
+	}
+}
+
+// GetCircuitBreakerStats 获取所有账号的熔断器状态
+func (h *FallbackHandler) GetCircuitBreakerStats() map[uint]map[string]interface{} {
+	h.breakersMu.Lock()
+	defer h.breakersMu.Unlock()
+
+	stats := make(map[uint]map[string]interface{}, len(h.breakers))
+	for accountID, breaker := range h.breakers {
+		stats[accountID] = breaker.GetStats()
+	}
+	return stats
+}
+
+// getBreakerStats 获取指定账号的熔断器状态，没有记录时返回nil
+func (h *FallbackHandler) getBreakerStats(accountID uint) map[string]interface{} {
+	h.breakersMu.Lock()
+	breaker, exists := h.breakers[accountID]
+	h.breakersMu.Unlock()
+
+	if !exists {
+		return nil
+	}
+	return breaker.GetStats()
+}
+
 // executeSingleRequest 执行单个账号请求（支持流式和非流式模式）
 func (h *FallbackHandler) executeSingleRequest(c *gin.Context, account *model.Account, requestBody []byte, requestFunc RequestFunc, startTime time.Time) *FallbackResult {
 	// 创建自适应响应捕获器，传入请求开始时间和请求体（用于检测流式模式）
 	capture := NewStreamingResponseCapture(c.Writer, startTime, requestBody)
+	return h.executeWithCapture(c, capture, account, requestBody, requestFunc)
+}
+
+// executeWithCapture 使用指定的响应捕获器执行单个账号请求
+func (h *FallbackHandler) executeWithCapture(c *gin.Context, capture *StreamingResponseCapture, account *model.Account, requestBody []byte, requestFunc RequestFunc) *FallbackResult {
+	startTime := capture.startTime
+	capture.holdMaxBytes = h.config.StreamHoldMaxBytes
+	capture.holdTimeout = h.config.StreamHoldTimeout
 	originalWriter := c.Writer
 	
+	// 延迟感知选择器需要记录账号的实时负载
+	tracker, trackLoad := h.selector.(LoadTracker)
+	if trackLoad {
+		tracker.RequestStarted(account.ID)
+	}
+
 	// 临时替换Writer
 	c.Writer = capture
+	c.Set(fallbackCaptureKey, capture)
 
 	// 执行请求函数
 	requestFunc(c, account, requestBody)
 
 	// 恢复原始Writer
 	c.Writer = originalWriter
+	c.Set(fallbackCaptureKey, nil)
+
+	// 流式响应在首个内容前结束：判断是否中断，未中断则输出暂存的数据
+	capture.finishHeldStream()
 
 	// 记录请求历史
 	h.recordRequest(account.ID)
@@ -362,12 +659,22 @@ func (h *FallbackHandler) executeSingleRequest(c *gin.Context, account *model.Ac
 				account.Name, capture.statusCode, result.Duration, modeStr, capture.totalDataSize))
 		}
 	} else {
-		// 失败时获取缓存的错误信息
-		result.ErrorMessage = string(capture.GetBufferedData())
+		// 失败时获取缓存的错误信息，流在首个内容前中断时使用中断原因
+		result.ErrorBody = capture.GetBufferedData()
+		if capture.abortReason != "" {
+			result.ErrorBody = []byte(capture.abortReason)
+		}
+		result.ErrorMessage = extractErrorMessage(result.ErrorBody)
+		result.ErrorClass = classifyFailure(capture.statusCode, result.ErrorBody)
+		result.RetryAfter = parseRetryAfter(capture.Header().Get("Retry-After"))
 		common.SysError(fmt.Sprintf("❌ 账号 %s 请求失败，状态码: %d，耗时: %v，错误: %s", 
 			account.Name, capture.statusCode, result.Duration, result.ErrorMessage))
 	}
 
+	if trackLoad {
+		tracker.RequestFinished(account.ID, attemptLatency(capture, result))
+	}
+
 	return result
 }
 
@@ -378,6 +685,7 @@ func (h *FallbackHandler) recordRequest(accountID uint) {
 	
 	now := time.Now()
 	h.requestHistory[accountID] = append(h.requestHistory[accountID], now)
+	h.shared.recordRequest(accountID, now)
 	
 	// 限制每个账号的历史记录数量为最近100条
 	if len(h.requestHistory[accountID]) > 100 {
@@ -483,8 +791,13 @@ func (h *FallbackHandler) GetAccountStats(accountID uint) map[string]interface{}
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 	
+	requestCount := len(h.requestHistory[accountID])
+	if sharedCount, ok := h.shared.requestCount(accountID); ok {
+		requestCount = sharedCount
+	}
+
 	stats := map[string]interface{}{
-		"request_count": len(h.requestHistory[accountID]),
+		"request_count": requestCount,
 	}
 	
 	if health := h.healthMonitor.GetAccountHealth(accountID); health != nil {
@@ -497,7 +810,15 @@ func (h *FallbackHandler) GetAccountStats(accountID uint) map[string]interface{}
 // RequestFunc 请求函数类型
 type RequestFunc func(c *gin.Context, account *model.Account, requestBody []byte)
 
+// fallbackCaptureKey 上下文中保存当前尝试的响应捕获器的键
+const fallbackCaptureKey = "fallback_capture"
+
+// errStreamAborted 流在首个内容前被判定失败，通知处理函数停止转发
+var errStreamAborted = errors.New("stream aborted before first content, fallback to next account")
+
 // StreamingResponseCapture 流式响应捕获器（支持流式和非流式模式）
+// 流式模式下在收到首个content_block_delta之前暂存输出，期间出现错误事件或流中断时判定为失败，
+// 客户端不会收到任何数据，fallback可以无感知地切换到下一个账号
 type StreamingResponseCapture struct {
 	gin.ResponseWriter
 	statusCode       int
@@ -511,6 +832,17 @@ type StreamingResponseCapture struct {
 	isStreamMode     bool         // 是否为流式模式
 	totalDataSize    int          // 总数据大小
 	upstreamHeaders  http.Header  // 新增：缓存上游响应头
+	holdMaxBytes     int           // 首个内容前最多暂存的字节数
+	holdTimeout      time.Duration // 首个内容前最长暂存时间
+	scanOffset       int           // 暂存数据中已解析的位置
+	sawMessageStart  bool          // 已收到message_start事件
+	sawMessageStop   bool          // 已收到message_stop事件
+	contentStarted   bool          // 已收到首个content_block_delta事件
+	aborted          bool          // 流在首个内容前被判定失败
+	abortReason      string        // 失败原因
+	race             *hedgeRace    // 对冲请求的竞争状态，非对冲请求为nil
+	hedgeHeader      http.Header   // 对冲请求获胜前使用的独立响应头
+	firstByteMu      sync.Mutex    // 保护firstByteTime，对冲时会被其他协程读取
 }
 
 // NewStreamingResponseCapture 创建流式响应捕获器
@@ -543,105 +875,263 @@ func isFallbackStreamResponse(header http.Header) bool {
 
 // Header 拦截Header方法，缓存上游响应头
 func (w *StreamingResponseCapture) Header() http.Header {
+	if w.hedgeHeader != nil {
+		return w.hedgeHeader
+	}
 	return w.ResponseWriter.Header()
 }
 
+// claimOutput 对冲请求中获取向客户端输出的权利，落败时标记失败并返回false
+func (w *StreamingResponseCapture) claimOutput() bool {
+	if w.race == nil {
+		return true
+	}
+	if !w.race.claim(w) {
+		w.abortStream(statusHedgeLost, "对冲请求落败")
+		return false
+	}
+	// 获胜后将独立的响应头复制到客户端响应
+	for name, values := range w.hedgeHeader {
+		w.ResponseWriter.Header()[name] = values
+	}
+	return true
+}
+
 // CacheUpstreamHeaders 缓存上游响应头（在非流式模式下使用）
 func (w *StreamingResponseCapture) CacheUpstreamHeaders() {
 	if !w.isStreamMode {
 		// 复制当前响应头到缓存
-		for name, values := range w.ResponseWriter.Header() {
+		for name, values := range w.Header() {
 			w.upstreamHeaders[name] = values
 		}
 	}
 }
 
 // WriteHeader 捕获状态码并判断是否成功
+// 处理函数通常在设置Content-Type之前调用c.Status，因此流式模式延迟到首次写入时判断，这里不输出响应头
 func (w *StreamingResponseCapture) WriteHeader(statusCode int) {
 	log.Printf("🔍 [Fallback] WriteHeader调用: statusCode=%d", statusCode)
 	
 	w.statusCode = statusCode
 	w.isSuccess = statusCode >= 200 && statusCode < 400
 	
-	// 在收到响应头时根据Content-Type判断是否为流式模式
-	if !w.headersCopied {
-		log.Printf("🔍 [Fallback] 开始判断流式模式，当前ResponseWriter头部：")
-		for name, values := range w.ResponseWriter.Header() {
-			log.Printf("🔍 [Fallback]   %s: %v", name, values)
-		}
-		w.isStreamMode = isFallbackStreamResponse(w.ResponseWriter.Header())
-		w.headersCopied = true
+	// 先缓存响应头，非流式模式在请求完成后统一输出
+	w.CacheUpstreamHeaders()
+}
+
+// WriteHeaderNow 响应头由捕获器决定何时输出，处理函数的提前输出请求被忽略
+func (w *StreamingResponseCapture) WriteHeaderNow() {
+	if w.headerSet {
+		w.ResponseWriter.WriteHeaderNow()
 	}
-	
-	log.Printf("🔍 [Fallback] 流式判断结果: isStreamMode=%v, isSuccess=%v", w.isStreamMode, w.isSuccess)
-	
-	if w.isStreamMode {
-		// 流式模式：如果成功立即设置响应头启动流式输出
-		if w.isSuccess && !w.headerSet {
-			log.Printf("📡 [Fallback] 启动流式输出模式")
-			w.ResponseWriter.WriteHeader(statusCode)
-			w.headerSet = true
-		}
-	} else {
-		// 非流式模式：先缓存响应头，不立即输出
-		log.Printf("📄 [Fallback] 缓存响应头用于非流式输出")
-		w.CacheUpstreamHeaders()
+}
+
+// Flush 只有在流式输出已开始后才刷新到客户端，暂存期间忽略
+func (w *StreamingResponseCapture) Flush() {
+	if w.headerSet {
+		w.ResponseWriter.Flush()
 	}
 }
 
+// WriteString 写入字符串，与Write使用相同的暂存逻辑
+func (w *StreamingResponseCapture) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
 // Write 写入响应体（根据流式/非流式模式采用不同策略）
 func (w *StreamingResponseCapture) Write(data []byte) (int, error) {
 	if len(data) == 0 {
 		return 0, nil
 	}
 	
-	// 如果还没有判断过流式模式，在首次写入时判断
+	// 首次写入时处理函数已设置好响应头，在此判断流式模式
 	if !w.headersCopied {
-		w.isStreamMode = isFallbackStreamResponse(w.ResponseWriter.Header())
+		w.isStreamMode = isFallbackStreamResponse(w.Header())
 		w.headersCopied = true
+		log.Printf("🔍 [Fallback] 流式判断结果: isStreamMode=%v, isSuccess=%v", w.isStreamMode, w.isSuccess)
 	}
 	
 	// 记录首次数据到达时间
 	if !w.hasReceivedData {
 		now := time.Now()
+		w.firstByteMu.Lock()
 		w.firstByteTime = &now
+		w.firstByteMu.Unlock()
 		w.hasReceivedData = true
 	}
 	
 	// 累计数据大小
 	w.totalDataSize += len(data)
 	
-	if w.isStreamMode {
-		// 流式模式：成功时立即输出，失败时缓存
-		if w.isSuccess && w.headerSet {
-			// 成功的流式输出：直接写入并立即刷新
-			n, err := w.ResponseWriter.Write(data)
-			if err == nil {
-				if flusher, ok := w.ResponseWriter.(interface{ Flush() }); ok {
-					flusher.Flush()
-				}
-			}
-			return n, err
-		} else if w.isSuccess && !w.headerSet {
-			// 首次成功写入：设置响应头后写入
-			w.ResponseWriter.WriteHeader(w.statusCode)
-			w.headerSet = true
-			
-			n, err := w.ResponseWriter.Write(data)
-			if err == nil {
-				if flusher, ok := w.ResponseWriter.(interface{ Flush() }); ok {
-					flusher.Flush()
-				}
-			}
-			return n, err
-		} else {
-			// 失败时缓存数据
-			return w.buffer.Write(data)
-		}
-	} else {
-		// 非流式模式：无论成功失败都先缓存，等请求完成后再决定如何处理
+	// 对冲请求的非流式成功响应：首次写入即为完整响应，在此竞争输出权
+	if !w.isStreamMode && w.isSuccess && !w.claimOutput() {
+		return 0, errHedgeLost
+	}
+
+	if !w.isStreamMode || !w.isSuccess {
+		// 非流式模式或失败响应：先缓存，等请求完成后再决定如何处理
 		return w.buffer.Write(data)
 	}
+
+	if w.headerSet {
+		// 流式输出已开始：直接写入并立即刷新
+		return w.writeThrough(data)
+	}
+
+	// 配置为0时不暂存，直接开始流式输出
+	if w.holdMaxBytes <= 0 {
+		if err := w.commitStream(); err != nil {
+			return 0, err
+		}
+		return w.writeThrough(data)
+	}
+
+	// 首个内容前：暂存数据并检查是否出现错误事件
+	w.buffer.Write(data)
+	w.scanHeldEvents()
+
+	if w.aborted {
+		return len(data), errStreamAborted
+	}
+	if w.race != nil && w.race.lost(w) {
+		w.abortStream(statusHedgeLost, "对冲请求落败")
+		return 0, errHedgeLost
+	}
+
+	if w.contentStarted || w.holdBudgetExceeded() {
+		if err := w.commitStream(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+// writeThrough 直接写入客户端并刷新
+func (w *StreamingResponseCapture) writeThrough(data []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(data)
+	if err == nil {
+		w.ResponseWriter.Flush()
+	}
+	return n, err
+}
+
+// scanHeldEvents 解析暂存数据中新增的完整SSE行，识别首个内容、错误事件和消息起止
+func (w *StreamingResponseCapture) scanHeldEvents() {
+	held := w.buffer.Bytes()
+	for !w.aborted && !w.contentStarted {
+		end := bytes.IndexByte(held[w.scanOffset:], '\n')
+		if end < 0 {
+			return
+		}
+		line := strings.TrimSpace(string(held[w.scanOffset : w.scanOffset+end]))
+		w.scanOffset += end + 1
+
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		payload := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+
+		switch gjson.Get(payload, "type").String() {
+		case "content_block_delta":
+			w.contentStarted = true
+		case "message_start":
+			w.sawMessageStart = true
+		case "message_stop":
+			w.sawMessageStop = true
+		case "error":
+			w.abortStream(streamErrorStatusCode(gjson.Get(payload, "error.type").String()), payload)
+		}
+	}
+}
+
+// holdBudgetExceeded 暂存数据超过字节数或时间预算时不再等待首个内容
+func (w *StreamingResponseCapture) holdBudgetExceeded() bool {
+	if w.buffer.Len() > w.holdMaxBytes {
+		return true
+	}
+	return w.holdTimeout > 0 && w.firstByteTime != nil && time.Since(*w.firstByteTime) > w.holdTimeout
+}
+
+// commitStream 输出响应头和暂存的数据，之后的数据直接透传
+func (w *StreamingResponseCapture) commitStream() error {
+	if !w.claimOutput() {
+		return errHedgeLost
+	}
+	log.Printf("📡 [Fallback] 启动流式输出模式，暂存数据: %dB", w.buffer.Len())
+	w.ResponseWriter.WriteHeader(w.statusCode)
+	w.headerSet = true
+
+	_, err := w.writeThrough(w.buffer.Bytes())
+	w.buffer.Reset()
+	w.scanOffset = 0
+	return err
+}
+
+// abortStream 标记流在首个内容前失败
+func (w *StreamingResponseCapture) abortStream(statusCode int, reason string) {
+	log.Printf("⚠️ [Fallback] 流式响应在首个内容前失败，状态码: %d，原因: %s", statusCode, reason)
+	w.aborted = true
+	w.abortReason = reason
+	w.statusCode = statusCode
+	w.isSuccess = false
+}
+
+// checkHeldStream 判断仍在暂存中的流是否已中断（收到message_start但未收到message_stop），返回是否失败
+func (w *StreamingResponseCapture) checkHeldStream() bool {
+	if w.aborted {
+		return true
+	}
+	if !w.isStreamMode || !w.isSuccess || w.headerSet {
+		return false
+	}
+
+	// 解析可能缺少结尾换行的最后一行
+	if w.buffer.Len() > 0 && !bytes.HasSuffix(w.buffer.Bytes(), []byte("\n")) {
+		w.buffer.WriteByte('\n')
+		w.scanHeldEvents()
+	}
+
+	if !w.aborted && w.sawMessageStart && !w.sawMessageStop {
+		w.abortStream(http.StatusBadGateway, "上游流式响应在首个内容前中断")
+	}
+	return w.aborted
+}
+
+// finishHeldStream 请求结束时处理仍在暂存中的流：未中断则输出（如没有内容块的正常响应）
+func (w *StreamingResponseCapture) finishHeldStream() {
+	if w.checkHeldStream() || !w.isStreamMode || !w.isSuccess || w.headerSet {
+		return
+	}
+	if err := w.commitStream(); err != nil {
+		log.Printf("⚠️ [Fallback] 输出暂存的流式数据失败: %v", err)
+	}
+}
+
+// streamErrorStatusCode 根据流式错误事件的类型返回对应的HTTP状态码
+func streamErrorStatusCode(errorType string) int {
+	switch errorType {
+	case "overloaded_error":
+		return 529
+	case "rate_limit_error":
+		return http.StatusTooManyRequests
+	case "api_error":
+		return http.StatusInternalServerError
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// fallbackStatusCode 返回处理函数应记录的状态码
+// 流在首个内容前被fallback判定失败时，客户端没有收到任何数据，本次尝试应按失败记录而不是上游的200
+func fallbackStatusCode(c *gin.Context, statusCode int) int {
+	value, exists := c.Get(fallbackCaptureKey)
+	if !exists {
+		return statusCode
+	}
+	if capture, ok := value.(*StreamingResponseCapture); ok && capture != nil && capture.checkHeldStream() {
+		return capture.statusCode
+	}
+	return statusCode
 }
 
 // GetBufferedData 获取缓存的数据
@@ -653,7 +1143,7 @@ func (w *StreamingResponseCapture) GetBufferedData() []byte {
 func (w *StreamingResponseCapture) FlushNonStreamResponse() error {
 	log.Printf("🔍 [Fallback] FlushNonStreamResponse: isStreamMode=%v, isSuccess=%v", w.isStreamMode, w.isSuccess)
 	
-	if !w.isStreamMode && w.isSuccess {
+	if !w.isStreamMode && w.isSuccess && w.claimOutput() {
 		log.Printf("📄 [Fallback] 开始输出非流式响应，数据大小: %d bytes", w.buffer.Len())
 		
 		// 复制缓存的上游响应头到最终响应，但跳过Content-Type
@@ -688,6 +1178,8 @@ func (w *StreamingResponseCapture) FlushNonStreamResponse() error {
 
 // GetFirstByteTime 获取首次响应时间(TTFB - Time To First Byte)
 func (w *StreamingResponseCapture) GetFirstByteTime() *time.Duration {
+	w.firstByteMu.Lock()
+	defer w.firstByteMu.Unlock()
 	if w.firstByteTime == nil {
 		return nil
 	}
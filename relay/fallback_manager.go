@@ -7,6 +7,7 @@ import (
 	"sync"
 	"time"
 	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
 )
 
 // FallbackManager Fallback管理器，负责管理所有的fallback处理器
@@ -14,6 +15,7 @@ type FallbackManager struct {
 	handlers map[string]*FallbackHandler // key: groupID的字符串表示
 	config   *FallbackConfig
 	mu       sync.RWMutex
+	pubsub   *redis.PubSub // 跨副本共享状态的订阅，未启用时为nil
 }
 
 // GlobalFallbackManager 全局fallback管理器
@@ -29,6 +31,7 @@ func InitFallbackManager(config *FallbackConfig) {
 		handlers: make(map[string]*FallbackHandler),
 		config:   config,
 	}
+	GlobalFallbackManager.subscribeSharedState()
 }
 
 // GetHandler 获取指定分组的fallback处理器
@@ -54,6 +57,7 @@ func (fm *FallbackManager) GetHandler(groupID int) *FallbackHandler {
 	
 	// 创建新的处理器
 	handler = NewFallbackHandler(fm.config)
+	handler.attachSharedState(groupID)
 	fm.handlers[groupKey] = handler
 	
 	return handler
@@ -94,8 +98,13 @@ func (fm *FallbackManager) GetStats() map[string]interface{} {
 	
 	for groupKey, handler := range fm.handlers {
 		groupStats := map[string]interface{}{
-			"circuit_breaker": handler.circuitBreaker.GetStats(),
+			"circuit_breakers": handler.GetCircuitBreakerStats(),
 			"health_monitor":  handler.healthMonitor.GetAllHealthStats(),
+			"sticky_session":  handler.stickyStats.GetStats(),
+			"concurrency_queue": handler.waitQueue.Len(),
+		}
+		if latencySelector, ok := handler.selector.(*LatencyAwareSelector); ok {
+			groupStats["latency"] = latencySelector.GetStats()
 		}
 		stats["groups"].(map[string]interface{})[groupKey] = groupStats
 	}
@@ -131,6 +140,12 @@ func (fm *FallbackManager) Cleanup() {
 	
 	// 清空处理器
 	fm.handlers = make(map[string]*FallbackHandler)
+
+	// 停止接收其他副本的状态变更通知
+	if fm.pubsub != nil {
+		fm.pubsub.Close()
+		fm.pubsub = nil
+	}
 	
 	log.Printf("✅ FallbackManager资源清理完成")
 }
@@ -183,7 +198,10 @@ func UpdateAccountPerformance(groupID int, accountID uint, success bool, respons
 		adaptiveSelector.UpdatePerformance(accountID, success, responseTime)
 	} else if smartSelector, ok := handler.selector.(*SmartLoadBalanceSelector); ok {
 		smartSelector.UpdatePerformance(accountID, success, responseTime)
+	} else {
+		return
 	}
+	handler.shared.markDirty(sharedStatePerformance, accountID)
 }
 
 // DisableAccount 禁用指定账号
@@ -213,7 +231,20 @@ func GetAccountHealth(groupID int, accountID uint) *AccountHealth {
 	}
 	
 	handler := GlobalFallbackManager.GetHandler(groupID)
-	return handler.healthMonitor.GetAccountHealth(accountID)
+	breakerStats := handler.getBreakerStats(accountID)
+
+	health := handler.healthMonitor.GetAccountHealth(accountID)
+	if health == nil {
+		if breakerStats == nil {
+			return nil
+		}
+		return &AccountHealth{AccountID: accountID, CircuitBreaker: breakerStats}
+	}
+
+	// 返回副本，避免修改健康监控中的数据
+	healthCopy := *health
+	healthCopy.CircuitBreaker = breakerStats
+	return &healthCopy
 }
 
 // GetGroupHealthStats 获取分组健康统计
@@ -223,7 +254,32 @@ func GetGroupHealthStats(groupID int) map[uint]*AccountHealth {
 	}
 	
 	handler := GlobalFallbackManager.GetHandler(groupID)
-	return handler.healthMonitor.GetAllHealthStats()
+	stats := handler.healthMonitor.GetAllHealthStats()
+	for accountID, breakerStats := range handler.GetCircuitBreakerStats() {
+		if health, exists := stats[accountID]; exists {
+			health.CircuitBreaker = breakerStats
+		} else {
+			stats[accountID] = &AccountHealth{AccountID: accountID, CircuitBreaker: breakerStats}
+		}
+	}
+	return stats
+}
+
+// GetStickySessionStats 获取会话粘性统计，groupID小于0时返回所有分组
+func GetStickySessionStats(groupID int) map[string]interface{} {
+	if GlobalFallbackManager == nil {
+		return map[string]interface{}{}
+	}
+
+	if groupID >= 0 {
+		return GlobalFallbackManager.GetHandler(groupID).stickyStats.GetStats()
+	}
+
+	groups := make(map[string]interface{})
+	for id, handler := range GlobalFallbackManager.GetAllHandlers() {
+		groups[strconv.Itoa(id)] = handler.stickyStats.GetStats()
+	}
+	return groups
 }
 
 // CleanupStaleData 清理过期数据
@@ -272,7 +328,9 @@ func ResetMetrics() {
 	}
 	
 	// 重置所有处理器的指标
-	// 当前实现中没有具体的指标存储，这里留空
+	for _, handler := range GlobalFallbackManager.GetAllHandlers() {
+		handler.stickyStats.Reset()
+	}
 }
 
 // ExportMetrics 导出指标数据
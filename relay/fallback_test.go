@@ -2,9 +2,15 @@ package relay
 
 import (
 	"claude-code-relay/model"
+	"net/http"
+	"net/http/httptest"
 	"runtime"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
+
+	"github.com/gin-gonic/gin"
 )
 
 // TestMemoryLeak 测试内存泄漏修复
@@ -212,4 +218,489 @@ func BenchmarkAdaptiveSelector(b *testing.B) {
 	}
 	
 	b.ReportAllocs()
-}
\ No newline at end of file
+}
+// writeTestStream 模拟处理函数输出流式响应
+func writeTestStream(c *gin.Context, events ...string) {
+	c.Status(http.StatusOK)
+	c.Header("Content-Type", "text/event-stream")
+	c.Writer.Flush()
+	for _, event := range events {
+		if _, err := c.Writer.Write([]byte(event)); err != nil {
+			return
+		}
+	}
+}
+
+// TestFallbackBeforeFirstContent 测试首个内容前出现错误事件或流中断时无感知切换到下一个账号
+func TestFallbackBeforeFirstContent(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	messageStart := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"usage\":{\"input_tokens\":10}}}\n\n"
+	overloaded := "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\",\"message\":\"Overloaded\"}}\n\n"
+	content := "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"hi\"}}\n\n"
+	messageStop := "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+
+	handler := NewFallbackHandler(&FallbackConfig{
+		MaxRetries:         3,
+		Strategy:           StrategyPriorityFirst,
+		StreamHoldMaxBytes: 64 * 1024,
+		StreamHoldTimeout:  time.Second * 10,
+	})
+	defer handler.Stop()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	accounts := []model.Account{{ID: 1, Name: "overloaded"}, {ID: 2, Name: "truncated"}, {ID: 3, Name: "ok"}}
+
+	var recordedStatus []int
+	result := handler.executeFallback(c, accounts, []byte(`{"stream":true}`), func(c *gin.Context, account *model.Account, requestBody []byte) {
+		switch account.ID {
+		case 1:
+			writeTestStream(c, messageStart, overloaded)
+		case 2:
+			writeTestStream(c, messageStart)
+		default:
+			writeTestStream(c, messageStart, content, messageStop)
+		}
+		recordedStatus = append(recordedStatus, fallbackStatusCode(c, http.StatusOK))
+	}, time.Now())
+
+	if !result.Success || result.Account.ID != 3 {
+		t.Fatalf("应切换到账号3并成功，实际: success=%v", result.Success)
+	}
+	if len(recordedStatus) != 3 || recordedStatus[0] != 529 || recordedStatus[1] != http.StatusBadGateway || recordedStatus[2] != http.StatusOK {
+		t.Errorf("各次尝试记录的状态码错误: %v", recordedStatus)
+	}
+
+	body := recorder.Body.String()
+	if strings.Contains(body, "overloaded_error") {
+		t.Error("客户端不应收到失败账号的错误事件")
+	}
+	if strings.Count(body, "event: message_start") != 1 || !strings.Contains(body, "text_delta") {
+		t.Errorf("客户端应只收到成功账号的完整流，实际: %s", body)
+	}
+}
+
+// TestStreamHoldBudget 测试超过暂存预算后开始输出
+func TestStreamHoldBudget(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	capture := NewStreamingResponseCapture(c.Writer, time.Now(), nil)
+	capture.holdMaxBytes = 16
+	c.Writer = capture
+
+	writeTestStream(c, "event: ping\ndata: {\"type\":\"ping\"}\n\n")
+	if recorder.Body.Len() == 0 {
+		t.Error("超过字节预算后应开始输出")
+	}
+}
+
+// TestHedgedRequest 测试主账号迟迟没有首字节时对冲到下一个账号，只输出胜者并取消落败方
+func TestHedgedRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := NewFallbackHandler(&FallbackConfig{
+		MaxRetries:         3,
+		Strategy:           StrategyPriorityFirst,
+		StreamHoldMaxBytes: 64 * 1024,
+		StreamHoldTimeout:  time.Second * 10,
+	})
+	defer handler.Stop()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Set("api_key", &model.ApiKey{ID: 1, HedgeDelay: 20})
+
+	accounts := []model.Account{{ID: 1, Name: "slow"}, {ID: 2, Name: "fast"}}
+	slowCancelled := make(chan bool, 1)
+
+	result := handler.executeFallback(c, accounts, []byte(`{"stream":true}`), func(c *gin.Context, account *model.Account, requestBody []byte) {
+		if account.ID == 1 {
+			select {
+			case <-c.Request.Context().Done():
+				slowCancelled <- isHedgeLoser(c)
+				return
+			case <-time.After(time.Second * 2):
+			}
+		}
+		writeTestStream(c, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\""+account.Name+"\"}}\n\n")
+	}, time.Now())
+
+	if !result.Success || result.Account.ID != 2 {
+		t.Fatalf("应由对冲的账号2获胜，实际: success=%v", result.Success)
+	}
+	select {
+	case loser := <-slowCancelled:
+		if !loser {
+			t.Error("被取消的主账号应识别为对冲落败方")
+		}
+	case <-time.After(time.Second):
+		t.Error("落败的主账号请求应被取消")
+	}
+	if body := recorder.Body.String(); strings.Contains(body, "slow") || !strings.Contains(body, "fast") {
+		t.Errorf("客户端应只收到胜者的数据，实际: %s", body)
+	}
+}
+
+// TestHedgedRequestClientError 测试主账号返回请求参数错误时不发起对冲，直接返回该错误
+func TestHedgedRequestClientError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := NewFallbackHandler(&FallbackConfig{
+		MaxRetries:         3,
+		Strategy:           StrategyPriorityFirst,
+		StreamHoldMaxBytes: 64 * 1024,
+		StreamHoldTimeout:  time.Second * 10,
+	})
+	defer handler.Stop()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Set("api_key", &model.ApiKey{ID: 1, HedgeDelay: 20})
+
+	accounts := []model.Account{{ID: 1, Name: "primary"}, {ID: 2, Name: "secondary"}, {ID: 3, Name: "third"}}
+	var calls int32
+
+	result := handler.executeFallback(c, accounts, []byte(`{"stream":true}`), func(c *gin.Context, account *model.Account, requestBody []byte) {
+		atomic.AddInt32(&calls, 1)
+		c.JSON(http.StatusBadRequest, gin.H{"type": "error", "error": gin.H{"type": "invalid_request_error", "message": "max_tokens: field required"}})
+	}, time.Now())
+
+	if result.Success || result.ErrorClass != ErrorClassClient || result.Account.ID != 1 {
+		t.Fatalf("应直接返回主账号的请求参数错误，实际: success=%v, class=%s", result.Success, result.ErrorClass)
+	}
+	// 等待超过对冲延迟，确认没有发起对冲请求
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("请求参数错误时只应请求上游一次，实际: %d", n)
+	}
+}
+
+// TestHedgedPrimaryFailureRecorded 测试主账号认证失败、备用账号获胜时，主账号的失败仍计入熔断器并被临时禁用
+func TestHedgedPrimaryFailureRecorded(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	handler := NewFallbackHandler(&FallbackConfig{
+		MaxRetries:              3,
+		Strategy:                StrategyPriorityFirst,
+		EnableCircuitBreaker:    true,
+		EnableHealthCheck:       true,
+		HealthCheckInterval:     time.Minute,
+		CircuitBreakerThreshold: 5,
+		FailureWindow:           time.Minute,
+		RecoveryWindow:          time.Minute,
+		StreamHoldMaxBytes:      64 * 1024,
+		StreamHoldTimeout:       time.Second * 10,
+	})
+	defer handler.Stop()
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", nil)
+	c.Set("api_key", &model.ApiKey{ID: 1, HedgeDelay: 20})
+
+	accounts := []model.Account{{ID: 1, Name: "revoked"}, {ID: 2, Name: "ok"}}
+	result := handler.executeFallback(c, accounts, []byte(`{"stream":true}`), func(c *gin.Context, account *model.Account, requestBody []byte) {
+		if account.ID == 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"type": "error", "error": gin.H{"type": "authentication_error", "message": "invalid x-api-key"}})
+			return
+		}
+		writeTestStream(c, "event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"delta\":{\"type\":\"text_delta\",\"text\":\"ok\"}}\n\n")
+	}, time.Now())
+
+	if !result.Success || result.Account.ID != 2 {
+		t.Fatalf("应由备用账号2成功，实际: success=%v", result.Success)
+	}
+	if count := handler.getBreaker(1).GetStats()["failure_count"]; count != int64(1) {
+		t.Errorf("主账号的失败应计入熔断器，实际失败次数: %v", count)
+	}
+	if handler.isAccountAvailable(1) {
+		t.Error("认证失败的主账号应被临时禁用")
+	}
+}
+
+// skewedLatencies 模拟延迟差异较大的一组账号的基础首字节延迟
+var skewedLatencies = []time.Duration{
+	time.Millisecond * 2000,
+	time.Millisecond * 150,
+	time.Millisecond * 1500,
+	time.Millisecond * 200,
+	time.Millisecond * 1800,
+	time.Millisecond * 100,
+}
+
+// simulateSkewedLatency 在虚拟时钟下模拟持续到达的并发请求，返回平均首字节延迟
+// 每个请求使用选择结果中的第一个账号，账号的延迟随其正在处理的请求数增加而上升，被选中的账号今日使用次数加1
+func simulateSkewedLatency(selector AccountSelector, requests int) time.Duration {
+	accounts := make([]model.Account, len(skewedLatencies))
+	for i := range accounts {
+		accounts[i] = model.Account{ID: uint(i + 1), Priority: 1, Weight: 100, CurrentStatus: 1}
+	}
+	tracker, _ := selector.(LoadTracker)
+
+	type pendingRequest struct {
+		accountID uint
+		doneAt    time.Duration
+		latency   time.Duration
+	}
+	var pending []pendingRequest
+	inFlight := make(map[uint]int)
+
+	const arrivalInterval = time.Millisecond * 250
+	var now, total time.Duration
+	for i := 0; i < requests; i++ {
+		now += arrivalInterval
+
+		remaining := pending[:0]
+		for _, request := range pending {
+			if request.doneAt > now {
+				remaining = append(remaining, request)
+				continue
+			}
+			inFlight[request.accountID]--
+			if tracker != nil {
+				tracker.RequestFinished(request.accountID, request.latency)
+			}
+		}
+		pending = remaining
+
+		candidates := make([]model.Account, len(accounts))
+		copy(candidates, accounts)
+		chosen := selector.Select(candidates)[0]
+
+		// 每个正在处理的请求使延迟增加20%，最多增加到3倍
+		base := skewedLatencies[chosen.ID-1]
+		latency := base + base*time.Duration(min(inFlight[chosen.ID], 10))/5
+		accounts[chosen.ID-1].TodayUsageCount++
+		inFlight[chosen.ID]++
+		if tracker != nil {
+			tracker.RequestStarted(chosen.ID)
+		}
+		pending = append(pending, pendingRequest{accountID: chosen.ID, doneAt: now + latency, latency: latency})
+		total += latency
+	}
+
+	return total / time.Duration(requests)
+}
+
+// skewedLatencySelectors 参与延迟对比的选择策略
+var skewedLatencySelectors = []struct {
+	name   string
+	create func() AccountSelector
+}{
+	{"priority_first", func() AccountSelector { return &PrioritySelector{} }},
+	{"weighted", func() AccountSelector { return &WeightedSelector{} }},
+	{"round_robin", func() AccountSelector { return &RoundRobinSelector{} }},
+	{"least_used", func() AccountSelector { return &LeastUsedSelector{} }},
+	{"latency_aware", func() AccountSelector { return NewLatencyAwareSelector() }},
+}
+
+// BenchmarkSelectorSkewedLatency 对比各选择策略在账号延迟差异较大时的平均首字节延迟
+// 每次迭代模拟一个请求，ttfb-ms/op 越低越好
+func BenchmarkSelectorSkewedLatency(b *testing.B) {
+	for _, tt := range skewedLatencySelectors {
+		b.Run(tt.name, func(b *testing.B) {
+			selector := tt.create()
+			b.ResetTimer()
+			avg := simulateSkewedLatency(selector, b.N)
+			b.ReportMetric(float64(avg)/float64(time.Millisecond), "ttfb-ms/op")
+		})
+	}
+}
+
+// BenchmarkLatencyAwareSelector 基准测试延迟感知选择器的选择开销
+func BenchmarkLatencyAwareSelector(b *testing.B) {
+	selector := NewLatencyAwareSelector()
+	accounts := make([]model.Account, 10)
+	for i := range accounts {
+		accounts[i] = model.Account{ID: uint(i), Priority: i % 2}
+		selector.RequestStarted(accounts[i].ID)
+		selector.RequestFinished(accounts[i].ID, time.Duration(i+1)*time.Millisecond*100)
+	}
+
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		selector.Select(accounts)
+	}
+
+	b.ReportAllocs()
+}
+
+// TestLatencyAwareSelectorSkewedLatency 测试延迟感知策略在延迟差异较大时优于现有策略
+func TestLatencyAwareSelectorSkewedLatency(t *testing.T) {
+	const requests = 2000
+	latencyAware := simulateSkewedLatency(NewLatencyAwareSelector(), requests)
+
+	for _, tt := range skewedLatencySelectors {
+		if tt.name == "latency_aware" {
+			continue
+		}
+		if avg := simulateSkewedLatency(tt.create(), requests); latencyAware >= avg {
+			t.Errorf("延迟感知策略的平均延迟 %v 应低于 %s 的 %v", latencyAware, tt.name, avg)
+		}
+	}
+}
+
+// TestLatencyAwareSelectorPriorityTier 测试延迟感知策略只在最高优先级内选择
+func TestLatencyAwareSelectorPriorityTier(t *testing.T) {
+	selector := NewLatencyAwareSelector()
+	// 低优先级账号延迟更低，但仍应排在高优先级账号之后
+	selector.RequestStarted(3)
+	selector.RequestFinished(3, time.Millisecond*10)
+	selector.RequestStarted(1)
+	selector.RequestFinished(1, time.Second)
+
+	accounts := []model.Account{{ID: 3, Priority: 2}, {ID: 1, Priority: 1}, {ID: 2, Priority: 1}}
+	result := selector.Select(accounts)
+	if len(result) != 3 || result[2].ID != 3 {
+		t.Fatalf("低优先级账号应排在最后，实际: %v", []uint{result[0].ID, result[1].ID, result[2].ID})
+	}
+	// 两个账号比较时选择代价更低的：账号2没有样本，代价最低
+	if result[0].ID != 2 {
+		t.Errorf("同优先级内应优先选择负载更低的账号，实际首选: %d", result[0].ID)
+	}
+}
+
+// TestCostAwareSelector 测试成本感知选择器优先使用MAX账号和成本系数低的账号
+func TestCostAwareSelector(t *testing.T) {
+	selector := &CostAwareSelector{}
+	accounts := []model.Account{
+		{ID: 1, Priority: 1, CostMultiplier: 1, CurrentStatus: 1},
+		{ID: 2, Priority: 2, CostMultiplier: 0.5, CurrentStatus: 1},
+		{ID: 3, Priority: 3, IsMax: true, CostMultiplier: 1, CurrentStatus: 1},
+		{ID: 4, Priority: 1, IsMax: true, CostMultiplier: 1, CurrentStatus: 3},
+		{ID: 5, Priority: 1, CostMultiplier: 0.5, CurrentStatus: 1, TodayUsageCount: 10},
+	}
+
+	result := selector.Select(accounts)
+	expected := []uint{3, 5, 2, 1, 4}
+	for i, id := range expected {
+		if result[i].ID != id {
+			t.Fatalf("账号顺序错误，期望: %v，实际第%d个为: %d", expected, i+1, result[i].ID)
+		}
+	}
+}
+
+// TestHedgeDelayOnlyForMessages 只有对话接口启用对冲，批处理创建不对冲
+func TestHedgeDelayOnlyForMessages(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := map[string]time.Duration{
+		"/claude-code/v1/messages":         20 * time.Millisecond,
+		"/claude-code/v1/messages/batches": 0,
+		"/claude-code/v1/chat/completions": 0,
+	}
+	for path, expected := range cases {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest(http.MethodPost, path, nil)
+		c.Set("api_key", &model.ApiKey{ID: 1, HedgeDelay: 20})
+		if got := hedgeDelayFor(c); got != expected {
+			t.Errorf("%s 的对冲延迟应为 %s, 实际: %s", path, expected, got)
+		}
+	}
+}
+
+// TestDefaultConfigOptInFeatures 主动探测、会话粘性和共享状态默认关闭
+func TestDefaultConfigOptInFeatures(t *testing.T) {
+	if config := getDefaultConfig(); config.EnableHealthProbe || config.EnableStickySession || config.EnableSharedState {
+		t.Errorf("可选功能应默认关闭: %+v", config)
+	}
+}
+
+// TestStreamHoldDisabled 默认暂存首个内容前的流式响应，配置为0时直接透传，不解析错误事件
+func TestStreamHoldDisabled(t *testing.T) {
+	if getDefaultConfig().StreamHoldMaxBytes <= 0 {
+		t.Error("默认应暂存首个内容前的流式响应，以便出错时切换账号")
+	}
+
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	capture := NewStreamingResponseCapture(c.Writer, time.Now(), nil)
+	c.Writer = capture
+
+	writeTestStream(c, "event: error\ndata: {\"type\":\"error\",\"error\":{\"type\":\"overloaded_error\"}}\n\n")
+	if capture.aborted || !strings.Contains(recorder.Body.String(), "overloaded_error") {
+		t.Errorf("未启用暂存时应直接输出: %s", recorder.Body.String())
+	}
+}
+
+// abortedStreamRequestBody 首个内容前中断测试使用的流式请求
+const abortedStreamRequestBody = `{"model":"claude-sonnet-4-20250514","stream":true,"max_tokens":10,"messages":[{"role":"user","content":"hi"}]}`
+
+// runHeldStreamAttempt 在启用暂存的fallback捕获器中执行一次处理函数，上游由upstream模拟
+// 账号和API Key写入测试数据库，便于检查处理函数记录的状态、统计和日志
+func runHeldStreamAttempt(t *testing.T, account *model.Account, requestBody string, handle RequestFunc, upstream http.HandlerFunc) (*StreamingResponseCapture, *httptest.ResponseRecorder, *model.ApiKey) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	setupTestDB(t)
+
+	server := httptest.NewServer(upstream)
+	t.Cleanup(server.Close)
+
+	account.RequestURL = server.URL
+	account.CurrentStatus = 1
+	if err := model.DB.Create(account).Error; err != nil {
+		t.Fatalf("创建账号失败: %v", err)
+	}
+	apiKey := &model.ApiKey{Name: "k1", Key: "sk-test", UserID: 1}
+	if err := model.DB.Create(apiKey).Error; err != nil {
+		t.Fatalf("创建API Key失败: %v", err)
+	}
+
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(requestBody))
+	c.Set("api_key", apiKey)
+
+	capture := NewStreamingResponseCapture(c.Writer, time.Now(), nil)
+	capture.holdMaxBytes = 64 * 1024
+	c.Writer = capture
+	c.Set(fallbackCaptureKey, capture)
+
+	handle(c, account, []byte(requestBody))
+	return capture, recorder, apiKey
+}
+
+// assertAbortedBeforeContent 流在首个内容前中断，客户端没有收到任何数据
+func assertAbortedBeforeContent(t *testing.T, capture *StreamingResponseCapture, recorder *httptest.ResponseRecorder) {
+	t.Helper()
+	if !capture.aborted || recorder.Body.Len() != 0 {
+		t.Fatalf("流应在首个内容前中断且不输出给客户端: aborted=%v, body=%s", capture.aborted, recorder.Body.String())
+	}
+}
+
+// assertAttemptRecordedAsFailure 失败的尝试按失败更新账号状态，不计入API Key统计也不记录日志
+func assertAttemptRecordedAsFailure(t *testing.T, account *model.Account, apiKey *model.ApiKey) {
+	t.Helper()
+
+	// 部分处理函数异步更新账号状态
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		var stored model.Account
+		if err := model.DB.First(&stored, account.ID).Error; err != nil {
+			t.Fatalf("读取账号失败: %v", err)
+		}
+		if stored.CurrentStatus == 2 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("失败的尝试应将账号标记为接口异常, 实际状态: %d", stored.CurrentStatus)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var storedKey model.ApiKey
+	var logCount int64
+	model.DB.First(&storedKey, apiKey.ID)
+	model.DB.Model(&model.Log{}).Count(&logCount)
+	if storedKey.TodayUsageCount != 0 || logCount != 0 {
+		t.Errorf("中断的尝试不应计入API Key统计或记录日志: 使用次数 %d, 日志 %d 条", storedKey.TodayUsageCount, logCount)
+	}
+}
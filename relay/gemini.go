@@ -0,0 +1,707 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	GeminiDefaultBaseURL = "https://generativelanguage.googleapis.com/v1beta"
+	geminiDefaultModel   = "gemini-2.5-pro"
+)
+
+// Gemini API 类型定义
+type GeminiInlineData struct {
+	MimeType string `json:"mimeType"`
+	Data     string `json:"data"`
+}
+
+type GeminiFunctionCall struct {
+	Name string                 `json:"name"`
+	Args map[string]interface{} `json:"args,omitempty"`
+}
+
+type GeminiFunctionResponse struct {
+	Name     string                 `json:"name"`
+	Response map[string]interface{} `json:"response"`
+}
+
+type GeminiPart struct {
+	Text             string                  `json:"text,omitempty"`
+	Thought          bool                    `json:"thought,omitempty"`
+	InlineData       *GeminiInlineData       `json:"inlineData,omitempty"`
+	FunctionCall     *GeminiFunctionCall     `json:"functionCall,omitempty"`
+	FunctionResponse *GeminiFunctionResponse `json:"functionResponse,omitempty"`
+}
+
+type GeminiContent struct {
+	Role  string       `json:"role,omitempty"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiFunctionDeclaration struct {
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters,omitempty"`
+}
+
+type GeminiTool struct {
+	FunctionDeclarations []GeminiFunctionDeclaration `json:"functionDeclarations"`
+}
+
+type GeminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode"`
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+type GeminiToolConfig struct {
+	FunctionCallingConfig GeminiFunctionCallingConfig `json:"functionCallingConfig"`
+}
+
+type GeminiGenerationConfig struct {
+	MaxOutputTokens int      `json:"maxOutputTokens,omitempty"`
+	Temperature     *float64 `json:"temperature,omitempty"`
+	TopP            *float64 `json:"topP,omitempty"`
+	TopK            *int     `json:"topK,omitempty"`
+	StopSequences   []string `json:"stopSequences,omitempty"`
+}
+
+type GeminiRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	Tools             []GeminiTool            `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+}
+
+// Gemini 响应类型定义
+type GeminiCandidate struct {
+	Content      GeminiContent `json:"content"`
+	FinishReason string        `json:"finishReason,omitempty"`
+	Index        int           `json:"index"`
+}
+
+type GeminiUsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+}
+
+type GeminiResponse struct {
+	Candidates    []GeminiCandidate    `json:"candidates"`
+	UsageMetadata *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion  string               `json:"modelVersion,omitempty"`
+}
+
+// HandleGeminiRequest 处理 Gemini 平台请求的中转
+func HandleGeminiRequest(c *gin.Context, account *model.Account, requestBody []byte) {
+	startTime := time.Now()
+
+	apiKey := extractAPIKey(c)
+
+	// 解析Claude请求
+	var claudeReq ClaudeRequest
+	if err := json.Unmarshal(requestBody, &claudeReq); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]interface{}{
+				"type":    "json_parse_error",
+				"message": "Failed to parse request JSON: " + err.Error(),
+			},
+		})
+		return
+	}
+
+	// 应用模型映射并转换为Gemini格式
+	mappedModelName := applyModelMapping(claudeReq.Model, account.ModelMapping, geminiDefaultModel)
+	geminiReq := convertClaudeToGemini(claudeReq)
+
+	geminiBody, err := json.Marshal(geminiReq)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": map[string]interface{}{
+				"type":    "json_marshal_error",
+				"message": "Failed to marshal Gemini request: " + err.Error(),
+			},
+		})
+		return
+	}
+
+	req, err := http.NewRequestWithContext(c.Request.Context(), "POST", buildGeminiURL(account, mappedModelName, claudeReq.Stream), bytes.NewBuffer(geminiBody))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errCreateRequest, err.Error()))
+		return
+	}
+	setGeminiHeaders(req, account)
+
+	client := createHTTPClient(account)
+	if client == nil {
+		c.JSON(http.StatusInternalServerError, errProxyConfig)
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		handleRequestError(c, err)
+		return
+	}
+	defer common.CloseIO(resp.Body)
+
+	// 检查响应状态
+	accountService := service.NewAccountService()
+	if resp.StatusCode >= 400 {
+		accountService.UpdateAccountStatus(account, resp.StatusCode, nil)
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		log.Printf("❌ Gemini 状态码: %d, 错误响应内容: %s", resp.StatusCode, string(bodyBytes))
+		c.Data(resp.StatusCode, "application/json", bodyBytes)
+		return
+	}
+
+	var usageTokens *common.TokenUsage
+	if claudeReq.Stream {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Status(resp.StatusCode)
+		c.Writer.Flush()
+
+		transformer := newGeminiStreamTransformer(claudeReq.Model)
+		usageTokens = transformer.process(c.Writer, resp.Body)
+	} else {
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, appendErrorMessage(errResponseRead, err.Error()))
+			return
+		}
+
+		var geminiResp GeminiResponse
+		if err := json.Unmarshal(bodyBytes, &geminiResp); err != nil {
+			// 转换失败，直接返回原始响应
+			c.Data(resp.StatusCode, "application/json", bodyBytes)
+		} else {
+			claudeResp := convertGeminiToClaudeResponse(geminiResp, claudeReq.Model)
+			c.JSON(resp.StatusCode, claudeResp)
+			usageTokens = &common.TokenUsage{
+				InputTokens:          claudeResp.Usage.InputTokens,
+				OutputTokens:         claudeResp.Usage.OutputTokens,
+				CacheReadInputTokens: geminiCachedTokens(geminiResp.UsageMetadata),
+				Model:                claudeReq.Model,
+			}
+		}
+	}
+
+	// 如果没有usage信息，创建0值的TokenUsage用于日志记录
+	if usageTokens == nil {
+		usageTokens = &common.TokenUsage{Model: claudeReq.Model}
+	}
+
+	// 对冲请求中落败的一方不计费也不记录日志
+	if isHedgeLoser(c) {
+		return
+	}
+
+	// 流在首个内容前被fallback判定失败时按失败记录
+	statusCode := fallbackStatusCode(c, resp.StatusCode)
+
+	updateAccountAndStats(account, statusCode, usageTokens)
+
+	if apiKey != nil {
+		go service.UpdateApiKeyStatus(apiKey, statusCode, usageTokens)
+	}
+
+	saveRequestLog(startTime, apiKey, account, statusCode, usageTokens, claudeReq.Stream)
+}
+
+// buildGeminiURL 构建Gemini请求地址，流式请求使用SSE格式返回
+func buildGeminiURL(account *model.Account, modelName string, stream bool) string {
+	baseURL := strings.TrimRight(account.RequestURL, "/")
+	if baseURL == "" {
+		baseURL = GeminiDefaultBaseURL
+	}
+
+	if stream {
+		return fmt.Sprintf("%s/models/%s:streamGenerateContent?alt=sse", baseURL, modelName)
+	}
+	return fmt.Sprintf("%s/models/%s:generateContent", baseURL, modelName)
+}
+
+// setGeminiHeaders 设置Gemini API请求头
+func setGeminiHeaders(req *http.Request, account *model.Account) {
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("x-goog-api-key", account.SecretKey)
+}
+
+// convertClaudeToGemini 将Claude请求转换为Gemini格式
+func convertClaudeToGemini(claudeReq ClaudeRequest) GeminiRequest {
+	geminiReq := GeminiRequest{
+		GenerationConfig: &GeminiGenerationConfig{
+			MaxOutputTokens: claudeReq.MaxTokens,
+			Temperature:     claudeReq.Temperature,
+			TopP:            claudeReq.TopP,
+			TopK:            claudeReq.TopK,
+			StopSequences:   claudeReq.StopSequences,
+		},
+	}
+
+	// 添加system消息（支持字符串和数组格式）
+	if systemMessage := extractSystemMessage(claudeReq.System); systemMessage != "" {
+		geminiReq.SystemInstruction = &GeminiContent{
+			Parts: []GeminiPart{{Text: systemMessage}},
+		}
+	}
+
+	// tool_result 只携带 tool_use_id，Gemini 的 functionResponse 需要函数名，先建立映射
+	toolNames := make(map[string]string)
+	for _, message := range claudeReq.Messages {
+		if contentBlocks, ok := message.Content.([]interface{}); ok {
+			for _, block := range contentBlocks {
+				if blockMap, ok := block.(map[string]interface{}); ok && blockMap["type"] == "tool_use" {
+					id, _ := blockMap["id"].(string)
+					name, _ := blockMap["name"].(string)
+					toolNames[id] = name
+				}
+			}
+		}
+	}
+
+	// 转换消息
+	for _, message := range claudeReq.Messages {
+		role := "user"
+		if message.Role == "assistant" {
+			role = "model"
+		}
+
+		var parts []GeminiPart
+		switch content := message.Content.(type) {
+		case string:
+			parts = append(parts, GeminiPart{Text: content})
+		case []interface{}:
+			for _, block := range content {
+				blockMap, ok := block.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				switch blockMap["type"] {
+				case "text":
+					if text, ok := blockMap["text"].(string); ok && text != "" {
+						parts = append(parts, GeminiPart{Text: text})
+					}
+				case "image":
+					if source, ok := blockMap["source"].(map[string]interface{}); ok {
+						mediaType, _ := source["media_type"].(string)
+						data, _ := source["data"].(string)
+						parts = append(parts, GeminiPart{
+							InlineData: &GeminiInlineData{MimeType: mediaType, Data: data},
+						})
+					}
+				case "tool_use":
+					name, _ := blockMap["name"].(string)
+					args, _ := blockMap["input"].(map[string]interface{})
+					parts = append(parts, GeminiPart{
+						FunctionCall: &GeminiFunctionCall{Name: name, Args: args},
+					})
+				case "tool_result":
+					toolUseID, _ := blockMap["tool_use_id"].(string)
+					parts = append(parts, GeminiPart{
+						FunctionResponse: &GeminiFunctionResponse{
+							Name:     toolNames[toolUseID],
+							Response: map[string]interface{}{"content": extractToolResultText(blockMap["content"])},
+						},
+					})
+				}
+			}
+		}
+
+		if len(parts) == 0 {
+			continue
+		}
+
+		// Gemini要求相邻消息角色交替，合并同角色的连续消息
+		if n := len(geminiReq.Contents); n > 0 && geminiReq.Contents[n-1].Role == role {
+			geminiReq.Contents[n-1].Parts = append(geminiReq.Contents[n-1].Parts, parts...)
+			continue
+		}
+		geminiReq.Contents = append(geminiReq.Contents, GeminiContent{Role: role, Parts: parts})
+	}
+
+	// 转换工具
+	if len(claudeReq.Tools) > 0 {
+		var declarations []GeminiFunctionDeclaration
+		for _, tool := range claudeReq.Tools {
+			declarations = append(declarations, GeminiFunctionDeclaration{
+				Name:        tool.Name,
+				Description: tool.Description,
+				Parameters:  recursivelyCleanSchema(tool.InputSchema),
+			})
+		}
+		geminiReq.Tools = []GeminiTool{{FunctionDeclarations: declarations}}
+	}
+
+	// 转换工具选择
+	if claudeReq.ToolChoice != nil {
+		switch claudeReq.ToolChoice.Type {
+		case "auto":
+			geminiReq.ToolConfig = &GeminiToolConfig{FunctionCallingConfig: GeminiFunctionCallingConfig{Mode: "AUTO"}}
+		case "any":
+			geminiReq.ToolConfig = &GeminiToolConfig{FunctionCallingConfig: GeminiFunctionCallingConfig{Mode: "ANY"}}
+		case "tool":
+			geminiReq.ToolConfig = &GeminiToolConfig{FunctionCallingConfig: GeminiFunctionCallingConfig{
+				Mode:                 "ANY",
+				AllowedFunctionNames: []string{claudeReq.ToolChoice.Name},
+			}}
+		}
+	}
+
+	return geminiReq
+}
+
+// extractToolResultText 提取tool_result中的内容，数组格式只保留文本部分
+func extractToolResultText(content interface{}) string {
+	switch c := content.(type) {
+	case nil:
+		return ""
+	case string:
+		return c
+	case []interface{}:
+		var textParts []string
+		for _, item := range c {
+			if itemMap, ok := item.(map[string]interface{}); ok && itemMap["type"] == "text" {
+				if text, ok := itemMap["text"].(string); ok {
+					textParts = append(textParts, text)
+				}
+			}
+		}
+		return strings.Join(textParts, "\n")
+	default:
+		contentBytes, _ := json.Marshal(c)
+		return string(contentBytes)
+	}
+}
+
+// mapGeminiFinishReason 映射Gemini停止原因为Claude格式
+func mapGeminiFinishReason(finishReason string, hasToolUse bool) string {
+	if hasToolUse {
+		return "tool_use"
+	}
+	switch finishReason {
+	case "MAX_TOKENS":
+		return "max_tokens"
+	default:
+		return "end_turn"
+	}
+}
+
+// geminiCachedTokens 获取Gemini缓存命中的token数
+func geminiCachedTokens(usage *GeminiUsageMetadata) int {
+	if usage == nil {
+		return 0
+	}
+	return usage.CachedContentTokenCount
+}
+
+// geminiUsageToClaude 将Gemini usage转换为Claude格式，缓存命中部分从输入token中扣除
+func geminiUsageToClaude(usage *GeminiUsageMetadata) ClaudeUsage {
+	if usage == nil {
+		return ClaudeUsage{}
+	}
+	return ClaudeUsage{
+		InputTokens:  usage.PromptTokenCount - usage.CachedContentTokenCount,
+		OutputTokens: usage.CandidatesTokenCount + usage.ThoughtsTokenCount,
+	}
+}
+
+// convertGeminiToClaudeResponse 将Gemini非流式响应转换为Claude格式
+func convertGeminiToClaudeResponse(geminiResp GeminiResponse, model string) ClaudeResponse {
+	var contentBlocks []ClaudeContentBlock
+	var finishReason string
+	hasToolUse := false
+
+	if len(geminiResp.Candidates) > 0 {
+		candidate := geminiResp.Candidates[0]
+		finishReason = candidate.FinishReason
+
+		for _, part := range candidate.Content.Parts {
+			if part.Thought {
+				continue
+			}
+			if part.FunctionCall != nil {
+				input := part.FunctionCall.Args
+				if input == nil {
+					input = make(map[string]interface{})
+				}
+				contentBlocks = append(contentBlocks, ClaudeContentBlock{
+					Type:  "tool_use",
+					ID:    fmt.Sprintf("toolu_%s", generateRandomID()),
+					Name:  part.FunctionCall.Name,
+					Input: input,
+				})
+				hasToolUse = true
+			} else if part.Text != "" {
+				contentBlocks = append(contentBlocks, ClaudeContentBlock{
+					Type: "text",
+					Text: part.Text,
+				})
+			}
+		}
+	}
+
+	return ClaudeResponse{
+		ID:         fmt.Sprintf("msg_%s", generateRandomID()),
+		Type:       "message",
+		Role:       "assistant",
+		Model:      model,
+		Content:    contentBlocks,
+		StopReason: mapGeminiFinishReason(finishReason, hasToolUse),
+		Usage:      geminiUsageToClaude(geminiResp.UsageMetadata),
+	}
+}
+
+// GeminiStreamTransformer Gemini流式响应转换器
+type GeminiStreamTransformer struct {
+	StreamTransformer
+	textBlockOpen bool
+	hasToolUse    bool
+	finishReason  string
+	usage         *GeminiUsageMetadata
+}
+
+// newGeminiStreamTransformer 创建Gemini流式转换器
+func newGeminiStreamTransformer(model string) *GeminiStreamTransformer {
+	return &GeminiStreamTransformer{
+		StreamTransformer: *createStreamTransformer(model),
+	}
+}
+
+// process 读取Gemini SSE流并转换为Claude SSE事件，返回token使用统计
+func (gt *GeminiStreamTransformer) process(writer gin.ResponseWriter, reader io.Reader) *common.TokenUsage {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), 10*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		var chunk GeminiResponse
+		if err := json.Unmarshal([]byte(strings.TrimSpace(line[5:])), &chunk); err != nil {
+			continue // 忽略解析错误的chunk
+		}
+		gt.processChunk(writer, chunk)
+	}
+
+	if err := scanner.Err(); err != nil {
+		log.Printf("Gemini stream read failed: %v", err)
+	}
+
+	gt.finish(writer)
+
+	usage := geminiUsageToClaude(gt.usage)
+	return &common.TokenUsage{
+		InputTokens:          usage.InputTokens,
+		OutputTokens:         usage.OutputTokens,
+		CacheReadInputTokens: geminiCachedTokens(gt.usage),
+		Model:                gt.model,
+	}
+}
+
+// processChunk 处理单个Gemini流式chunk
+func (gt *GeminiStreamTransformer) processChunk(writer gin.ResponseWriter, chunk GeminiResponse) {
+	if !gt.initialized {
+		gt.sendEvent(writer, "message_start", map[string]interface{}{
+			"type": "message_start",
+			"message": map[string]interface{}{
+				"id":          gt.messageID,
+				"type":        "message",
+				"role":        "assistant",
+				"model":       gt.model,
+				"content":     []interface{}{},
+				"stop_reason": nil,
+				"usage": map[string]int{
+					"input_tokens":  0,
+					"output_tokens": 0,
+				},
+			},
+		})
+		gt.initialized = true
+	}
+
+	if chunk.UsageMetadata != nil {
+		gt.usage = chunk.UsageMetadata
+	}
+
+	if len(chunk.Candidates) == 0 {
+		return
+	}
+
+	candidate := chunk.Candidates[0]
+	if candidate.FinishReason != "" {
+		gt.finishReason = candidate.FinishReason
+	}
+
+	for _, part := range candidate.Content.Parts {
+		if part.Thought {
+			continue
+		}
+
+		if part.FunctionCall != nil {
+			gt.closeTextBlock(writer)
+
+			// Gemini一次性返回完整的函数调用，按Claude格式拆分为开始、参数增量和结束事件
+			args := part.FunctionCall.Args
+			if args == nil {
+				args = make(map[string]interface{})
+			}
+			argsBytes, _ := json.Marshal(args)
+
+			index := gt.contentBlockIndex
+			gt.sendEvent(writer, "content_block_start", map[string]interface{}{
+				"type":  "content_block_start",
+				"index": index,
+				"content_block": map[string]interface{}{
+					"type":  "tool_use",
+					"id":    fmt.Sprintf("toolu_%s", generateRandomID()),
+					"name":  part.FunctionCall.Name,
+					"input": map[string]interface{}{},
+				},
+			})
+			gt.sendEvent(writer, "content_block_delta", map[string]interface{}{
+				"type":  "content_block_delta",
+				"index": index,
+				"delta": map[string]interface{}{
+					"type":         "input_json_delta",
+					"partial_json": string(argsBytes),
+				},
+			})
+			gt.sendEvent(writer, "content_block_stop", map[string]interface{}{
+				"type":  "content_block_stop",
+				"index": index,
+			})
+			gt.contentBlockIndex++
+			gt.hasToolUse = true
+			continue
+		}
+
+		if part.Text == "" {
+			continue
+		}
+
+		if !gt.textBlockOpen {
+			gt.sendEvent(writer, "content_block_start", map[string]interface{}{
+				"type":  "content_block_start",
+				"index": gt.contentBlockIndex,
+				"content_block": map[string]interface{}{
+					"type": "text",
+					"text": "",
+				},
+			})
+			gt.textBlockOpen = true
+		}
+
+		gt.sendEvent(writer, "content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": gt.contentBlockIndex,
+			"delta": map[string]interface{}{
+				"type": "text_delta",
+				"text": part.Text,
+			},
+		})
+	}
+}
+
+// closeTextBlock 结束当前打开的文本内容块
+func (gt *GeminiStreamTransformer) closeTextBlock(writer gin.ResponseWriter) {
+	if !gt.textBlockOpen {
+		return
+	}
+	gt.sendEvent(writer, "content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": gt.contentBlockIndex,
+	})
+	gt.contentBlockIndex++
+	gt.textBlockOpen = false
+}
+
+// finish 发送最终事件，message_delta中携带完整usage供日志统计
+// 上游在返回结束原因前断开时不补发结束事件，客户端和fallback都能识别为中断
+func (gt *GeminiStreamTransformer) finish(writer gin.ResponseWriter) {
+	if !gt.initialized || gt.finishReason == "" {
+		return
+	}
+
+	gt.closeTextBlock(writer)
+
+	usage := geminiUsageToClaude(gt.usage)
+	gt.sendEvent(writer, "message_delta", map[string]interface{}{
+		"type": "message_delta",
+		"delta": map[string]interface{}{
+			"stop_reason":   mapGeminiFinishReason(gt.finishReason, gt.hasToolUse),
+			"stop_sequence": nil,
+		},
+		"usage": map[string]int{
+			"input_tokens":            usage.InputTokens,
+			"output_tokens":           usage.OutputTokens,
+			"cache_read_input_tokens": geminiCachedTokens(gt.usage),
+		},
+	})
+
+	gt.sendEvent(writer, "message_stop", map[string]interface{}{
+		"type": "message_stop",
+	})
+}
+
+// TestHandleGeminiRequest 测试Gemini账号连通性，返回状态码和错误信息
+func TestHandleGeminiRequest(account *model.Account) (int, string) {
+	return testGeminiRequest(account, GetTestRequestBody(100))
+}
+
+// testGeminiRequest 使用指定请求体测试Gemini账号连通性
+func testGeminiRequest(account *model.Account, requestBody string) (int, string) {
+	var claudeReq ClaudeRequest
+	if err := json.Unmarshal([]byte(requestBody), &claudeReq); err != nil {
+		return http.StatusBadRequest, "Failed to parse request JSON: " + err.Error()
+	}
+
+	mappedModelName := applyModelMapping(claudeReq.Model, account.ModelMapping, geminiDefaultModel)
+	geminiBody, err := json.Marshal(convertClaudeToGemini(claudeReq))
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to marshal Gemini request: " + err.Error()
+	}
+
+	req, err := http.NewRequest("POST", buildGeminiURL(account, mappedModelName, false), bytes.NewBuffer(geminiBody))
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to create request: " + err.Error()
+	}
+	setGeminiHeaders(req, account)
+
+	client := createHTTPClient(account)
+	if client == nil {
+		return http.StatusInternalServerError, "Failed to create HTTP client"
+	}
+	client.Timeout = 30 * time.Second
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return http.StatusInternalServerError, "Request failed: " + err.Error()
+	}
+	defer common.CloseIO(resp.Body)
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(bodyBytes)
+	}
+
+	return resp.StatusCode, ""
+}
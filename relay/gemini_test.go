@@ -0,0 +1,225 @@
+package relay
+
+import (
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestBuildGeminiURL 默认地址、自定义地址和流式地址
+func TestBuildGeminiURL(t *testing.T) {
+	cases := []struct {
+		name       string
+		requestURL string
+		stream     bool
+		expected   string
+	}{
+		{"默认地址", "", false, GeminiDefaultBaseURL + "/models/gemini-2.5-pro:generateContent"},
+		{"默认地址流式", "", true, GeminiDefaultBaseURL + "/models/gemini-2.5-pro:streamGenerateContent?alt=sse"},
+		{"自定义地址去除结尾斜杠", "https://proxy.example.com/v1beta/", false, "https://proxy.example.com/v1beta/models/gemini-2.5-pro:generateContent"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			account := &model.Account{RequestURL: tc.requestURL}
+			if got := buildGeminiURL(account, "gemini-2.5-pro", tc.stream); got != tc.expected {
+				t.Errorf("请求地址不正确:\n期望: %s\n实际: %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+// TestConvertClaudeToGemini Claude请求转换为Gemini格式
+func TestConvertClaudeToGemini(t *testing.T) {
+	cases := []struct {
+		name  string
+		body  string
+		check func(t *testing.T, req GeminiRequest)
+	}{
+		{
+			name: "system和生成参数",
+			body: `{"model":"gemini-2.5-pro","max_tokens":256,"temperature":0.5,"stop_sequences":["END"],
+				"system":[{"type":"text","text":"你是助手"}],
+				"messages":[{"role":"user","content":"你好"}]}`,
+			check: func(t *testing.T, req GeminiRequest) {
+				if req.SystemInstruction == nil || req.SystemInstruction.Parts[0].Text != "你是助手" {
+					t.Errorf("systemInstruction不正确: %+v", req.SystemInstruction)
+				}
+				config := req.GenerationConfig
+				if config.MaxOutputTokens != 256 || config.Temperature == nil || *config.Temperature != 0.5 || config.StopSequences[0] != "END" {
+					t.Errorf("generationConfig不正确: %+v", config)
+				}
+				if len(req.Contents) != 1 || req.Contents[0].Role != "user" || req.Contents[0].Parts[0].Text != "你好" {
+					t.Errorf("contents不正确: %+v", req.Contents)
+				}
+			},
+		},
+		{
+			name: "assistant映射为model并合并同角色连续消息",
+			body: `{"model":"gemini-2.5-pro","max_tokens":10,"messages":[
+				{"role":"user","content":"第一句"},
+				{"role":"user","content":[{"type":"text","text":"第二句"},{"type":"text","text":""}]},
+				{"role":"assistant","content":"回答"}]}`,
+			check: func(t *testing.T, req GeminiRequest) {
+				if len(req.Contents) != 2 {
+					t.Fatalf("应合并为2条消息: %+v", req.Contents)
+				}
+				if req.Contents[0].Role != "user" || len(req.Contents[0].Parts) != 2 {
+					t.Errorf("同角色消息未合并或空文本未跳过: %+v", req.Contents[0])
+				}
+				if req.Contents[1].Role != "model" {
+					t.Errorf("assistant应映射为model: %s", req.Contents[1].Role)
+				}
+			},
+		},
+		{
+			name: "图片转换为inlineData",
+			body: `{"model":"gemini-2.5-pro","max_tokens":10,"messages":[{"role":"user","content":[
+				{"type":"image","source":{"type":"base64","media_type":"image/png","data":"iVBORw0"}}]}]}`,
+			check: func(t *testing.T, req GeminiRequest) {
+				inline := req.Contents[0].Parts[0].InlineData
+				if inline == nil || inline.MimeType != "image/png" || inline.Data != "iVBORw0" {
+					t.Errorf("inlineData不正确: %+v", inline)
+				}
+			},
+		},
+		{
+			name: "工具调用和结果按tool_use_id还原函数名",
+			body: `{"model":"gemini-2.5-pro","max_tokens":10,"messages":[
+				{"role":"user","content":"查天气"},
+				{"role":"assistant","content":[{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"北京"}}]},
+				{"role":"user","content":[{"type":"tool_result","tool_use_id":"toolu_1","content":[{"type":"text","text":"晴"}]}]}]}`,
+			check: func(t *testing.T, req GeminiRequest) {
+				call := req.Contents[1].Parts[0].FunctionCall
+				if call == nil || call.Name != "get_weather" || call.Args["city"] != "北京" {
+					t.Errorf("functionCall不正确: %+v", call)
+				}
+				response := req.Contents[2].Parts[0].FunctionResponse
+				if response == nil || response.Name != "get_weather" || response.Response["content"] != "晴" {
+					t.Errorf("functionResponse不正确: %+v", response)
+				}
+			},
+		},
+		{
+			name: "工具定义和指定工具选择",
+			body: `{"model":"gemini-2.5-pro","max_tokens":10,"messages":[{"role":"user","content":"hi"}],
+				"tools":[{"name":"get_weather","description":"查询天气","input_schema":{"type":"object","properties":{"city":{"type":"string"}}}}],
+				"tool_choice":{"type":"tool","name":"get_weather"}}`,
+			check: func(t *testing.T, req GeminiRequest) {
+				if len(req.Tools) != 1 || req.Tools[0].FunctionDeclarations[0].Name != "get_weather" {
+					t.Errorf("tools不正确: %+v", req.Tools)
+				}
+				config := req.ToolConfig
+				if config == nil || config.FunctionCallingConfig.Mode != "ANY" || config.FunctionCallingConfig.AllowedFunctionNames[0] != "get_weather" {
+					t.Errorf("toolConfig不正确: %+v", config)
+				}
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var claudeReq ClaudeRequest
+			if err := json.Unmarshal([]byte(tc.body), &claudeReq); err != nil {
+				t.Fatalf("解析请求失败: %v", err)
+			}
+			tc.check(t, convertClaudeToGemini(claudeReq))
+		})
+	}
+}
+
+// TestConvertGeminiToClaudeResponse 非流式响应跳过思考内容，函数调用转换为tool_use
+func TestConvertGeminiToClaudeResponse(t *testing.T) {
+	var geminiResp GeminiResponse
+	body := `{"candidates":[{"content":{"role":"model","parts":[
+		{"text":"思考中","thought":true},
+		{"text":"我来查询"},
+		{"functionCall":{"name":"get_weather","args":{"city":"北京"}}}]},"finishReason":"STOP"}],
+		"usageMetadata":{"promptTokenCount":100,"candidatesTokenCount":20,"cachedContentTokenCount":40,"thoughtsTokenCount":5}}`
+	if err := json.Unmarshal([]byte(body), &geminiResp); err != nil {
+		t.Fatalf("解析响应失败: %v", err)
+	}
+
+	resp := convertGeminiToClaudeResponse(geminiResp, "gemini-2.5-pro")
+	if len(resp.Content) != 2 || resp.Content[0].Type != "text" || resp.Content[1].Type != "tool_use" {
+		t.Fatalf("内容块不正确: %+v", resp.Content)
+	}
+	if resp.StopReason != "tool_use" {
+		t.Errorf("stop_reason 应为 tool_use: %s", resp.StopReason)
+	}
+	if resp.Usage.InputTokens != 60 || resp.Usage.OutputTokens != 25 {
+		t.Errorf("usage不正确: %+v", resp.Usage)
+	}
+}
+
+// TestGeminiStreamTransformer Gemini流式chunk转换为Claude SSE事件
+func TestGeminiStreamTransformer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	stream := strings.Join([]string{
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"思考","thought":true}]}}]}`,
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"你"}]}}]}`,
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"好"}]}}]}`,
+		`data: {"candidates":[{"content":{"role":"model","parts":[{"functionCall":{"name":"get_weather","args":{"city":"北京"}}}]},"finishReason":"STOP"}],"usageMetadata":{"promptTokenCount":50,"candidatesTokenCount":10,"cachedContentTokenCount":20}}`,
+	}, "\n\n")
+
+	usage := newGeminiStreamTransformer("gemini-2.5-pro").process(c.Writer, strings.NewReader(stream))
+
+	output := recorder.Body.String()
+	if strings.Count(output, "event: message_start") != 1 {
+		t.Errorf("message_start 应只发送一次: %s", output)
+	}
+	if strings.Count(output, `"type":"text_delta"`) != 2 || strings.Contains(output, "思考") {
+		t.Errorf("文本增量不正确或包含思考内容: %s", output)
+	}
+	if strings.Count(output, "event: content_block_start") != 2 || strings.Count(output, "event: content_block_stop") != 2 {
+		t.Errorf("内容块开始/结束事件数量不正确: %s", output)
+	}
+	if !strings.Contains(output, `"type":"input_json_delta"`) || !strings.Contains(output, `"stop_reason":"tool_use"`) {
+		t.Errorf("函数调用未转换为tool_use: %s", output)
+	}
+	if !strings.HasSuffix(strings.TrimSpace(output), `data: {"type":"message_stop"}`) {
+		t.Errorf("应以message_stop结束: %s", output)
+	}
+	if usage.InputTokens != 30 || usage.OutputTokens != 10 || usage.CacheReadInputTokens != 20 {
+		t.Errorf("usage不正确: %+v", usage)
+	}
+}
+
+// TestMapGeminiFinishReason 停止原因映射
+func TestMapGeminiFinishReason(t *testing.T) {
+	cases := []struct {
+		finishReason string
+		hasToolUse   bool
+		expected     string
+	}{
+		{"STOP", false, "end_turn"},
+		{"MAX_TOKENS", false, "max_tokens"},
+		{"SAFETY", false, "end_turn"},
+		{"STOP", true, "tool_use"},
+	}
+	for _, tc := range cases {
+		if got := mapGeminiFinishReason(tc.finishReason, tc.hasToolUse); got != tc.expected {
+			t.Errorf("%s(hasToolUse=%v) 应映射为 %s, 实际: %s", tc.finishReason, tc.hasToolUse, tc.expected, got)
+		}
+	}
+}
+
+// TestGeminiStreamAbortedBeforeContent 流在返回结束原因前断开时不补发结束事件，按失败记录
+func TestGeminiStreamAbortedBeforeContent(t *testing.T) {
+	account := &model.Account{Name: "gemini", PlatformType: constant.PlatformGemini, SecretKey: "key"}
+	capture, recorder, apiKey := runHeldStreamAttempt(t, account, abortedStreamRequestBody, HandleGeminiRequest, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"candidates":[{"content":{"role":"model","parts":[{"text":"思考","thought":true}]}}]}` + "\n\n"))
+	})
+	assertAbortedBeforeContent(t, capture, recorder)
+	assertAttemptRecordedAsFailure(t, account, apiKey)
+}
@@ -0,0 +1,206 @@
+package relay
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// healthProbeMaxJitter 主动探测的最大随机延迟，避免所有探测同时发出
+const healthProbeMaxJitter = 10 * time.Second
+
+// healthProbeRequestBody 主动探测使用的请求体，只生成1个token以降低成本
+var healthProbeRequestBody = GetTestRequestBody(1)
+
+// probingAccounts 正在探测中的账号，同一账号属于多个分组时只探测一次
+var probingAccounts sync.Map
+
+// probeAccount 向账号发送探测请求，返回状态码和错误信息
+func probeAccount(account *model.Account) (int, string) {
+	switch account.PlatformType {
+	case constant.PlatformClaude:
+		return testClaudeRequest(account, healthProbeRequestBody)
+	case constant.PlatformClaudeConsole:
+		return testClaudeConsoleRequest(account, healthProbeRequestBody)
+	case constant.PlatformOpenAI, constant.PlatformAzureOpenAI:
+		return testOpenAIRequest(account, healthProbeRequestBody)
+	case constant.PlatformGemini:
+		return testGeminiRequest(account, healthProbeRequestBody)
+	case constant.PlatformBedrock:
+		return testBedrockRequest(account, []byte(healthProbeRequestBody))
+	case constant.PlatformVertex:
+		return testVertexRequest(account, []byte(healthProbeRequestBody))
+	default:
+		return 0, "不支持的平台类型: " + account.PlatformType
+	}
+}
+
+// needsProbe 判断账号是否需要主动探测：健康监控中不健康、降级或被临时禁用，或账号本身处于异常/限流状态
+func needsProbe(health *AccountHealth, account *model.Account) bool {
+	if account.CurrentStatus == accountStatusDisabled || account.CurrentStatus == accountStatusRateLimit {
+		return true
+	}
+	if health == nil {
+		return false
+	}
+	return health.Status == "unhealthy" || health.Status == "degraded" || health.Status == "disabled"
+}
+
+// selectProbeAccounts 从已监控的账号中筛选需要主动探测的已激活账号
+func (hm *HealthMonitor) selectProbeAccounts(accountIDs []uint) []model.Account {
+	if len(accountIDs) == 0 || model.DB == nil {
+		return nil
+	}
+
+	var accounts []model.Account
+	if err := model.DB.Where("id IN ? AND active_status = ?", accountIDs, 1).Find(&accounts).Error; err != nil {
+		common.SysError("查询待探测账号失败: " + err.Error())
+		return nil
+	}
+
+	result := make([]model.Account, 0, len(accounts))
+	for _, account := range accounts {
+		if needsProbe(hm.GetAccountHealth(account.ID), &account) {
+			result = append(result, account)
+		}
+	}
+	return result
+}
+
+// probeAccounts 以有限并发主动探测异常账号，每个探测前随机延迟，结果写回健康状态和账号状态
+func (hm *HealthMonitor) probeAccounts(accountIDs []uint) {
+	accounts := hm.selectProbeAccounts(accountIDs)
+	if len(accounts) == 0 {
+		return
+	}
+
+	concurrency := hm.config.HealthProbeConcurrency
+	if concurrency <= 0 {
+		concurrency = 1
+	}
+	maxJitter := healthProbeMaxJitter
+	if half := hm.checkInterval / 2; half < maxJitter {
+		maxJitter = half
+	}
+
+	log.Printf("🩺 开始主动探测 %d 个异常账号，并发数: %d", len(accounts), concurrency)
+
+	semaphore := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := range accounts {
+		account := &accounts[i]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			semaphore <- struct{}{}
+			defer func() { <-semaphore }()
+
+			if maxJitter > 0 {
+				timer := time.NewTimer(time.Duration(rand.Int63n(int64(maxJitter))))
+				select {
+				case <-timer.C:
+				case <-hm.stopChan:
+					timer.Stop()
+					return
+				}
+			}
+
+			if _, probing := probingAccounts.LoadOrStore(account.ID, struct{}{}); probing {
+				return
+			}
+			defer probingAccounts.Delete(account.ID)
+
+			startTime := time.Now()
+			statusCode, errorMessage := probeAccount(account)
+			hm.recordProbeResult(account, statusCode, errorMessage, time.Since(startTime))
+		}()
+	}
+	wg.Wait()
+}
+
+// recordProbeResult 将探测结果写入健康状态，并同步更新账号的当前状态
+func (hm *HealthMonitor) recordProbeResult(account *model.Account, statusCode int, errorMessage string, duration time.Duration) {
+	success := errorMessage == "" && statusCode >= 200 && statusCode < 300
+	if !success && errorMessage == "" {
+		errorMessage = fmt.Sprintf("探测失败，状态码: %d", statusCode)
+	}
+
+	hm.mu.Lock()
+	health := hm.accountHealth[account.ID]
+	if health == nil {
+		health = &AccountHealth{AccountID: account.ID}
+		hm.accountHealth[account.ID] = health
+	}
+
+	now := time.Now()
+	previousStatus := health.Status
+	health.LastCheckTime = now
+	health.LastProbeTime = &now
+	health.LastProbeStatus = statusCode
+
+	if success {
+		// 探测成功说明账号已恢复，重新开始统计错误率
+		health.SuccessCount = 1
+		health.FailureCount = 0
+		health.ErrorRate = 0
+		health.LastSuccess = now
+		health.AvgResponseTime = duration
+		health.DisabledUntil = nil
+		health.FailureReason = ""
+		health.Status = "healthy"
+	} else {
+		health.FailureCount++
+		health.LastFailure = now
+		health.FailureReason = errorMessage
+		health.ErrorRate = float64(health.FailureCount) / float64(health.SuccessCount+health.FailureCount)
+		health.Status = hm.determineHealthStatus(health)
+	}
+	currentStatus := health.Status
+	hm.notifyChange(account.ID)
+	hm.mu.Unlock()
+
+	if success {
+		log.Printf("🩺 账号 %s 探测成功，健康状态: %s -> %s", account.Name, previousStatus, currentStatus)
+	} else {
+		log.Printf("🩺 账号 %s 探测失败: %s", account.Name, errorMessage)
+	}
+
+	updateProbeAccountStatus(account, success, statusCode)
+}
+
+// updateProbeAccountStatus 根据探测结果更新账号的当前状态，状态码映射与正常请求一致
+func updateProbeAccountStatus(account *model.Account, success bool, statusCode int) {
+	updates := map[string]interface{}{}
+	switch {
+	case success:
+		if account.CurrentStatus != accountStatusActive {
+			updates["current_status"] = accountStatusActive
+			updates["rate_limit_end_time"] = nil
+		}
+	case statusCode == statusRateLimit:
+		if account.CurrentStatus != accountStatusRateLimit {
+			updates["current_status"] = accountStatusRateLimit
+		}
+	case statusCode > 400:
+		if account.CurrentStatus == accountStatusActive {
+			updates["current_status"] = accountStatusDisabled
+		}
+	}
+
+	if len(updates) == 0 {
+		return
+	}
+
+	if err := model.DB.Model(account).Updates(updates).Error; err != nil {
+		common.SysError(fmt.Sprintf("更新账号 %s 探测状态失败: %v", account.Name, err))
+		return
+	}
+	log.Printf("🩺 账号 %s 当前状态更新: %d -> %v", account.Name, account.CurrentStatus, updates["current_status"])
+}
@@ -0,0 +1,62 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestNeedsProbe 测试主动探测对象的筛选
+func TestNeedsProbe(t *testing.T) {
+	active := &model.Account{CurrentStatus: accountStatusActive}
+	tests := []struct {
+		name    string
+		health  *AccountHealth
+		account *model.Account
+		want    bool
+	}{
+		{"健康账号", &AccountHealth{Status: "healthy"}, active, false},
+		{"没有健康记录", nil, active, false},
+		{"不健康", &AccountHealth{Status: "unhealthy"}, active, true},
+		{"降级", &AccountHealth{Status: "degraded"}, active, true},
+		{"临时禁用", &AccountHealth{Status: "disabled"}, active, true},
+		{"限流账号", &AccountHealth{Status: "idle"}, &model.Account{CurrentStatus: accountStatusRateLimit}, true},
+		{"接口异常账号", nil, &model.Account{CurrentStatus: accountStatusDisabled}, true},
+	}
+
+	for _, tt := range tests {
+		if got := needsProbe(tt.health, tt.account); got != tt.want {
+			t.Errorf("%s: 期望 %v，实际 %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+// TestRecordProbeResult 测试探测结果写回健康状态
+func TestRecordProbeResult(t *testing.T) {
+	monitor := NewHealthMonitor(time.Minute, &FallbackConfig{})
+	disabledUntil := time.Now().Add(time.Minute * 10)
+	monitor.accountHealth[1] = &AccountHealth{
+		AccountID:     1,
+		Status:        "disabled",
+		SuccessCount:  1,
+		FailureCount:  9,
+		ErrorRate:     0.9,
+		DisabledUntil: &disabledUntil,
+	}
+	// 账号状态与探测结果一致时不需要更新数据库
+	account := &model.Account{ID: 1, Name: "probe", CurrentStatus: accountStatusDisabled}
+
+	monitor.recordProbeResult(account, http.StatusInternalServerError, "", time.Millisecond)
+	health := monitor.GetAccountHealth(1)
+	if health.FailureCount != 10 || health.LastProbeStatus != http.StatusInternalServerError || health.Status != "disabled" {
+		t.Errorf("探测失败应累计失败次数并保持禁用，实际: %+v", health)
+	}
+
+	account.CurrentStatus = accountStatusActive
+	monitor.recordProbeResult(account, http.StatusOK, "", time.Millisecond)
+	health = monitor.GetAccountHealth(1)
+	if health.Status != "healthy" || health.DisabledUntil != nil || health.ErrorRate != 0 || health.LastProbeTime == nil {
+		t.Errorf("探测成功应恢复账号健康状态，实际: %+v", health)
+	}
+}
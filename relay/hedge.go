@@ -0,0 +1,219 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// statusHedgeLost 对冲请求落败时记录的状态码（客户端关闭请求）
+const statusHedgeLost = 499
+
+// errHedgeLost 对冲请求中另一方已先开始输出
+var errHedgeLost = errors.New("hedged request lost the race")
+
+// hedgeRace 对冲请求的竞争状态，第一个开始向客户端输出的尝试获胜
+type hedgeRace struct {
+	mu      sync.Mutex
+	winner  *StreamingResponseCapture
+	claimed chan struct{} // 产生胜者时关闭
+}
+
+// newHedgeRace 创建对冲竞争状态
+func newHedgeRace() *hedgeRace {
+	return &hedgeRace{claimed: make(chan struct{})}
+}
+
+// claim 尝试获得输出权，已获胜或成为胜者时返回true
+func (r *hedgeRace) claim(w *StreamingResponseCapture) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.winner == nil {
+		r.winner = w
+		close(r.claimed)
+		return true
+	}
+	return r.winner == w
+}
+
+// lost 判断该尝试是否已落败
+func (r *hedgeRace) lost(w *StreamingResponseCapture) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.winner != nil && r.winner != w
+}
+
+// hedgeAttempt 对冲请求中的一次尝试
+type hedgeAttempt struct {
+	account *model.Account
+	capture *StreamingResponseCapture
+	cancel  context.CancelFunc
+}
+
+// hedgeOutcome 对冲尝试的结果
+type hedgeOutcome struct {
+	attempt *hedgeAttempt
+	result  *FallbackResult
+}
+
+// hedgeDelayFor 获取API Key配置的对冲延迟，未启用时返回0
+// 只有对话接口（/v1/messages）可以对冲，批处理创建等有副作用的请求重复发送会产生重复的上游资源
+func hedgeDelayFor(c *gin.Context) time.Duration {
+	if c.Request == nil || !strings.HasSuffix(c.Request.URL.Path, "/v1/messages") {
+		return 0
+	}
+
+	apiKey, exists := c.Get("api_key")
+	if !exists {
+		return 0
+	}
+	keyInfo, ok := apiKey.(*model.ApiKey)
+	if !ok || keyInfo.HedgeDelay <= 0 {
+		return 0
+	}
+	return time.Duration(keyInfo.HedgeDelay) * time.Millisecond
+}
+
+// isHedgeLoser 判断当前请求是否为对冲中落败的一方，落败的一方不计费也不记录日志
+func isHedgeLoser(c *gin.Context) bool {
+	value, exists := c.Get(fallbackCaptureKey)
+	if !exists {
+		return false
+	}
+	capture, ok := value.(*StreamingResponseCapture)
+	return ok && capture != nil && capture.race != nil && capture.race.lost(capture)
+}
+
+// startHedgeAttempt 在独立的上下文中异步执行一次尝试，取消其请求上下文即可中止上游请求
+func (h *FallbackHandler) startHedgeAttempt(c *gin.Context, account *model.Account, requestBody []byte, requestFunc RequestFunc, race *hedgeRace, outcomes chan<- hedgeOutcome) *hedgeAttempt {
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	attemptContext := c.Copy()
+	attemptContext.Request = c.Request.WithContext(ctx)
+
+	capture := NewStreamingResponseCapture(c.Writer, time.Now(), requestBody)
+	capture.race = race
+	// 两个尝试并发写响应头，获胜前各自使用独立的响应头
+	capture.hedgeHeader = make(http.Header)
+
+	attempt := &hedgeAttempt{account: account, capture: capture, cancel: cancel}
+	go func() {
+		result := h.executeWithCapture(attemptContext, capture, account, requestBody, requestFunc)
+		outcomes <- hedgeOutcome{attempt: attempt, result: result}
+	}()
+
+	return attempt
+}
+
+// executeHedged 执行对冲请求：主账号在延迟内没有首字节时，同时向备用账号发起请求，
+// 先开始输出的一方获胜，另一方通过请求上下文取消
+func (h *FallbackHandler) executeHedged(c *gin.Context, primary, secondary *model.Account, requestBody []byte, requestFunc RequestFunc, delay time.Duration) *FallbackResult {
+	race := newHedgeRace()
+	outcomes := make(chan hedgeOutcome, 2)
+
+	first := h.startHedgeAttempt(c, primary, requestBody, requestFunc, race, outcomes)
+	attempts := []*hedgeAttempt{first}
+	defer func() {
+		for _, attempt := range attempts {
+			attempt.cancel()
+		}
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	// 备用账号同样需要占用并发槽位，并发已满时不发起对冲
+	var secondarySlot *accountSlot
+	defer func() { secondarySlot.release() }()
+	startSecondary := func() bool {
+		slot, acquired := h.acquireSlot(c, secondary)
+		if !acquired {
+			log.Printf("⚠️ 备用账号 %s 并发已满，不发起对冲请求", secondary.Name)
+			return false
+		}
+		secondarySlot = slot
+		attempts = append(attempts, h.startHedgeAttempt(c, secondary, requestBody, requestFunc, race, outcomes))
+		return true
+	}
+
+	claimed := race.claimed
+	pending := 1
+	var winner, lastFailure *FallbackResult
+
+	for pending > 0 {
+		select {
+		case <-timer.C:
+			if len(attempts) == 1 && first.capture.GetFirstByteTime() == nil {
+				log.Printf("⏱️ 账号 %s 在 %v 内没有首字节，发起对冲请求到账号 %s", primary.Name, delay, secondary.Name)
+				if startSecondary() {
+					pending++
+				}
+			}
+		case <-claimed:
+			claimed = nil
+			// 取消落败的一方
+			for _, attempt := range attempts {
+				if race.lost(attempt.capture) {
+					attempt.cancel()
+				}
+			}
+		case outcome := <-outcomes:
+			pending--
+			if race.lost(outcome.attempt.capture) {
+				log.Printf("🏁 对冲请求中账号 %s 落败，已取消", outcome.attempt.account.Name)
+				continue
+			}
+			// 每个未落败的尝试都计入各自账号的熔断器，失败的账号按失败类型临时禁用
+			h.recordBreakerResult(outcome.result)
+			if outcome.result.Success {
+				winner = outcome.result
+				continue
+			}
+			h.disableFailedAccount(outcome.result)
+			// 请求本身有问题时换账号也不会成功，保留该结果且不再发起对冲
+			if lastFailure != nil && lastFailure.ErrorClass == ErrorClassClient {
+				continue
+			}
+			lastFailure = outcome.result
+			if outcome.result.ErrorClass == ErrorClassClient {
+				continue
+			}
+			// 主账号直接失败时立即改用备用账号，不再等待对冲延迟
+			if len(attempts) == 1 && startSecondary() {
+				pending++
+			}
+		}
+	}
+
+	if winner != nil {
+		winner.AttemptCount = len(attempts)
+		return winner
+	}
+	if lastFailure == nil {
+		lastFailure = &FallbackResult{Success: false, Account: primary, ErrorMessage: "对冲请求均失败"}
+	}
+	lastFailure.AttemptCount = len(attempts)
+	return lastFailure
+}
+
+// nextHedgePartner 查找可以作为对冲备用的下一个可用账号，没有时返回-1
+// 熔断器半开的账号只接收有限的探测请求，不作为对冲备用
+func (h *FallbackHandler) nextHedgePartner(accounts []model.Account, current, maxAttempts int) int {
+	for i := current + 1; i < maxAttempts; i++ {
+		if h.config.EnableCircuitBreaker && h.getBreaker(accounts[i].ID).GetState() != CircuitClosed {
+			continue
+		}
+		if h.isAccountAvailable(accounts[i].ID) {
+			return i
+		}
+	}
+	return -1
+}
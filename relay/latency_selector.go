@@ -0,0 +1,192 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"math"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	latencyEWMAAlpha      = 0.3              // 新样本在EWMA中的权重
+	latencyDecayWindow    = 30 * time.Second // 没有新样本时EWMA的衰减时间常数，让变慢的账号之后有机会重新被选中
+	latencyFailurePenalty = 5 * time.Second  // 请求失败时计入EWMA的最小延迟
+)
+
+// LoadTracker 需要感知账号实时负载和延迟的选择器实现此接口，
+// FallbackHandler在每次尝试开始和结束时回调，latency为0表示本次尝试不计入延迟统计
+type LoadTracker interface {
+	RequestStarted(accountID uint)
+	RequestFinished(accountID uint, latency time.Duration)
+}
+
+// latencyStats 账号的延迟和负载统计
+type latencyStats struct {
+	ewma       float64   // TTFB的指数加权移动平均（纳秒）
+	inFlight   int64     // 正在处理的请求数
+	lastSample time.Time // 最近一次更新EWMA的时间
+}
+
+// LatencyAwareSelector 延迟感知选择器：
+// 记录每个账号TTFB的EWMA和正在处理的请求数，只在最高优先级的账号中使用
+// power-of-two-choices（随机取两个账号，选负载更低的），其余账号按优先级和负载排在后面作为fallback
+type LatencyAwareSelector struct {
+	mu    sync.Mutex
+	stats map[uint]*latencyStats
+	rand  *rand.Rand
+}
+
+// NewLatencyAwareSelector 创建延迟感知选择器
+func NewLatencyAwareSelector() *LatencyAwareSelector {
+	return &LatencyAwareSelector{
+		stats: make(map[uint]*latencyStats),
+		rand:  rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Select 选择账号顺序：最高优先级的账号按power-of-two-choices逐个选出，其余账号按优先级和负载排序
+func (s *LatencyAwareSelector) Select(accounts []model.Account) []model.Account {
+	if len(accounts) == 0 {
+		return accounts
+	}
+
+	bestPriority := accounts[0].Priority
+	for _, account := range accounts {
+		if account.Priority < bestPriority {
+			bestPriority = account.Priority
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	var tier, rest []model.Account
+	for _, account := range accounts {
+		if account.Priority == bestPriority {
+			tier = append(tier, account)
+		} else {
+			rest = append(rest, account)
+		}
+	}
+
+	result := make([]model.Account, 0, len(accounts))
+	for len(tier) > 1 {
+		i := s.rand.Intn(len(tier))
+		j := s.rand.Intn(len(tier) - 1)
+		if j >= i {
+			j++
+		}
+		if s.cost(tier[j].ID, now) < s.cost(tier[i].ID, now) {
+			i = j
+		}
+		result = append(result, tier[i])
+		tier = append(tier[:i], tier[i+1:]...)
+	}
+	result = append(result, tier...)
+
+	sort.SliceStable(rest, func(i, j int) bool {
+		if rest[i].Priority != rest[j].Priority {
+			return rest[i].Priority < rest[j].Priority
+		}
+		return s.cost(rest[i].ID, now) < s.cost(rest[j].ID, now)
+	})
+	return append(result, rest...)
+}
+
+// cost 计算账号的负载代价：衰减后的EWMA TTFB × (正在处理的请求数 + 1)，调用方需持有锁
+// 没有样本的账号代价为0，会被优先尝试以获取延迟数据
+func (s *LatencyAwareSelector) cost(accountID uint, now time.Time) float64 {
+	stats := s.stats[accountID]
+	if stats == nil {
+		return 0
+	}
+	return decayedEWMA(stats, now) * float64(stats.inFlight+1)
+}
+
+// decayedEWMA 按距离上次样本的时间衰减EWMA
+func decayedEWMA(stats *latencyStats, now time.Time) float64 {
+	idle := now.Sub(stats.lastSample)
+	if idle <= 0 {
+		return stats.ewma
+	}
+	return stats.ewma * math.Exp(-float64(idle)/float64(latencyDecayWindow))
+}
+
+// RequestStarted 记录账号开始处理一个请求
+func (s *LatencyAwareSelector) RequestStarted(accountID uint) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats[accountID]
+	if stats == nil {
+		stats = &latencyStats{}
+		s.stats[accountID] = stats
+	}
+	stats.inFlight++
+}
+
+// RequestFinished 记录账号完成一个请求，并将延迟计入EWMA
+func (s *LatencyAwareSelector) RequestFinished(accountID uint, latency time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stats := s.stats[accountID]
+	if stats == nil {
+		return
+	}
+	if stats.inFlight > 0 {
+		stats.inFlight--
+	}
+	if latency <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if stats.lastSample.IsZero() {
+		stats.ewma = float64(latency)
+	} else {
+		stats.ewma = latencyEWMAAlpha*float64(latency) + (1-latencyEWMAAlpha)*decayedEWMA(stats, now)
+	}
+	stats.lastSample = now
+}
+
+// GetStats 获取各账号的EWMA TTFB和正在处理的请求数
+func (s *LatencyAwareSelector) GetStats() map[uint]map[string]interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	result := make(map[uint]map[string]interface{}, len(s.stats))
+	for accountID, stats := range s.stats {
+		result[accountID] = map[string]interface{}{
+			"ewma_ttfb_ms": decayedEWMA(stats, now) / float64(time.Millisecond),
+			"in_flight":    stats.inFlight,
+		}
+	}
+	return result
+}
+
+// attemptLatency 计算一次尝试计入延迟统计的值：成功时使用TTFB，
+// 对冲落败时使用已等待的时间，其他失败至少计入latencyFailurePenalty
+func attemptLatency(capture *StreamingResponseCapture, result *FallbackResult) time.Duration {
+	if result.Success {
+		if ttfb := capture.GetFirstByteTime(); ttfb != nil {
+			return *ttfb
+		}
+		return result.Duration
+	}
+	if capture.race != nil && capture.race.lost(capture) {
+		return result.Duration
+	}
+	// 请求本身的错误与账号无关
+	if result.ErrorClass == ErrorClassClient {
+		return 0
+	}
+	if result.Duration > latencyFailurePenalty {
+		return result.Duration
+	}
+	return latencyFailurePenalty
+}
@@ -0,0 +1,387 @@
+package relay
+
+import (
+	"bufio"
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+const (
+	// 批处理结果单行最大长度
+	maxBatchResultLineSize = 32 * 1024 * 1024
+	// 批处理处理完成状态
+	batchStatusEnded = "ended"
+)
+
+var errBatchNotSupported = errors.New("该账号平台不支持Message Batches API")
+
+// SupportsMessageBatches 判断账号平台是否支持Message Batches API
+func SupportsMessageBatches(account *model.Account) bool {
+	return account.PlatformType == constant.PlatformClaude || account.PlatformType == constant.PlatformClaudeConsole
+}
+
+// newBatchAPIRequest 创建Message Batches API请求，path为/v1/messages/batches之后的路径
+func newBatchAPIRequest(ctx context.Context, account *model.Account, method, path string, body []byte) (*http.Request, error) {
+	var requestURL string
+	var headers map[string]string
+
+	switch account.PlatformType {
+	case constant.PlatformClaude:
+		accessToken, err := getValidAccessToken(account)
+		if err != nil {
+			return nil, err
+		}
+		requestURL = ClaudeAPIURL + "/batches" + path
+		headers = buildClaudeAPIHeaders(accessToken)
+	case constant.PlatformClaudeConsole:
+		requestURL = strings.TrimRight(account.RequestURL, "/") + "/v1/messages/batches" + path
+		headers = buildConsoleAPIHeaders(account.SecretKey)
+	default:
+		return nil, errBatchNotSupported
+	}
+
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, requestURL, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	// 批处理接口不需要Claude Code专用的beta标记
+	req.Header.Del("anthropic-beta")
+	req.Header.Del("x-stainless-helper-method")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+
+	return req, nil
+}
+
+// doBatchAPIRequest 发送Message Batches API请求并读取完整响应
+func doBatchAPIRequest(ctx context.Context, account *model.Account, method, path string, body []byte) (int, []byte, error) {
+	req, err := newBatchAPIRequest(ctx, account, method, path, body)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	client := createHTTPClient(account)
+	if client == nil {
+		return 0, nil, errors.New("代理配置错误")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer common.CloseIO(resp.Body)
+
+	reader, err := createResponseReader(resp)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	responseBody, err := io.ReadAll(reader)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	return resp.StatusCode, responseBody, nil
+}
+
+// HandleCreateMessageBatch 在指定账号上创建批处理，成功后记录批处理与账号的绑定关系
+func HandleCreateMessageBatch(c *gin.Context, account *model.Account, requestBody []byte) {
+	statusCode, responseBody, err := doBatchAPIRequest(c.Request.Context(), account, http.MethodPost, "", requestBody)
+	if err != nil {
+		respondBatchRequestError(c, err)
+		return
+	}
+
+	if statusCode < 200 || statusCode >= 300 {
+		log.Printf("❌ 创建批处理失败 账号: %s 状态码: %d, 错误响应内容: %s", account.Name, statusCode, string(responseBody))
+		accountService := service.NewAccountService()
+		accountService.UpdateAccountStatus(account, statusCode, nil)
+		c.Data(statusCode, "application/json", responseBody)
+		return
+	}
+
+	batchID := gjson.GetBytes(responseBody, "id").String()
+	if batchID == "" {
+		c.JSON(http.StatusBadGateway, gin.H{
+			"error": map[string]interface{}{
+				"type":    "response_read_error",
+				"message": "上游响应缺少批处理ID",
+			},
+		})
+		return
+	}
+
+	// 对冲落败的一方不保存记录，避免同一请求产生重复计费的批处理
+	if isHedgeLoser(c) {
+		return
+	}
+
+	batch := &model.MessageBatch{
+		BatchID:          batchID,
+		AccountID:        account.ID,
+		ProcessingStatus: gjson.GetBytes(responseBody, "processing_status").String(),
+		RequestCount:     int(gjson.GetBytes(requestBody, "requests.#").Int()),
+		Snapshot:         string(responseBody),
+	}
+	if keyInfo, exists := c.Get("api_key"); exists {
+		apiKey := keyInfo.(*model.ApiKey)
+		batch.ApiKeyID = apiKey.ID
+		batch.UserID = apiKey.UserID
+	}
+	if err := model.CreateMessageBatch(batch); err != nil {
+		// 上游已创建成功，记录失败只影响后续查询和计费，仍然返回结果
+		log.Printf("保存批处理记录失败 batch_id: %s, err: %v", batchID, err)
+	}
+
+	c.Data(statusCode, "application/json", responseBody)
+}
+
+// HandleMessageBatchRequest 在批处理绑定的账号上执行查询或取消操作，并同步本地状态
+func HandleMessageBatchRequest(c *gin.Context, account *model.Account, batch *model.MessageBatch, method, path string) {
+	var body []byte
+	if method == http.MethodPost {
+		body = []byte("{}")
+	}
+
+	statusCode, responseBody, err := doBatchAPIRequest(c.Request.Context(), account, method, "/"+batch.BatchID+path, body)
+	if err != nil {
+		respondBatchRequestError(c, err)
+		return
+	}
+
+	if statusCode >= 200 && statusCode < 300 {
+		syncMessageBatchSnapshot(batch, account, responseBody)
+	}
+
+	c.Data(statusCode, "application/json", responseBody)
+}
+
+// HandleMessageBatchResults 透传批处理结果（JSONL格式）
+func HandleMessageBatchResults(c *gin.Context, account *model.Account, batch *model.MessageBatch) {
+	req, err := newBatchAPIRequest(c.Request.Context(), account, http.MethodGet, "/"+batch.BatchID+"/results", nil)
+	if err != nil {
+		respondBatchRequestError(c, err)
+		return
+	}
+
+	client := createHTTPClient(account)
+	if client == nil {
+		c.JSON(http.StatusInternalServerError, errProxyConfig)
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		handleRequestError(c, err)
+		return
+	}
+	defer common.CloseIO(resp.Body)
+
+	reader, err := createResponseReader(resp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errDecompression, err.Error()))
+		return
+	}
+
+	contentType := resp.Header.Get("Content-Type")
+	if contentType == "" {
+		contentType = "application/binary"
+	}
+	c.Status(resp.StatusCode)
+	c.Header("Content-Type", contentType)
+	if _, err := io.Copy(c.Writer, reader); err != nil {
+		log.Printf("透传批处理结果失败 batch_id: %s, err: %v", batch.BatchID, err)
+	}
+}
+
+// respondBatchRequestError 返回批处理请求的错误信息
+func respondBatchRequestError(c *gin.Context, err error) {
+	if errors.Is(err, errBatchNotSupported) {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]interface{}{
+				"type":    "invalid_request_error",
+				"message": err.Error(),
+			},
+		})
+		return
+	}
+	handleRequestError(c, err)
+}
+
+// syncMessageBatchSnapshot 根据上游批处理对象更新本地记录，处理完成时触发计费
+func syncMessageBatchSnapshot(batch *model.MessageBatch, account *model.Account, responseBody []byte) {
+	status := gjson.GetBytes(responseBody, "processing_status").String()
+	if status == "" {
+		return
+	}
+
+	batch.ProcessingStatus = status
+	batch.Snapshot = string(responseBody)
+	if err := model.UpdateMessageBatchStatus(batch); err != nil {
+		log.Printf("更新批处理记录失败 batch_id: %s, err: %v", batch.BatchID, err)
+		return
+	}
+
+	if status == batchStatusEnded && batch.BillStatus == model.BatchBillPending {
+		go func() {
+			if err := BillMessageBatch(batch, account); err != nil {
+				log.Printf("批处理计费失败 batch_id: %s, err: %v", batch.BatchID, err)
+			}
+		}()
+	}
+}
+
+// SyncMessageBatch 查询上游批处理状态（用于定时任务），处理完成后计费
+func SyncMessageBatch(batch *model.MessageBatch) error {
+	account, err := model.GetAccountByID(batch.AccountID)
+	if err != nil {
+		return fmt.Errorf("批处理绑定的账号不存在: %w", err)
+	}
+
+	if batch.ProcessingStatus != batchStatusEnded {
+		statusCode, responseBody, err := doBatchAPIRequest(context.Background(), account, http.MethodGet, "/"+batch.BatchID, nil)
+		if err != nil {
+			return err
+		}
+		if statusCode != http.StatusOK {
+			return fmt.Errorf("查询批处理状态失败，状态码: %d, 响应: %s", statusCode, string(responseBody))
+		}
+
+		batch.ProcessingStatus = gjson.GetBytes(responseBody, "processing_status").String()
+		batch.Snapshot = string(responseBody)
+		if err := model.UpdateMessageBatchStatus(batch); err != nil {
+			return err
+		}
+	}
+
+	if batch.ProcessingStatus != batchStatusEnded {
+		return nil
+	}
+	return BillMessageBatch(batch, account)
+}
+
+// BillMessageBatch 下载批处理结果，按批处理价格逐条计费并写入日志
+// 只有成功（succeeded）的条目会产生费用
+func BillMessageBatch(batch *model.MessageBatch, account *model.Account) error {
+	claimed, err := model.ClaimMessageBatchBilling(batch.ID)
+	if err != nil || !claimed {
+		return err
+	}
+
+	billedCount, totalCost, err := billMessageBatchResults(batch, account)
+	if err != nil {
+		if releaseErr := model.ReleaseMessageBatchBilling(batch.ID); releaseErr != nil {
+			log.Printf("释放批处理计费状态失败 batch_id: %s, err: %v", batch.BatchID, releaseErr)
+		}
+		return err
+	}
+
+	batch.BillStatus = model.BatchBillDone
+	batch.BilledCount = billedCount
+	batch.TotalCost = totalCost
+	log.Printf("批处理计费完成 batch_id: %s, 计费条数: %d, 总费用: %s", batch.BatchID, billedCount, common.FormatCost(totalCost))
+	return model.CompleteMessageBatchBilling(batch.ID, billedCount, totalCost)
+}
+
+// billMessageBatchResults 读取批处理结果并逐条写入日志，返回计费条数和总费用
+func billMessageBatchResults(batch *model.MessageBatch, account *model.Account) (int, float64, error) {
+	req, err := newBatchAPIRequest(context.Background(), account, http.MethodGet, "/"+batch.BatchID+"/results", nil)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	client := createHTTPClient(account)
+	if client == nil {
+		return 0, 0, errors.New("代理配置错误")
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer common.CloseIO(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return 0, 0, fmt.Errorf("获取批处理结果失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	reader, err := createResponseReader(resp)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// 先完整解析结果再写日志，避免下载中断时只记录了部分条目
+	var usages []*common.TokenUsage
+	var itemKeys []string
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxBatchResultLineSize)
+	for scanner.Scan() {
+		if usage := parseBatchResultUsage(scanner.Bytes()); usage != nil {
+			usages = append(usages, usage)
+			itemKeys = append(itemKeys, batchItemKey(batch.BatchID, gjson.GetBytes(scanner.Bytes(), "custom_id").String()))
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, 0, err
+	}
+
+	// 日志按批处理条目幂等写入，任一条目失败时返回错误，批处理保持待计费状态等待重试
+	logService := service.NewLogService()
+	totalCost := 0.0
+	for i, usage := range usages {
+		batchLog, err := logService.CreateBatchLogFromTokenUsage(usage, batch.UserID, batch.ApiKeyID, batch.AccountID, itemKeys[i])
+		if err != nil {
+			return 0, 0, fmt.Errorf("保存批处理日志失败 %s: %w", itemKeys[i], err)
+		}
+		totalCost += batchLog.TotalCost
+	}
+
+	return len(usages), totalCost, nil
+}
+
+// batchItemKey 批处理条目的计费标识，custom_id 在同一批处理内唯一
+func batchItemKey(batchID, customID string) string {
+	return batchID + ":" + customID
+}
+
+// parseBatchResultUsage 解析单行批处理结果的token使用量，非成功条目返回nil
+func parseBatchResultUsage(line []byte) *common.TokenUsage {
+	result := gjson.GetBytes(line, "result")
+	if result.Get("type").String() != "succeeded" {
+		return nil
+	}
+
+	message := result.Get("message")
+	usage := message.Get("usage")
+	return &common.TokenUsage{
+		InputTokens:              int(usage.Get("input_tokens").Int()),
+		OutputTokens:             int(usage.Get("output_tokens").Int()),
+		CacheReadInputTokens:     int(usage.Get("cache_read_input_tokens").Int()),
+		CacheCreationInputTokens: int(usage.Get("cache_creation_input_tokens").Int()),
+		Model:                    message.Get("model").String(),
+	}
+}
@@ -0,0 +1,27 @@
+package relay
+
+import (
+	"claude-code-relay/common"
+	"math"
+	"testing"
+)
+
+// TestParseBatchResultUsage 只有成功的批处理条目才计费，且按批处理价格计算
+func TestParseBatchResultUsage(t *testing.T) {
+	succeeded := []byte(`{"custom_id":"a","result":{"type":"succeeded","message":{"model":"claude-sonnet-4-20250514","usage":{"input_tokens":1000000,"output_tokens":1000000,"cache_read_input_tokens":10}}}}`)
+	errored := []byte(`{"custom_id":"b","result":{"type":"errored","error":{"type":"invalid_request"}}}`)
+
+	usage := parseBatchResultUsage(succeeded)
+	if usage == nil || usage.InputTokens != 1000000 || usage.CacheReadInputTokens != 10 || usage.Model != "claude-sonnet-4-20250514" {
+		t.Fatalf("解析成功条目失败: %+v", usage)
+	}
+	if parseBatchResultUsage(errored) != nil {
+		t.Error("失败条目不应计费")
+	}
+
+	standard := common.CalculateCost(usage).Costs.Total
+	batch := common.CalculateBatchCost(usage).Costs.Total
+	if math.Abs(batch-standard*common.BatchPricingRate) > 1e-9 {
+		t.Errorf("批处理费用应为标准费用的一半: 标准 %f, 批处理 %f", standard, batch)
+	}
+}
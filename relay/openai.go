@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"claude-code-relay/common"
+	"claude-code-relay/constant"
 	"claude-code-relay/model"
 	"claude-code-relay/service"
 	"crypto/tls"
@@ -30,6 +31,7 @@ type ClaudeTool struct {
 type ClaudeContentBlock struct {
 	Type      string                 `json:"type"`
 	Text      string                 `json:"text,omitempty"`
+	Thinking  string                 `json:"thinking,omitempty"`
 	Source    *ClaudeContentSource   `json:"source,omitempty"`
 	ID        string                 `json:"id,omitempty"`
 	Name      string                 `json:"name,omitempty"`
@@ -49,6 +51,11 @@ type ClaudeMessage struct {
 	Content interface{} `json:"content"`
 }
 
+type ClaudeThinking struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
+}
+
 type ClaudeToolChoice struct {
 	Type string `json:"type"`
 	Name string `json:"name,omitempty"`
@@ -66,15 +73,17 @@ type ClaudeRequest struct {
 	TopK          *int                   `json:"top_k,omitempty"`
 	Tools         []ClaudeTool           `json:"tools,omitempty"`
 	ToolChoice    *ClaudeToolChoice      `json:"tool_choice,omitempty"`
+	Thinking      *ClaudeThinking        `json:"thinking,omitempty"`
 	Metadata      map[string]interface{} `json:"metadata,omitempty"`
 }
 
 // OpenAI API 类型定义
 type OpenAIMessage struct {
-	Role       string           `json:"role"`
-	Content    interface{}      `json:"content"`
-	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
-	ToolCallID string           `json:"tool_call_id,omitempty"`
+	Role             string           `json:"role"`
+	Content          interface{}      `json:"content"`
+	ReasoningContent string           `json:"reasoning_content,omitempty"`
+	ToolCalls        []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID       string           `json:"tool_call_id,omitempty"`
 }
 
 type OpenAIToolCall struct {
@@ -105,15 +114,16 @@ type OpenAIToolChoice struct {
 }
 
 type OpenAIRequest struct {
-	Model       string          `json:"model"`
-	Messages    []OpenAIMessage `json:"messages"`
-	MaxTokens   *int            `json:"max_tokens,omitempty"`
-	Temperature *float64        `json:"temperature,omitempty"`
-	TopP        *float64        `json:"top_p,omitempty"`
-	Stop        []string        `json:"stop,omitempty"`
-	Stream      bool            `json:"stream,omitempty"`
-	Tools       []OpenAITool    `json:"tools,omitempty"`
-	ToolChoice  interface{}     `json:"tool_choice,omitempty"`
+	Model           string          `json:"model"`
+	Messages        []OpenAIMessage `json:"messages"`
+	MaxTokens       *int            `json:"max_tokens,omitempty"`
+	Temperature     *float64        `json:"temperature,omitempty"`
+	TopP            *float64        `json:"top_p,omitempty"`
+	Stop            []string        `json:"stop,omitempty"`
+	Stream          bool            `json:"stream,omitempty"`
+	Tools           []OpenAITool    `json:"tools,omitempty"`
+	ToolChoice      interface{}     `json:"tool_choice,omitempty"`
+	ReasoningEffort string          `json:"reasoning_effort,omitempty"`
 }
 
 // OpenAI 响应类型定义
@@ -154,6 +164,9 @@ type ClaudeUsage struct {
 	OutputTokens int `json:"output_tokens"`
 }
 
+// AzureOpenAIDefaultAPIVersion Azure OpenAI 默认API版本
+const AzureOpenAIDefaultAPIVersion = "2024-10-21"
+
 type OpenAITargetConfig struct {
 	BaseURL   string
 	ModelName string
@@ -202,11 +215,8 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account, requestBody []b
 	// 应用模型映射
 	mappedModelName := applyModelMapping(claudeReq.Model, account.ModelMapping, targetConfig.ModelName)
 
-	// 转换Claude请求为OpenAI格式
-	openaiReq := convertClaudeToOpenAI(claudeReq, mappedModelName)
-
-	// 序列化OpenAI请求
-	openaiBody, err := json.Marshal(openaiReq)
+	// 转换Claude请求为OpenAI格式（账号开启Responses API时使用/responses接口）
+	openaiBody, openaiURL, err := buildOpenAIUpstreamRequest(account, claudeReq, mappedModelName)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
 			"error": map[string]interface{}{
@@ -218,7 +228,6 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account, requestBody []b
 	}
 
 	// 创建OpenAI API请求
-	openaiURL := targetConfig.BaseURL + "/chat/completions"
 	req, err := http.NewRequestWithContext(ctx, "POST", openaiURL, bytes.NewBuffer(openaiBody))
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -232,7 +241,7 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account, requestBody []b
 
 	// 设置请求头
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+account.SecretKey)
+	setOpenAIAuthHeaders(req, account)
 
 	// 创建HTTP客户端
 	httpClientTimeout, _ := time.ParseDuration(os.Getenv("HTTP_CLIENT_TIMEOUT") + "s")
@@ -287,10 +296,122 @@ func HandleOpenAIRequest(c *gin.Context, account *model.Account, requestBody []b
 		return
 	}
 
+	// Responses API 的响应格式不同，单独转换
+	if account.UseResponsesAPI {
+		handleResponsesAPIResponse(c, resp, claudeReq.Model, account, apiKey, startTime)
+		return
+	}
+
 	// 统一使用流式响应处理（根据实际响应判断是否流式，传递原始Claude模型名称用于日志记录）
 	handleStreamingResponse(c, resp, claudeReq.Model, account, apiKey, startTime)
 }
 
+// buildOpenAIUpstreamRequest 根据账号配置构建上游请求体和请求地址
+func buildOpenAIUpstreamRequest(account *model.Account, claudeReq ClaudeRequest, mappedModelName string) ([]byte, string, error) {
+	if account.UseResponsesAPI {
+		body, err := json.Marshal(convertClaudeToResponses(claudeReq, mappedModelName))
+		return body, buildOpenAIResponsesURL(account), err
+	}
+
+	body, err := json.Marshal(convertClaudeToOpenAI(claudeReq, mappedModelName))
+	return body, buildOpenAIChatURL(account, mappedModelName), err
+}
+
+// buildOpenAIChatURL 构建Chat Completions请求地址
+// Azure OpenAI 使用部署级地址，模型映射的目标即部署名称，并通过api-version查询参数指定版本
+func buildOpenAIChatURL(account *model.Account, mappedModelName string) string {
+	baseURL := strings.TrimRight(account.RequestURL, "/")
+	if account.PlatformType != constant.PlatformAzureOpenAI {
+		return baseURL + "/chat/completions"
+	}
+
+	apiVersion := account.ApiVersion
+	if apiVersion == "" {
+		apiVersion = AzureOpenAIDefaultAPIVersion
+	}
+	return fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s",
+		baseURL, url.PathEscape(mappedModelName), url.QueryEscape(apiVersion))
+}
+
+// setOpenAIAuthHeaders 设置鉴权请求头，Azure OpenAI 使用api-key头
+func setOpenAIAuthHeaders(req *http.Request, account *model.Account) {
+	if account.PlatformType == constant.PlatformAzureOpenAI {
+		req.Header.Set("api-key", account.SecretKey)
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+account.SecretKey)
+}
+
+// thinkingToReasoningEffort 将Claude的thinking预算映射为OpenAI的reasoning_effort
+// 未开启thinking时返回空字符串，不下发该参数
+func thinkingToReasoningEffort(thinking *ClaudeThinking) string {
+	if thinking == nil || thinking.Type != "enabled" {
+		return ""
+	}
+
+	switch {
+	case thinking.BudgetTokens < 8000:
+		return "low"
+	case thinking.BudgetTokens < 16000:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+// extractReasoningContent 提取OpenAI兼容平台返回的推理内容
+// DeepSeek、Qwen等使用reasoning_content字段，OpenRouter等使用reasoning字段
+func extractReasoningContent(data map[string]interface{}) string {
+	if reasoning, ok := data["reasoning_content"].(string); ok && reasoning != "" {
+		return reasoning
+	}
+	if reasoning, ok := data["reasoning"].(string); ok {
+		return reasoning
+	}
+	return ""
+}
+
+// mapOpenAIFinishReason 映射OpenAI停止原因为Claude格式
+func mapOpenAIFinishReason(finishReason string) string {
+	switch finishReason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
+
+// isContentFilterOnlyChunk 判断是否为只携带内容过滤结果的chunk（Azure OpenAI特有）
+// 这类chunk的choices为空（prompt_filter_results），或choice中只有content_filter_results而没有增量内容
+func isContentFilterOnlyChunk(chunk map[string]interface{}) bool {
+	choices, _ := chunk["choices"].([]interface{})
+	if len(choices) == 0 {
+		_, hasPromptFilter := chunk["prompt_filter_results"]
+		return hasPromptFilter
+	}
+
+	choice, ok := choices[0].(map[string]interface{})
+	if !ok {
+		return false
+	}
+	if _, hasFilter := choice["content_filter_results"]; !hasFilter {
+		return false
+	}
+	if reason, ok := choice["finish_reason"].(string); ok && reason != "" {
+		return false
+	}
+	delta, _ := choice["delta"].(map[string]interface{})
+	if content, ok := delta["content"].(string); ok && content != "" {
+		return false
+	}
+	_, hasToolCalls := delta["tool_calls"]
+	return !hasToolCalls
+}
+
 // extractSystemMessage 从system字段中提取系统消息文本
 // 支持字符串和数组格式的system字段
 func extractSystemMessage(systemField interface{}) string {
@@ -340,7 +461,8 @@ func applyModelMapping(claudeModel, modelMapping, defaultTargetModel string) str
 			continue
 		}
 
-		parts := strings.Split(mapping, ":")
+		// 只按第一个冒号切分，目标模型名中可能包含冒号（如 Bedrock 的 -v1:0）
+		parts := strings.SplitN(mapping, ":", 2)
 		if len(parts) != 2 {
 			continue
 		}
@@ -543,6 +665,11 @@ func convertClaudeToOpenAI(claudeReq ClaudeRequest, modelName string) OpenAIRequ
 		Stop:        claudeReq.StopSequences,
 	}
 
+	// 转换思考预算，非推理模型（如gpt-4o）会拒绝reasoning_effort参数，仅对推理模型下发
+	if isOpenAIReasoningModel(modelName) {
+		openaiReq.ReasoningEffort = thinkingToReasoningEffort(claudeReq.Thinking)
+	}
+
 	// 转换工具
 	if len(claudeReq.Tools) > 0 {
 		for _, tool := range claudeReq.Tools {
@@ -582,6 +709,14 @@ func convertOpenAIToClaudeResponse(openaiResp OpenAIResponse, model string) Clau
 	if len(openaiResp.Choices) > 0 {
 		choice := openaiResp.Choices[0]
 
+		// 推理内容转换为thinking内容块，放在最前面
+		if choice.Message.ReasoningContent != "" {
+			contentBlocks = append(contentBlocks, ClaudeContentBlock{
+				Type:     "thinking",
+				Thinking: choice.Message.ReasoningContent,
+			})
+		}
+
 		// 添加文本内容
 		if choice.Message.Content != nil {
 			if content, ok := choice.Message.Content.(string); ok && content != "" {
@@ -611,18 +746,9 @@ func convertOpenAIToClaudeResponse(openaiResp OpenAIResponse, model string) Clau
 	}
 
 	// 映射停止原因
-	stopReasonMap := map[string]string{
-		"stop":       "end_turn",
-		"length":     "max_tokens",
-		"tool_calls": "tool_use",
-	}
-
-	var stopReason string
+	stopReason := "end_turn"
 	if len(openaiResp.Choices) > 0 {
-		stopReason = stopReasonMap[openaiResp.Choices[0].FinishReason]
-	}
-	if stopReason == "" {
-		stopReason = "end_turn"
+		stopReason = mapOpenAIFinishReason(openaiResp.Choices[0].FinishReason)
 	}
 
 	return ClaudeResponse{
@@ -649,6 +775,7 @@ func handleStreamingResponse(c *gin.Context, resp *http.Response, model string,
 		c.Header("Content-Type", "text/event-stream")
 		c.Header("Cache-Control", "no-cache")
 		c.Header("Connection", "keep-alive")
+		c.Status(resp.StatusCode)
 		c.Writer.Flush()
 
 		// 创建流式转换器并处理OpenAI流式响应
@@ -664,17 +791,25 @@ func handleStreamingResponse(c *gin.Context, resp *http.Response, model string,
 			}
 		}
 
+		// 对冲请求中落败的一方不计费也不记录日志
+		if isHedgeLoser(c) {
+			return
+		}
+
+		// 流在首个内容前被fallback判定失败时按失败记录
+		statusCode := fallbackStatusCode(c, resp.StatusCode)
+
 		// 更新账号状态和统计信息
 		accountService := service.NewAccountService()
-		go accountService.UpdateAccountStatus(account, resp.StatusCode, usageTokens)
+		go accountService.UpdateAccountStatus(account, statusCode, usageTokens)
 
 		// 更新API Key统计信息
 		if apiKey != nil {
-			go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+			go service.UpdateApiKeyStatus(apiKey, statusCode, usageTokens)
 		}
 
 		// 记录日志
-		if resp.StatusCode >= 200 && resp.StatusCode < 300 && usageTokens != nil && apiKey != nil {
+		if statusCode >= 200 && statusCode < 300 && usageTokens != nil && apiKey != nil {
 			duration := time.Since(startTime).Milliseconds()
 			logService := service.NewLogService()
 			go func() {
@@ -720,17 +855,25 @@ func handleStreamingResponse(c *gin.Context, resp *http.Response, model string,
 			}
 		}
 
+		// 对冲请求中落败的一方不计费也不记录日志
+		if isHedgeLoser(c) {
+			return
+		}
+
+		// 流在首个内容前被fallback判定失败时按失败记录
+		statusCode := fallbackStatusCode(c, resp.StatusCode)
+
 		// 更新账号状态和统计信息
 		accountService := service.NewAccountService()
-		go accountService.UpdateAccountStatus(account, resp.StatusCode, usageTokens)
+		go accountService.UpdateAccountStatus(account, statusCode, usageTokens)
 
 		// 更新API Key统计信息
 		if apiKey != nil {
-			go service.UpdateApiKeyStatus(apiKey, resp.StatusCode, usageTokens)
+			go service.UpdateApiKeyStatus(apiKey, statusCode, usageTokens)
 		}
 
 		// 记录日志
-		if resp.StatusCode >= 200 && resp.StatusCode < 300 && usageTokens != nil && apiKey != nil {
+		if statusCode >= 200 && statusCode < 300 && usageTokens != nil && apiKey != nil {
 			duration := time.Since(startTime).Milliseconds()
 			logService := service.NewLogService()
 			go func() {
@@ -784,6 +927,15 @@ func convertOpenAIResponseToClaude(responseBody []byte, model string) (interface
 
 				// 处理内容
 				var contentBlocks []map[string]interface{}
+
+				// 推理内容转换为thinking内容块，放在最前面
+				if reasoning := extractReasoningContent(message); reasoning != "" {
+					contentBlocks = append(contentBlocks, map[string]interface{}{
+						"type":      "thinking",
+						"thinking":  reasoning,
+						"signature": "",
+					})
+				}
 				
 				// 添加文本内容
 				if content, ok := message["content"].(string); ok && content != "" {
@@ -819,6 +971,8 @@ func convertOpenAIResponseToClaude(responseBody []byte, model string) (interface
 				}
 
 				claudeResponse["content"] = contentBlocks
+				finishReason, _ := choice["finish_reason"].(string)
+				claudeResponse["stop_reason"] = mapOpenAIFinishReason(finishReason)
 				
 				// 添加usage信息
 				if usageTokens != nil {
@@ -842,6 +996,7 @@ func processOpenAIStreamResponse(writer gin.ResponseWriter, reader io.Reader, tr
 
 	var totalPromptTokens, totalCompletionTokens int
 	var responseContent strings.Builder
+	var reasoningContent strings.Builder
 	var toolCalls []OpenAIToolCall
 	var finishReason string
 
@@ -892,12 +1047,17 @@ func processOpenAIStreamResponse(writer gin.ResponseWriter, reader io.Reader, tr
 					if content, ok := delta["content"].(string); ok {
 						responseContent.WriteString(content)
 					}
+					reasoningContent.WriteString(extractReasoningContent(delta))
 
 					// 收集工具调用增量数据
 					if toolCallsData, ok := delta["tool_calls"].([]interface{}); ok {
 						for _, tc := range toolCallsData {
 							if tcMap, ok := tc.(map[string]interface{}); ok {
-								index := int(tcMap["index"].(float64))
+								indexValue, ok := tcMap["index"].(float64)
+								if !ok {
+									continue
+								}
+								index := int(indexValue)
 
 								// 确保toolCalls数组足够长
 								for len(toolCalls) <= index {
@@ -936,6 +1096,14 @@ func processOpenAIStreamResponse(writer gin.ResponseWriter, reader io.Reader, tr
 		// 构建Claude格式的内容块
 		var contentBlocks []ClaudeContentBlock
 
+		// 添加推理内容
+		if reasoningContent.Len() > 0 {
+			contentBlocks = append(contentBlocks, ClaudeContentBlock{
+				Type:     "thinking",
+				Thinking: reasoningContent.String(),
+			})
+		}
+
 		// 添加文本内容
 		if responseContent.Len() > 0 {
 			contentBlocks = append(contentBlocks, ClaudeContentBlock{
@@ -965,15 +1133,7 @@ func processOpenAIStreamResponse(writer gin.ResponseWriter, reader io.Reader, tr
 		}
 
 		// 映射停止原因
-		stopReasonMap := map[string]string{
-			"stop":       "end_turn",
-			"length":     "max_tokens",
-			"tool_calls": "tool_use",
-		}
-		stopReason := stopReasonMap[finishReason]
-		if stopReason == "" {
-			stopReason = "end_turn"
-		}
+		stopReason := mapOpenAIFinishReason(finishReason)
 
 		claudeResponse := ClaudeResponse{
 			ID:         fmt.Sprintf("msg_%s", generateRandomID()),
@@ -1013,6 +1173,10 @@ type StreamTransformer struct {
 	model             string
 	toolCalls         map[int]*ToolCallState
 	contentBlockIndex int
+	finishReason      string
+	blockCount        int  // 已开始的内容块数量，用于分配下一个内容块索引
+	textBlockIndex    int  // 文本内容块索引，-1表示尚未开始
+	thinkingIndex     int  // 当前打开的thinking内容块索引，-1表示未打开
 }
 
 // ToolCallState 工具调用状态
@@ -1032,6 +1196,8 @@ func createStreamTransformer(model string) *StreamTransformer {
 		model:             model,
 		toolCalls:         make(map[int]*ToolCallState),
 		contentBlockIndex: 0,
+		textBlockIndex:    -1,
+		thinkingIndex:     -1,
 	}
 }
 
@@ -1054,45 +1220,31 @@ func (st *StreamTransformer) sendEvent(writer gin.ResponseWriter, eventType stri
 
 // processChunk 处理单个流式chunk
 func (st *StreamTransformer) processChunk(writer gin.ResponseWriter, openaiChunk map[string]interface{}) {
-	// 初始化消息开始事件
-	if !st.initialized {
-		st.sendEvent(writer, "message_start", map[string]interface{}{
-			"type": "message_start",
-			"message": map[string]interface{}{
-				"id":          st.messageID,
-				"type":        "message",
-				"role":        "assistant",
-				"model":       st.model,
-				"content":     []interface{}{},
-				"stop_reason": nil,
-				"usage": map[string]int{
-					"input_tokens":  0,
-					"output_tokens": 0,
-				},
-			},
-		})
-
-		st.sendEvent(writer, "content_block_start", map[string]interface{}{
-			"type":  "content_block_start",
-			"index": 0,
-			"content_block": map[string]interface{}{
-				"type": "text",
-				"text": "",
-			},
-		})
-
-		st.initialized = true
+	// 跳过Azure的内容过滤结果chunk，避免在没有实际内容时初始化或写入空事件
+	if isContentFilterOnlyChunk(openaiChunk) {
+		return
 	}
 
+	// 初始化消息开始事件
+	st.ensureStarted(writer)
+
 	// 处理choices数组
 	if choices, ok := openaiChunk["choices"].([]interface{}); ok && len(choices) > 0 {
 		if choice, ok := choices[0].(map[string]interface{}); ok {
+			if reason, ok := choice["finish_reason"].(string); ok && reason != "" {
+				st.finishReason = reason
+			}
 			if delta, ok := choice["delta"].(map[string]interface{}); ok {
+				// 处理推理内容（DeepSeek、Qwen等平台的reasoning_content/reasoning字段）
+				if reasoning := extractReasoningContent(delta); reasoning != "" {
+					st.processReasoningDelta(writer, reasoning)
+				}
+
 				// 处理文本内容
-				if content, ok := delta["content"].(string); ok {
+				if content, ok := delta["content"].(string); ok && content != "" {
 					st.sendEvent(writer, "content_block_delta", map[string]interface{}{
 						"type":  "content_block_delta",
-						"index": 0,
+						"index": st.ensureTextBlock(writer),
 						"delta": map[string]interface{}{
 							"type": "text_delta",
 							"text": content,
@@ -1113,9 +1265,109 @@ func (st *StreamTransformer) processChunk(writer gin.ResponseWriter, openaiChunk
 	}
 }
 
+// ensureStarted 发送消息开始事件
+func (st *StreamTransformer) ensureStarted(writer gin.ResponseWriter) {
+	if st.initialized {
+		return
+	}
+
+	st.sendEvent(writer, "message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":          st.messageID,
+			"type":        "message",
+			"role":        "assistant",
+			"model":       st.model,
+			"content":     []interface{}{},
+			"stop_reason": nil,
+			"usage": map[string]int{
+				"input_tokens":  0,
+				"output_tokens": 0,
+			},
+		},
+	})
+	st.initialized = true
+}
+
+// startBlock 开始新的内容块并返回其索引
+func (st *StreamTransformer) startBlock(writer gin.ResponseWriter, contentBlock map[string]interface{}) int {
+	index := st.blockCount
+	st.blockCount++
+	st.sendEvent(writer, "content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         index,
+		"content_block": contentBlock,
+	})
+	return index
+}
+
+// stopBlock 结束指定的内容块
+func (st *StreamTransformer) stopBlock(writer gin.ResponseWriter, index int) {
+	st.sendEvent(writer, "content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": index,
+	})
+}
+
+// closeThinkingBlock 结束当前打开的thinking内容块
+func (st *StreamTransformer) closeThinkingBlock(writer gin.ResponseWriter) {
+	if st.thinkingIndex < 0 {
+		return
+	}
+	st.stopBlock(writer, st.thinkingIndex)
+	st.thinkingIndex = -1
+}
+
+// closeTextBlock 结束当前打开的文本内容块，之后的文本开始新的内容块
+func (st *StreamTransformer) closeTextBlock(writer gin.ResponseWriter) {
+	if st.textBlockIndex < 0 {
+		return
+	}
+	st.stopBlock(writer, st.textBlockIndex)
+	st.textBlockIndex = -1
+}
+
+// ensureTextBlock 确保文本内容块已开始，返回其索引
+func (st *StreamTransformer) ensureTextBlock(writer gin.ResponseWriter) int {
+	if st.textBlockIndex < 0 {
+		st.closeThinkingBlock(writer)
+		st.textBlockIndex = st.startBlock(writer, map[string]interface{}{
+			"type": "text",
+			"text": "",
+		})
+	}
+	return st.textBlockIndex
+}
+
+// processReasoningDelta 将推理增量转换为thinking内容块增量
+func (st *StreamTransformer) processReasoningDelta(writer gin.ResponseWriter, reasoning string) {
+	if st.thinkingIndex < 0 {
+		// 文本之后出现推理内容时先结束文本内容块，内容块不能交叉
+		st.closeTextBlock(writer)
+		st.thinkingIndex = st.startBlock(writer, map[string]interface{}{
+			"type":      "thinking",
+			"thinking":  "",
+			"signature": "",
+		})
+	}
+
+	st.sendEvent(writer, "content_block_delta", map[string]interface{}{
+		"type":  "content_block_delta",
+		"index": st.thinkingIndex,
+		"delta": map[string]interface{}{
+			"type":     "thinking_delta",
+			"thinking": reasoning,
+		},
+	})
+}
+
 // processToolCallDelta 处理工具调用增量
 func (st *StreamTransformer) processToolCallDelta(writer gin.ResponseWriter, tcDelta map[string]interface{}) {
-	index := int(tcDelta["index"].(float64))
+	indexValue, ok := tcDelta["index"].(float64)
+	if !ok {
+		return
+	}
+	index := int(indexValue)
 
 	// 初始化工具调用状态
 	if _, exists := st.toolCalls[index]; !exists {
@@ -1146,20 +1398,14 @@ func (st *StreamTransformer) processToolCallDelta(writer gin.ResponseWriter, tcD
 
 	// 如果工具调用准备就绪且未开始，发送开始事件
 	if toolCall.ID != "" && toolCall.Name != "" && !toolCall.Started {
-		st.contentBlockIndex++
-		toolCall.ClaudeIndex = st.contentBlockIndex
-		toolCall.Started = true
-
-		st.sendEvent(writer, "content_block_start", map[string]interface{}{
-			"type":  "content_block_start",
-			"index": toolCall.ClaudeIndex,
-			"content_block": map[string]interface{}{
-				"type":  "tool_use",
-				"id":    toolCall.ID,
-				"name":  toolCall.Name,
-				"input": map[string]interface{}{},
-			},
+		st.closeThinkingBlock(writer)
+		toolCall.ClaudeIndex = st.startBlock(writer, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    toolCall.ID,
+			"name":  toolCall.Name,
+			"input": map[string]interface{}{},
 		})
+		toolCall.Started = true
 	}
 
 	// 如果有新的参数内容，发送增量事件
@@ -1181,11 +1427,18 @@ func (st *StreamTransformer) processToolCallDelta(writer gin.ResponseWriter, tcD
 
 // sendFinalEvents 发送最终事件
 func (st *StreamTransformer) sendFinalEvents(writer gin.ResponseWriter) {
-	// 发送内容块结束事件
-	st.sendEvent(writer, "content_block_stop", map[string]interface{}{
-		"type":  "content_block_stop",
-		"index": 0,
-	})
+	st.ensureStarted(writer)
+	st.closeThinkingBlock(writer)
+
+	// 没有任何内容时补一个空文本块，保持响应结构完整
+	if st.blockCount == 0 {
+		st.ensureTextBlock(writer)
+	}
+
+	// 发送文本内容块结束事件
+	if st.textBlockIndex >= 0 {
+		st.stopBlock(writer, st.textBlockIndex)
+	}
 
 	// 发送所有工具调用的结束事件
 	for _, toolCall := range st.toolCalls {
@@ -1201,7 +1454,7 @@ func (st *StreamTransformer) sendFinalEvents(writer gin.ResponseWriter) {
 	st.sendEvent(writer, "message_delta", map[string]interface{}{
 		"type": "message_delta",
 		"delta": map[string]interface{}{
-			"stop_reason":   "end_turn",
+			"stop_reason":   mapOpenAIFinishReason(st.finishReason),
 			"stop_sequence": nil,
 		},
 		"usage": map[string]int{
@@ -1217,7 +1470,11 @@ func (st *StreamTransformer) sendFinalEvents(writer gin.ResponseWriter) {
 
 // TestHandleOpenAIRequest 仅用于单元测试，返回状态码和响应内容
 func TestHandleOpenAIRequest(account *model.Account) (int, string) {
-	requestBody := GetTestRequestBody(100)
+	return testOpenAIRequest(account, GetTestRequestBody(100))
+}
+
+// testOpenAIRequest 使用指定请求体测试OpenAI账号连通性
+func testOpenAIRequest(account *model.Account, requestBody string) (int, string) {
 
 	// 解析Claude请求
 	var claudeReq ClaudeRequest
@@ -1239,16 +1496,12 @@ func TestHandleOpenAIRequest(account *model.Account) (int, string) {
 	mappedModelName := applyModelMapping(claudeReq.Model, account.ModelMapping, targetConfig.ModelName)
 
 	// 转换Claude请求为OpenAI格式
-	openaiReq := convertClaudeToOpenAI(claudeReq, mappedModelName)
-
-	// 序列化OpenAI请求
-	openaiBody, err := json.Marshal(openaiReq)
+	openaiBody, openaiURL, err := buildOpenAIUpstreamRequest(account, claudeReq, mappedModelName)
 	if err != nil {
 		return http.StatusInternalServerError, "Failed to marshal OpenAI request: " + err.Error()
 	}
 
 	// 创建OpenAI API请求
-	openaiURL := targetConfig.BaseURL + "/chat/completions"
 	req, err := http.NewRequest("POST", openaiURL, bytes.NewBuffer(openaiBody))
 	if err != nil {
 		return http.StatusInternalServerError, "Failed to create request: " + err.Error()
@@ -1256,7 +1509,7 @@ func TestHandleOpenAIRequest(account *model.Account) (int, string) {
 
 	// 设置请求头
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+account.SecretKey)
+	setOpenAIAuthHeaders(req, account)
 
 	// 创建HTTP客户端
 	httpClientTimeout := 30 * time.Second
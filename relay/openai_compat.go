@@ -0,0 +1,700 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// 入站 OpenAI Chat Completions 兼容层：将OpenAI格式请求转换为Claude格式，
+// 再将Claude格式响应（SSE或JSON）转换回OpenAI格式返回给客户端
+
+const defaultCompatMaxTokens = 4096
+
+// OpenAIChatMessage 入站OpenAI消息（content 可能为字符串或数组）
+type OpenAIChatMessage struct {
+	Role       string           `json:"role"`
+	Content    interface{}      `json:"content"`
+	Name       string           `json:"name,omitempty"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallID string           `json:"tool_call_id,omitempty"`
+}
+
+// OpenAIStreamOptions 流式选项
+type OpenAIStreamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+// OpenAIChatCompletionRequest 入站OpenAI Chat Completions请求
+type OpenAIChatCompletionRequest struct {
+	Model               string               `json:"model"`
+	Messages            []OpenAIChatMessage  `json:"messages"`
+	MaxTokens           int                  `json:"max_tokens,omitempty"`
+	MaxCompletionTokens int                  `json:"max_completion_tokens,omitempty"`
+	Temperature         *float64             `json:"temperature,omitempty"`
+	TopP                *float64             `json:"top_p,omitempty"`
+	Stop                interface{}          `json:"stop,omitempty"`
+	Stream              bool                 `json:"stream,omitempty"`
+	StreamOptions       *OpenAIStreamOptions `json:"stream_options,omitempty"`
+	Tools               []OpenAITool         `json:"tools,omitempty"`
+	ToolChoice          interface{}          `json:"tool_choice,omitempty"`
+	User                string               `json:"user,omitempty"`
+}
+
+// ConvertOpenAIRequestToClaude 将入站OpenAI请求体转换为Claude Messages请求体
+func ConvertOpenAIRequestToClaude(body []byte) (*OpenAIChatCompletionRequest, []byte, error) {
+	var openaiReq OpenAIChatCompletionRequest
+	if err := json.Unmarshal(body, &openaiReq); err != nil {
+		return nil, nil, err
+	}
+	if openaiReq.Model == "" {
+		return nil, nil, fmt.Errorf("missing model")
+	}
+	if len(openaiReq.Messages) == 0 {
+		return nil, nil, fmt.Errorf("messages must not be empty")
+	}
+
+	claudeReq := ClaudeRequest{
+		Model:       openaiReq.Model,
+		MaxTokens:   openaiReq.MaxCompletionTokens,
+		Stream:      openaiReq.Stream,
+		Temperature: openaiReq.Temperature,
+		TopP:        openaiReq.TopP,
+	}
+	if claudeReq.MaxTokens == 0 {
+		claudeReq.MaxTokens = openaiReq.MaxTokens
+	}
+	if claudeReq.MaxTokens == 0 {
+		claudeReq.MaxTokens = defaultCompatMaxTokens
+	}
+
+	// 停止序列支持字符串和数组两种格式
+	switch stop := openaiReq.Stop.(type) {
+	case string:
+		if stop != "" {
+			claudeReq.StopSequences = []string{stop}
+		}
+	case []interface{}:
+		for _, s := range stop {
+			if str, ok := s.(string); ok && str != "" {
+				claudeReq.StopSequences = append(claudeReq.StopSequences, str)
+			}
+		}
+	}
+
+	if openaiReq.User != "" {
+		claudeReq.Metadata = map[string]interface{}{"user_id": openaiReq.User}
+	}
+
+	// 转换消息，system/developer 消息合并为Claude的system字段
+	var systemParts []string
+	for _, message := range openaiReq.Messages {
+		var role string
+		var blocks []interface{}
+
+		switch message.Role {
+		case "system", "developer":
+			if text := extractOpenAIText(message.Content); text != "" {
+				systemParts = append(systemParts, text)
+			}
+			continue
+		case "tool":
+			role = "user"
+			blocks = append(blocks, map[string]interface{}{
+				"type":        "tool_result",
+				"tool_use_id": message.ToolCallID,
+				"content":     extractOpenAIText(message.Content),
+			})
+		case "assistant":
+			role = "assistant"
+			if text := extractOpenAIText(message.Content); text != "" {
+				blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+			}
+			for _, toolCall := range message.ToolCalls {
+				input := make(map[string]interface{})
+				if toolCall.Function.Arguments != "" {
+					_ = json.Unmarshal([]byte(toolCall.Function.Arguments), &input)
+				}
+				blocks = append(blocks, map[string]interface{}{
+					"type":  "tool_use",
+					"id":    toolCall.ID,
+					"name":  toolCall.Function.Name,
+					"input": input,
+				})
+			}
+		default:
+			role = "user"
+			blocks = convertOpenAIContentToClaude(message.Content)
+		}
+
+		if len(blocks) == 0 {
+			continue
+		}
+
+		// Claude要求user/assistant交替出现，合并同角色的连续消息（如多个tool结果）
+		if n := len(claudeReq.Messages); n > 0 && claudeReq.Messages[n-1].Role == role {
+			prev := claudeReq.Messages[n-1].Content.([]interface{})
+			claudeReq.Messages[n-1].Content = append(prev, blocks...)
+			continue
+		}
+		claudeReq.Messages = append(claudeReq.Messages, ClaudeMessage{Role: role, Content: blocks})
+	}
+
+	if len(systemParts) > 0 {
+		claudeReq.System = strings.Join(systemParts, "\n\n")
+	}
+
+	// 转换工具定义
+	for _, tool := range openaiReq.Tools {
+		if tool.Type != "" && tool.Type != "function" {
+			continue
+		}
+		schema := tool.Function.Parameters
+		if schema == nil {
+			schema = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		claudeReq.Tools = append(claudeReq.Tools, ClaudeTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: schema,
+		})
+	}
+
+	// 转换工具选择
+	switch choice := openaiReq.ToolChoice.(type) {
+	case string:
+		switch choice {
+		case "auto":
+			claudeReq.ToolChoice = &ClaudeToolChoice{Type: "auto"}
+		case "required":
+			claudeReq.ToolChoice = &ClaudeToolChoice{Type: "any"}
+		case "none":
+			claudeReq.ToolChoice = &ClaudeToolChoice{Type: "none"}
+		}
+	case map[string]interface{}:
+		if function, ok := choice["function"].(map[string]interface{}); ok {
+			if name, ok := function["name"].(string); ok && name != "" {
+				claudeReq.ToolChoice = &ClaudeToolChoice{Type: "tool", Name: name}
+			}
+		}
+	}
+	if len(claudeReq.Tools) == 0 {
+		claudeReq.ToolChoice = nil
+	}
+
+	claudeBody, err := json.Marshal(claudeReq)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &openaiReq, claudeBody, nil
+}
+
+// extractOpenAIText 提取OpenAI消息中的文本内容（支持字符串和数组格式）
+func extractOpenAIText(content interface{}) string {
+	switch c := content.(type) {
+	case string:
+		return c
+	case []interface{}:
+		var textParts []string
+		for _, part := range c {
+			if partMap, ok := part.(map[string]interface{}); ok && partMap["type"] == "text" {
+				if text, ok := partMap["text"].(string); ok {
+					textParts = append(textParts, text)
+				}
+			}
+		}
+		return strings.Join(textParts, "\n")
+	default:
+		return ""
+	}
+}
+
+// convertOpenAIContentToClaude 将OpenAI user消息内容转换为Claude内容块
+func convertOpenAIContentToClaude(content interface{}) []interface{} {
+	var blocks []interface{}
+
+	switch c := content.(type) {
+	case string:
+		if c != "" {
+			blocks = append(blocks, map[string]interface{}{"type": "text", "text": c})
+		}
+	case []interface{}:
+		for _, part := range c {
+			partMap, ok := part.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			switch partMap["type"] {
+			case "text":
+				if text, ok := partMap["text"].(string); ok && text != "" {
+					blocks = append(blocks, map[string]interface{}{"type": "text", "text": text})
+				}
+			case "image_url":
+				imageURL, _ := partMap["image_url"].(map[string]interface{})
+				url, _ := imageURL["url"].(string)
+				if source := convertImageURLToClaudeSource(url); source != nil {
+					blocks = append(blocks, map[string]interface{}{"type": "image", "source": source})
+				}
+			}
+		}
+	}
+
+	return blocks
+}
+
+// convertImageURLToClaudeSource 将图片URL（data URI或http链接）转换为Claude图片source
+func convertImageURLToClaudeSource(url string) map[string]interface{} {
+	if url == "" {
+		return nil
+	}
+
+	// data:image/png;base64,xxxx
+	if strings.HasPrefix(url, "data:") {
+		header, data, found := strings.Cut(url[5:], ",")
+		if !found {
+			return nil
+		}
+		mediaType := strings.TrimSuffix(header, ";base64")
+		return map[string]interface{}{
+			"type":       "base64",
+			"media_type": mediaType,
+			"data":       data,
+		}
+	}
+
+	return map[string]interface{}{
+		"type": "url",
+		"url":  url,
+	}
+}
+
+// mapClaudeStopReasonToOpenAI 映射Claude停止原因为OpenAI格式
+func mapClaudeStopReasonToOpenAI(stopReason string) string {
+	switch stopReason {
+	case "max_tokens":
+		return "length"
+	case "tool_use":
+		return "tool_calls"
+	default:
+		return "stop"
+	}
+}
+
+// buildOpenAIUsage 根据Claude usage构建OpenAI usage，缓存token计入prompt_tokens
+func buildOpenAIUsage(inputTokens, outputTokens, cacheReadTokens, cacheCreationTokens int) map[string]interface{} {
+	promptTokens := inputTokens + cacheReadTokens + cacheCreationTokens
+	return map[string]interface{}{
+		"prompt_tokens":     promptTokens,
+		"completion_tokens": outputTokens,
+		"total_tokens":      promptTokens + outputTokens,
+		"prompt_tokens_details": map[string]interface{}{
+			"cached_tokens": cacheReadTokens,
+		},
+	}
+}
+
+// OpenAICompatWriter 将下游写入的Claude格式响应转换为OpenAI格式的ResponseWriter
+// 各平台处理函数仍按Claude格式输出并完成计费和日志，本写入器只负责格式转换
+type OpenAICompatWriter struct {
+	gin.ResponseWriter
+	model        string
+	includeUsage bool
+	created      int64
+
+	statusCode   int
+	isStreamMode bool
+	modeDecided  bool
+	headerSent   bool
+	pending      []byte        // 流式模式下未处理完的半行数据
+	buffer       *bytes.Buffer // 非流式及错误响应的缓存
+
+	// 流式状态
+	chunkID             string
+	toolIndexes         map[int]int
+	nextToolIndex       int
+	inputTokens         int
+	outputTokens        int
+	cacheReadTokens     int
+	cacheCreationTokens int
+	finished            bool
+}
+
+// NewOpenAICompatWriter 创建OpenAI兼容写入器
+func NewOpenAICompatWriter(writer gin.ResponseWriter, model string, includeUsage bool) *OpenAICompatWriter {
+	return &OpenAICompatWriter{
+		ResponseWriter: writer,
+		model:          model,
+		includeUsage:   includeUsage,
+		created:        time.Now().Unix(),
+		statusCode:     http.StatusOK,
+		buffer:         bytes.NewBuffer([]byte{}),
+		chunkID:        fmt.Sprintf("chatcmpl-%s", generateRandomID()),
+		toolIndexes:    make(map[int]int),
+	}
+}
+
+// WriteHeader 记录状态码并直接透传
+func (w *OpenAICompatWriter) WriteHeader(statusCode int) {
+	if w.headerSent {
+		return
+	}
+	w.statusCode = statusCode
+	w.headerSent = true
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+// WriteHeaderNow 确保状态码已写入
+func (w *OpenAICompatWriter) WriteHeaderNow() {
+	if !w.headerSent {
+		w.WriteHeader(w.statusCode)
+	}
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+// WriteString 写入字符串
+func (w *OpenAICompatWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Write 流式模式下逐行转换SSE事件，其他情况缓存等待Finish统一转换
+func (w *OpenAICompatWriter) Write(data []byte) (int, error) {
+	if !w.headerSent {
+		w.WriteHeader(http.StatusOK)
+	}
+
+	// 处理函数通常先写状态码再设置响应头，因此在首次写入时根据Content-Type判断模式
+	if !w.modeDecided {
+		w.isStreamMode = w.statusCode < 300 && strings.Contains(w.Header().Get("Content-Type"), "text/event-stream")
+		w.Header().Del("Content-Length")
+		w.modeDecided = true
+	}
+
+	if !w.isStreamMode {
+		return w.buffer.Write(data)
+	}
+
+	w.pending = append(w.pending, data...)
+	for {
+		idx := bytes.IndexByte(w.pending, '\n')
+		if idx < 0 {
+			break
+		}
+		line := strings.TrimSpace(string(w.pending[:idx]))
+		w.pending = w.pending[idx+1:]
+		if strings.HasPrefix(line, "data:") {
+			if err := w.processEvent(strings.TrimSpace(line[5:])); err != nil {
+				return len(data), err
+			}
+		}
+	}
+	return len(data), nil
+}
+
+// processEvent 将单个Claude SSE事件转换为OpenAI chunk
+func (w *OpenAICompatWriter) processEvent(data string) error {
+	event := gjson.Parse(data)
+
+	switch event.Get("type").String() {
+	case "message_start":
+		usage := event.Get("message.usage")
+		w.inputTokens = int(usage.Get("input_tokens").Int())
+		w.cacheReadTokens = int(usage.Get("cache_read_input_tokens").Int())
+		w.cacheCreationTokens = int(usage.Get("cache_creation_input_tokens").Int())
+		return w.sendChunk(map[string]interface{}{"role": "assistant", "content": ""}, nil, nil)
+
+	case "content_block_start":
+		block := event.Get("content_block")
+		if block.Get("type").String() != "tool_use" {
+			return nil
+		}
+		toolIndex := w.nextToolIndex
+		w.toolIndexes[int(event.Get("index").Int())] = toolIndex
+		w.nextToolIndex++
+		return w.sendChunk(map[string]interface{}{
+			"tool_calls": []interface{}{
+				map[string]interface{}{
+					"index": toolIndex,
+					"id":    block.Get("id").String(),
+					"type":  "function",
+					"function": map[string]interface{}{
+						"name":      block.Get("name").String(),
+						"arguments": "",
+					},
+				},
+			},
+		}, nil, nil)
+
+	case "content_block_delta":
+		delta := event.Get("delta")
+		switch delta.Get("type").String() {
+		case "text_delta":
+			return w.sendChunk(map[string]interface{}{"content": delta.Get("text").String()}, nil, nil)
+		case "input_json_delta":
+			toolIndex, ok := w.toolIndexes[int(event.Get("index").Int())]
+			if !ok {
+				return nil
+			}
+			return w.sendChunk(map[string]interface{}{
+				"tool_calls": []interface{}{
+					map[string]interface{}{
+						"index": toolIndex,
+						"function": map[string]interface{}{
+							"arguments": delta.Get("partial_json").String(),
+						},
+					},
+				},
+			}, nil, nil)
+		}
+
+	case "message_delta":
+		usage := event.Get("usage")
+		if v := usage.Get("output_tokens"); v.Exists() {
+			w.outputTokens = int(v.Int())
+		}
+		if v := usage.Get("input_tokens"); v.Int() > 0 {
+			w.inputTokens = int(v.Int())
+		}
+		if v := usage.Get("cache_read_input_tokens"); v.Int() > 0 {
+			w.cacheReadTokens = int(v.Int())
+		}
+		if v := usage.Get("cache_creation_input_tokens"); v.Int() > 0 {
+			w.cacheCreationTokens = int(v.Int())
+		}
+
+		finishReason := mapClaudeStopReasonToOpenAI(event.Get("delta.stop_reason").String())
+		var usageData map[string]interface{}
+		if !w.includeUsage {
+			usageData = w.usage()
+		}
+		return w.sendChunk(map[string]interface{}{}, &finishReason, usageData)
+
+	case "message_stop":
+		return w.sendDone()
+
+	case "error":
+		if err := w.writeData(string(convertClaudeErrorToOpenAI([]byte(event.Raw), http.StatusInternalServerError))); err != nil {
+			return err
+		}
+		return w.sendDone()
+	}
+
+	return nil
+}
+
+// usage 获取当前累计的OpenAI格式usage
+func (w *OpenAICompatWriter) usage() map[string]interface{} {
+	return buildOpenAIUsage(w.inputTokens, w.outputTokens, w.cacheReadTokens, w.cacheCreationTokens)
+}
+
+// sendChunk 发送单个chat.completion.chunk
+func (w *OpenAICompatWriter) sendChunk(delta map[string]interface{}, finishReason *string, usage map[string]interface{}) error {
+	chunk := map[string]interface{}{
+		"id":      w.chunkID,
+		"object":  "chat.completion.chunk",
+		"created": w.created,
+		"model":   w.model,
+		"choices": []interface{}{
+			map[string]interface{}{
+				"index":         0,
+				"delta":         delta,
+				"finish_reason": finishReason,
+			},
+		},
+	}
+	if usage != nil {
+		chunk["usage"] = usage
+	}
+
+	chunkBytes, _ := json.Marshal(chunk)
+	return w.writeData(string(chunkBytes))
+}
+
+// sendDone 发送结束标记，按需附带独立的usage chunk
+func (w *OpenAICompatWriter) sendDone() error {
+	if w.finished {
+		return nil
+	}
+	w.finished = true
+
+	if w.includeUsage {
+		chunkBytes, _ := json.Marshal(map[string]interface{}{
+			"id":      w.chunkID,
+			"object":  "chat.completion.chunk",
+			"created": w.created,
+			"model":   w.model,
+			"choices": []interface{}{},
+			"usage":   w.usage(),
+		})
+		if err := w.writeData(string(chunkBytes)); err != nil {
+			return err
+		}
+	}
+	return w.writeData("[DONE]")
+}
+
+// writeData 写入一条SSE data行并刷新
+func (w *OpenAICompatWriter) writeData(data string) error {
+	_, err := fmt.Fprintf(w.ResponseWriter, "data: %s\n\n", data)
+	if err == nil {
+		w.ResponseWriter.Flush()
+	}
+	return err
+}
+
+// Finish 在处理函数返回后调用：非流式成功响应转换为chat.completion，错误响应转换为OpenAI错误格式
+func (w *OpenAICompatWriter) Finish() {
+	if w.isStreamMode {
+		// 上游流异常中断时补发结束标记，避免客户端一直等待
+		if !w.finished {
+			_ = w.sendDone()
+		}
+		return
+	}
+
+	if w.buffer.Len() == 0 {
+		return
+	}
+
+	data := w.buffer.Bytes()
+	if w.statusCode >= 200 && w.statusCode < 300 {
+		if converted := convertClaudeResponseToOpenAI(data, w.model, w.created); converted != nil {
+			data = converted
+		}
+	} else {
+		data = convertClaudeErrorToOpenAI(data, w.statusCode)
+	}
+	_, _ = w.ResponseWriter.Write(data)
+}
+
+// convertClaudeErrorToOpenAI 将Claude格式的错误响应转换为OpenAI错误格式 {"error":{"message","type","code"}}
+// 原错误类型保留在code中，缺少错误类型时按状态码推断
+func convertClaudeErrorToOpenAI(responseBody []byte, statusCode int) []byte {
+	errorBody := gjson.ParseBytes(responseBody)
+	errorInfo := errorBody.Get("error")
+
+	message := errorInfo.Get("message").String()
+	if message == "" {
+		message = errorBody.Get("message").String()
+	}
+	if message == "" && errorInfo.Type == gjson.String {
+		message = errorInfo.String()
+	}
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+
+	claudeType := errorInfo.Get("type").String()
+	var code interface{} = claudeType
+	if value := errorInfo.Get("code"); value.Exists() {
+		code = value.Value()
+	} else if claudeType == "" {
+		code = nil
+	}
+
+	result, err := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": message,
+			"type":    mapClaudeErrorTypeToOpenAI(claudeType, statusCode),
+			"code":    code,
+		},
+	})
+	if err != nil {
+		return responseBody
+	}
+	return result
+}
+
+// mapClaudeErrorTypeToOpenAI Claude错误类型映射为OpenAI错误类型
+func mapClaudeErrorTypeToOpenAI(claudeType string, statusCode int) string {
+	switch claudeType {
+	case "invalid_request_error", "not_found_error", "request_too_large":
+		return "invalid_request_error"
+	case "authentication_error", "permission_error", "rate_limit_error":
+		return claudeType
+	case "":
+	default:
+		return "server_error"
+	}
+
+	switch {
+	case statusCode == http.StatusUnauthorized:
+		return "authentication_error"
+	case statusCode == http.StatusForbidden:
+		return "permission_error"
+	case statusCode == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case statusCode >= 400 && statusCode < 500:
+		return "invalid_request_error"
+	}
+	return "server_error"
+}
+
+// convertClaudeResponseToOpenAI 将Claude非流式响应转换为OpenAI chat.completion格式
+func convertClaudeResponseToOpenAI(responseBody []byte, model string, created int64) []byte {
+	claudeResp := gjson.ParseBytes(responseBody)
+	if claudeResp.Get("type").String() != "message" {
+		return nil
+	}
+
+	var textParts []string
+	var toolCalls []OpenAIToolCall
+	for _, block := range claudeResp.Get("content").Array() {
+		switch block.Get("type").String() {
+		case "text":
+			textParts = append(textParts, block.Get("text").String())
+		case "tool_use":
+			arguments := block.Get("input").Raw
+			if arguments == "" {
+				arguments = "{}"
+			}
+			toolCalls = append(toolCalls, OpenAIToolCall{
+				ID:   block.Get("id").String(),
+				Type: "function",
+				Function: OpenAIFunctionCall{
+					Name:      block.Get("name").String(),
+					Arguments: arguments,
+				},
+			})
+		}
+	}
+
+	message := map[string]interface{}{
+		"role":    "assistant",
+		"content": strings.Join(textParts, ""),
+	}
+	if len(toolCalls) > 0 {
+		message["tool_calls"] = toolCalls
+	}
+
+	usage := claudeResp.Get("usage")
+	openaiResp := map[string]interface{}{
+		"id":      fmt.Sprintf("chatcmpl-%s", strings.TrimPrefix(claudeResp.Get("id").String(), "msg_")),
+		"object":  "chat.completion",
+		"created": created,
+		"model":   model,
+		"choices": []interface{}{
+			map[string]interface{}{
+				"index":         0,
+				"message":       message,
+				"finish_reason": mapClaudeStopReasonToOpenAI(claudeResp.Get("stop_reason").String()),
+			},
+		},
+		"usage": buildOpenAIUsage(
+			int(usage.Get("input_tokens").Int()),
+			int(usage.Get("output_tokens").Int()),
+			int(usage.Get("cache_read_input_tokens").Int()),
+			int(usage.Get("cache_creation_input_tokens").Int()),
+		),
+	}
+
+	result, err := json.Marshal(openaiResp)
+	if err != nil {
+		return nil
+	}
+	return result
+}
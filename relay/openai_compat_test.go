@@ -0,0 +1,257 @@
+package relay
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// TestConvertOpenAIRequestToClaude 入站OpenAI请求转换为Claude请求
+func TestConvertOpenAIRequestToClaude(t *testing.T) {
+	cases := []struct {
+		name  string
+		body  string
+		check func(t *testing.T, claudeBody gjson.Result)
+	}{
+		{
+			name: "system合并、max_tokens默认值和停止序列",
+			body: `{"model":"claude-sonnet-4-20250514","stop":"END","user":"u1","messages":[
+				{"role":"system","content":"规则一"},
+				{"role":"developer","content":[{"type":"text","text":"规则二"}]},
+				{"role":"user","content":"你好"}]}`,
+			check: func(t *testing.T, body gjson.Result) {
+				if body.Get("system").String() != "规则一\n\n规则二" {
+					t.Errorf("system不正确: %s", body.Get("system").Raw)
+				}
+				if body.Get("max_tokens").Int() != defaultCompatMaxTokens {
+					t.Errorf("max_tokens默认值不正确: %d", body.Get("max_tokens").Int())
+				}
+				if body.Get("stop_sequences.0").String() != "END" || body.Get("metadata.user_id").String() != "u1" {
+					t.Errorf("stop_sequences或metadata不正确: %s", body.Raw)
+				}
+				if body.Get("messages.#").Int() != 1 || body.Get("messages.0.content.0.text").String() != "你好" {
+					t.Errorf("messages不正确: %s", body.Get("messages").Raw)
+				}
+			},
+		},
+		{
+			name: "max_completion_tokens优先于max_tokens",
+			body: `{"model":"m","max_tokens":100,"max_completion_tokens":200,"messages":[{"role":"user","content":"hi"}]}`,
+			check: func(t *testing.T, body gjson.Result) {
+				if body.Get("max_tokens").Int() != 200 {
+					t.Errorf("max_tokens不正确: %d", body.Get("max_tokens").Int())
+				}
+			},
+		},
+		{
+			name: "图片data URI转换为base64图片",
+			body: `{"model":"m","messages":[{"role":"user","content":[
+				{"type":"text","text":"看图"},
+				{"type":"image_url","image_url":{"url":"data:image/png;base64,iVBORw0"}},
+				{"type":"image_url","image_url":{"url":"https://example.com/a.png"}}]}]}`,
+			check: func(t *testing.T, body gjson.Result) {
+				content := body.Get("messages.0.content")
+				if content.Get("1.source.type").String() != "base64" || content.Get("1.source.media_type").String() != "image/png" || content.Get("1.source.data").String() != "iVBORw0" {
+					t.Errorf("data URI图片不正确: %s", content.Raw)
+				}
+				if content.Get("2.source.type").String() != "url" || content.Get("2.source.url").String() != "https://example.com/a.png" {
+					t.Errorf("URL图片不正确: %s", content.Raw)
+				}
+			},
+		},
+		{
+			name: "工具调用和多个工具结果合并为一条user消息",
+			body: `{"model":"m","messages":[
+				{"role":"user","content":"查天气"},
+				{"role":"assistant","content":null,"tool_calls":[
+					{"id":"call_1","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"北京\"}"}},
+					{"id":"call_2","type":"function","function":{"name":"get_weather","arguments":"{\"city\":\"上海\"}"}}]},
+				{"role":"tool","tool_call_id":"call_1","content":"晴"},
+				{"role":"tool","tool_call_id":"call_2","content":"雨"}]}`,
+			check: func(t *testing.T, body gjson.Result) {
+				messages := body.Get("messages")
+				if messages.Get("#").Int() != 3 {
+					t.Fatalf("消息数量不正确: %s", messages.Raw)
+				}
+				if messages.Get("1.content.0.type").String() != "tool_use" || messages.Get("1.content.1.input.city").String() != "上海" {
+					t.Errorf("tool_use不正确: %s", messages.Get("1").Raw)
+				}
+				results := messages.Get("2.content")
+				if messages.Get("2.role").String() != "user" || results.Get("#").Int() != 2 || results.Get("1.tool_use_id").String() != "call_2" || results.Get("1.content").String() != "雨" {
+					t.Errorf("tool_result不正确: %s", messages.Get("2").Raw)
+				}
+			},
+		},
+		{
+			name: "工具定义和工具选择",
+			body: `{"model":"m","messages":[{"role":"user","content":"hi"}],
+				"tools":[{"type":"function","function":{"name":"get_weather","description":"查询天气"}}],
+				"tool_choice":{"type":"function","function":{"name":"get_weather"}}}`,
+			check: func(t *testing.T, body gjson.Result) {
+				if body.Get("tools.0.name").String() != "get_weather" || body.Get("tools.0.input_schema.type").String() != "object" {
+					t.Errorf("tools不正确: %s", body.Get("tools").Raw)
+				}
+				if body.Get("tool_choice.type").String() != "tool" || body.Get("tool_choice.name").String() != "get_weather" {
+					t.Errorf("tool_choice不正确: %s", body.Get("tool_choice").Raw)
+				}
+			},
+		},
+		{
+			name: "没有工具时忽略工具选择",
+			body: `{"model":"m","tool_choice":"required","messages":[{"role":"user","content":"hi"}]}`,
+			check: func(t *testing.T, body gjson.Result) {
+				if body.Get("tool_choice").Exists() {
+					t.Errorf("没有工具时不应设置tool_choice: %s", body.Raw)
+				}
+			},
+		},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, claudeBody, err := ConvertOpenAIRequestToClaude([]byte(tc.body))
+			if err != nil {
+				t.Fatalf("转换失败: %v", err)
+			}
+			tc.check(t, gjson.ParseBytes(claudeBody))
+		})
+	}
+}
+
+// TestConvertOpenAIRequestToClaudeInvalid 缺少model或messages时返回错误
+func TestConvertOpenAIRequestToClaudeInvalid(t *testing.T) {
+	for _, body := range []string{
+		`{"messages":[{"role":"user","content":"hi"}]}`,
+		`{"model":"m","messages":[]}`,
+		`not json`,
+	} {
+		if _, _, err := ConvertOpenAIRequestToClaude([]byte(body)); err == nil {
+			t.Errorf("请求应转换失败: %s", body)
+		}
+	}
+}
+
+// TestConvertClaudeResponseToOpenAI Claude非流式响应转换为chat.completion
+func TestConvertClaudeResponseToOpenAI(t *testing.T) {
+	body := []byte(`{"id":"msg_123","type":"message","role":"assistant","content":[
+		{"type":"text","text":"我来查询"},
+		{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{"city":"北京"}}],
+		"stop_reason":"tool_use","usage":{"input_tokens":10,"output_tokens":5,"cache_read_input_tokens":20,"cache_creation_input_tokens":3}}`)
+
+	result := gjson.ParseBytes(convertClaudeResponseToOpenAI(body, "gpt-alias", 1700000000))
+	if result.Get("id").String() != "chatcmpl-123" || result.Get("object").String() != "chat.completion" || result.Get("model").String() != "gpt-alias" {
+		t.Errorf("响应基础字段不正确: %s", result.Raw)
+	}
+	choice := result.Get("choices.0")
+	if choice.Get("finish_reason").String() != "tool_calls" || choice.Get("message.content").String() != "我来查询" {
+		t.Errorf("choice不正确: %s", choice.Raw)
+	}
+	if choice.Get("message.tool_calls.0.function.arguments").String() != `{"city":"北京"}` {
+		t.Errorf("tool_calls不正确: %s", choice.Get("message.tool_calls").Raw)
+	}
+	usage := result.Get("usage")
+	if usage.Get("prompt_tokens").Int() != 33 || usage.Get("total_tokens").Int() != 38 || usage.Get("prompt_tokens_details.cached_tokens").Int() != 20 {
+		t.Errorf("usage不正确: %s", usage.Raw)
+	}
+
+	if convertClaudeResponseToOpenAI([]byte(`{"type":"error","error":{"type":"api_error"}}`), "m", 0) != nil {
+		t.Error("非message响应不应转换")
+	}
+}
+
+// claudeTestStream 包含文本和工具调用的Claude SSE流
+var claudeTestStream = []string{
+	`event: message_start` + "\n" + `data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"usage":{"input_tokens":10,"cache_read_input_tokens":4,"output_tokens":1}}}`,
+	`event: content_block_start` + "\n" + `data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+	`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}`,
+	`event: content_block_stop` + "\n" + `data: {"type":"content_block_stop","index":0}`,
+	`event: content_block_start` + "\n" + `data: {"type":"content_block_start","index":1,"content_block":{"type":"tool_use","id":"toolu_1","name":"get_weather","input":{}}}`,
+	`event: content_block_delta` + "\n" + `data: {"type":"content_block_delta","index":1,"delta":{"type":"input_json_delta","partial_json":"{\"city\":\"北京\"}"}}`,
+	`event: content_block_stop` + "\n" + `data: {"type":"content_block_stop","index":1}`,
+	`event: message_delta` + "\n" + `data: {"type":"message_delta","delta":{"stop_reason":"tool_use"},"usage":{"output_tokens":7}}`,
+	`event: message_stop` + "\n" + `data: {"type":"message_stop"}`,
+}
+
+// TestOpenAICompatWriterStream Claude SSE流转换为OpenAI chunk，跨写入拆分的行也能正确处理
+func TestOpenAICompatWriterStream(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	writer := NewOpenAICompatWriter(c.Writer, "gpt-alias", true)
+	writer.Header().Set("Content-Type", "text/event-stream")
+	writer.WriteHeader(http.StatusOK)
+
+	stream := strings.Join(claudeTestStream, "\n\n") + "\n\n"
+	half := len(stream) / 2
+	writer.Write([]byte(stream[:half]))
+	writer.Write([]byte(stream[half:]))
+	writer.Finish()
+
+	var chunks []gjson.Result
+	for _, line := range strings.Split(recorder.Body.String(), "\n") {
+		if strings.HasPrefix(line, "data: {") {
+			chunks = append(chunks, gjson.Parse(strings.TrimPrefix(line, "data: ")))
+		}
+	}
+	if len(chunks) != 6 {
+		t.Fatalf("chunk数量不正确: %s", recorder.Body.String())
+	}
+	if chunks[0].Get("choices.0.delta.role").String() != "assistant" || chunks[1].Get("choices.0.delta.content").String() != "Hello" {
+		t.Errorf("角色或文本chunk不正确: %s", recorder.Body.String())
+	}
+	if chunks[2].Get("choices.0.delta.tool_calls.0.function.name").String() != "get_weather" ||
+		chunks[3].Get("choices.0.delta.tool_calls.0.function.arguments").String() != `{"city":"北京"}` {
+		t.Errorf("工具调用chunk不正确: %s", recorder.Body.String())
+	}
+	if chunks[4].Get("choices.0.finish_reason").String() != "tool_calls" || chunks[4].Get("usage").Exists() {
+		t.Errorf("结束chunk不正确: %s", chunks[4].Raw)
+	}
+	if chunks[5].Get("choices.#").Int() != 0 || chunks[5].Get("usage.prompt_tokens").Int() != 14 || chunks[5].Get("usage.completion_tokens").Int() != 7 {
+		t.Errorf("include_usage时应单独发送usage chunk: %s", chunks[5].Raw)
+	}
+	if strings.Count(recorder.Body.String(), "data: [DONE]") != 1 {
+		t.Errorf("[DONE] 应只发送一次: %s", recorder.Body.String())
+	}
+}
+
+// TestOpenAICompatWriterConvertsErrors 错误响应保留状态码并转换为OpenAI错误格式
+func TestOpenAICompatWriterConvertsErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	cases := []struct {
+		name         string
+		statusCode   int
+		body         string
+		expectedType string
+		expectedCode interface{}
+	}{
+		{"上游请求错误", http.StatusBadRequest, `{"type":"error","error":{"type":"invalid_request_error","message":"bad"}}`, "invalid_request_error", "invalid_request_error"},
+		{"所有账号失败", http.StatusServiceUnavailable, `{"error":{"type":"service_unavailable","message":"服务暂时不可用"}}`, "server_error", "service_unavailable"},
+		{"缺少错误类型按状态码推断", http.StatusTooManyRequests, `{"message":"请求过多"}`, "rate_limit_error", nil},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(recorder)
+
+			writer := NewOpenAICompatWriter(c.Writer, "gpt-alias", false)
+			writer.Header().Set("Content-Type", "application/json")
+			writer.WriteHeader(tc.statusCode)
+			writer.Write([]byte(tc.body))
+			writer.Finish()
+
+			errorInfo := gjson.Get(recorder.Body.String(), "error")
+			if recorder.Code != tc.statusCode || errorInfo.Get("type").String() != tc.expectedType || errorInfo.Get("code").Value() != tc.expectedCode {
+				t.Errorf("错误响应转换不正确: %d %s", recorder.Code, recorder.Body.String())
+			}
+			if errorInfo.Get("message").String() != gjson.Get(tc.body, "error.message").String()+gjson.Get(tc.body, "message").String() {
+				t.Errorf("错误信息不正确: %s", recorder.Body.String())
+			}
+		})
+	}
+}
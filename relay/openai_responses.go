@@ -0,0 +1,735 @@
+package relay
+
+import (
+	"bufio"
+	"claude-code-relay/common"
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AzureOpenAIResponsesAPIVersion Azure OpenAI Responses API 默认版本
+const AzureOpenAIResponsesAPIVersion = "2025-04-01-preview"
+
+// OpenAI Responses API 类型定义
+type ResponsesRequest struct {
+	Model           string              `json:"model"`
+	Input           []interface{}       `json:"input"`
+	Instructions    string              `json:"instructions,omitempty"`
+	MaxOutputTokens *int                `json:"max_output_tokens,omitempty"`
+	Temperature     *float64            `json:"temperature,omitempty"`
+	TopP            *float64            `json:"top_p,omitempty"`
+	Stream          bool                `json:"stream,omitempty"`
+	Tools           []ResponsesTool     `json:"tools,omitempty"`
+	ToolChoice      interface{}         `json:"tool_choice,omitempty"`
+	Reasoning       *ResponsesReasoning `json:"reasoning,omitempty"`
+	Store           bool                `json:"store"`
+}
+
+type ResponsesTool struct {
+	Type        string      `json:"type"`
+	Name        string      `json:"name"`
+	Description string      `json:"description,omitempty"`
+	Parameters  interface{} `json:"parameters"`
+}
+
+type ResponsesReasoning struct {
+	Effort  string `json:"effort,omitempty"`
+	Summary string `json:"summary,omitempty"`
+}
+
+type ResponsesResponse struct {
+	ID                string                `json:"id"`
+	Model             string                `json:"model"`
+	Status            string                `json:"status"`
+	Output            []ResponsesOutputItem `json:"output"`
+	Usage             *ResponsesUsage       `json:"usage"`
+	IncompleteDetails *struct {
+		Reason string `json:"reason"`
+	} `json:"incomplete_details"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type ResponsesOutputItem struct {
+	Type      string                 `json:"type"`
+	ID        string                 `json:"id"`
+	Role      string                 `json:"role,omitempty"`
+	Content   []ResponsesContentPart `json:"content,omitempty"`
+	Summary   []ResponsesContentPart `json:"summary,omitempty"`
+	CallID    string                 `json:"call_id,omitempty"`
+	Name      string                 `json:"name,omitempty"`
+	Arguments string                 `json:"arguments,omitempty"`
+}
+
+type ResponsesContentPart struct {
+	Type    string `json:"type"`
+	Text    string `json:"text"`
+	Refusal string `json:"refusal,omitempty"`
+}
+
+type ResponsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	OutputTokens       int `json:"output_tokens"`
+	InputTokensDetails struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details"`
+	OutputTokensDetails struct {
+		ReasoningTokens int `json:"reasoning_tokens"`
+	} `json:"output_tokens_details"`
+}
+
+// buildOpenAIResponsesURL 构建Responses API请求地址
+// Azure OpenAI 的Responses API不使用部署级地址，部署名称通过请求体的model字段传递
+func buildOpenAIResponsesURL(account *model.Account) string {
+	baseURL := strings.TrimRight(account.RequestURL, "/")
+	if account.PlatformType != constant.PlatformAzureOpenAI {
+		return baseURL + "/responses"
+	}
+
+	apiVersion := account.ApiVersion
+	if apiVersion == "" {
+		apiVersion = AzureOpenAIResponsesAPIVersion
+	}
+	return fmt.Sprintf("%s/openai/responses?api-version=%s", baseURL, url.QueryEscape(apiVersion))
+}
+
+// isOpenAIReasoningModel 判断是否为支持reasoning参数的OpenAI推理模型
+func isOpenAIReasoningModel(modelName string) bool {
+	name := strings.ToLower(modelName)
+	for _, prefix := range []string{"o1", "o3", "o4", "gpt-5", "codex"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// convertClaudeToResponses 将Claude请求转换为Responses API格式
+func convertClaudeToResponses(claudeReq ClaudeRequest, modelName string) ResponsesRequest {
+	var input []interface{}
+
+	for _, message := range claudeReq.Messages {
+		contentBlocks, ok := message.Content.([]interface{})
+		if !ok {
+			// 简单文本消息
+			input = append(input, map[string]interface{}{
+				"role":    message.Role,
+				"content": message.Content,
+			})
+			continue
+		}
+
+		// 按原始顺序转换，文本和图片合并为一条消息，工具调用及结果作为独立的输入项
+		var parts []map[string]interface{}
+		flushParts := func() {
+			if len(parts) == 0 {
+				return
+			}
+			input = append(input, map[string]interface{}{
+				"role":    message.Role,
+				"content": parts,
+			})
+			parts = nil
+		}
+
+		for _, block := range contentBlocks {
+			blockMap, ok := block.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			switch blockMap["type"] {
+			case "text":
+				text, _ := blockMap["text"].(string)
+				partType := "input_text"
+				if message.Role == "assistant" {
+					partType = "output_text"
+				}
+				parts = append(parts, map[string]interface{}{
+					"type": partType,
+					"text": text,
+				})
+			case "image":
+				if source, ok := blockMap["source"].(map[string]interface{}); ok {
+					parts = append(parts, map[string]interface{}{
+						"type":      "input_image",
+						"image_url": fmt.Sprintf("data:%s;base64,%s", source["media_type"], source["data"]),
+					})
+				}
+			case "tool_use":
+				flushParts()
+				arguments := "{}"
+				if blockMap["input"] != nil {
+					argBytes, _ := json.Marshal(blockMap["input"])
+					arguments = string(argBytes)
+				}
+				input = append(input, map[string]interface{}{
+					"type":      "function_call",
+					"call_id":   blockMap["id"],
+					"name":      blockMap["name"],
+					"arguments": arguments,
+				})
+			case "tool_result":
+				flushParts()
+				var output string
+				if str, ok := blockMap["content"].(string); ok {
+					output = str
+				} else if blockMap["content"] != nil {
+					contentBytes, _ := json.Marshal(blockMap["content"])
+					output = string(contentBytes)
+				}
+				input = append(input, map[string]interface{}{
+					"type":    "function_call_output",
+					"call_id": blockMap["tool_use_id"],
+					"output":  output,
+				})
+			}
+		}
+		flushParts()
+	}
+
+	responsesReq := ResponsesRequest{
+		Model:        modelName,
+		Input:        input,
+		Instructions: extractSystemMessage(claudeReq.System),
+		Temperature:  claudeReq.Temperature,
+		TopP:         claudeReq.TopP,
+		Stream:       claudeReq.Stream,
+	}
+	if claudeReq.MaxTokens > 0 {
+		maxTokens := claudeReq.MaxTokens
+		responsesReq.MaxOutputTokens = &maxTokens
+	}
+
+	// 推理模型请求返回推理摘要，用于转换为thinking内容块；thinking预算映射为推理强度
+	// 非推理模型不支持reasoning参数，即使开启thinking也不下发
+	if isOpenAIReasoningModel(modelName) {
+		responsesReq.Reasoning = &ResponsesReasoning{
+			Effort:  thinkingToReasoningEffort(claudeReq.Thinking),
+			Summary: "auto",
+		}
+		responsesReq.Temperature = nil
+		responsesReq.TopP = nil
+	}
+
+	// 转换工具
+	for _, tool := range claudeReq.Tools {
+		responsesReq.Tools = append(responsesReq.Tools, ResponsesTool{
+			Type:        "function",
+			Name:        tool.Name,
+			Description: tool.Description,
+			Parameters:  recursivelyCleanSchema(tool.InputSchema),
+		})
+	}
+
+	// 转换工具选择
+	if claudeReq.ToolChoice != nil {
+		switch claudeReq.ToolChoice.Type {
+		case "auto":
+			responsesReq.ToolChoice = "auto"
+		case "any":
+			responsesReq.ToolChoice = "required"
+		case "tool":
+			responsesReq.ToolChoice = map[string]interface{}{
+				"type": "function",
+				"name": claudeReq.ToolChoice.Name,
+			}
+		}
+	}
+
+	return responsesReq
+}
+
+// convertResponsesUsage 转换Responses API的usage，缓存命中的token计入cache_read_input_tokens
+func convertResponsesUsage(usage *ResponsesUsage, modelName string) *common.TokenUsage {
+	tokenUsage := &common.TokenUsage{Model: modelName}
+	if usage == nil {
+		return tokenUsage
+	}
+
+	cachedTokens := usage.InputTokensDetails.CachedTokens
+	tokenUsage.InputTokens = usage.InputTokens - cachedTokens
+	if tokenUsage.InputTokens < 0 {
+		tokenUsage.InputTokens = 0
+	}
+	tokenUsage.CacheReadInputTokens = cachedTokens
+	tokenUsage.OutputTokens = usage.OutputTokens
+	return tokenUsage
+}
+
+// mapResponsesStopReason 根据Responses API的状态映射Claude停止原因
+func mapResponsesStopReason(status, incompleteReason string, hasToolUse bool) string {
+	if status == "incomplete" {
+		switch incompleteReason {
+		case "max_output_tokens":
+			return "max_tokens"
+		case "content_filter":
+			return "refusal"
+		}
+	}
+	if hasToolUse {
+		return "tool_use"
+	}
+	return "end_turn"
+}
+
+// convertResponsesToClaude 将Responses API非流式响应转换为Claude格式
+func convertResponsesToClaude(responseBody []byte, modelName string) (map[string]interface{}, *common.TokenUsage) {
+	var responsesResp ResponsesResponse
+	if err := json.Unmarshal(responseBody, &responsesResp); err != nil || responsesResp.Output == nil {
+		return nil, nil
+	}
+
+	contentBlocks := make([]map[string]interface{}, 0, len(responsesResp.Output))
+	hasToolUse := false
+	for _, item := range responsesResp.Output {
+		switch item.Type {
+		case "reasoning":
+			var summaries []string
+			for _, summary := range item.Summary {
+				summaries = append(summaries, summary.Text)
+			}
+			if len(summaries) > 0 {
+				contentBlocks = append(contentBlocks, map[string]interface{}{
+					"type":      "thinking",
+					"thinking":  strings.Join(summaries, "\n\n"),
+					"signature": "",
+				})
+			}
+		case "message":
+			for _, part := range item.Content {
+				text := part.Text
+				if part.Type == "refusal" {
+					text = part.Refusal
+				}
+				if text != "" {
+					contentBlocks = append(contentBlocks, map[string]interface{}{
+						"type": "text",
+						"text": text,
+					})
+				}
+			}
+		case "function_call":
+			hasToolUse = true
+			var input interface{}
+			if err := json.Unmarshal([]byte(item.Arguments), &input); err != nil || input == nil {
+				input = map[string]interface{}{}
+			}
+			contentBlocks = append(contentBlocks, map[string]interface{}{
+				"type":  "tool_use",
+				"id":    item.CallID,
+				"name":  item.Name,
+				"input": input,
+			})
+		}
+	}
+
+	incompleteReason := ""
+	if responsesResp.IncompleteDetails != nil {
+		incompleteReason = responsesResp.IncompleteDetails.Reason
+	}
+
+	usageTokens := convertResponsesUsage(responsesResp.Usage, modelName)
+	claudeResponse := map[string]interface{}{
+		"id":            fmt.Sprintf("msg_%s", generateRandomID()),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         modelName,
+		"content":       contentBlocks,
+		"stop_reason":   mapResponsesStopReason(responsesResp.Status, incompleteReason, hasToolUse),
+		"stop_sequence": nil,
+		"usage": map[string]interface{}{
+			"input_tokens":            usageTokens.InputTokens,
+			"output_tokens":           usageTokens.OutputTokens,
+			"cache_read_input_tokens": usageTokens.CacheReadInputTokens,
+		},
+	}
+
+	return claudeResponse, usageTokens
+}
+
+// ResponsesStreamTransformer 将Responses API的流式事件转换为Claude SSE事件
+type ResponsesStreamTransformer struct {
+	messageID   string
+	model       string
+	started     bool
+	finished    bool
+	blockIndex  int    // 下一个内容块的索引
+	openItemID  string // 当前打开的内容块对应的输出项ID
+	openBlock   bool
+	hasToolUse  bool
+	toolIndexes map[string]int // 输出项ID -> 工具调用内容块索引
+	usage       *common.TokenUsage
+	errorType   string // 上游返回失败事件时转换后的Claude错误类型，为空表示未失败
+}
+
+// newResponsesStreamTransformer 创建Responses API流式转换器
+func newResponsesStreamTransformer(modelName string) *ResponsesStreamTransformer {
+	return &ResponsesStreamTransformer{
+		messageID:   fmt.Sprintf("msg_%s", generateRandomID()),
+		model:       modelName,
+		toolIndexes: make(map[string]int),
+		usage:       &common.TokenUsage{Model: modelName},
+	}
+}
+
+// sendEvent 发送SSE事件
+func (st *ResponsesStreamTransformer) sendEvent(writer gin.ResponseWriter, eventType string, data interface{}) {
+	jsonData, _ := json.Marshal(data)
+	fmt.Fprintf(writer, "event: %s\ndata: %s\n\n", eventType, jsonData)
+	writer.Flush()
+}
+
+// ensureStarted 发送message_start事件
+func (st *ResponsesStreamTransformer) ensureStarted(writer gin.ResponseWriter) {
+	if st.started {
+		return
+	}
+	st.started = true
+	st.sendEvent(writer, "message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            st.messageID,
+			"type":          "message",
+			"role":          "assistant",
+			"model":         st.model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage": map[string]int{
+				"input_tokens":  0,
+				"output_tokens": 0,
+			},
+		},
+	})
+}
+
+// closeBlock 关闭当前打开的内容块
+func (st *ResponsesStreamTransformer) closeBlock(writer gin.ResponseWriter) {
+	if !st.openBlock {
+		return
+	}
+	st.sendEvent(writer, "content_block_stop", map[string]interface{}{
+		"type":  "content_block_stop",
+		"index": st.blockIndex - 1,
+	})
+	st.openBlock = false
+	st.openItemID = ""
+}
+
+// openBlockFor 为输出项打开新的内容块，同一输出项的增量复用已打开的内容块
+func (st *ResponsesStreamTransformer) openBlockFor(writer gin.ResponseWriter, itemID string, contentBlock map[string]interface{}) int {
+	if st.openBlock && st.openItemID == itemID {
+		return st.blockIndex - 1
+	}
+	st.closeBlock(writer)
+
+	index := st.blockIndex
+	st.sendEvent(writer, "content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         index,
+		"content_block": contentBlock,
+	})
+	st.blockIndex++
+	st.openBlock = true
+	st.openItemID = itemID
+	return index
+}
+
+// processEvent 处理单个Responses API流式事件
+func (st *ResponsesStreamTransformer) processEvent(writer gin.ResponseWriter, event map[string]interface{}) {
+	eventType, _ := event["type"].(string)
+	itemID, _ := event["item_id"].(string)
+
+	switch eventType {
+	case "response.created", "response.in_progress":
+		st.ensureStarted(writer)
+
+	case "response.output_item.added":
+		item, _ := event["item"].(map[string]interface{})
+		if item["type"] != "function_call" {
+			return
+		}
+		st.ensureStarted(writer)
+		id, _ := item["id"].(string)
+		st.hasToolUse = true
+		st.toolIndexes[id] = st.openBlockFor(writer, id, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    item["call_id"],
+			"name":  item["name"],
+			"input": map[string]interface{}{},
+		})
+
+	case "response.output_text.delta", "response.refusal.delta":
+		delta, _ := event["delta"].(string)
+		if delta == "" {
+			return
+		}
+		st.ensureStarted(writer)
+		index := st.openBlockFor(writer, itemID, map[string]interface{}{
+			"type": "text",
+			"text": "",
+		})
+		st.sendEvent(writer, "content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": map[string]interface{}{
+				"type": "text_delta",
+				"text": delta,
+			},
+		})
+
+	case "response.reasoning_summary_text.delta":
+		delta, _ := event["delta"].(string)
+		if delta == "" {
+			return
+		}
+		st.ensureStarted(writer)
+		index := st.openBlockFor(writer, itemID, map[string]interface{}{
+			"type":      "thinking",
+			"thinking":  "",
+			"signature": "",
+		})
+		st.sendEvent(writer, "content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": map[string]interface{}{
+				"type":     "thinking_delta",
+				"thinking": delta,
+			},
+		})
+
+	case "response.reasoning_summary_part.done":
+		// 多段推理摘要之间用空行分隔
+		if st.openBlock && st.openItemID == itemID {
+			st.sendEvent(writer, "content_block_delta", map[string]interface{}{
+				"type":  "content_block_delta",
+				"index": st.blockIndex - 1,
+				"delta": map[string]interface{}{
+					"type":     "thinking_delta",
+					"thinking": "\n\n",
+				},
+			})
+		}
+
+	case "response.function_call_arguments.delta":
+		delta, _ := event["delta"].(string)
+		index, ok := st.toolIndexes[itemID]
+		if !ok || delta == "" {
+			return
+		}
+		st.sendEvent(writer, "content_block_delta", map[string]interface{}{
+			"type":  "content_block_delta",
+			"index": index,
+			"delta": map[string]interface{}{
+				"type":         "input_json_delta",
+				"partial_json": delta,
+			},
+		})
+
+	case "response.output_item.done":
+		item, _ := event["item"].(map[string]interface{})
+		if id, _ := item["id"].(string); st.openBlock && st.openItemID == id {
+			st.closeBlock(writer)
+		}
+
+	case "response.completed", "response.incomplete":
+		var completed struct {
+			Response ResponsesResponse `json:"response"`
+		}
+		raw, _ := json.Marshal(event)
+		_ = json.Unmarshal(raw, &completed)
+
+		incompleteReason := ""
+		if completed.Response.IncompleteDetails != nil {
+			incompleteReason = completed.Response.IncompleteDetails.Reason
+		}
+		st.usage = convertResponsesUsage(completed.Response.Usage, st.model)
+		st.sendFinalEvents(writer, mapResponsesStopReason(completed.Response.Status, incompleteReason, st.hasToolUse))
+
+	case "response.failed", "error":
+		message := "upstream response failed"
+		code, _ := event["code"].(string)
+		if errData, ok := event["error"].(map[string]interface{}); ok {
+			if msg, ok := errData["message"].(string); ok {
+				message = msg
+			}
+			code, _ = errData["code"].(string)
+		} else if response, ok := event["response"].(map[string]interface{}); ok {
+			if errData, ok := response["error"].(map[string]interface{}); ok {
+				if msg, ok := errData["message"].(string); ok {
+					message = msg
+				}
+				code, _ = errData["code"].(string)
+			}
+		} else if msg, ok := event["message"].(string); ok {
+			message = msg
+		}
+		st.finished = true
+		st.errorType = mapResponsesErrorType(code)
+		st.sendEvent(writer, "error", map[string]interface{}{
+			"type": "error",
+			"error": map[string]interface{}{
+				"type":    st.errorType,
+				"message": message,
+			},
+		})
+	}
+}
+
+// mapResponsesErrorType 将Responses API的错误码映射为Claude错误类型
+func mapResponsesErrorType(code string) string {
+	switch code {
+	case "rate_limit_exceeded":
+		return "rate_limit_error"
+	case "server_is_overloaded", "slow_down":
+		return "overloaded_error"
+	default:
+		return "api_error"
+	}
+}
+
+// sendFinalEvents 发送结束事件，usage在message_delta中返回
+func (st *ResponsesStreamTransformer) sendFinalEvents(writer gin.ResponseWriter, stopReason string) {
+	if st.finished {
+		return
+	}
+	st.finished = true
+	st.ensureStarted(writer)
+	st.closeBlock(writer)
+
+	st.sendEvent(writer, "message_delta", map[string]interface{}{
+		"type": "message_delta",
+		"delta": map[string]interface{}{
+			"stop_reason":   stopReason,
+			"stop_sequence": nil,
+		},
+		"usage": map[string]int{
+			"input_tokens":            st.usage.InputTokens,
+			"output_tokens":           st.usage.OutputTokens,
+			"cache_read_input_tokens": st.usage.CacheReadInputTokens,
+		},
+	})
+	st.sendEvent(writer, "message_stop", map[string]interface{}{
+		"type": "message_stop",
+	})
+}
+
+// processResponsesStream 读取Responses API流式响应并转换为Claude SSE
+func processResponsesStream(writer gin.ResponseWriter, reader io.Reader, transformer *ResponsesStreamTransformer) *common.TokenUsage {
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "" || data == "[DONE]" {
+			continue
+		}
+
+		var event map[string]interface{}
+		if err := json.Unmarshal([]byte(data), &event); err != nil {
+			continue // 忽略解析错误的事件
+		}
+		transformer.processEvent(writer, event)
+	}
+
+	// 上游异常断开时补发结束事件，保证客户端收到完整的消息
+	if !transformer.finished && transformer.started {
+		transformer.sendFinalEvents(writer, mapResponsesStopReason("", "", transformer.hasToolUse))
+	}
+
+	return transformer.usage
+}
+
+// handleResponsesAPIResponse 处理Responses API的流式和非流式响应
+func handleResponsesAPIResponse(c *gin.Context, resp *http.Response, modelName string, account *model.Account, apiKey *model.ApiKey, startTime time.Time) {
+	isStream := isOpenAIStreamResponse(resp)
+
+	var usageTokens *common.TokenUsage
+	var transformer *ResponsesStreamTransformer
+	if isStream {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+		c.Header("Connection", "keep-alive")
+		c.Status(resp.StatusCode)
+		c.Writer.Flush()
+
+		transformer = newResponsesStreamTransformer(modelName)
+		usageTokens = processResponsesStream(c.Writer, resp.Body, transformer)
+	} else {
+		bodyBytes, err := io.ReadAll(resp.Body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"error": map[string]interface{}{
+					"type":    "response_read_error",
+					"message": "Failed to read response: " + err.Error(),
+				},
+			})
+			return
+		}
+
+		var claudeResponse map[string]interface{}
+		claudeResponse, usageTokens = convertResponsesToClaude(bodyBytes, modelName)
+		if claudeResponse != nil {
+			c.JSON(resp.StatusCode, claudeResponse)
+		} else {
+			// 转换失败，直接返回原始响应
+			c.Data(resp.StatusCode, "application/json", bodyBytes)
+		}
+	}
+
+	if usageTokens == nil {
+		usageTokens = &common.TokenUsage{Model: modelName}
+	}
+
+	// 对冲请求中落败的一方不计费也不记录日志
+	if isHedgeLoser(c) {
+		return
+	}
+
+	// 流在首个内容前被fallback判定失败时按失败记录
+	statusCode := fallbackStatusCode(c, resp.StatusCode)
+	// 上游在流中返回失败事件时同样按失败记录，不计费也不记录日志
+	if transformer != nil && transformer.errorType != "" && statusCode < 300 {
+		statusCode = streamErrorStatusCode(transformer.errorType)
+	}
+
+	// 更新账号状态和统计信息
+	accountService := service.NewAccountService()
+	go accountService.UpdateAccountStatus(account, statusCode, usageTokens)
+
+	// 更新API Key统计信息
+	if apiKey != nil {
+		go service.UpdateApiKeyStatus(apiKey, statusCode, usageTokens)
+	}
+
+	// 记录日志
+	if statusCode >= 200 && statusCode < 300 && apiKey != nil {
+		duration := time.Since(startTime).Milliseconds()
+		logService := service.NewLogService()
+		go func() {
+			_, err := logService.CreateLogFromTokenUsage(usageTokens, apiKey.UserID, apiKey.ID, account.ID, duration, isStream)
+			if err != nil {
+				log.Printf("保存日志失败: %v", err)
+			}
+		}()
+	}
+}
@@ -0,0 +1,269 @@
+package relay
+
+import (
+	"claude-code-relay/constant"
+	"claude-code-relay/model"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestBuildAzureOpenAIChatURL Azure账号应使用部署级地址和api-key头
+func TestBuildAzureOpenAIChatURL(t *testing.T) {
+	account := &model.Account{
+		PlatformType: constant.PlatformAzureOpenAI,
+		RequestURL:   "https://demo.openai.azure.com/",
+		SecretKey:    "azure-key",
+		ModelMapping: "claude-sonnet-4-20250514:gpt-4o-prod",
+	}
+
+	deployment := applyModelMapping("claude-sonnet-4-20250514", account.ModelMapping, "gpt-4o")
+	expected := "https://demo.openai.azure.com/openai/deployments/gpt-4o-prod/chat/completions?api-version=" + AzureOpenAIDefaultAPIVersion
+	if got := buildOpenAIChatURL(account, deployment); got != expected {
+		t.Errorf("Azure请求地址不正确:\n期望: %s\n实际: %s", expected, got)
+	}
+
+	req := httptest.NewRequest("POST", expected, nil)
+	setOpenAIAuthHeaders(req, account)
+	if req.Header.Get("api-key") != "azure-key" || req.Header.Get("Authorization") != "" {
+		t.Errorf("Azure鉴权头不正确: %v", req.Header)
+	}
+}
+
+// TestStreamSkipsAzureContentFilterChunks 内容过滤结果chunk不应影响流式转换状态
+func TestStreamSkipsAzureContentFilterChunks(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	stream := strings.Join([]string{
+		`data: {"choices":[],"prompt_filter_results":[{"prompt_index":0,"content_filter_results":{}}]}`,
+		`data: {"id":"1","choices":[{"index":0,"delta":{"role":"assistant","content":""},"content_filter_results":{}}]}`,
+		`data: {"id":"1","choices":[{"index":0,"delta":{"content":"Hi"},"content_filter_results":{"hate":{"filtered":false}}}]}`,
+		`data: {"id":"1","choices":[{"index":0,"delta":{},"content_filter_results":{"hate":{"filtered":false}}}]}`,
+		`data: {"id":"1","choices":[{"index":0,"delta":{},"finish_reason":"content_filter"}]}`,
+		`data: [DONE]`,
+	}, "\n\n")
+
+	transformer := createStreamTransformer("claude-sonnet-4-20250514")
+	processOpenAIStreamResponse(c.Writer, strings.NewReader(stream), transformer, true)
+
+	output := recorder.Body.String()
+	if strings.Count(output, "event: message_start") != 1 {
+		t.Errorf("message_start 应只发送一次: %s", output)
+	}
+	if strings.Count(output, "event: content_block_start") != 1 {
+		t.Errorf("content_block_start 应只发送一次: %s", output)
+	}
+	if !strings.Contains(output, `"stop_reason":"refusal"`) {
+		t.Errorf("content_filter 应映射为 refusal: %s", output)
+	}
+}
+
+// TestConvertResponsesToClaude Responses API非流式响应应转换推理摘要、工具调用和缓存token
+func TestConvertResponsesToClaude(t *testing.T) {
+	body := []byte(`{"id":"resp_1","status":"completed","output":[
+		{"type":"reasoning","id":"rs_1","summary":[{"type":"summary_text","text":"思考过程"}]},
+		{"type":"message","id":"msg_1","role":"assistant","content":[{"type":"output_text","text":"好的"}]},
+		{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather","arguments":"{\"city\":\"北京\"}"}
+	],"usage":{"input_tokens":100,"output_tokens":20,"input_tokens_details":{"cached_tokens":60}}}`)
+
+	claudeResponse, usage := convertResponsesToClaude(body, "claude-sonnet-4-20250514")
+	if claudeResponse == nil {
+		t.Fatal("转换失败")
+	}
+
+	content := claudeResponse["content"].([]map[string]interface{})
+	if len(content) != 3 || content[0]["type"] != "thinking" || content[1]["type"] != "text" || content[2]["type"] != "tool_use" {
+		t.Errorf("内容块不正确: %v", content)
+	}
+	if claudeResponse["stop_reason"] != "tool_use" {
+		t.Errorf("stop_reason 应为 tool_use: %v", claudeResponse["stop_reason"])
+	}
+	if usage.InputTokens != 40 || usage.CacheReadInputTokens != 60 || usage.OutputTokens != 20 {
+		t.Errorf("usage不正确: %+v", usage)
+	}
+}
+
+// TestResponsesStreamTransformer Responses API流式事件应转换为Claude SSE
+func TestResponsesStreamTransformer(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	stream := strings.Join([]string{
+		`data: {"type":"response.created","response":{"id":"resp_1"}}`,
+		`data: {"type":"response.output_item.added","output_index":0,"item":{"type":"reasoning","id":"rs_1"}}`,
+		`data: {"type":"response.reasoning_summary_text.delta","item_id":"rs_1","delta":"思考"}`,
+		`data: {"type":"response.output_item.done","output_index":0,"item":{"type":"reasoning","id":"rs_1"}}`,
+		`data: {"type":"response.output_item.added","output_index":1,"item":{"type":"message","id":"msg_1"}}`,
+		`data: {"type":"response.output_text.delta","item_id":"msg_1","delta":"Hi"}`,
+		`data: {"type":"response.output_item.done","output_index":1,"item":{"type":"message","id":"msg_1"}}`,
+		`data: {"type":"response.output_item.added","output_index":2,"item":{"type":"function_call","id":"fc_1","call_id":"call_1","name":"get_weather"}}`,
+		`data: {"type":"response.function_call_arguments.delta","item_id":"fc_1","delta":"{\"city\":1}"}`,
+		`data: {"type":"response.output_item.done","output_index":2,"item":{"type":"function_call","id":"fc_1"}}`,
+		`data: {"type":"response.completed","response":{"status":"completed","usage":{"input_tokens":50,"output_tokens":10,"input_tokens_details":{"cached_tokens":30}}}}`,
+	}, "\n\n")
+
+	usage := processResponsesStream(c.Writer, strings.NewReader(stream), newResponsesStreamTransformer("claude-sonnet-4-20250514"))
+
+	output := recorder.Body.String()
+	for _, expected := range []string{`"type":"thinking_delta"`, `"type":"text_delta"`, `"type":"input_json_delta"`, `"stop_reason":"tool_use"`, `"cache_read_input_tokens":30`} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("输出缺少 %s: %s", expected, output)
+		}
+	}
+	if strings.Count(output, "event: content_block_start") != 3 || strings.Count(output, "event: content_block_stop") != 3 {
+		t.Errorf("内容块开始/结束事件数量不正确: %s", output)
+	}
+	if usage.InputTokens != 20 || usage.CacheReadInputTokens != 30 || usage.OutputTokens != 10 {
+		t.Errorf("usage不正确: %+v", usage)
+	}
+}
+
+// TestStreamReasoningContentToThinking reasoning_content增量应转换为thinking内容块
+func TestStreamReasoningContentToThinking(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	stream := strings.Join([]string{
+		`data: {"id":"1","choices":[{"index":0,"delta":{"role":"assistant","content":"","reasoning_content":"先分析"}}]}`,
+		`data: {"id":"1","choices":[{"index":0,"delta":{"reasoning_content":"问题"}}]}`,
+		`data: {"id":"1","choices":[{"index":0,"delta":{"content":"答案"}}]}`,
+		`data: {"id":"1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	}, "\n\n")
+
+	processOpenAIStreamResponse(c.Writer, strings.NewReader(stream), createStreamTransformer("claude-sonnet-4-20250514"), true)
+
+	output := recorder.Body.String()
+	thinkingStart := strings.Index(output, `"content_block":{"signature":"","thinking":"","type":"thinking"},"index":0`)
+	textStart := strings.Index(output, `"content_block":{"text":"","type":"text"},"index":1`)
+	if thinkingStart < 0 || textStart < thinkingStart {
+		t.Errorf("thinking内容块应位于文本内容块之前: %s", output)
+	}
+	if strings.Count(output, `"type":"thinking_delta"`) != 2 {
+		t.Errorf("thinking_delta 事件数量不正确: %s", output)
+	}
+	if strings.Count(output, "event: content_block_stop") != 2 {
+		t.Errorf("content_block_stop 事件数量不正确: %s", output)
+	}
+}
+
+// TestThinkingToReasoningEffort thinking预算应映射为reasoning_effort
+func TestThinkingToReasoningEffort(t *testing.T) {
+	cases := map[int]string{1024: "low", 10000: "medium", 31999: "high"}
+	for budget, expected := range cases {
+		if got := thinkingToReasoningEffort(&ClaudeThinking{Type: "enabled", BudgetTokens: budget}); got != expected {
+			t.Errorf("预算 %d 应映射为 %s, 实际: %s", budget, expected, got)
+		}
+	}
+	if got := thinkingToReasoningEffort(&ClaudeThinking{Type: "disabled"}); got != "" {
+		t.Errorf("未开启thinking时不应设置reasoning_effort: %s", got)
+	}
+}
+
+// TestReasoningOnlyForReasoningModels 非推理模型即使开启thinking也不下发reasoning参数
+func TestReasoningOnlyForReasoningModels(t *testing.T) {
+	claudeReq := ClaudeRequest{
+		MaxTokens: 1024,
+		Messages:  []ClaudeMessage{{Role: "user", Content: "hi"}},
+		Thinking:  &ClaudeThinking{Type: "enabled", BudgetTokens: 10000},
+	}
+
+	cases := []struct {
+		modelName       string
+		expectReasoning bool
+	}{
+		{"gpt-4o", false},
+		{"gpt-4.1-mini", false},
+		{"o3-mini", true},
+		{"gpt-5", true},
+	}
+	for _, tc := range cases {
+		openaiReq := convertClaudeToOpenAI(claudeReq, tc.modelName)
+		if (openaiReq.ReasoningEffort != "") != tc.expectReasoning {
+			t.Errorf("%s 的reasoning_effort不正确: %q", tc.modelName, openaiReq.ReasoningEffort)
+		}
+
+		responsesReq := convertClaudeToResponses(claudeReq, tc.modelName)
+		if (responsesReq.Reasoning != nil) != tc.expectReasoning {
+			t.Errorf("%s 的reasoning不正确: %+v", tc.modelName, responsesReq.Reasoning)
+		}
+		if tc.expectReasoning && responsesReq.Reasoning.Effort != "medium" {
+			t.Errorf("%s 的推理强度应为medium: %s", tc.modelName, responsesReq.Reasoning.Effort)
+		}
+	}
+}
+
+// TestOpenAIStreamAbortedBeforeContent 流在首个内容前断开时按失败记录，而不是上游的200
+func TestOpenAIStreamAbortedBeforeContent(t *testing.T) {
+	account := &model.Account{Name: "openai", PlatformType: constant.PlatformOpenAI, SecretKey: "sk"}
+	capture, recorder, apiKey := runHeldStreamAttempt(t, account, abortedStreamRequestBody, HandleOpenAIRequest, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"id":"chatcmpl-1","choices":[{"index":0,"delta":{"role":"assistant"}}],"usage":{"prompt_tokens":10,"completion_tokens":0}}` + "\n\n"))
+	})
+	assertAbortedBeforeContent(t, capture, recorder)
+	assertAttemptRecordedAsFailure(t, account, apiKey)
+}
+
+// TestResponsesStreamAbortedBeforeContent Responses API在首个内容前返回失败事件时按失败记录
+func TestResponsesStreamAbortedBeforeContent(t *testing.T) {
+	account := &model.Account{Name: "responses", PlatformType: constant.PlatformOpenAI, SecretKey: "sk", UseResponsesAPI: true}
+	capture, recorder, apiKey := runHeldStreamAttempt(t, account, abortedStreamRequestBody, HandleOpenAIRequest, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"type":"response.created","response":{"id":"resp_1","status":"in_progress"}}` + "\n\n"))
+		w.Write([]byte(`data: {"type":"response.failed","response":{"id":"resp_1","status":"failed","error":{"code":"server_error","message":"boom"}}}` + "\n\n"))
+	})
+	assertAbortedBeforeContent(t, capture, recorder)
+	assertAttemptRecordedAsFailure(t, account, apiKey)
+}
+
+// TestResponsesStreamFailedAfterContent 已输出内容后上游返回失败事件时，客户端收到错误事件，本次请求按失败记录
+func TestResponsesStreamFailedAfterContent(t *testing.T) {
+	account := &model.Account{Name: "responses", PlatformType: constant.PlatformOpenAI, SecretKey: "sk", UseResponsesAPI: true}
+	capture, recorder, apiKey := runHeldStreamAttempt(t, account, abortedStreamRequestBody, HandleOpenAIRequest, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Write([]byte(`data: {"type":"response.created","response":{"id":"resp_1","status":"in_progress"}}` + "\n\n"))
+		w.Write([]byte(`data: {"type":"response.output_text.delta","item_id":"msg_1","delta":"部分"}` + "\n\n"))
+		w.Write([]byte(`data: {"type":"response.failed","response":{"id":"resp_1","status":"failed","error":{"code":"server_error","message":"upstream failed"}}}` + "\n\n"))
+	})
+
+	body := recorder.Body.String()
+	if capture.aborted || !strings.Contains(body, "部分") || !strings.Contains(body, `"type":"api_error"`) || strings.Contains(body, "message_stop") {
+		t.Errorf("客户端应收到已输出的内容和错误事件: %s", body)
+	}
+	assertAttemptRecordedAsFailure(t, account, apiKey)
+}
+
+// TestStreamReasoningAfterText 文本之后出现推理内容时先结束文本内容块，再开始thinking内容块
+func TestStreamReasoningAfterText(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	recorder := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(recorder)
+
+	stream := strings.Join([]string{
+		`data: {"id":"1","choices":[{"index":0,"delta":{"role":"assistant","content":"开头"}}]}`,
+		`data: {"id":"1","choices":[{"index":0,"delta":{"reasoning_content":"再想想"}}]}`,
+		`data: {"id":"1","choices":[{"index":0,"delta":{"content":"结论"}}]}`,
+		`data: {"id":"1","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+		`data: [DONE]`,
+	}, "\n\n")
+
+	processOpenAIStreamResponse(c.Writer, strings.NewReader(stream), createStreamTransformer("claude-sonnet-4-20250514"), true)
+
+	output := recorder.Body.String()
+	textStop := strings.Index(output, `{"index":0,"type":"content_block_stop"}`)
+	thinkingStart := strings.Index(output, `"content_block":{"signature":"","thinking":"","type":"thinking"},"index":1`)
+	secondText := strings.Index(output, `"content_block":{"text":"","type":"text"},"index":2`)
+	if textStop < 0 || thinkingStart < textStop || secondText < thinkingStart {
+		t.Errorf("内容块应依次为文本、thinking、文本且不交叉: %s", output)
+	}
+	if strings.Count(output, "event: content_block_stop") != 3 {
+		t.Errorf("content_block_stop 事件数量不正确: %s", output)
+	}
+}
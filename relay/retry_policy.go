@@ -0,0 +1,161 @@
+package relay
+
+import (
+	"claude-code-relay/constant"
+	"context"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/tidwall/gjson"
+)
+
+// ErrorClass 失败请求的错误分类，决定fallback是否继续尝试其他账号
+type ErrorClass string
+
+const (
+	ErrorClassClient    ErrorClass = "client_error" // 请求本身有问题(400/413)，换账号也不会成功，直接失败
+	ErrorClassAuth      ErrorClass = "auth_error"   // 账号凭证无效(401/403)，标记账号不可用后尝试下一个
+	ErrorClassRateLimit ErrorClass = "rate_limit"   // 限流或过载(429/529)，退避后尝试下一个
+	ErrorClassServer    ErrorClass = "server_error" // 上游异常或网络错误，直接尝试下一个
+)
+
+// rateLimitBackoffBase 限流/过载时的最小退避基数，即使未配置重试延迟也会退避
+const rateLimitBackoffBase = 500 * time.Millisecond
+
+// accountScopedErrorKeywords 上游以400返回但实际是账号问题的错误信息，需要换账号而不是直接失败
+var accountScopedErrorKeywords = []string{
+	"credit balance",
+	"organization has been disabled",
+	"billing",
+}
+
+// classifyFailure 根据状态码和错误内容对失败请求分类
+func classifyFailure(statusCode int, errorBody []byte) ErrorClass {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge:
+		message := strings.ToLower(extractErrorMessage(errorBody))
+		for _, keyword := range accountScopedErrorKeywords {
+			if strings.Contains(message, keyword) {
+				return ErrorClassAuth
+			}
+		}
+		return ErrorClassClient
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrorClassAuth
+	case http.StatusTooManyRequests, 529:
+		return ErrorClassRateLimit
+	default:
+		return ErrorClassServer
+	}
+}
+
+// extractErrorMessage 从错误响应中提取错误信息，不是JSON时返回原始内容
+func extractErrorMessage(errorBody []byte) string {
+	if message := gjson.GetBytes(errorBody, "error.message"); message.Exists() {
+		return message.String()
+	}
+	if message := gjson.GetBytes(errorBody, "message"); message.Exists() {
+		return message.String()
+	}
+	return strings.TrimSpace(string(errorBody))
+}
+
+// parseRetryAfter 解析Retry-After响应头，支持秒数和HTTP日期两种格式
+func parseRetryAfter(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if retryTime, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(retryTime); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
+
+// retryBackoff 计算第retry次重试前的等待时间：以RetryDelay为基数指数增长并加入抖动，
+// 不超过MaxRetryDelay；限流时在上限内尽量满足上游要求的Retry-After
+func (h *FallbackHandler) retryBackoff(retry int, class ErrorClass, retryAfter time.Duration) time.Duration {
+	base := h.config.RetryDelay
+	if class == ErrorClassRateLimit && base < rateLimitBackoffBase {
+		base = rateLimitBackoffBase
+	}
+	if base <= 0 {
+		return 0
+	}
+
+	maxDelay := h.config.MaxRetryDelay
+	if maxDelay < base {
+		maxDelay = base
+	}
+
+	delay := maxDelay
+	if retry < 30 {
+		if exp := base << uint(retry); exp > 0 && exp < maxDelay {
+			delay = exp
+		}
+	}
+
+	// 抖动：在[delay/2, delay]之间随机，避免多个请求同时重试
+	delay = delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+
+	if retryAfter > delay && retryAfter <= maxDelay {
+		delay = retryAfter
+	}
+	return delay
+}
+
+// waitForRetry 等待指定时间，客户端断开时提前返回false
+func waitForRetry(ctx context.Context, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// requestContext 获取请求上下文，测试等场景没有请求时返回Background
+func requestContext(c *gin.Context) context.Context {
+	if c.Request == nil {
+		return context.Background()
+	}
+	return c.Request.Context()
+}
+
+// RespondFallbackError 所有账号都失败时写入错误响应：
+// 请求本身的错误原样返回上游的错误信息，便于客户端修正；排队等待空闲账号失败时返回过载；其他情况返回通用的服务不可用
+func RespondFallbackError(c *gin.Context, result *FallbackResult) {
+	if result.ErrorClass == ErrorClassClient && len(result.ErrorBody) > 0 {
+		c.Data(result.StatusCode, "application/json", result.ErrorBody)
+		return
+	}
+
+	if result.FailureReason == "concurrency_limit" {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": gin.H{
+				"message": constant.ErrConcurrencyLimit,
+				"type":    "overloaded_error",
+			},
+		})
+		return
+	}
+
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error": gin.H{
+			"message": constant.ErrServiceUnavailable,
+			"type":    "service_unavailable",
+		},
+	})
+}
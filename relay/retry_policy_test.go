@@ -0,0 +1,98 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestClassifyFailure 测试失败请求的错误分类
+func TestClassifyFailure(t *testing.T) {
+	cases := []struct {
+		status int
+		body   string
+		want   ErrorClass
+	}{
+		{http.StatusBadRequest, `{"type":"error","error":{"type":"invalid_request_error","message":"prompt is too long"}}`, ErrorClassClient},
+		{http.StatusRequestEntityTooLarge, `{"error":{"message":"request too large"}}`, ErrorClassClient},
+		{http.StatusBadRequest, `{"error":{"message":"Your credit balance is too low to access the Anthropic API."}}`, ErrorClassAuth},
+		{http.StatusUnauthorized, ``, ErrorClassAuth},
+		{http.StatusForbidden, ``, ErrorClassAuth},
+		{http.StatusTooManyRequests, ``, ErrorClassRateLimit},
+		{529, ``, ErrorClassRateLimit},
+		{http.StatusInternalServerError, ``, ErrorClassServer},
+		{http.StatusNotFound, ``, ErrorClassServer},
+	}
+
+	for _, tc := range cases {
+		if got := classifyFailure(tc.status, []byte(tc.body)); got != tc.want {
+			t.Errorf("状态码 %d 分类错误: 期望 %s, 实际 %s", tc.status, tc.want, got)
+		}
+	}
+}
+
+// TestRetryBackoff 测试指数退避和Retry-After
+func TestRetryBackoff(t *testing.T) {
+	handler := &FallbackHandler{config: &FallbackConfig{RetryDelay: 100 * time.Millisecond, MaxRetryDelay: time.Second}}
+
+	for retry := 0; retry < 6; retry++ {
+		expected := 100 * time.Millisecond << uint(retry)
+		if expected > time.Second {
+			expected = time.Second
+		}
+		delay := handler.retryBackoff(retry, ErrorClassServer, 0)
+		if delay < expected/2 || delay > expected {
+			t.Errorf("第%d次重试延迟 %v 不在 [%v, %v] 范围内", retry, delay, expected/2, expected)
+		}
+	}
+
+	if delay := handler.retryBackoff(0, ErrorClassRateLimit, 800*time.Millisecond); delay != 800*time.Millisecond {
+		t.Errorf("上限内的Retry-After应被遵守，实际: %v", delay)
+	}
+	if delay := handler.retryBackoff(0, ErrorClassRateLimit, time.Hour); delay > time.Second {
+		t.Errorf("退避时间不应超过最大重试延迟，实际: %v", delay)
+	}
+
+	noDelay := &FallbackHandler{config: &FallbackConfig{MaxRetryDelay: time.Second}}
+	if delay := noDelay.retryBackoff(0, ErrorClassServer, 0); delay != 0 {
+		t.Errorf("未配置重试延迟时服务端错误应立即重试，实际: %v", delay)
+	}
+
+	if got := parseRetryAfter("3"); got != 3*time.Second {
+		t.Errorf("Retry-After秒数解析错误: %v", got)
+	}
+}
+
+// TestFallbackFailFastOnClientError 测试请求本身的错误不再尝试其他账号
+func TestFallbackFailFastOnClientError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := NewFallbackHandler(&FallbackConfig{MaxRetries: 3, Strategy: StrategyPriorityFirst, EnableHealthCheck: true, RecoveryWindow: time.Minute, HealthCheckInterval: time.Minute})
+	defer handler.Stop()
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	accounts := []model.Account{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	var attempts []uint
+	result := handler.executeFallback(c, accounts, []byte(`{}`), func(c *gin.Context, account *model.Account, requestBody []byte) {
+		attempts = append(attempts, account.ID)
+		if account.ID == 1 {
+			c.JSON(http.StatusUnauthorized, gin.H{"error": gin.H{"type": "authentication_error", "message": "invalid x-api-key"}})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": gin.H{"type": "invalid_request_error", "message": "prompt is too long"}})
+	}, time.Now())
+
+	if len(attempts) != 2 {
+		t.Fatalf("401应换号、400应立即失败，实际尝试: %v", attempts)
+	}
+	if result.ErrorClass != ErrorClassClient || result.ErrorMessage != "prompt is too long" {
+		t.Errorf("结果应为请求错误并提取错误信息，实际: %s %s", result.ErrorClass, result.ErrorMessage)
+	}
+	if handler.isAccountAvailable(1) {
+		t.Error("认证失败的账号应被标记为不可用")
+	}
+}
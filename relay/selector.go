@@ -186,6 +186,10 @@ func createAccountSelector(strategy FallbackStrategy) AccountSelector {
 		return &RoundRobinSelector{}
 	case StrategyLeastUsed:
 		return &LeastUsedSelector{}
+	case StrategyLatencyAware:
+		return NewLatencyAwareSelector()
+	case StrategyCostAware:
+		return &CostAwareSelector{}
 	default:
 		// 默认使用混合策略
 		return &HybridSelector{}
@@ -295,6 +299,27 @@ func (s *AdaptiveSelector) UpdatePerformance(accountID uint, success bool, respo
 	}
 }
 
+// getPerformance 获取账号性能数据的副本，没有数据时返回nil
+func (s *AdaptiveSelector) getPerformance(accountID uint) *PerformanceData {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	perf := s.performanceData[accountID]
+	if perf == nil {
+		return nil
+	}
+	perfCopy := *perf
+	return &perfCopy
+}
+
+// restorePerformance 用其他副本同步的性能数据覆盖本地数据
+func (s *AdaptiveSelector) restorePerformance(accountID uint, perf *PerformanceData) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.performanceData[accountID] = perf
+}
+
 // CleanupOldData 清理过期的性能数据
 func (s *AdaptiveSelector) CleanupOldData(cutoff time.Time) {
 	s.mu.Lock()
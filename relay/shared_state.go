@@ -0,0 +1,372 @@
+package relay
+
+import (
+	"claude-code-relay/common"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// 跨副本共享状态的Redis键和频道
+// 状态键格式: fallback_state:{groupID}:{类型}:{账号ID}
+// 请求历史键格式: fallback_requests:{groupID}:{账号ID}（有序集合，score为请求时间）
+const (
+	sharedStateKeyPrefix   = "fallback_state"
+	sharedRequestKeyPrefix = "fallback_requests"
+	sharedStateChannel     = "fallback_state_sync"
+)
+
+const (
+	sharedStateTTL           = 24 * time.Hour   // 共享状态的有效期，与健康数据的清理周期一致
+	sharedStateFlushInterval = time.Second      // 本地变更批量写入Redis的间隔
+	sharedRequestWindow      = 10 * time.Minute // 共享请求历史的统计窗口，与本地请求历史一致
+)
+
+// sharedStateKind 共享状态类型
+type sharedStateKind string
+
+const (
+	sharedStateHealth      sharedStateKind = "health"
+	sharedStateBreaker     sharedStateKind = "breaker"
+	sharedStatePerformance sharedStateKind = "perf"
+)
+
+// sharedStateEntry 一条共享状态变更
+type sharedStateEntry struct {
+	Kind      sharedStateKind `json:"kind"`
+	AccountID uint            `json:"account_id"`
+}
+
+// sharedStateMessage 状态变更通知，其他副本收到后从Redis重新加载对应状态
+type sharedStateMessage struct {
+	Instance string             `json:"instance"`
+	GroupID  int                `json:"group_id"`
+	Entries  []sharedStateEntry `json:"entries"`
+}
+
+// sharedStateInstanceID 当前副本的标识，用于忽略自己发布的通知
+var sharedStateInstanceID = newSharedStateInstanceID()
+
+// newSharedStateInstanceID 生成随机的副本标识
+func newSharedStateInstanceID() string {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 36)
+	}
+	return hex.EncodeToString(buf)
+}
+
+// sharedState 分组处理器的跨副本共享状态：
+// 本地变更先标记为脏数据，按固定间隔批量写入Redis并发布通知；
+// 其他副本收到通知后从Redis加载最新状态覆盖本地，多个副本同时修改时以最后写入的为准
+type sharedState struct {
+	groupID int
+	handler *FallbackHandler
+	mu      sync.Mutex
+	dirty   map[sharedStateEntry]struct{}
+}
+
+// attachSharedState 为处理器启用跨副本共享状态：加载Redis中已有的状态，并启动定期同步
+func (h *FallbackHandler) attachSharedState(groupID int) {
+	if common.RDB == nil || !h.config.EnableSharedState {
+		return
+	}
+
+	shared := &sharedState{
+		groupID: groupID,
+		handler: h,
+		dirty:   make(map[sharedStateEntry]struct{}),
+	}
+	shared.loadAll()
+
+	h.shared = shared
+	h.healthMonitor.onChange = func(accountID uint) {
+		shared.markDirty(sharedStateHealth, accountID)
+	}
+
+	go shared.run(h.stopChan)
+}
+
+// markDirty 标记本地状态已变更，等待下次同步写入Redis
+func (s *sharedState) markDirty(kind sharedStateKind, accountID uint) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.dirty[sharedStateEntry{Kind: kind, AccountID: accountID}] = struct{}{}
+	s.mu.Unlock()
+}
+
+// run 定期将本地变更写入Redis，处理器停止时写入剩余变更后退出
+func (s *sharedState) run(stopChan chan struct{}) {
+	ticker := time.NewTicker(sharedStateFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.flush()
+		case <-stopChan:
+			s.flush()
+			return
+		}
+	}
+}
+
+// flush 将脏数据批量写入Redis并通知其他副本
+func (s *sharedState) flush() {
+	s.mu.Lock()
+	if len(s.dirty) == 0 {
+		s.mu.Unlock()
+		return
+	}
+	entries := make([]sharedStateEntry, 0, len(s.dirty))
+	for entry := range s.dirty {
+		entries = append(entries, entry)
+	}
+	s.dirty = make(map[sharedStateEntry]struct{})
+	s.mu.Unlock()
+
+	ctx := context.Background()
+	pipe := common.RDB.Pipeline()
+	published := entries[:0]
+	for _, entry := range entries {
+		value := s.snapshot(entry)
+		if value == nil {
+			continue
+		}
+		data, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		pipe.Set(ctx, s.stateKey(entry), data, sharedStateTTL)
+		published = append(published, entry)
+	}
+	if len(published) == 0 {
+		return
+	}
+
+	message, _ := json.Marshal(sharedStateMessage{Instance: sharedStateInstanceID, GroupID: s.groupID, Entries: published})
+	pipe.Publish(ctx, sharedStateChannel, message)
+
+	if _, err := pipe.Exec(ctx); err != nil {
+		common.SysError("同步fallback共享状态失败: " + err.Error())
+	}
+}
+
+// snapshot 获取本地状态快照，没有数据时返回nil
+func (s *sharedState) snapshot(entry sharedStateEntry) interface{} {
+	switch entry.Kind {
+	case sharedStateHealth:
+		health := s.handler.healthMonitor.GetAccountHealth(entry.AccountID)
+		if health == nil {
+			return nil
+		}
+		s.handler.healthMonitor.mu.RLock()
+		healthCopy := *health
+		s.handler.healthMonitor.mu.RUnlock()
+		return &healthCopy
+	case sharedStateBreaker:
+		s.handler.breakersMu.Lock()
+		breaker, exists := s.handler.breakers[entry.AccountID]
+		s.handler.breakersMu.Unlock()
+		if !exists {
+			return nil
+		}
+		snapshot := breaker.snapshot()
+		return &snapshot
+	case sharedStatePerformance:
+		if selector := s.handler.adaptiveSelector(); selector != nil {
+			if perf := selector.getPerformance(entry.AccountID); perf != nil {
+				return perf
+			}
+		}
+	}
+	return nil
+}
+
+// apply 将Redis中的状态覆盖到本地
+func (s *sharedState) apply(entry sharedStateEntry, data []byte) error {
+	switch entry.Kind {
+	case sharedStateHealth:
+		var health AccountHealth
+		if err := json.Unmarshal(data, &health); err != nil {
+			return err
+		}
+		health.AccountID = entry.AccountID
+		s.handler.healthMonitor.restoreAccountHealth(&health)
+	case sharedStateBreaker:
+		var snapshot circuitBreakerSnapshot
+		if err := json.Unmarshal(data, &snapshot); err != nil {
+			return err
+		}
+		s.handler.getBreaker(entry.AccountID).restore(snapshot)
+	case sharedStatePerformance:
+		selector := s.handler.adaptiveSelector()
+		if selector == nil {
+			return nil
+		}
+		var perf PerformanceData
+		if err := json.Unmarshal(data, &perf); err != nil {
+			return err
+		}
+		selector.restorePerformance(entry.AccountID, &perf)
+	}
+	return nil
+}
+
+// reload 从Redis加载指定的状态并覆盖本地
+func (s *sharedState) reload(entries []sharedStateEntry) {
+	ctx := context.Background()
+	for _, entry := range entries {
+		data, err := common.RDB.Get(ctx, s.stateKey(entry)).Bytes()
+		if err != nil {
+			if err != redis.Nil {
+				common.SysError("读取fallback共享状态失败: " + err.Error())
+			}
+			continue
+		}
+		if err := s.apply(entry, data); err != nil {
+			common.SysError(fmt.Sprintf("解析fallback共享状态 %s 失败: %v", s.stateKey(entry), err))
+		}
+	}
+}
+
+// loadAll 加载Redis中该分组的全部状态，用于副本启动或新建处理器时恢复
+func (s *sharedState) loadAll() {
+	ctx := context.Background()
+	pattern := fmt.Sprintf("%s:%d:*", sharedStateKeyPrefix, s.groupID)
+
+	var entries []sharedStateEntry
+	iter := common.RDB.Scan(ctx, 0, pattern, 100).Iterator()
+	for iter.Next(ctx) {
+		if entry, ok := parseSharedStateKey(iter.Val()); ok {
+			entries = append(entries, entry)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		common.SysError("加载fallback共享状态失败: " + err.Error())
+		return
+	}
+
+	s.reload(entries)
+	if len(entries) > 0 {
+		log.Printf("🔄 分组 %d 从Redis恢复了 %d 条fallback共享状态", s.groupID, len(entries))
+	}
+}
+
+// stateKey 生成状态在Redis中的键
+func (s *sharedState) stateKey(entry sharedStateEntry) string {
+	return fmt.Sprintf("%s:%d:%s:%d", sharedStateKeyPrefix, s.groupID, entry.Kind, entry.AccountID)
+}
+
+// parseSharedStateKey 解析状态键中的类型和账号ID
+func parseSharedStateKey(key string) (sharedStateEntry, bool) {
+	parts := strings.Split(key, ":")
+	if len(parts) != 4 || parts[0] != sharedStateKeyPrefix {
+		return sharedStateEntry{}, false
+	}
+	accountID, err := strconv.ParseUint(parts[3], 10, 64)
+	if err != nil {
+		return sharedStateEntry{}, false
+	}
+
+	kind := sharedStateKind(parts[2])
+	switch kind {
+	case sharedStateHealth, sharedStateBreaker, sharedStatePerformance:
+		return sharedStateEntry{Kind: kind, AccountID: uint(accountID)}, true
+	default:
+		return sharedStateEntry{}, false
+	}
+}
+
+// requestKey 生成账号请求历史在Redis中的键
+func (s *sharedState) requestKey(accountID uint) string {
+	return fmt.Sprintf("%s:%d:%d", sharedRequestKeyPrefix, s.groupID, accountID)
+}
+
+// recordRequest 将请求记录写入共享的请求历史，并清理统计窗口外的记录
+func (s *sharedState) recordRequest(accountID uint, now time.Time) {
+	if s == nil {
+		return
+	}
+
+	key := s.requestKey(accountID)
+	member := sharedStateInstanceID + ":" + strconv.FormatInt(now.UnixNano(), 10)
+	go func() {
+		ctx := context.Background()
+		pipe := common.RDB.Pipeline()
+		pipe.ZAdd(ctx, key, &redis.Z{Score: float64(now.UnixNano()), Member: member})
+		pipe.ZRemRangeByScore(ctx, key, "-inf", strconv.FormatInt(now.Add(-sharedRequestWindow).UnixNano(), 10))
+		pipe.Expire(ctx, key, sharedRequestWindow)
+		if _, err := pipe.Exec(ctx); err != nil {
+			common.SysError("记录共享请求历史失败: " + err.Error())
+		}
+	}()
+}
+
+// requestCount 获取所有副本在统计窗口内的请求数，未启用或读取失败时返回false
+func (s *sharedState) requestCount(accountID uint) (int, bool) {
+	if s == nil {
+		return 0, false
+	}
+
+	minScore := strconv.FormatInt(time.Now().Add(-sharedRequestWindow).UnixNano(), 10)
+	count, err := common.RDB.ZCount(context.Background(), s.requestKey(accountID), minScore, "+inf").Result()
+	if err != nil {
+		return 0, false
+	}
+	return int(count), true
+}
+
+// adaptiveSelector 获取处理器使用的自适应选择器，没有时返回nil
+func (h *FallbackHandler) adaptiveSelector() *AdaptiveSelector {
+	switch selector := h.selector.(type) {
+	case *AdaptiveSelector:
+		return selector
+	case *SmartLoadBalanceSelector:
+		return selector.adaptiveSelector
+	default:
+		return nil
+	}
+}
+
+// subscribeSharedState 订阅其他副本的状态变更通知，收到后重新加载对应分组的状态
+func (fm *FallbackManager) subscribeSharedState() {
+	if common.RDB == nil || !fm.config.EnableSharedState {
+		return
+	}
+
+	pubsub := common.RDB.Subscribe(context.Background(), sharedStateChannel)
+	fm.pubsub = pubsub
+
+	go func() {
+		for msg := range pubsub.Channel() {
+			var message sharedStateMessage
+			if err := json.Unmarshal([]byte(msg.Payload), &message); err != nil {
+				continue
+			}
+			if message.Instance == sharedStateInstanceID {
+				continue
+			}
+
+			// 本副本还没有该分组的处理器时忽略，创建处理器时会从Redis加载
+			fm.mu.RLock()
+			handler, exists := fm.handlers[strconv.Itoa(message.GroupID)]
+			fm.mu.RUnlock()
+			if exists && handler.shared != nil {
+				handler.shared.reload(message.Entries)
+			}
+		}
+	}()
+}
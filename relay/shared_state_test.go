@@ -0,0 +1,67 @@
+package relay
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestParseSharedStateKey 测试共享状态键的解析
+func TestParseSharedStateKey(t *testing.T) {
+	shared := &sharedState{groupID: 3}
+	entry := sharedStateEntry{Kind: sharedStateBreaker, AccountID: 42}
+
+	parsed, ok := parseSharedStateKey(shared.stateKey(entry))
+	if !ok || parsed != entry {
+		t.Errorf("解析结果不一致，期望 %+v，实际 %+v", entry, parsed)
+	}
+
+	for _, key := range []string{"fallback_state:3:unknown:1", "fallback_state:3:health:abc", "sticky_session:3:abc"} {
+		if _, ok := parseSharedStateKey(key); ok {
+			t.Errorf("无效的键 %s 不应解析成功", key)
+		}
+	}
+}
+
+// TestSharedStateApply 测试一个副本的状态快照同步到另一个副本
+func TestSharedStateApply(t *testing.T) {
+	config := &FallbackConfig{
+		Strategy:                StrategyPriorityFirst,
+		EnableCircuitBreaker:    true,
+		CircuitBreakerThreshold: 1,
+		FailureWindow:           time.Minute,
+		RecoveryWindow:          time.Minute,
+		HealthCheckInterval:     time.Minute,
+	}
+	source := NewFallbackHandler(config)
+	defer source.Stop()
+	target := NewFallbackHandler(config)
+	defer target.Stop()
+
+	sourceState := &sharedState{handler: source}
+	targetState := &sharedState{handler: target}
+
+	source.getBreaker(7).RecordFailure()
+	source.healthMonitor.SetAccountDisabled(7, time.Minute, "认证失败")
+
+	for _, kind := range []sharedStateKind{sharedStateBreaker, sharedStateHealth} {
+		entry := sharedStateEntry{Kind: kind, AccountID: 7}
+		data, err := json.Marshal(sourceState.snapshot(entry))
+		if err != nil {
+			t.Fatalf("序列化%s状态失败: %v", kind, err)
+		}
+		if err := targetState.apply(entry, data); err != nil {
+			t.Fatalf("应用%s状态失败: %v", kind, err)
+		}
+	}
+
+	if !target.getBreaker(7).IsOpen() {
+		t.Error("同步后另一个副本的熔断器应开启")
+	}
+	if target.isAccountAvailable(7) {
+		t.Error("同步后另一个副本应认为账号不可用")
+	}
+	if health := target.healthMonitor.GetAccountHealth(7); health == nil || health.FailureReason != "认证失败" {
+		t.Errorf("健康状态应同步，实际: %+v", health)
+	}
+}
@@ -0,0 +1,194 @@
+package relay
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"github.com/tidwall/gjson"
+)
+
+// 会话粘性在Redis中的键前缀，完整格式: sticky_session:{groupID}:{会话哈希}
+const stickySessionKeyPrefix = "sticky_session"
+
+// DefaultStickySessionTTL 会话粘性绑定的默认有效期
+const DefaultStickySessionTTL = time.Hour
+
+// StickySessionStats 会话粘性命中统计
+type StickySessionStats struct {
+	hits      int64 // 命中绑定账号
+	misses    int64 // 新会话，没有绑定记录
+	failovers int64 // 绑定账号不可用，切换到其他账号
+	rebinds   int64 // 会话改绑到新账号
+	errors    int64 // Redis读写失败
+}
+
+// recordHit 记录命中
+func (s *StickySessionStats) recordHit() {
+	atomic.AddInt64(&s.hits, 1)
+}
+
+// recordMiss 记录未命中
+func (s *StickySessionStats) recordMiss() {
+	atomic.AddInt64(&s.misses, 1)
+}
+
+// recordFailover 记录绑定账号不可用导致的切换
+func (s *StickySessionStats) recordFailover() {
+	atomic.AddInt64(&s.failovers, 1)
+}
+
+// recordRebind 记录会话改绑
+func (s *StickySessionStats) recordRebind() {
+	atomic.AddInt64(&s.rebinds, 1)
+}
+
+// recordError 记录Redis错误
+func (s *StickySessionStats) recordError() {
+	atomic.AddInt64(&s.errors, 1)
+}
+
+// Reset 重置统计
+func (s *StickySessionStats) Reset() {
+	atomic.StoreInt64(&s.hits, 0)
+	atomic.StoreInt64(&s.misses, 0)
+	atomic.StoreInt64(&s.failovers, 0)
+	atomic.StoreInt64(&s.rebinds, 0)
+	atomic.StoreInt64(&s.errors, 0)
+}
+
+// GetStats 获取统计快照，命中率 = 命中 / (命中 + 未命中 + 切换)
+func (s *StickySessionStats) GetStats() map[string]interface{} {
+	hits := atomic.LoadInt64(&s.hits)
+	misses := atomic.LoadInt64(&s.misses)
+	failovers := atomic.LoadInt64(&s.failovers)
+
+	hitRate := 0.0
+	if total := hits + misses + failovers; total > 0 {
+		hitRate = float64(hits) / float64(total)
+	}
+
+	return map[string]interface{}{
+		"hits":      hits,
+		"misses":    misses,
+		"failovers": failovers,
+		"rebinds":   atomic.LoadInt64(&s.rebinds),
+		"errors":    atomic.LoadInt64(&s.errors),
+		"hit_rate":  hitRate,
+	}
+}
+
+// buildStickySessionKey 根据请求生成会话粘性键
+// 优先使用 metadata.user_id，其次使用系统提示词和第一条消息计算会话哈希；
+// 会话哈希按API Key隔离，避免不同Key的相同对话共享绑定。无法识别会话时返回空字符串
+func buildStickySessionKey(c *gin.Context, requestBody []byte) string {
+	var groupID int
+	var apiKeyID uint
+	if apiKey, exists := c.Get("api_key"); exists {
+		if keyInfo, ok := apiKey.(*model.ApiKey); ok {
+			groupID = keyInfo.GroupID
+			apiKeyID = keyInfo.ID
+		}
+	}
+
+	var source string
+	if userID := gjson.GetBytes(requestBody, "metadata.user_id").String(); userID != "" {
+		source = "user:" + userID
+	} else {
+		firstMessage := gjson.GetBytes(requestBody, "messages.0")
+		if !firstMessage.Exists() {
+			return ""
+		}
+		system := gjson.GetBytes(requestBody, "system").Raw
+		source = "conv:" + system + "\n" + firstMessage.Raw
+	}
+
+	sum := sha256.Sum256([]byte(strconv.FormatUint(uint64(apiKeyID), 10) + ":" + source))
+	return fmt.Sprintf("%s:%d:%s", stickySessionKeyPrefix, groupID, hex.EncodeToString(sum[:16]))
+}
+
+// promoteAccount 将指定账号移动到列表最前面，其余账号保持原有顺序
+// 账号不在列表中时返回false
+func promoteAccount(accounts []model.Account, accountID uint) ([]model.Account, bool) {
+	for i := range accounts {
+		if accounts[i].ID != accountID {
+			continue
+		}
+		if i == 0 {
+			return accounts, true
+		}
+		result := make([]model.Account, 0, len(accounts))
+		result = append(result, accounts[i])
+		result = append(result, accounts[:i]...)
+		result = append(result, accounts[i+1:]...)
+		return result, true
+	}
+	return accounts, false
+}
+
+// applyStickySession 查找会话绑定的账号，账号可用时将其排在首位
+// 返回调整后的账号列表和原绑定的账号ID（没有绑定时为0）
+func (h *FallbackHandler) applyStickySession(sessionKey string, accounts []model.Account) ([]model.Account, uint) {
+	if common.RDB == nil || sessionKey == "" {
+		return accounts, 0
+	}
+
+	value, err := common.RDB.Get(context.Background(), sessionKey).Result()
+	if err != nil {
+		if err == redis.Nil {
+			h.stickyStats.recordMiss()
+		} else {
+			h.stickyStats.recordError()
+			common.SysError("读取会话粘性绑定失败: " + err.Error())
+		}
+		return accounts, 0
+	}
+
+	boundID, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		h.stickyStats.recordMiss()
+		return accounts, 0
+	}
+	accountID := uint(boundID)
+
+	reordered, found := promoteAccount(accounts, accountID)
+	if !found || !h.isAccountAvailable(accountID) {
+		log.Printf("🔀 会话绑定的账号 %d 不可用，切换到其他账号", accountID)
+		h.stickyStats.recordFailover()
+		return accounts, accountID
+	}
+
+	h.stickyStats.recordHit()
+	return reordered, accountID
+}
+
+// bindStickySession 将会话绑定到本次成功处理请求的账号，并刷新有效期
+func (h *FallbackHandler) bindStickySession(sessionKey string, previousID, accountID uint) {
+	if common.RDB == nil || sessionKey == "" {
+		return
+	}
+
+	ttl := h.config.StickySessionTTL
+	if ttl <= 0 {
+		ttl = DefaultStickySessionTTL
+	}
+
+	if err := common.RDB.Set(context.Background(), sessionKey, strconv.FormatUint(uint64(accountID), 10), ttl).Err(); err != nil {
+		h.stickyStats.recordError()
+		common.SysError("写入会话粘性绑定失败: " + err.Error())
+		return
+	}
+
+	if previousID != 0 && previousID != accountID {
+		h.stickyStats.recordRebind()
+	}
+}
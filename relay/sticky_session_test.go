@@ -0,0 +1,63 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TestBuildStickySessionKey 测试会话粘性键的生成
+func TestBuildStickySessionKey(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	newContext := func(apiKeyID uint) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Set("api_key", &model.ApiKey{ID: apiKeyID, GroupID: 1})
+		return c
+	}
+
+	turn1 := []byte(`{"system":"sys","messages":[{"role":"user","content":"hi"}]}`)
+	turn2 := []byte(`{"system":"sys","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":"hello"},{"role":"user","content":"more"}]}`)
+
+	key1 := buildStickySessionKey(newContext(1), turn1)
+	if key1 == "" {
+		t.Fatal("对话请求应生成会话键")
+	}
+	if key2 := buildStickySessionKey(newContext(1), turn2); key2 != key1 {
+		t.Errorf("同一对话的后续轮次应得到相同会话键: %s != %s", key2, key1)
+	}
+	if other := buildStickySessionKey(newContext(2), turn1); other == key1 {
+		t.Error("不同API Key的相同对话不应共享会话键")
+	}
+
+	withUser1 := []byte(`{"metadata":{"user_id":"u1"},"messages":[{"role":"user","content":"a"}]}`)
+	withUser2 := []byte(`{"metadata":{"user_id":"u1"},"messages":[{"role":"user","content":"b"}]}`)
+	if buildStickySessionKey(newContext(1), withUser1) != buildStickySessionKey(newContext(1), withUser2) {
+		t.Error("相同metadata.user_id应优先生成相同会话键")
+	}
+
+	if key := buildStickySessionKey(newContext(1), []byte(`{"requests":[]}`)); key != "" {
+		t.Errorf("无法识别会话时应返回空字符串，实际: %s", key)
+	}
+}
+
+// TestPromoteAccount 测试绑定账号前移
+func TestPromoteAccount(t *testing.T) {
+	accounts := []model.Account{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	result, found := promoteAccount(accounts, 3)
+	if !found {
+		t.Fatal("应找到账号3")
+	}
+	if result[0].ID != 3 || result[1].ID != 1 || result[2].ID != 2 {
+		t.Errorf("账号顺序错误: %v", []uint{result[0].ID, result[1].ID, result[2].ID})
+	}
+	if accounts[0].ID != 1 {
+		t.Error("不应修改原始账号列表")
+	}
+
+	if _, found := promoteAccount(accounts, 9); found {
+		t.Error("不存在的账号不应返回found")
+	}
+}
@@ -0,0 +1,59 @@
+package relay
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// StripUnsignedThinkingBlocks 移除历史assistant消息中没有签名的thinking内容块
+// OpenAI、Responses等平台的推理内容转换为thinking内容块时没有签名，客户端在下一轮原样带回时，
+// Anthropic原生接口（Claude、Claude Console、Bedrock、Vertex）会因签名无效拒绝请求
+// 移除后消息没有其他内容时改为文本内容块，避免出现空消息
+func StripUnsignedThinkingBlocks(requestBody []byte) []byte {
+	if !bytes.Contains(requestBody, []byte(`"thinking"`)) {
+		return requestBody
+	}
+	messages := gjson.GetBytes(requestBody, "messages")
+	if !messages.IsArray() {
+		return requestBody
+	}
+
+	for i, message := range messages.Array() {
+		content := message.Get("content")
+		if message.Get("role").String() != "assistant" || !content.IsArray() {
+			continue
+		}
+
+		var kept, converted []json.RawMessage
+		stripped := false
+		for _, block := range content.Array() {
+			if block.Get("type").String() != "thinking" || block.Get("signature").String() != "" {
+				kept = append(kept, json.RawMessage(block.Raw))
+				continue
+			}
+			stripped = true
+			if text := block.Get("thinking").String(); text != "" {
+				textBlock, _ := json.Marshal(map[string]string{"type": "text", "text": text})
+				converted = append(converted, textBlock)
+			}
+		}
+		if !stripped {
+			continue
+		}
+		if len(kept) == 0 {
+			kept = converted
+		}
+		if len(kept) == 0 {
+			continue
+		}
+
+		if body, err := sjson.SetBytes(requestBody, "messages."+strconv.Itoa(i)+".content", kept); err == nil {
+			requestBody = body
+		}
+	}
+	return requestBody
+}
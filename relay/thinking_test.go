@@ -0,0 +1,34 @@
+package relay
+
+import (
+	"testing"
+
+	"github.com/tidwall/gjson"
+)
+
+// TestStripUnsignedThinkingBlocks 移除历史assistant消息中没有签名的thinking内容块，保留有签名的内容块
+func TestStripUnsignedThinkingBlocks(t *testing.T) {
+	body := []byte(`{"model":"claude-sonnet-4-20250514","messages":[
+		{"role":"user","content":"hi"},
+		{"role":"assistant","content":[{"type":"thinking","thinking":"先分析","signature":""},{"type":"text","text":"答案"}]},
+		{"role":"user","content":"继续"},
+		{"role":"assistant","content":[{"type":"thinking","thinking":"只有推理","signature":""}]},
+		{"role":"user","content":"再继续"},
+		{"role":"assistant","content":[{"type":"thinking","thinking":"已签名","signature":"sig"},{"type":"text","text":"ok"}]}
+	]}`)
+
+	result := gjson.ParseBytes(StripUnsignedThinkingBlocks(body))
+
+	if content := result.Get("messages.1.content").Array(); len(content) != 1 || content[0].Get("text").String() != "答案" {
+		t.Errorf("没有签名的thinking内容块应被移除: %s", result.Get("messages.1.content").Raw)
+	}
+	if content := result.Get("messages.3.content").Array(); len(content) != 1 || content[0].Get("type").String() != "text" || content[0].Get("text").String() != "只有推理" {
+		t.Errorf("移除后没有其他内容时应改为文本内容块: %s", result.Get("messages.3.content").Raw)
+	}
+	if content := result.Get("messages.5.content").Array(); len(content) != 2 || content[0].Get("signature").String() != "sig" {
+		t.Errorf("有签名的thinking内容块应保留: %s", result.Get("messages.5.content").Raw)
+	}
+	if result.Get("messages.0.content").String() != "hi" {
+		t.Errorf("其他消息不应被修改: %s", result.Get("messages.0").Raw)
+	}
+}
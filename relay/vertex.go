@@ -0,0 +1,383 @@
+package relay
+
+import (
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"claude-code-relay/service"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/tidwall/gjson"
+	"github.com/tidwall/sjson"
+)
+
+// Vertex 账号字段约定：
+// SecretKey 存放服务账号JSON，Region 为Vertex区域（为空时使用 us-east5），
+// AccessToken/ExpiresAt 缓存服务账号换取的OAuth访问令牌
+
+const (
+	VertexTokenURL         = "https://oauth2.googleapis.com/token"
+	vertexDefaultRegion    = "us-east5"
+	vertexAnthropicVersion = "vertex-2023-10-16"
+	vertexOAuthScope       = "https://www.googleapis.com/auth/cloud-platform"
+	vertexJWTGrantType     = "urn:ietf:params:oauth:grant-type:jwt-bearer"
+)
+
+// vertexModelDatePattern 匹配模型ID末尾的日期版本号，如 -20250514
+var vertexModelDatePattern = regexp.MustCompile(`-(\d{8})$`)
+
+// vertexTokenLocks 按账号加锁，避免并发请求重复换取访问令牌
+var vertexTokenLocks sync.Map
+
+// VertexServiceAccount Google服务账号JSON
+type VertexServiceAccount struct {
+	Type         string `json:"type"`
+	ProjectID    string `json:"project_id"`
+	PrivateKeyID string `json:"private_key_id"`
+	PrivateKey   string `json:"private_key"`
+	ClientEmail  string `json:"client_email"`
+	TokenURI     string `json:"token_uri"`
+}
+
+// HandleVertexRequest 处理Google Vertex AI平台的Claude请求
+func HandleVertexRequest(c *gin.Context, account *model.Account, requestBody []byte) {
+	startTime := time.Now()
+
+	apiKey := extractAPIKey(c)
+
+	claudeModel := gjson.GetBytes(requestBody, "model").String()
+	isStream := gjson.GetBytes(requestBody, "stream").Bool()
+
+	serviceAccount, err := parseVertexServiceAccount(account.SecretKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errAuthFailed, err.Error()))
+		return
+	}
+
+	accessToken, err := getValidVertexAccessToken(account, serviceAccount)
+	if err != nil {
+		log.Printf("获取Vertex访问token失败: %v", err)
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errAuthFailed, err.Error()))
+		return
+	}
+
+	body, err := buildVertexRequestBody(requestBody)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": map[string]interface{}{
+				"type":    "json_parse_error",
+				"message": "Failed to build Vertex request: " + err.Error(),
+			},
+		})
+		return
+	}
+
+	client := createHTTPClient(account)
+	if client == nil {
+		c.JSON(http.StatusInternalServerError, errProxyConfig)
+		return
+	}
+
+	modelID := resolveVertexModelID(claudeModel, account.ModelMapping)
+	requestURL := buildVertexURL(account, serviceAccount.ProjectID, modelID, isStream)
+	req, err := createVertexRequest(c.Request.Context(), requestURL, body, accessToken, isStream)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errCreateRequest, err.Error()))
+		return
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		handleRequestError(c, err)
+		return
+	}
+	defer common.CloseIO(resp.Body)
+
+	responseReader, err := createResponseReader(resp)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, appendErrorMessage(errDecompression, err.Error()))
+		return
+	}
+
+	var usageTokens *common.TokenUsage
+	if resp.StatusCode < statusBadRequest {
+		// Vertex返回的就是Anthropic格式的SSE/JSON，直接复用Claude的响应处理
+		usageTokens = handleSuccessResponse(c, resp, responseReader, isStreamResponse(resp))
+		if usageTokens != nil && claudeModel != "" {
+			usageTokens.Model = claudeModel
+		}
+	} else {
+		// 访问令牌失效时清空缓存，下次请求重新换取
+		if resp.StatusCode == http.StatusUnauthorized {
+			account.ExpiresAt = 0
+		}
+		handleErrorResponse(c, resp, responseReader, account)
+	}
+
+	// 对冲请求中落败的一方不计费也不记录日志
+	if isHedgeLoser(c) {
+		return
+	}
+
+	// 流在首个内容前被fallback判定失败时按失败记录
+	statusCode := fallbackStatusCode(c, resp.StatusCode)
+
+	updateAccountAndStats(account, statusCode, usageTokens)
+
+	if apiKey != nil {
+		go service.UpdateApiKeyStatus(apiKey, statusCode, usageTokens)
+	}
+
+	saveRequestLog(startTime, apiKey, account, statusCode, usageTokens, isStream)
+}
+
+// parseVertexServiceAccount 解析账号中保存的服务账号JSON
+func parseVertexServiceAccount(secretKey string) (*VertexServiceAccount, error) {
+	if strings.TrimSpace(secretKey) == "" {
+		return nil, errors.New("账号缺少服务账号JSON")
+	}
+
+	var serviceAccount VertexServiceAccount
+	if err := json.Unmarshal([]byte(secretKey), &serviceAccount); err != nil {
+		return nil, fmt.Errorf("解析服务账号JSON失败: %v", err)
+	}
+	if serviceAccount.ClientEmail == "" || serviceAccount.PrivateKey == "" || serviceAccount.ProjectID == "" {
+		return nil, errors.New("服务账号JSON缺少client_email、private_key或project_id")
+	}
+	if serviceAccount.TokenURI == "" {
+		serviceAccount.TokenURI = VertexTokenURL
+	}
+	return &serviceAccount, nil
+}
+
+// getValidVertexAccessToken 获取有效的Vertex访问token，即将过期时使用服务账号重新换取
+func getValidVertexAccessToken(account *model.Account, serviceAccount *VertexServiceAccount) (string, error) {
+	lock, _ := vertexTokenLocks.LoadOrStore(account.ID, &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+
+	// 检查token是否过期（提前5分钟刷新）
+	now := time.Now().Unix()
+	if account.AccessToken != "" && int64(account.ExpiresAt) > now+tokenRefreshBuffer {
+		return account.AccessToken, nil
+	}
+
+	log.Printf("账号 %s 的Vertex token不存在或即将过期，使用服务账号换取", account.Name)
+
+	accessToken, expiresAt, err := mintVertexAccessToken(account, serviceAccount)
+	if err != nil {
+		// 换取失败时，如果当前token未完全过期，仍尝试使用
+		if account.AccessToken != "" && now < int64(account.ExpiresAt) {
+			log.Printf("换取失败但token未完全过期，尝试使用当前token: %v", err)
+			return account.AccessToken, nil
+		}
+		return "", err
+	}
+
+	account.AccessToken = accessToken
+	account.ExpiresAt = int(expiresAt)
+
+	// 保存到数据库
+	if err := model.UpdateAccount(account); err != nil {
+		log.Printf("更新账号token信息到数据库失败: %v", err)
+		// 不返回错误，因为内存中的token已经更新
+	}
+
+	return accessToken, nil
+}
+
+// mintVertexAccessToken 使用服务账号私钥签发JWT并换取OAuth访问令牌
+func mintVertexAccessToken(account *model.Account, serviceAccount *VertexServiceAccount) (string, int64, error) {
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(serviceAccount.PrivateKey))
+	if err != nil {
+		return "", 0, fmt.Errorf("解析服务账号私钥失败: %v", err)
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   serviceAccount.ClientEmail,
+		"scope": vertexOAuthScope,
+		"aud":   serviceAccount.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if serviceAccount.PrivateKeyID != "" {
+		token.Header["kid"] = serviceAccount.PrivateKeyID
+	}
+
+	assertion, err := token.SignedString(privateKey)
+	if err != nil {
+		return "", 0, fmt.Errorf("签发JWT失败: %v", err)
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", vertexJWTGrantType)
+	form.Set("assertion", assertion)
+
+	req, err := http.NewRequest("POST", serviceAccount.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("创建token请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := createHTTPClient(account)
+	if client == nil {
+		return "", 0, errors.New("代理配置错误")
+	}
+	client.Timeout = 30 * time.Second
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", 0, fmt.Errorf("token请求失败: %v", err)
+	}
+	defer common.CloseIO(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("读取token响应失败: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("换取token失败，状态码: %d, 响应: %s", resp.StatusCode, string(body))
+	}
+
+	var tokenResp OAuthTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", 0, fmt.Errorf("解析token响应失败: %v", err)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", 0, errors.New("token响应中缺少access_token")
+	}
+
+	log.Printf("Vertex token换取成功，新token: %s，将在 %d 秒后过期", maskToken(tokenResp.AccessToken), tokenResp.ExpiresIn)
+
+	return tokenResp.AccessToken, now.Unix() + int64(tokenResp.ExpiresIn), nil
+}
+
+// buildVertexRequestBody 将Anthropic请求体转换为Vertex rawPredict请求体
+// Vertex通过URL指定模型，请求体中不能携带model字段，且需要指定anthropic_version
+func buildVertexRequestBody(requestBody []byte) ([]byte, error) {
+	if !gjson.ValidBytes(requestBody) {
+		return nil, errors.New("invalid JSON body")
+	}
+
+	body, err := sjson.DeleteBytes(requestBody, "model")
+	if err != nil {
+		return nil, err
+	}
+	return sjson.SetBytes(body, "anthropic_version", vertexAnthropicVersion)
+}
+
+// resolveVertexModelID 解析Vertex模型ID，优先使用账号的模型映射
+// 未配置映射时将日期后缀改写为 @版本 形式，如 claude-sonnet-4-20250514 -> claude-sonnet-4@20250514
+func resolveVertexModelID(claudeModel, modelMapping string) string {
+	defaultModelID := claudeModel
+	if !strings.Contains(claudeModel, "@") {
+		defaultModelID = vertexModelDatePattern.ReplaceAllString(claudeModel, "@$1")
+	}
+	return applyModelMapping(claudeModel, modelMapping, defaultModelID)
+}
+
+// buildVertexURL 构建Vertex rawPredict/streamRawPredict地址
+func buildVertexURL(account *model.Account, projectID, modelID string, stream bool) string {
+	region := account.Region
+	if region == "" {
+		region = vertexDefaultRegion
+	}
+
+	baseURL := strings.TrimRight(account.RequestURL, "/")
+	if baseURL == "" {
+		if region == "global" {
+			baseURL = "https://aiplatform.googleapis.com"
+		} else {
+			baseURL = fmt.Sprintf("https://%s-aiplatform.googleapis.com", region)
+		}
+	}
+
+	method := "rawPredict"
+	if stream {
+		method = "streamRawPredict"
+	}
+
+	return fmt.Sprintf("%s/v1/projects/%s/locations/%s/publishers/anthropic/models/%s:%s",
+		baseURL, projectID, region, modelID, method)
+}
+
+// createVertexRequest 创建Vertex请求
+func createVertexRequest(ctx context.Context, requestURL string, body []byte, accessToken string, stream bool) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", requestURL, bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Content-Type", "application/json")
+	if stream {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+	return req, nil
+}
+
+// TestHandleVertexRequest 测试Vertex账号连通性，返回状态码和错误信息
+func TestHandleVertexRequest(account *model.Account) (int, string) {
+	return testVertexRequest(account, []byte(GetTestRequestBody(100)))
+}
+
+// testVertexRequest 使用指定请求体测试Vertex账号连通性
+func testVertexRequest(account *model.Account, requestBody []byte) (int, string) {
+	serviceAccount, err := parseVertexServiceAccount(account.SecretKey)
+	if err != nil {
+		return http.StatusBadRequest, err.Error()
+	}
+
+	accessToken, err := getValidVertexAccessToken(account, serviceAccount)
+	if err != nil {
+		return http.StatusUnauthorized, "Failed to get access token: " + err.Error()
+	}
+
+	body, err := buildVertexRequestBody(requestBody)
+	if err != nil {
+		return http.StatusBadRequest, "Failed to build Vertex request: " + err.Error()
+	}
+	// 测试请求使用非流式接口
+	body, _ = sjson.DeleteBytes(body, "stream")
+
+	modelID := resolveVertexModelID(gjson.GetBytes(requestBody, "model").String(), account.ModelMapping)
+	requestURL := buildVertexURL(account, serviceAccount.ProjectID, modelID, false)
+	req, err := createVertexRequest(context.Background(), requestURL, body, accessToken, false)
+	if err != nil {
+		return http.StatusInternalServerError, "Failed to create request: " + err.Error()
+	}
+
+	client := createHTTPClient(account)
+	if client == nil {
+		return http.StatusInternalServerError, "Failed to create HTTP client"
+	}
+	client.Timeout = 30 * time.Second
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return http.StatusInternalServerError, "Request failed: " + err.Error()
+	}
+	defer common.CloseIO(resp.Body)
+
+	if resp.StatusCode >= 400 {
+		bodyBytes, _ := io.ReadAll(resp.Body)
+		return resp.StatusCode, string(bodyBytes)
+	}
+
+	return resp.StatusCode, ""
+}
@@ -0,0 +1,154 @@
+package relay
+
+import (
+	"claude-code-relay/model"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/tidwall/gjson"
+)
+
+// TestResolveVertexModelID 日期后缀改写为@版本，模型映射优先
+func TestResolveVertexModelID(t *testing.T) {
+	cases := []struct {
+		claudeModel  string
+		modelMapping string
+		expected     string
+	}{
+		{"claude-sonnet-4-20250514", "", "claude-sonnet-4@20250514"},
+		{"claude-3-5-haiku-20241022", "", "claude-3-5-haiku@20241022"},
+		{"claude-opus-4@20250514", "", "claude-opus-4@20250514"},
+		{"claude-sonnet-4", "", "claude-sonnet-4"},
+		{"claude-sonnet-4-20250514", "sonnet:claude-sonnet-4-5@20250929", "claude-sonnet-4-5@20250929"},
+		{"claude-3-5-haiku-20241022", "sonnet:claude-sonnet-4-5@20250929", "claude-3-5-haiku@20241022"},
+	}
+	for _, tc := range cases {
+		if got := resolveVertexModelID(tc.claudeModel, tc.modelMapping); got != tc.expected {
+			t.Errorf("%s(映射: %q) 应解析为 %s, 实际: %s", tc.claudeModel, tc.modelMapping, tc.expected, got)
+		}
+	}
+}
+
+// TestBuildVertexURL 区域默认值、global区域和自定义地址
+func TestBuildVertexURL(t *testing.T) {
+	cases := []struct {
+		name       string
+		region     string
+		requestURL string
+		stream     bool
+		expected   string
+	}{
+		{"默认区域", "", "", false, "https://us-east5-aiplatform.googleapis.com/v1/projects/p1/locations/us-east5/publishers/anthropic/models/claude-sonnet-4@20250514:rawPredict"},
+		{"指定区域流式", "europe-west1", "", true, "https://europe-west1-aiplatform.googleapis.com/v1/projects/p1/locations/europe-west1/publishers/anthropic/models/claude-sonnet-4@20250514:streamRawPredict"},
+		{"global区域", "global", "", false, "https://aiplatform.googleapis.com/v1/projects/p1/locations/global/publishers/anthropic/models/claude-sonnet-4@20250514:rawPredict"},
+		{"自定义地址", "us-east5", "https://vertex.example.com/", false, "https://vertex.example.com/v1/projects/p1/locations/us-east5/publishers/anthropic/models/claude-sonnet-4@20250514:rawPredict"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			account := &model.Account{Region: tc.region, RequestURL: tc.requestURL}
+			if got := buildVertexURL(account, "p1", "claude-sonnet-4@20250514", tc.stream); got != tc.expected {
+				t.Errorf("请求地址不正确:\n期望: %s\n实际: %s", tc.expected, got)
+			}
+		})
+	}
+}
+
+// TestBuildVertexRequestBody 请求体去除model并设置anthropic_version
+func TestBuildVertexRequestBody(t *testing.T) {
+	body, err := buildVertexRequestBody([]byte(`{"model":"claude-sonnet-4-20250514","max_tokens":10,"messages":[]}`))
+	if err != nil {
+		t.Fatalf("构建请求体失败: %v", err)
+	}
+	if gjson.GetBytes(body, "model").Exists() || gjson.GetBytes(body, "anthropic_version").String() != vertexAnthropicVersion {
+		t.Errorf("请求体不正确: %s", body)
+	}
+	if _, err := buildVertexRequestBody([]byte(`not json`)); err == nil {
+		t.Error("非法JSON应返回错误")
+	}
+}
+
+// TestParseVertexServiceAccount 校验必填字段并补全token地址
+func TestParseVertexServiceAccount(t *testing.T) {
+	serviceAccount, err := parseVertexServiceAccount(`{"project_id":"p1","client_email":"sa@p1.iam.gserviceaccount.com","private_key":"key"}`)
+	if err != nil {
+		t.Fatalf("解析失败: %v", err)
+	}
+	if serviceAccount.TokenURI != VertexTokenURL {
+		t.Errorf("token地址默认值不正确: %s", serviceAccount.TokenURI)
+	}
+
+	for _, secretKey := range []string{"", "not json", `{"project_id":"p1","client_email":"sa@p1"}`} {
+		if _, err := parseVertexServiceAccount(secretKey); err == nil {
+			t.Errorf("服务账号JSON应解析失败: %q", secretKey)
+		}
+	}
+}
+
+// TestMintVertexAccessToken 使用服务账号私钥签发JWT，并在模拟的token端点换取访问令牌
+func TestMintVertexAccessToken(t *testing.T) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("生成私钥失败: %v", err)
+	}
+	privateKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(privateKey)})
+
+	var tokenURI string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			t.Errorf("解析表单失败: %v", err)
+		}
+		if r.Form.Get("grant_type") != vertexJWTGrantType {
+			t.Errorf("grant_type不正确: %s", r.Form.Get("grant_type"))
+		}
+
+		token, err := jwt.Parse(r.Form.Get("assertion"), func(token *jwt.Token) (interface{}, error) {
+			if token.Method != jwt.SigningMethodRS256 {
+				t.Errorf("签名算法不正确: %v", token.Header["alg"])
+			}
+			return &privateKey.PublicKey, nil
+		})
+		if err != nil {
+			t.Errorf("JWT校验失败: %v", err)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		claims := token.Claims.(jwt.MapClaims)
+		if claims["iss"] != "sa@p1.iam.gserviceaccount.com" || claims["scope"] != vertexOAuthScope || claims["aud"] != tokenURI {
+			t.Errorf("JWT声明不正确: %v", claims)
+		}
+		if token.Header["kid"] != "key-1" {
+			t.Errorf("JWT缺少kid: %v", token.Header)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"access_token":"ya29.test","expires_in":3600,"token_type":"Bearer"}`))
+	}))
+	defer server.Close()
+	tokenURI = server.URL + "/token"
+
+	serviceAccount := &VertexServiceAccount{
+		ProjectID:    "p1",
+		PrivateKeyID: "key-1",
+		PrivateKey:   string(privateKeyPEM),
+		ClientEmail:  "sa@p1.iam.gserviceaccount.com",
+		TokenURI:     tokenURI,
+	}
+	accessToken, expiresAt, err := mintVertexAccessToken(&model.Account{Name: "vertex"}, serviceAccount)
+	if err != nil {
+		t.Fatalf("换取访问令牌失败: %v", err)
+	}
+	if accessToken != "ya29.test" || expiresAt == 0 {
+		t.Errorf("访问令牌不正确: %s, %d", accessToken, expiresAt)
+	}
+
+	serviceAccount.PrivateKey = "invalid"
+	if _, _, err := mintVertexAccessToken(&model.Account{Name: "vertex"}, serviceAccount); err == nil {
+		t.Error("私钥无效时应返回错误")
+	}
+}
@@ -25,6 +25,17 @@ func SetAPIRouter(server *gin.Engine) {
 	{
 		// 对话接口
 		claude.POST("/v1/messages", controller.GetMessages)
+		claude.POST("/v1/messages/count_tokens", controller.CountTokens)
+		// 批处理接口
+		claude.POST("/v1/messages/batches", controller.CreateMessageBatch)
+		claude.GET("/v1/messages/batches", controller.ListMessageBatches)
+		claude.GET("/v1/messages/batches/:batch_id", controller.GetMessageBatch)
+		claude.POST("/v1/messages/batches/:batch_id/cancel", controller.CancelMessageBatch)
+		claude.GET("/v1/messages/batches/:batch_id/results", controller.GetMessageBatchResults)
+		// 模型列表
+		claude.GET("/v1/models", controller.GetModels)
+		// OpenAI 兼容接口
+		claude.POST("/v1/chat/completions", controller.ChatCompletions)
 	}
 
 	// API路由组
@@ -55,6 +66,11 @@ func SetAPIRouter(server *gin.Engine) {
 				user.PUT("/profile", controller.UpdateProfile)
 				user.PUT("/change-email", controller.ChangeEmail)
 				user.PUT("/change-password", controller.ChangePassword)
+				user.GET("/balance", controller.GetMyBalance)               // 获取当前用户余额
+				user.GET("/balance/ledger", controller.GetMyBalanceLedgers) // 获取当前用户余额流水
+
+				// 兑换码兑换按用户限流，防止暴力猜测兑换码
+				user.POST("/redeem", middleware.UserRateLimit("redeem", 10, time.Minute), controller.Redeem)
 			}
 
 			// 菜单相关
@@ -121,9 +137,32 @@ func SetAPIRouter(server *gin.Engine) {
 				admin.GET("/users", controller.GetUsers)
 				admin.POST("/users", controller.AdminCreateUser)
 				admin.PUT("/users/:id/status", controller.AdminUpdateUserStatus)
+				admin.GET("/users/:id/balance", controller.AdminGetUserBalance)               // 获取用户余额
+				admin.GET("/users/:id/balance/ledger", controller.AdminGetUserBalanceLedgers) // 获取用户余额流水
+				admin.POST("/users/:id/balance/topup", controller.AdminTopUpBalance)          // 充值
+				admin.POST("/users/:id/balance/adjust", controller.AdminAdjustBalance)        // 调整余额
+				admin.POST("/users/:id/balance/refund", controller.AdminRefundBalance)        // 退款
 				admin.GET("/logs", controller.GetApiLogs)
 				admin.GET("/dashboard", controller.GetDashboard)
 
+				// 兑换码管理（管理员专用）
+				redemption := admin.Group("/redemption-codes")
+				{
+					redemption.GET("/list", controller.GetRedemptionCodes)                      // 获取兑换码列表
+					redemption.POST("/create", controller.CreateRedemptionCodes)                // 批量生成兑换码
+					redemption.PUT("/update-status/:id", controller.UpdateRedemptionCodeStatus) // 启用或停用兑换码
+					redemption.GET("/export", controller.ExportRedemptionCodes)                 // 导出批次兑换码CSV
+				}
+
+				// 价格规则管理（管理员专用）
+				priceRules := admin.Group("/price-rules")
+				{
+					priceRules.GET("/list", controller.GetPriceRules)            // 获取价格规则列表
+					priceRules.POST("/create", controller.CreatePriceRule)       // 创建价格规则
+					priceRules.PUT("/update/:id", controller.UpdatePriceRule)    // 更新价格规则
+					priceRules.DELETE("/delete/:id", controller.DeletePriceRule) // 删除价格规则
+				}
+
 				// 日志管理（管理员专用）
 				adminLogs := admin.Group("/logs")
 				{
@@ -143,6 +182,7 @@ func SetAPIRouter(server *gin.Engine) {
 					fallback.POST("/disable-account", controller.DisableAccountManually) // 手动禁用账号
 					fallback.POST("/enable-account", controller.EnableAccountManually)   // 手动启用账号
 					fallback.GET("/account-health", controller.GetAccountHealth)       // 获取账号健康状态
+					fallback.GET("/sticky-stats", controller.GetStickySessionStats)    // 获取会话粘性统计
 					fallback.POST("/reset-metrics", controller.ResetMetrics)           // 重置指标
 					fallback.GET("/export-metrics", controller.ExportMetrics)          // 导出指标数据
 				}
@@ -166,10 +206,15 @@ func SetAPIRouter(server *gin.Engine) {
 			return
 		}
 
-		// 如果是 claude-code 路径但不是已定义的路由，返回空白200响应
+		// 如果是 claude-code 路径但不是已定义的路由，返回Anthropic格式的404错误
 		if strings.HasPrefix(c.Request.URL.Path, "/claude-code/") {
-			// 未定义的claude-code路由返回空白200
-			c.Status(200)
+			c.JSON(http.StatusNotFound, gin.H{
+				"type": "error",
+				"error": map[string]interface{}{
+					"type":    "not_found_error",
+					"message": "Not found: " + c.Request.URL.Path,
+				},
+			})
 			return
 		}
 
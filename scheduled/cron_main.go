@@ -60,6 +60,13 @@ func (s *CronService) Start() {
 		return
 	}
 
+	// 每5分钟同步批处理状态并对已完成的批处理计费
+	_, err = s.cron.AddFunc("0 */5 * * * *", s.syncMessageBatches)
+	if err != nil {
+		log.Printf("Failed to add message batch sync cron job: %v", err)
+		return
+	}
+
 	// 启动定时任务
 	s.cron.Start()
 	common.SysLog("Cron service started successfully")
@@ -251,8 +258,14 @@ func (s *CronService) testAndRecoverAccount(account *model.Account) bool {
 		statusCode, err = relay.TestsHandleClaudeRequest(account)
 	case constant.PlatformClaudeConsole:
 		statusCode, err = relay.TestHandleClaudeConsoleRequest(account)
-	case constant.PlatformOpenAI:
+	case constant.PlatformOpenAI, constant.PlatformAzureOpenAI:
 		statusCode, err = relay.TestHandleOpenAIRequest(account)
+	case constant.PlatformGemini:
+		statusCode, err = relay.TestHandleGeminiRequest(account)
+	case constant.PlatformBedrock:
+		statusCode, err = relay.TestHandleBedrockRequest(account)
+	case constant.PlatformVertex:
+		statusCode, err = relay.TestHandleVertexRequest(account)
 	default:
 		common.SysError(fmt.Sprintf("Unsupported platform type for account %s (ID: %d): %s", account.Name, account.ID, account.PlatformType))
 		return false
@@ -334,3 +347,25 @@ func (s *CronService) checkRateLimitExpiredAccounts() {
 	duration := time.Since(startTime)
 	common.SysLog(fmt.Sprintf("Rate limit expired accounts check task completed in %s. Recovered: %d", duration.String(), recoveredCount))
 }
+
+// syncMessageBatches 同步未计费批处理的状态，处理完成的批处理按批处理价格计费
+func (s *CronService) syncMessageBatches() {
+	batches, err := model.GetUnbilledMessageBatches(100)
+	if err != nil {
+		common.SysError("Failed to get unbilled message batches: " + err.Error())
+		return
+	}
+
+	for i := range batches {
+		syncErr := relay.SyncMessageBatch(&batches[i])
+		if syncErr != nil {
+			log.Printf("Failed to sync message batch %s: %v", batches[i].BatchID, syncErr)
+			if batches[i].SyncFailures+1 >= model.MaxBatchSyncFailures {
+				common.SysError(fmt.Sprintf("Message batch %s marked as billing failed after %d attempts", batches[i].BatchID, model.MaxBatchSyncFailures))
+			}
+		}
+		if err := model.RecordMessageBatchSyncResult(&batches[i], syncErr); err != nil {
+			common.SysError("Failed to record message batch sync result: " + err.Error())
+		}
+	}
+}
@@ -58,6 +58,10 @@ func (s *AccountService) CreateAccount(req *model.CreateAccountRequest, userID u
 		PlatformType:     req.PlatformType,
 		RequestURL:       req.RequestURL,
 		SecretKey:        req.SecretKey,
+		Region:           req.Region,
+		AccessKeyID:      req.AccessKeyID,
+		ApiVersion:       req.ApiVersion,
+		UseResponsesAPI:  req.UseResponsesAPI,
 		GroupID:          req.GroupID,
 		Priority:         req.Priority,
 		Weight:           req.Weight,
@@ -72,6 +76,11 @@ func (s *AccountService) CreateAccount(req *model.CreateAccountRequest, userID u
 		ExpiresAt:        req.ExpiresAt,
 		TodayUsageCount:  todayUsageCount,
 		UserID:           userID,
+		CostMultiplier:   1,
+		MaxConcurrency:   req.MaxConcurrency,
+	}
+	if req.CostMultiplier != nil {
+		account.CostMultiplier = *req.CostMultiplier
 	}
 
 	if err := model.CreateAccount(account); err != nil {
@@ -107,11 +116,21 @@ func (s *AccountService) UpdateAccount(id uint, req *model.UpdateAccountRequest,
 	account.Name = req.Name
 	account.PlatformType = req.PlatformType
 	account.RequestURL = req.RequestURL
+	account.Region = req.Region
+	account.ApiVersion = req.ApiVersion
+	account.UseResponsesAPI = req.UseResponsesAPI
+	if req.AccessKeyID != "" {
+		account.AccessKeyID = req.AccessKeyID
+	}
 	if req.GroupID != nil {
 		account.GroupID = *req.GroupID
 	}
 	account.Priority = req.Priority
 	account.Weight = req.Weight
+	if req.CostMultiplier != nil {
+		account.CostMultiplier = *req.CostMultiplier
+	}
+	account.MaxConcurrency = req.MaxConcurrency
 	account.EnableProxy = req.EnableProxy
 	account.ProxyURI = req.ProxyURI
 	account.ModelMapping = req.ModelMapping
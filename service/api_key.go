@@ -32,12 +32,20 @@ func CreateApiKey(userID uint, req *model.CreateApiKeyRequest) (*model.ApiKey, e
 	}
 
 	apiKey := &model.ApiKey{
-		Name:      req.Name,
-		Key:       req.Key,
-		ExpiresAt: req.ExpiresAt,
-		Status:    req.Status,
-		GroupID:   req.GroupID,
-		UserID:    userID,
+		Name:       req.Name,
+		Key:        req.Key,
+		ExpiresAt:  req.ExpiresAt,
+		Status:     req.Status,
+		GroupID:    req.GroupID,
+		UserID:     userID,
+		HedgeDelay: req.HedgeDelay,
+		RpmLimit:   req.RpmLimit,
+		ItpmLimit:  req.ItpmLimit,
+		OtpmLimit:  req.OtpmLimit,
+
+		WeeklyBudget:       req.WeeklyBudget,
+		MonthlyBudget:      req.MonthlyBudget,
+		BudgetAlertPercent: req.BudgetAlertPercent,
 	}
 
 	if apiKey.Status == 0 {
@@ -100,6 +108,27 @@ func UpdateApiKey(id, userID uint, req *model.UpdateApiKeyRequest) (*model.ApiKe
 	if req.DailyLimit != nil {
 		apiKey.DailyLimit = *req.DailyLimit
 	}
+	if req.HedgeDelay != nil {
+		apiKey.HedgeDelay = *req.HedgeDelay
+	}
+	if req.RpmLimit != nil {
+		apiKey.RpmLimit = *req.RpmLimit
+	}
+	if req.ItpmLimit != nil {
+		apiKey.ItpmLimit = *req.ItpmLimit
+	}
+	if req.OtpmLimit != nil {
+		apiKey.OtpmLimit = *req.OtpmLimit
+	}
+	if req.WeeklyBudget != nil {
+		apiKey.WeeklyBudget = *req.WeeklyBudget
+	}
+	if req.MonthlyBudget != nil {
+		apiKey.MonthlyBudget = *req.MonthlyBudget
+	}
+	if req.BudgetAlertPercent != nil {
+		apiKey.BudgetAlertPercent = *req.BudgetAlertPercent
+	}
 
 	err = model.UpdateApiKey(apiKey)
 	if err != nil {
@@ -181,11 +210,14 @@ func ValidateApiKey(key string) (*model.ApiKey, error) {
 
 // UpdateApiKeyStatus 根据响应状态码更新API Key统计信息
 func UpdateApiKeyStatus(apiKey *model.ApiKey, statusCode int, usage *common.TokenUsage) {
-	// 只在请求成功时更新API Key统计信息
+	// 只在请求成功时更新API Key统计信息，失败时退还限流预扣的tokens
 	if statusCode != 200 && statusCode != 201 {
+		SettleApiKeyRateLimit(apiKey, nil)
 		return
 	}
 
+	SettleApiKeyRateLimit(apiKey, usage)
+
 	now := time.Now()
 
 	// 判断最后使用时间是否为当天
@@ -207,9 +239,10 @@ func UpdateApiKeyStatus(apiKey *model.ApiKey, statusCode int, usage *common.Toke
 
 	// 更新token使用量和费用（如果有的话）
 	if usage != nil {
-		// 计算本次请求的费用
-		costResult := common.CalculateCost(usage)
+		// 计算本次请求的费用（按价格规则调整后的计费费用）
+		costResult := common.CalculateBilledCost(usage, model.GetPriceAdjustment(apiKey.UserID, apiKey.ID, usage.Model))
 		currentCost := costResult.Costs.Total
+		RecordBudgetSpend(apiKey, currentCost)
 
 		if apiKey.LastUsedTime != nil {
 			lastUsedDate := time.Time(*apiKey.LastUsedTime).Format("2006-01-02")
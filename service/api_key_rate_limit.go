@@ -0,0 +1,227 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// API Key限流的Redis键: api_key_rate_limit:{apiKeyID}:{维度}（哈希，tokens为桶内剩余令牌，ts为上次更新时间毫秒）
+const apiKeyRateLimitKeyPrefix = "api_key_rate_limit"
+
+// rateLimitWindow 令牌桶的补充周期，限额按每分钟计算
+const rateLimitWindow = time.Minute
+
+// 限流维度，名称与anthropic-ratelimit-*响应头一致
+const (
+	RateLimitRequests     = "requests"
+	RateLimitInputTokens  = "input-tokens"
+	RateLimitOutputTokens = "output-tokens"
+)
+
+// tokenBucketScript 按时间补充多个令牌桶后检查并扣除，任一桶不足时都不扣除
+// KEYS: 各维度的桶 ARGV[1]: 当前时间(毫秒) ARGV[2]: 1表示只调整不检查 ARGV[3..]: 每个桶的限额和扣除量
+// 单次扣除量超过限额时只要桶是满的就放行，桶可以被扣成负数，之后按补充速度恢复
+// 返回: [是否放行, 各桶扣除后的剩余令牌...]
+var tokenBucketScript = redis.NewScript(`
+local now = tonumber(ARGV[1])
+local force = ARGV[2] == '1'
+local current = {}
+local allowed = 1
+for i = 1, #KEYS do
+	local limit = tonumber(ARGV[1 + i * 2])
+	local cost = tonumber(ARGV[2 + i * 2])
+	local bucket = redis.call('HMGET', KEYS[i], 'tokens', 'ts')
+	local tokens = tonumber(bucket[1])
+	local ts = tonumber(bucket[2])
+	if tokens == nil or ts == nil then
+		tokens = limit
+		ts = now
+	end
+	tokens = math.min(limit, tokens + math.max(0, now - ts) * limit / 60000)
+	current[i] = tokens
+	if not force and (tokens <= 0 or (cost > tokens and tokens < limit)) then
+		allowed = 0
+	end
+end
+local result = {allowed}
+for i = 1, #KEYS do
+	local limit = tonumber(ARGV[1 + i * 2])
+	local cost = tonumber(ARGV[2 + i * 2])
+	if allowed == 1 then
+		current[i] = math.min(limit, current[i] - cost)
+		redis.call('HSET', KEYS[i], 'tokens', tostring(current[i]), 'ts', now)
+		redis.call('PEXPIRE', KEYS[i], math.ceil((limit - current[i]) * 60000 / limit) + 1000)
+	end
+	result[#result + 1] = tostring(current[i])
+end
+return result
+`)
+
+// RateLimitStatus 单个限流维度的状态
+type RateLimitStatus struct {
+	Name      string    // 维度名称
+	Limit     int       // 每分钟限额
+	Remaining int       // 剩余额度
+	Reset     time.Time // 额度完全恢复的时间
+}
+
+// RateLimitResult API Key限流检查结果
+type RateLimitResult struct {
+	Allowed    bool
+	RetryAfter time.Duration     // 被限流时建议的重试等待时间
+	Statuses   []RateLimitStatus // 已配置的各维度状态
+}
+
+// rateLimitReservations 请求开始时预扣的输入tokens，响应结束后按实际用量结算
+// 键为请求中的*model.ApiKey，同一请求的多次尝试共享同一个对象
+var rateLimitReservations sync.Map
+
+// rateLimitBucket 一次检查或调整涉及的令牌桶
+type rateLimitBucket struct {
+	name  string
+	limit int
+	cost  int
+}
+
+// CheckApiKeyRateLimit 检查API Key的每分钟请求数、输入tokens和输出tokens限制：
+// 请求数扣除1，输入tokens预扣估算值，输出tokens只检查是否还有余量，实际用量在响应结束后结算
+// 未配置限制、未配置Redis或Redis异常时返回nil，不限制请求
+func CheckApiKeyRateLimit(apiKey *model.ApiKey, estimatedInputTokens int) *RateLimitResult {
+	if common.RDB == nil {
+		return nil
+	}
+
+	var buckets []rateLimitBucket
+	if apiKey.RpmLimit > 0 {
+		buckets = append(buckets, rateLimitBucket{name: RateLimitRequests, limit: apiKey.RpmLimit, cost: 1})
+	}
+	if apiKey.ItpmLimit > 0 {
+		buckets = append(buckets, rateLimitBucket{name: RateLimitInputTokens, limit: apiKey.ItpmLimit, cost: estimatedInputTokens})
+	}
+	if apiKey.OtpmLimit > 0 {
+		buckets = append(buckets, rateLimitBucket{name: RateLimitOutputTokens, limit: apiKey.OtpmLimit, cost: 0})
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+	allowed, remaining, err := runTokenBucket(apiKey.ID, buckets, now, false)
+	if err != nil {
+		common.SysError(fmt.Sprintf("API Key %d 限流检查失败: %v", apiKey.ID, err))
+		return nil
+	}
+
+	result := &RateLimitResult{Allowed: allowed, Statuses: make([]RateLimitStatus, len(buckets))}
+	for i, bucket := range buckets {
+		result.Statuses[i] = RateLimitStatus{
+			Name:      bucket.name,
+			Limit:     bucket.limit,
+			Remaining: max(0, int(math.Floor(remaining[i]))),
+			Reset:     now.Add(refillDuration(bucket.limit, float64(bucket.limit)-remaining[i])),
+		}
+		if !allowed {
+			// 需要恢复到能容纳本次请求的额度，超过限额的请求等到桶满
+			needed := float64(min(max(bucket.cost, 1), bucket.limit))
+			if wait := refillDuration(bucket.limit, needed-remaining[i]); wait > result.RetryAfter {
+				result.RetryAfter = wait
+			}
+		}
+	}
+
+	if allowed && apiKey.ItpmLimit > 0 && estimatedInputTokens > 0 {
+		rateLimitReservations.Store(apiKey, estimatedInputTokens)
+	}
+	return result
+}
+
+// SettleApiKeyRateLimit 按实际用量结算请求开始时预扣的tokens，请求失败时退还预扣的输入tokens
+// 同一请求可能多次调用（fallback的每次尝试），预扣额度只结算一次
+func SettleApiKeyRateLimit(apiKey *model.ApiKey, usage *common.TokenUsage) {
+	if common.RDB == nil || apiKey == nil {
+		return
+	}
+
+	reserved := 0
+	if value, ok := rateLimitReservations.LoadAndDelete(apiKey); ok {
+		reserved = value.(int)
+	}
+
+	var buckets []rateLimitBucket
+	if apiKey.ItpmLimit > 0 {
+		// 与Anthropic一致，缓存读取的tokens不计入输入tokens限制
+		inputTokens := 0
+		if usage != nil {
+			inputTokens = usage.InputTokens + usage.CacheCreationInputTokens
+		}
+		if delta := inputTokens - reserved; delta != 0 {
+			buckets = append(buckets, rateLimitBucket{name: RateLimitInputTokens, limit: apiKey.ItpmLimit, cost: delta})
+		}
+	}
+	if apiKey.OtpmLimit > 0 && usage != nil && usage.OutputTokens > 0 {
+		buckets = append(buckets, rateLimitBucket{name: RateLimitOutputTokens, limit: apiKey.OtpmLimit, cost: usage.OutputTokens})
+	}
+	if len(buckets) == 0 {
+		return
+	}
+
+	if _, _, err := runTokenBucket(apiKey.ID, buckets, time.Now(), true); err != nil {
+		common.SysError(fmt.Sprintf("API Key %d 限流结算失败: %v", apiKey.ID, err))
+	}
+}
+
+// runTokenBucket 执行令牌桶脚本，返回是否放行和各桶的剩余令牌
+func runTokenBucket(apiKeyID uint, buckets []rateLimitBucket, now time.Time, force bool) (bool, []float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+	defer cancel()
+
+	keys := make([]string, len(buckets))
+	args := []interface{}{now.UnixMilli(), "0"}
+	if force {
+		args[1] = "1"
+	}
+	for i, bucket := range buckets {
+		keys[i] = rateLimitKey(apiKeyID, bucket.name)
+		args = append(args, bucket.limit, bucket.cost)
+	}
+
+	values, err := tokenBucketScript.Run(ctx, common.RDB, keys, args...).Slice()
+	if err != nil {
+		return false, nil, err
+	}
+	if len(values) != len(buckets)+1 {
+		return false, nil, fmt.Errorf("限流脚本返回值数量错误: %d", len(values))
+	}
+
+	remaining := make([]float64, len(buckets))
+	for i := range buckets {
+		text, _ := values[i+1].(string)
+		remaining[i], err = strconv.ParseFloat(text, 64)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+	allowed, _ := values[0].(int64)
+	return allowed == 1, remaining, nil
+}
+
+// rateLimitKey 获取API Key某个限流维度的Redis键
+func rateLimitKey(apiKeyID uint, name string) string {
+	return fmt.Sprintf("%s:%d:%s", apiKeyRateLimitKeyPrefix, apiKeyID, name)
+}
+
+// refillDuration 计算按每分钟limit的速度补充tokens个令牌需要的时间
+func refillDuration(limit int, tokens float64) time.Duration {
+	if limit <= 0 || tokens <= 0 {
+		return 0
+	}
+	return time.Duration(math.Ceil(tokens * float64(rateLimitWindow) / float64(limit)))
+}
@@ -0,0 +1,155 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"math"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+)
+
+// setupTestRedis 使用miniredis替换全局Redis连接，测试结束后恢复
+func setupTestRedis(t *testing.T) *miniredis.Miniredis {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	original := common.RDB
+	common.RDB = client
+	t.Cleanup(func() {
+		common.RDB = original
+		client.Close()
+	})
+	return server
+}
+
+// TestRefillDuration 按每分钟限额计算补充令牌所需时间
+func TestRefillDuration(t *testing.T) {
+	cases := []struct {
+		limit    int
+		tokens   float64
+		expected time.Duration
+	}{
+		{60, 1, time.Second},
+		{60, 60, time.Minute},
+		{1000, 250, 15 * time.Second},
+		{7, 1, time.Duration(math.Ceil(float64(time.Minute) / 7))},
+		{60, 0, 0},
+		{60, -5, 0},
+		{0, 10, 0},
+	}
+	for _, tc := range cases {
+		if got := refillDuration(tc.limit, tc.tokens); got != tc.expected {
+			t.Errorf("限额 %d 补充 %v 个令牌应需要 %s, 实际: %s", tc.limit, tc.tokens, tc.expected, got)
+		}
+	}
+}
+
+// TestTokenBucketScript 令牌桶的放行和拒绝边界、超过限额的请求以及强制调整
+func TestTokenBucketScript(t *testing.T) {
+	setupTestRedis(t)
+	now := time.Now()
+
+	run := func(apiKeyID uint, buckets []rateLimitBucket, at time.Duration, force bool) (bool, []float64) {
+		t.Helper()
+		allowed, remaining, err := runTokenBucket(apiKeyID, buckets, now.Add(at), force)
+		if err != nil {
+			t.Fatalf("执行限流脚本失败: %v", err)
+		}
+		return allowed, remaining
+	}
+
+	// 每分钟2次：前两次放行，第三次拒绝且不扣除，30秒后补充1次
+	requests := []rateLimitBucket{{name: RateLimitRequests, limit: 2, cost: 1}}
+	for i, expected := range []float64{1, 0} {
+		if allowed, remaining := run(1, requests, 0, false); !allowed || remaining[0] != expected {
+			t.Fatalf("第 %d 次请求应放行并剩余 %v, 实际: %v %v", i+1, expected, allowed, remaining)
+		}
+	}
+	if allowed, remaining := run(1, requests, 0, false); allowed || remaining[0] != 0 {
+		t.Errorf("额度用完后应拒绝且不扣除: %v %v", allowed, remaining)
+	}
+	if allowed, _ := run(1, requests, 29*time.Second, false); allowed {
+		t.Error("补充不足1次时应拒绝")
+	}
+	if allowed, remaining := run(1, requests, 30*time.Second, false); !allowed || remaining[0] != 0 {
+		t.Errorf("30秒后应补充1次并放行: %v %v", allowed, remaining)
+	}
+
+	// 单次扣除量超过限额：桶满时放行并扣成负数，之后按补充速度恢复
+	inputTokens := []rateLimitBucket{{name: RateLimitInputTokens, limit: 100, cost: 150}}
+	if allowed, remaining := run(2, inputTokens, 0, false); !allowed || remaining[0] != -50 {
+		t.Fatalf("桶满时超过限额的请求应放行并扣成负数: %v %v", allowed, remaining)
+	}
+	inputTokens[0].cost = 1
+	if allowed, _ := run(2, inputTokens, 30*time.Second, false); allowed {
+		t.Error("令牌恢复到0之前应拒绝")
+	}
+	if allowed, _ := run(2, inputTokens, 31*time.Second, false); !allowed {
+		t.Error("令牌恢复后应放行")
+	}
+	inputTokens[0].cost = 150
+	if allowed, _ := run(2, inputTokens, 40*time.Second, false); allowed {
+		t.Error("桶未满时超过剩余令牌的请求应拒绝")
+	}
+
+	// 多个桶中任一不足时都不扣除
+	buckets := []rateLimitBucket{{name: RateLimitRequests, limit: 10, cost: 1}, {name: RateLimitInputTokens, limit: 100, cost: 100}}
+	run(3, buckets[1:], 0, false)
+	if allowed, remaining := run(3, buckets, 0, false); allowed || remaining[0] != 10 {
+		t.Errorf("任一桶不足时应拒绝且其他桶不扣除: %v %v", allowed, remaining)
+	}
+
+	// 强制调整不检查余量，退还时不超过限额
+	if allowed, remaining := run(4, []rateLimitBucket{{name: RateLimitOutputTokens, limit: 100, cost: 300}}, 0, true); !allowed || remaining[0] != -200 {
+		t.Errorf("强制调整应直接扣除: %v %v", allowed, remaining)
+	}
+	if _, remaining := run(4, []rateLimitBucket{{name: RateLimitOutputTokens, limit: 100, cost: -500}}, 0, true); remaining[0] != 100 {
+		t.Errorf("退还后不应超过限额: %v", remaining)
+	}
+}
+
+// TestSettleApiKeyRateLimit 请求失败时退还预扣的输入tokens且只退还一次，成功时按实际用量结算
+func TestSettleApiKeyRateLimit(t *testing.T) {
+	server := setupTestRedis(t)
+
+	bucketTokens := func() float64 {
+		t.Helper()
+		tokens, err := strconv.ParseFloat(server.HGet(rateLimitKey(1, RateLimitInputTokens), "tokens"), 64)
+		if err != nil {
+			t.Fatalf("读取令牌桶失败: %v", err)
+		}
+		return tokens
+	}
+	assertTokens := func(expected float64) {
+		t.Helper()
+		// 测试执行期间按每毫秒limit/60000的速度补充少量令牌
+		if tokens := bucketTokens(); tokens < expected || tokens > expected+5 {
+			t.Errorf("剩余令牌应约为 %v, 实际: %v", expected, tokens)
+		}
+	}
+
+	// 同一API Key的两个请求各自预扣
+	failed := &model.ApiKey{ID: 1, ItpmLimit: 1000}
+	succeeded := &model.ApiKey{ID: 1, ItpmLimit: 1000}
+	if result := CheckApiKeyRateLimit(failed, 400); result == nil || !result.Allowed {
+		t.Fatalf("应放行: %+v", result)
+	}
+	if result := CheckApiKeyRateLimit(succeeded, 300); result == nil || !result.Allowed {
+		t.Fatalf("应放行: %+v", result)
+	}
+	assertTokens(300)
+
+	SettleApiKeyRateLimit(failed, nil)
+	assertTokens(700)
+	SettleApiKeyRateLimit(failed, nil)
+	assertTokens(700)
+
+	// 实际输入150（缓存读取不计入），预扣300，退还150
+	SettleApiKeyRateLimit(succeeded, &common.TokenUsage{InputTokens: 100, CacheCreationInputTokens: 50, CacheReadInputTokens: 1000})
+	assertTokens(850)
+}
@@ -0,0 +1,138 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+type BalanceService struct{}
+
+func NewBalanceService() *BalanceService {
+	return &BalanceService{}
+}
+
+// BalanceInfo 用户余额信息
+type BalanceInfo struct {
+	Prepaid bool `json:"prepaid"` // 是否启用预付费，未启用时不扣费也不限制请求
+	*model.UserBalance
+}
+
+// GetBalance 获取用户余额，未启用预付费的用户返回零值
+func (s *BalanceService) GetBalance(userID uint) (*BalanceInfo, error) {
+	balance, err := model.GetUserBalance(userID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return &BalanceInfo{UserBalance: &model.UserBalance{UserID: userID}}, nil
+		}
+		return nil, errors.New("获取余额失败")
+	}
+	return &BalanceInfo{Prepaid: true, UserBalance: balance}, nil
+}
+
+// GetLedgers 分页获取用户的余额流水
+func (s *BalanceService) GetLedgers(userID uint, ledgerType string, page, limit int) (*model.BalanceLedgerListResult, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	ledgers, total, err := model.GetBalanceLedgers(userID, ledgerType, page, limit)
+	if err != nil {
+		return nil, errors.New("获取余额流水失败")
+	}
+
+	return &model.BalanceLedgerListResult{
+		Ledgers: ledgers,
+		Total:   total,
+		Page:    page,
+		Limit:   limit,
+	}, nil
+}
+
+// TopUp 管理员为用户充值，首次充值时为用户启用预付费
+func (s *BalanceService) TopUp(userID uint, amount float64, operatorID uint, remark string) (*model.UserBalance, error) {
+	if amount <= 0 {
+		return nil, errors.New("充值金额必须大于0")
+	}
+
+	return s.change(&model.BalanceLedger{
+		UserID:     userID,
+		Type:       model.LedgerTypeTopup,
+		Amount:     amount,
+		OperatorID: operatorID,
+		Remark:     remark,
+	})
+}
+
+// Adjust 管理员调整用户余额，正数增加负数扣减，不计入累计充值和消费
+func (s *BalanceService) Adjust(userID uint, amount float64, operatorID uint, remark string) (*model.UserBalance, error) {
+	if amount == 0 {
+		return nil, errors.New("调整金额不能为0")
+	}
+
+	return s.change(&model.BalanceLedger{
+		UserID:     userID,
+		Type:       model.LedgerTypeAdjust,
+		Amount:     amount,
+		OperatorID: operatorID,
+		Remark:     remark,
+	})
+}
+
+// Refund 管理员退款，关联日志时默认退还该日志的全部费用，退款金额不能超过日志费用
+func (s *BalanceService) Refund(userID uint, logID string, amount float64, operatorID uint, remark string) (*model.UserBalance, error) {
+	if amount < 0 {
+		return nil, errors.New("退款金额不能为负数")
+	}
+
+	if logID != "" {
+		log, err := model.GetLogById(logID)
+		if err != nil || log.UserID != userID {
+			return nil, errors.New("日志不存在")
+		}
+		if amount == 0 {
+			amount = log.TotalCost
+		}
+		if amount > log.TotalCost {
+			return nil, errors.New("退款金额不能超过日志费用")
+		}
+	}
+	if amount <= 0 {
+		return nil, errors.New("退款金额必须大于0")
+	}
+
+	return s.change(&model.BalanceLedger{
+		UserID:     userID,
+		Type:       model.LedgerTypeRefund,
+		Amount:     amount,
+		LogID:      logID,
+		OperatorID: operatorID,
+		Remark:     remark,
+	})
+}
+
+// change 校验用户后变动余额并记录流水
+func (s *BalanceService) change(entry *model.BalanceLedger) (*model.UserBalance, error) {
+	if _, err := model.GetUserById(entry.UserID); err != nil {
+		return nil, errors.New("用户不存在")
+	}
+
+	balance, err := model.ChangeUserBalance(entry)
+	if err != nil {
+		if errors.Is(err, model.ErrLogAlreadyRefunded) {
+			return nil, err
+		}
+		common.SysError(fmt.Sprintf("变动用户 %d 余额失败: %v", entry.UserID, err))
+		return nil, errors.New("变动余额失败")
+	}
+
+	common.SysLog(fmt.Sprintf("管理员 %d 为用户 %d %s %.4f USD，变动后余额 %.4f USD",
+		entry.OperatorID, entry.UserID, entry.Type, entry.Amount, balance.Balance))
+	return balance, nil
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"errors"
+	"math"
+	"testing"
+)
+
+// TestRefundLog 关联日志的退款默认退还全部费用，不能超过日志费用且只能退款一次
+func TestRefundLog(t *testing.T) {
+	setupTestDB(t)
+	for _, user := range []*model.User{{Username: "u1", Email: "u1@example.com"}, {Username: "u2", Email: "u2@example.com"}} {
+		if err := model.CreateUser(user); err != nil {
+			t.Fatalf("创建用户失败: %v", err)
+		}
+	}
+
+	s := NewBalanceService()
+	if _, err := s.TopUp(1, 10, 99, ""); err != nil {
+		t.Fatalf("充值失败: %v", err)
+	}
+	usage := &common.TokenUsage{Model: "claude-sonnet-4-20250514", InputTokens: 100000, OutputTokens: 10000}
+	log, err := model.CreateLogFromTokenUsage(usage, 1, 0, 0, 1000, false)
+	if err != nil {
+		t.Fatalf("写入日志失败: %v", err)
+	}
+	consumed, _ := model.GetUserBalance(1)
+
+	if _, err := s.Refund(1, log.ID, log.TotalCost+0.01, 99, ""); err == nil || err.Error() != "退款金额不能超过日志费用" {
+		t.Errorf("超过日志费用的退款应被拒绝: %v", err)
+	}
+	if _, err := s.Refund(2, log.ID, 0, 99, ""); err == nil || err.Error() != "日志不存在" {
+		t.Errorf("退还其他用户的日志应被拒绝: %v", err)
+	}
+
+	balance, err := s.Refund(1, log.ID, 0, 99, "")
+	if err != nil {
+		t.Fatalf("退款失败: %v", err)
+	}
+	if math.Abs(balance.Balance-(consumed.Balance+log.TotalCost)) > 1e-9 || math.Abs(balance.Balance-10) > 1e-9 {
+		t.Errorf("应退还日志全部费用: 扣费后 %v, 退款后 %v, 费用 %v", consumed.Balance, balance.Balance, log.TotalCost)
+	}
+	if _, err := s.Refund(1, log.ID, 0.01, 99, ""); !errors.Is(err, model.ErrLogAlreadyRefunded) {
+		t.Errorf("重复退款应返回 ErrLogAlreadyRefunded, 实际: %v", err)
+	}
+}
@@ -0,0 +1,215 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// 预算范围
+const (
+	BudgetScopeKey   = "key"
+	BudgetScopeGroup = "group"
+)
+
+// budgetSpendCacheTTL 预算消费金额的缓存时间，期间的新消费在本地累加，过期后从日志重新统计
+const budgetSpendCacheTTL = 30 * time.Second
+
+// budgetWindow 滚动预算的统计窗口
+type budgetWindow struct {
+	name     string
+	duration time.Duration
+}
+
+var (
+	budgetWeekly  = budgetWindow{name: "weekly", duration: 7 * 24 * time.Hour}
+	budgetMonthly = budgetWindow{name: "monthly", duration: 30 * 24 * time.Hour}
+)
+
+// BudgetStatus 单个预算的消费情况
+type BudgetStatus struct {
+	Scope        string  // 预算范围：key或group
+	ScopeID      uint    // API Key ID或分组ID
+	Window       string  // 统计窗口：weekly或monthly
+	Limit        float64 // 预算金额(美元)
+	Spent        float64 // 窗口内已消费金额(美元)
+	AlertPercent int     // 提醒阈值(百分比)，0表示不提醒
+	OwnerID      uint    // 接收提醒的用户ID
+	ScopeName    string  // API Key或分组名称
+}
+
+// Exceeded 是否已达到预算（硬阈值），达到后拒绝请求
+func (s *BudgetStatus) Exceeded() bool {
+	return s.Spent >= s.Limit
+}
+
+// ShouldAlert 是否已达到提醒阈值（软阈值）
+func (s *BudgetStatus) ShouldAlert() bool {
+	return s.AlertPercent > 0 && s.Spent >= s.Limit*float64(s.AlertPercent)/100
+}
+
+// budgetSpend 缓存的窗口消费金额
+type budgetSpend struct {
+	spent    float64
+	loadedAt time.Time
+}
+
+var (
+	budgetSpendMu    sync.Mutex
+	budgetSpendCache = make(map[string]*budgetSpend)
+	// budgetAlerted 本副本已处理过的提醒，避免同一窗口内每个请求都检查Redis
+	budgetAlerted sync.Map
+)
+
+// CheckBudgets 统计API Key及其分组的滚动周预算和月预算消费情况，只返回已配置的预算
+// 达到提醒阈值时异步通知预算所有者
+func CheckBudgets(apiKey *model.ApiKey) []BudgetStatus {
+	var statuses []BudgetStatus
+	appendStatus := func(scope string, scopeID uint, name string, ownerID uint, window budgetWindow, limit float64, alertPercent int) {
+		if limit <= 0 {
+			return
+		}
+		spent, err := getBudgetSpend(scope, scopeID, window)
+		if err != nil {
+			common.SysError(fmt.Sprintf("统计%s %d 的%s消费失败: %v", scope, scopeID, window.name, err))
+			return
+		}
+		statuses = append(statuses, BudgetStatus{
+			Scope:        scope,
+			ScopeID:      scopeID,
+			Window:       window.name,
+			Limit:        limit,
+			Spent:        spent,
+			AlertPercent: alertPercent,
+			OwnerID:      ownerID,
+			ScopeName:    name,
+		})
+	}
+
+	appendStatus(BudgetScopeKey, apiKey.ID, apiKey.Name, apiKey.UserID, budgetWeekly, apiKey.WeeklyBudget, apiKey.BudgetAlertPercent)
+	appendStatus(BudgetScopeKey, apiKey.ID, apiKey.Name, apiKey.UserID, budgetMonthly, apiKey.MonthlyBudget, apiKey.BudgetAlertPercent)
+
+	if apiKey.GroupID > 0 {
+		var group model.Group
+		if err := model.DB.Select("id", "name", "user_id", "weekly_budget", "monthly_budget", "budget_alert_percent").
+			First(&group, apiKey.GroupID).Error; err == nil {
+			appendStatus(BudgetScopeGroup, group.ID, group.Name, group.UserID, budgetWeekly, group.WeeklyBudget, group.BudgetAlertPercent)
+			appendStatus(BudgetScopeGroup, group.ID, group.Name, group.UserID, budgetMonthly, group.MonthlyBudget, group.BudgetAlertPercent)
+		}
+	}
+
+	for i := range statuses {
+		if statuses[i].ShouldAlert() {
+			go notifyBudgetAlert(statuses[i])
+		}
+	}
+	return statuses
+}
+
+// RecordBudgetSpend 将请求费用累加到已缓存的预算消费金额，使缓存期内的统计保持准确
+func RecordBudgetSpend(apiKey *model.ApiKey, cost float64) {
+	if cost <= 0 {
+		return
+	}
+
+	budgetSpendMu.Lock()
+	defer budgetSpendMu.Unlock()
+
+	for _, window := range []budgetWindow{budgetWeekly, budgetMonthly} {
+		if spend, ok := budgetSpendCache[budgetSpendKey(BudgetScopeKey, apiKey.ID, window)]; ok {
+			spend.spent += cost
+		}
+		if apiKey.GroupID > 0 {
+			if spend, ok := budgetSpendCache[budgetSpendKey(BudgetScopeGroup, uint(apiKey.GroupID), window)]; ok {
+				spend.spent += cost
+			}
+		}
+	}
+}
+
+// getBudgetSpend 获取窗口内的消费金额，缓存过期时从日志重新统计
+func getBudgetSpend(scope string, scopeID uint, window budgetWindow) (float64, error) {
+	key := budgetSpendKey(scope, scopeID, window)
+
+	budgetSpendMu.Lock()
+	spend, ok := budgetSpendCache[key]
+	if ok && time.Since(spend.loadedAt) < budgetSpendCacheTTL {
+		spent := spend.spent
+		budgetSpendMu.Unlock()
+		return spent, nil
+	}
+	budgetSpendMu.Unlock()
+
+	since := time.Now().Add(-window.duration)
+	var spent float64
+	var err error
+	if scope == BudgetScopeGroup {
+		spent, err = model.GetGroupCostSince(scopeID, since)
+	} else {
+		spent, err = model.GetApiKeyCostSince(scopeID, since)
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	budgetSpendMu.Lock()
+	budgetSpendCache[key] = &budgetSpend{spent: spent, loadedAt: time.Now()}
+	budgetSpendMu.Unlock()
+	return spent, nil
+}
+
+// budgetSpendKey 获取预算消费缓存的键
+func budgetSpendKey(scope string, scopeID uint, window budgetWindow) string {
+	return fmt.Sprintf("%s:%d:%s", scope, scopeID, window.name)
+}
+
+// notifyBudgetAlert 发送预算提醒邮件，同一预算在一个统计窗口内只提醒一次（配置Redis时多个副本共享）
+func notifyBudgetAlert(status BudgetStatus) {
+	alertKey := fmt.Sprintf("budget_alert:%s", budgetSpendKey(status.Scope, status.ScopeID, budgetWindow{name: status.Window}))
+	ttl := budgetWeekly.duration
+	if status.Window == budgetMonthly.name {
+		ttl = budgetMonthly.duration
+	}
+
+	if alertedAt, ok := budgetAlerted.Load(alertKey); ok && time.Since(alertedAt.(time.Time)) < ttl {
+		return
+	}
+	budgetAlerted.Store(alertKey, time.Now())
+
+	if common.RDB != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second*2)
+		first, err := common.RDB.SetNX(ctx, alertKey, time.Now().Unix(), ttl).Result()
+		cancel()
+		if err != nil {
+			common.SysError("记录预算提醒状态失败: " + err.Error())
+			return
+		}
+		if !first {
+			return
+		}
+	}
+
+	scopeName := "API Key"
+	if status.Scope == BudgetScopeGroup {
+		scopeName = "分组"
+	}
+	windowName := "最近7天"
+	if status.Window == budgetMonthly.name {
+		windowName = "最近30天"
+	}
+	message := fmt.Sprintf("%s「%s」%s已消费 $%.4f，达到预算 $%.2f 的 %.1f%%（提醒阈值 %d%%）。\n达到预算后该%s的请求将被拒绝。",
+		scopeName, status.ScopeName, windowName, status.Spent, status.Limit, status.Spent/status.Limit*100, status.AlertPercent, scopeName)
+	log.Printf("💰 %s", message)
+
+	user, err := model.GetUserById(status.OwnerID)
+	if err != nil || user.Email == "" {
+		return
+	}
+	if err := common.SendSystemNotificationEmail(user.Email, "预算使用提醒", message); err != nil {
+		common.SysError("发送预算提醒邮件失败: " + err.Error())
+	}
+}
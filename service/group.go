@@ -27,6 +27,10 @@ func CreateGroup(req *model.CreateGroupRequest, userID uint) (*model.Group, erro
 		Remark: req.Remark,
 		Status: req.Status,
 		UserID: userID,
+
+		WeeklyBudget:       req.WeeklyBudget,
+		MonthlyBudget:      req.MonthlyBudget,
+		BudgetAlertPercent: req.BudgetAlertPercent,
 	}
 
 	// 如果没有指定状态，默认为启用
@@ -84,6 +88,15 @@ func UpdateGroup(id string, req *model.UpdateGroupRequest, userID uint) (*model.
 	if req.Status != nil {
 		group.Status = *req.Status
 	}
+	if req.WeeklyBudget != nil {
+		group.WeeklyBudget = *req.WeeklyBudget
+	}
+	if req.MonthlyBudget != nil {
+		group.MonthlyBudget = *req.MonthlyBudget
+	}
+	if req.BudgetAlertPercent != nil {
+		group.BudgetAlertPercent = *req.BudgetAlertPercent
+	}
 
 	err = model.UpdateGroup(group)
 	if err != nil {
@@ -46,6 +46,23 @@ func (s *LogService) CreateLogFromTokenUsage(usage *common.TokenUsage, userID, a
 	return log, nil
 }
 
+// CreateBatchLogFromTokenUsage 根据批处理单条结果创建日志记录（按批处理价格计费）
+func (s *LogService) CreateBatchLogFromTokenUsage(usage *common.TokenUsage, userID, apiKeyID, accountID uint, itemKey string) (*model.Log, error) {
+	if usage == nil {
+		return nil, errors.New("TokenUsage不能为空")
+	}
+	if userID == 0 {
+		return nil, errors.New("用户ID不能为空")
+	}
+
+	log, err := model.CreateBatchLogFromTokenUsage(usage, userID, apiKeyID, accountID, itemKey)
+	if err != nil {
+		return nil, errors.New("创建日志失败: " + err.Error())
+	}
+
+	return log, nil
+}
+
 // GetLogById 根据ID获取日志
 func (s *LogService) GetLogById(id string) (*model.Log, error) {
 	if id == "" {
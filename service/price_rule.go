@@ -0,0 +1,151 @@
+package service
+
+import (
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+type PriceRuleService struct{}
+
+func NewPriceRuleService() *PriceRuleService {
+	return &PriceRuleService{}
+}
+
+// GetRules 分页获取价格规则
+func (s *PriceRuleService) GetRules(scope string, scopeID uint, page, limit int) (*model.PriceRuleListResult, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	rules, total, err := model.GetPriceRules(scope, scopeID, page, limit)
+	if err != nil {
+		return nil, errors.New("获取价格规则失败")
+	}
+
+	return &model.PriceRuleListResult{
+		Rules: rules,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}, nil
+}
+
+// CreateRule 创建价格规则，同一用户或分组对同一模型只能有一条规则
+func (s *PriceRuleService) CreateRule(req *model.CreatePriceRuleRequest) (*model.PriceRule, error) {
+	if err := s.checkScope(req.Scope, req.ScopeID); err != nil {
+		return nil, err
+	}
+
+	rule := &model.PriceRule{
+		Scope:           req.Scope,
+		ScopeID:         req.ScopeID,
+		ModelName:       strings.TrimSpace(req.ModelName),
+		Multiplier:      1,
+		InputPrice:      req.InputPrice,
+		OutputPrice:     req.OutputPrice,
+		CacheWritePrice: req.CacheWritePrice,
+		CacheReadPrice:  req.CacheReadPrice,
+		Remark:          req.Remark,
+	}
+	if req.Multiplier != nil {
+		rule.Multiplier = *req.Multiplier
+	}
+
+	if err := s.checkDuplicate(rule); err != nil {
+		return nil, err
+	}
+	if err := model.CreatePriceRule(rule); err != nil {
+		common.SysError("创建价格规则失败: " + err.Error())
+		return nil, errors.New("创建价格规则失败")
+	}
+
+	common.SysLog(fmt.Sprintf("创建价格规则 %d：%s %d，模型 %q，倍率 %.4f", rule.ID, rule.Scope, rule.ScopeID, rule.ModelName, rule.Multiplier))
+	return rule, nil
+}
+
+// UpdateRule 更新价格规则，规则范围不可修改
+func (s *PriceRuleService) UpdateRule(id uint, req *model.UpdatePriceRuleRequest) (*model.PriceRule, error) {
+	rule, err := model.GetPriceRuleByID(id)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("价格规则不存在")
+		}
+		return nil, errors.New("获取价格规则失败")
+	}
+
+	rule.ModelName = strings.TrimSpace(req.ModelName)
+	rule.Multiplier = 1
+	if req.Multiplier != nil {
+		rule.Multiplier = *req.Multiplier
+	}
+	rule.InputPrice = req.InputPrice
+	rule.OutputPrice = req.OutputPrice
+	rule.CacheWritePrice = req.CacheWritePrice
+	rule.CacheReadPrice = req.CacheReadPrice
+	rule.Remark = req.Remark
+
+	if err := s.checkDuplicate(rule); err != nil {
+		return nil, err
+	}
+	if err := model.UpdatePriceRule(rule); err != nil {
+		common.SysError("更新价格规则失败: " + err.Error())
+		return nil, errors.New("更新价格规则失败")
+	}
+
+	return rule, nil
+}
+
+// DeleteRule 删除价格规则，删除后按下一优先级的规则或官方价格计费
+func (s *PriceRuleService) DeleteRule(id uint) error {
+	if _, err := model.GetPriceRuleByID(id); err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return errors.New("价格规则不存在")
+		}
+		return errors.New("获取价格规则失败")
+	}
+
+	if err := model.DeletePriceRule(id); err != nil {
+		return errors.New("删除价格规则失败")
+	}
+	return nil
+}
+
+// checkScope 校验规则对应的用户或分组存在
+func (s *PriceRuleService) checkScope(scope string, scopeID uint) error {
+	switch scope {
+	case model.PriceRuleScopeUser:
+		if _, err := model.GetUserById(scopeID); err != nil {
+			return errors.New("用户不存在")
+		}
+	case model.PriceRuleScopeGroup:
+		if err := model.DB.Select("id").First(&model.Group{}, scopeID).Error; err != nil {
+			return errors.New("分组不存在")
+		}
+	default:
+		return errors.New("规则范围无效")
+	}
+	return nil
+}
+
+// checkDuplicate 校验同一用户或分组对同一模型没有其他规则
+func (s *PriceRuleService) checkDuplicate(rule *model.PriceRule) error {
+	var count int64
+	err := model.DB.Model(&model.PriceRule{}).
+		Where("scope = ? AND scope_id = ? AND model_name = ? AND id <> ?", rule.Scope, rule.ScopeID, rule.ModelName, rule.ID).
+		Count(&count).Error
+	if err != nil {
+		return errors.New("获取价格规则失败")
+	}
+	if count > 0 {
+		return errors.New("该范围和模型的价格规则已存在")
+	}
+	return nil
+}
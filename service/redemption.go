@@ -0,0 +1,180 @@
+package service
+
+import (
+	"bytes"
+	"claude-code-relay/common"
+	"claude-code-relay/model"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type RedemptionService struct{}
+
+func NewRedemptionService() *RedemptionService {
+	return &RedemptionService{}
+}
+
+// RedeemResult 兑换结果
+type RedeemResult struct {
+	Amount    float64 `json:"amount"`    // 兑换金额(USD)
+	Balance   float64 `json:"balance"`   // 兑换后余额(USD)
+	Duplicate bool    `json:"duplicate"` // 是否为重复兑换（未再次充值）
+}
+
+// CreateCodes 批量生成兑换码，返回批次号和生成的兑换码
+func (s *RedemptionService) CreateCodes(req *model.CreateRedemptionCodesRequest, operatorID uint) (string, []model.RedemptionCode, error) {
+	if req.ExpiresAt != nil && time.Time(*req.ExpiresAt).Before(time.Now()) {
+		return "", nil, errors.New("过期时间不能早于当前时间")
+	}
+
+	maxUses := req.MaxUses
+	if maxUses <= 0 {
+		maxUses = 1
+	}
+
+	batchNo := time.Now().Format("20060102150405") + strings.ToUpper(common.GenerateRandomString(2))
+	codes := make([]model.RedemptionCode, req.Count)
+	for i := range codes {
+		code := strings.ToUpper(common.GenerateRandomString(10))
+		if code == "" {
+			return "", nil, errors.New("生成兑换码失败")
+		}
+		codes[i] = model.RedemptionCode{
+			Code:      code,
+			BatchNo:   batchNo,
+			Name:      req.Name,
+			Value:     req.Value,
+			MaxUses:   maxUses,
+			ExpiresAt: req.ExpiresAt,
+			Status:    model.RedemptionStatusEnabled,
+			CreatedBy: operatorID,
+		}
+	}
+
+	if err := model.CreateRedemptionCodes(codes); err != nil {
+		common.SysError("创建兑换码失败: " + err.Error())
+		return "", nil, errors.New("生成兑换码失败")
+	}
+
+	common.SysLog(fmt.Sprintf("管理员 %d 生成兑换码批次 %s：%d 个，面值 %.2f USD", operatorID, batchNo, req.Count, req.Value))
+	return batchNo, codes, nil
+}
+
+// GetCodes 分页获取兑换码列表
+func (s *RedemptionService) GetCodes(batchNo string, status *int, page, limit int) (*model.RedemptionCodeListResult, error) {
+	if page < 1 {
+		page = 1
+	}
+	if limit < 1 || limit > 100 {
+		limit = 10
+	}
+
+	codes, total, err := model.GetRedemptionCodes(batchNo, status, page, limit)
+	if err != nil {
+		return nil, errors.New("获取兑换码列表失败")
+	}
+
+	return &model.RedemptionCodeListResult{
+		Codes: codes,
+		Total: total,
+		Page:  page,
+		Limit: limit,
+	}, nil
+}
+
+// UpdateStatus 启用或停用兑换码
+func (s *RedemptionService) UpdateStatus(id uint, status int) error {
+	if status != model.RedemptionStatusEnabled && status != model.RedemptionStatusDisabled {
+		return errors.New("状态参数无效")
+	}
+
+	if err := model.UpdateRedemptionCodeStatus(id, status); err != nil {
+		if errors.Is(err, model.ErrRedemptionNotFound) {
+			return err
+		}
+		return errors.New("更新兑换码状态失败")
+	}
+	return nil
+}
+
+// ExportCSV 导出批次下的兑换码为CSV
+func (s *RedemptionService) ExportCSV(batchNo string) ([]byte, error) {
+	if batchNo == "" {
+		return nil, errors.New("批次号不能为空")
+	}
+
+	codes, err := model.GetRedemptionCodesByBatch(batchNo)
+	if err != nil {
+		return nil, errors.New("获取兑换码失败")
+	}
+	if len(codes) == 0 {
+		return nil, errors.New("批次不存在")
+	}
+
+	var buf bytes.Buffer
+	// 写入BOM，Excel打开时正确识别UTF-8
+	buf.WriteString("\xEF\xBB\xBF")
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write([]string{"兑换码", "批次号", "批次名称", "面值(USD)", "使用次数上限", "已使用次数", "过期时间", "状态", "创建时间"})
+	for _, code := range codes {
+		expiresAt := ""
+		if code.ExpiresAt != nil {
+			expiresAt = code.ExpiresAt.String()
+		}
+		status := "启用"
+		if code.Status != model.RedemptionStatusEnabled {
+			status = "停用"
+		}
+		_ = writer.Write([]string{
+			code.Code,
+			code.BatchNo,
+			code.Name,
+			strconv.FormatFloat(code.Value, 'f', -1, 64),
+			strconv.Itoa(code.MaxUses),
+			strconv.Itoa(code.UsedCount),
+			expiresAt,
+			status,
+			code.CreatedAt.String(),
+		})
+	}
+	writer.Flush()
+	if err := writer.Error(); err != nil {
+		return nil, errors.New("导出兑换码失败")
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Redeem 用户兑换兑换码，同一用户重复兑换同一兑换码时返回首次兑换的结果
+func (s *RedemptionService) Redeem(code string, userID uint) (*RedeemResult, error) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+	if code == "" {
+		return nil, errors.New("兑换码不能为空")
+	}
+
+	record, balance, duplicate, err := model.RedeemCode(code, userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, model.ErrRedemptionNotFound),
+			errors.Is(err, model.ErrRedemptionDisabled),
+			errors.Is(err, model.ErrRedemptionExpired),
+			errors.Is(err, model.ErrRedemptionExhausted):
+			return nil, err
+		}
+		common.SysError(fmt.Sprintf("用户 %d 兑换兑换码失败: %v", userID, err))
+		return nil, errors.New("兑换失败，请稍后重试")
+	}
+
+	if !duplicate {
+		common.SysLog(fmt.Sprintf("用户 %d 兑换兑换码 %s，充值 %.4f USD", userID, code, record.Amount))
+	}
+	return &RedeemResult{
+		Amount:    record.Amount,
+		Balance:   balance.Balance,
+		Duplicate: duplicate,
+	}, nil
+}
@@ -0,0 +1,48 @@
+package service
+
+import (
+	"claude-code-relay/model"
+	"strings"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// setupTestDB 使用内存SQLite替换全局数据库连接，测试结束后恢复
+func setupTestDB(t *testing.T) {
+	t.Helper()
+
+	db, err := gorm.Open(sqlite.Open("file::memory:"), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		t.Fatalf("打开测试数据库失败: %v", err)
+	}
+	// 内存数据库每个连接都是独立的库，限制为单连接
+	sqlDB, err := db.DB()
+	if err != nil {
+		t.Fatalf("获取数据库连接失败: %v", err)
+	}
+	sqlDB.SetMaxOpenConns(1)
+
+	for _, table := range []interface{}{&model.User{}, &model.Account{}, &model.Group{}, &model.ApiKey{}, &model.Log{}, &model.MessageBatch{}, &model.UserBalance{}, &model.BalanceLedger{}, &model.RedemptionCode{}, &model.RedemptionRecord{}, &model.PriceRule{}} {
+		// SQLite不支持MySQL的ON UPDATE默认值
+		stmt := &gorm.Statement{DB: db}
+		if err := stmt.Parse(table); err != nil {
+			t.Fatalf("解析表结构失败: %v", err)
+		}
+		for _, field := range stmt.Schema.Fields {
+			field.DefaultValue = strings.TrimSuffix(field.DefaultValue, " ON UPDATE CURRENT_TIMESTAMP")
+		}
+		if err := db.AutoMigrate(table); err != nil {
+			t.Fatalf("创建表 %T 失败: %v", table, err)
+		}
+	}
+
+	original := model.DB
+	model.DB = db
+	t.Cleanup(func() {
+		model.DB = original
+		sqlDB.Close()
+	})
+}
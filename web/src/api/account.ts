@@ -19,9 +19,13 @@ const Api = {
 export interface Account {
   id: number;
   name: string;
-  platform_type: string; // claude/claude_console/openai/gemini
+  platform_type: string; // claude/claude_console/openai/gemini/bedrock/vertex/azure_openai
   request_url: string;
   secret_key: string; // 现在会返回密钥
+  region: string; // 云厂商区域(bedrock/vertex)
+  access_key_id: string; // 云厂商访问密钥ID(bedrock)
+  api_version: string; // API版本(azure_openai)
+  use_responses_api: boolean; // 是否使用Responses API(openai/azure_openai)
   access_token: string; // 现在会返回访问令牌
   refresh_token: string; // 现在会返回刷新令牌
   expires_at: number;
@@ -29,6 +33,8 @@ export interface Account {
   group_id: number;
   priority: number;
   weight: number;
+  cost_multiplier: number; // 成本系数(实际单价相对官方标价的倍数)
+  max_concurrency: number; // 最大并发请求数，0表示不限制
   today_usage_count: number;
   today_input_tokens: number;
   today_output_tokens: number;
@@ -65,9 +71,15 @@ export interface AccountCreateParams {
   platform_type: string;
   request_url?: string;
   secret_key?: string;
+  region?: string;
+  access_key_id?: string;
+  api_version?: string;
+  use_responses_api?: boolean;
   group_id?: number;
   priority?: number;
   weight?: number;
+  cost_multiplier?: number;
+  max_concurrency?: number;
   enable_proxy?: boolean;
   proxy_uri?: string;
   model_mapping?: string;
@@ -86,9 +98,15 @@ export interface AccountUpdateParams {
   platform_type: string;
   request_url?: string;
   secret_key?: string;
+  region?: string;
+  access_key_id?: string;
+  api_version?: string;
+  use_responses_api?: boolean;
   group_id?: number;
   priority?: number;
   weight?: number;
+  cost_multiplier?: number;
+  max_concurrency?: number;
   enable_proxy?: boolean;
   proxy_uri?: string;
   model_mapping?: string;
@@ -26,6 +26,13 @@ export interface ApiKey {
   today_total_cost: number;
   model_restriction: string;
   daily_limit: number;
+  hedge_delay: number;
+  rpm_limit: number;
+  itpm_limit: number;
+  otpm_limit: number;
+  weekly_budget: number;
+  monthly_budget: number;
+  budget_alert_percent: number;
   last_used_time?: string;
   created_at: string;
   updated_at: string;
@@ -55,6 +62,13 @@ export interface CreateApiKeyRequest {
   group_id?: number;
   model_restriction?: string;
   daily_limit?: number;
+  hedge_delay?: number;
+  rpm_limit?: number;
+  itpm_limit?: number;
+  otpm_limit?: number;
+  weekly_budget?: number;
+  monthly_budget?: number;
+  budget_alert_percent?: number;
 }
 
 // 更新API Key
@@ -65,6 +79,13 @@ export interface UpdateApiKeyRequest {
   group_id?: number;
   model_restriction?: string;
   daily_limit?: number;
+  hedge_delay?: number;
+  rpm_limit?: number;
+  itpm_limit?: number;
+  otpm_limit?: number;
+  weekly_budget?: number;
+  monthly_budget?: number;
+  budget_alert_percent?: number;
 }
 
 // 更新API Key状态
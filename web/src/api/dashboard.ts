@@ -54,6 +54,22 @@ export interface TrendDataItem {
   output_tokens: number; // 输出tokens
 }
 
+// 成本节省统计项
+export interface CostSavingsItem {
+  list_cost: number; // 官方标价费用(USD)
+  actual_cost: number; // 账号实际成本(USD)
+  savings: number; // 节省金额(USD)
+  savings_rate: number; // 节省比例(%)
+}
+
+// 计费差额统计项
+export interface PricingMarginItem {
+  raw_cost: number; // 官方标价费用(USD)
+  billed_cost: number; // 计费费用(USD)
+  margin: number; // 差额(USD)
+  margin_rate: number; // 差额比例(%)
+}
+
 // 仪表盘统计数据
 export interface DashboardStats {
   // 顶部面板数据
@@ -77,6 +93,12 @@ export interface DashboardStats {
   // 今日vs昨日数据对比
   today_stats: DayStatsItem; // 今日统计
   yesterday_stats: DayStatsItem; // 昨日统计
+
+  // 成本节省统计
+  cost_savings: CostSavingsItem; // 按账号成本系数折算后相对官方标价的节省
+
+  // 计费差额统计
+  pricing_margin: PricingMarginItem; // 按价格规则计费相对官方标价的差额
 }
 
 /**
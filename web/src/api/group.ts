@@ -21,6 +21,9 @@ export interface Group {
   updated_at: string;
   api_key_count: number; // API密钥数量
   account_count: number; // 账号数量
+  weekly_budget: number; // 周预算(美元)，0表示不限制
+  monthly_budget: number; // 月预算(美元)，0表示不限制
+  budget_alert_percent: number; // 预算提醒阈值(百分比)
 }
 
 // 创建分组
@@ -28,6 +31,9 @@ export interface GroupCreateParams {
   name: string;
   remark?: string;
   status?: number;
+  weekly_budget?: number;
+  monthly_budget?: number;
+  budget_alert_percent?: number;
 }
 
 export interface GroupUpdateParams extends GroupCreateParams {
@@ -23,7 +23,9 @@ export interface Log {
   output_cost: number;
   cache_write_cost: number;
   cache_read_cost: number;
-  total_cost: number;
+  total_cost: number; // 计费费用(USD)，按价格规则调整
+  raw_cost?: number | null; // 官方标价费用(USD)，为空表示与total_cost相同
+  account_cost?: number | null;
   is_stream: boolean;
   duration: number;
   created_at: string;
@@ -0,0 +1,102 @@
+import { request } from '@/utils/request';
+
+const Api = {
+  GetList: '/api/v1/admin/price-rules/list',
+  Create: '/api/v1/admin/price-rules/create',
+  Update: '/api/v1/admin/price-rules/update/:id',
+  Delete: '/api/v1/admin/price-rules/delete/:id',
+};
+
+// 规则范围
+export const PriceRuleScopeLabels: Record<string, string> = {
+  user: '用户',
+  group: '分组',
+};
+
+// 价格规则，覆盖单价为空时使用官方价格，单位USD/1M tokens
+export interface PriceRule {
+  id: number;
+  scope: string; // user: 用户, group: 分组
+  scope_id: number;
+  model_name: string; // 为空表示所有模型
+  multiplier: number;
+  input_price: number | null;
+  output_price: number | null;
+  cache_write_price: number | null;
+  cache_read_price: number | null;
+  remark: string;
+  created_at: string;
+  updated_at: string;
+}
+
+// 价格规则列表响应
+export interface PriceRuleListResult {
+  rules: PriceRule[];
+  total: number;
+  page: number;
+  limit: number;
+}
+
+// 价格规则列表查询参数
+export interface PriceRuleListParams {
+  page?: number;
+  limit?: number;
+  scope?: string;
+  scope_id?: number;
+}
+
+// 更新价格规则请求参数
+export interface UpdatePriceRuleRequest {
+  model_name?: string;
+  multiplier?: number;
+  input_price?: number | null;
+  output_price?: number | null;
+  cache_write_price?: number | null;
+  cache_read_price?: number | null;
+  remark?: string;
+}
+
+// 创建价格规则请求参数
+export interface CreatePriceRuleRequest extends UpdatePriceRuleRequest {
+  scope: string;
+  scope_id: number;
+}
+
+/**
+ * 获取价格规则列表
+ */
+export function getPriceRuleList(params: PriceRuleListParams = {}) {
+  return request.get<PriceRuleListResult>({
+    url: Api.GetList,
+    params,
+  });
+}
+
+/**
+ * 创建价格规则
+ */
+export function createPriceRule(data: CreatePriceRuleRequest) {
+  return request.post<PriceRule>({
+    url: Api.Create,
+    data,
+  });
+}
+
+/**
+ * 更新价格规则
+ */
+export function updatePriceRule(id: number, data: UpdatePriceRuleRequest) {
+  return request.put<PriceRule>({
+    url: Api.Update.replace(':id', String(id)),
+    data,
+  });
+}
+
+/**
+ * 删除价格规则
+ */
+export function deletePriceRule(id: number) {
+  return request.delete({
+    url: Api.Delete.replace(':id', String(id)),
+  });
+}
@@ -0,0 +1,98 @@
+import { request } from '@/utils/request';
+
+const Api = {
+  GetList: '/api/v1/admin/redemption-codes/list',
+  Create: '/api/v1/admin/redemption-codes/create',
+  UpdateStatus: '/api/v1/admin/redemption-codes/update-status/:id',
+  Export: '/api/v1/admin/redemption-codes/export',
+};
+
+// 兑换码
+export interface RedemptionCode {
+  id: number;
+  code: string;
+  batch_no: string;
+  name: string;
+  value: number;
+  max_uses: number;
+  used_count: number;
+  expires_at: string | null;
+  status: number; // 1: 启用, 0: 停用
+  created_by: number;
+  created_at: string;
+}
+
+// 兑换码列表响应
+export interface RedemptionCodeListResult {
+  codes: RedemptionCode[];
+  total: number;
+  page: number;
+  limit: number;
+}
+
+// 兑换码列表查询参数
+export interface RedemptionCodeListParams {
+  page?: number;
+  limit?: number;
+  batch_no?: string;
+  status?: number;
+}
+
+// 批量生成兑换码请求参数
+export interface CreateRedemptionCodesRequest {
+  name?: string;
+  value: number;
+  count: number;
+  max_uses?: number;
+  expires_at?: string;
+}
+
+// 批量生成兑换码响应
+export interface CreateRedemptionCodesResult {
+  batch_no: string;
+  codes: RedemptionCode[];
+}
+
+/**
+ * 获取兑换码列表
+ */
+export function getRedemptionCodeList(params: RedemptionCodeListParams = {}) {
+  return request.get<RedemptionCodeListResult>({
+    url: Api.GetList,
+    params,
+  });
+}
+
+/**
+ * 批量生成兑换码
+ */
+export function createRedemptionCodes(data: CreateRedemptionCodesRequest) {
+  return request.post<CreateRedemptionCodesResult>({
+    url: Api.Create,
+    data,
+  });
+}
+
+/**
+ * 启用或停用兑换码
+ */
+export function updateRedemptionCodeStatus(id: number, status: number) {
+  return request.put({
+    url: Api.UpdateStatus.replace(':id', String(id)),
+    data: { status },
+  });
+}
+
+/**
+ * 导出批次兑换码CSV
+ */
+export function exportRedemptionCodes(batchNo: string) {
+  return request.get<Blob>(
+    {
+      url: Api.Export,
+      params: { batch_no: batchNo },
+      responseType: 'blob',
+    },
+    { isTransformResponse: false },
+  );
+}
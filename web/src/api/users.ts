@@ -4,6 +4,11 @@ const Api = {
   GetList: '/api/v1/admin/users',
   Create: '/api/v1/admin/users',
   UpdateStatus: '/api/v1/admin/users/:id/status',
+  GetBalance: '/api/v1/admin/users/:id/balance',
+  GetBalanceLedger: '/api/v1/admin/users/:id/balance/ledger',
+  TopUpBalance: '/api/v1/admin/users/:id/balance/topup',
+  AdjustBalance: '/api/v1/admin/users/:id/balance/adjust',
+  RefundBalance: '/api/v1/admin/users/:id/balance/refund',
 };
 
 // 用户状态枚举
@@ -62,6 +67,64 @@ export interface UpdateUserStatusRequest {
   status: number;
 }
 
+// 余额流水类型标签
+export const LedgerTypeLabels: Record<string, string> = {
+  topup: '充值',
+  adjust: '调整',
+  refund: '退款',
+  consume: '消费',
+};
+
+// 用户余额
+export interface UserBalance {
+  prepaid: boolean; // 是否启用预付费，未启用时不扣费也不限制请求
+  user_id: number;
+  balance: number;
+  total_recharged: number;
+  total_consumed: number;
+}
+
+// 余额流水
+export interface BalanceLedger {
+  id: number;
+  user_id: number;
+  type: string;
+  amount: number;
+  balance_after: number;
+  log_id: string;
+  operator_id: number;
+  remark: string;
+  created_at: string;
+}
+
+// 余额流水列表响应
+export interface BalanceLedgerListResult {
+  ledgers: BalanceLedger[];
+  total: number;
+  page: number;
+  limit: number;
+}
+
+// 余额流水查询参数
+export interface BalanceLedgerParams {
+  page?: number;
+  limit?: number;
+  type?: string;
+}
+
+// 充值/调整余额请求参数
+export interface BalanceChangeRequest {
+  amount: number;
+  remark?: string;
+}
+
+// 退款请求参数
+export interface BalanceRefundRequest {
+  log_id?: string;
+  amount?: number;
+  remark?: string;
+}
+
 /**
  * 获取用户列表
  */
@@ -91,3 +154,52 @@ export function updateUserStatus(id: number, data: UpdateUserStatusRequest) {
     data,
   });
 }
+
+/**
+ * 获取用户余额
+ */
+export function getUserBalance(id: number) {
+  return request.get<UserBalance>({
+    url: Api.GetBalance.replace(':id', String(id)),
+  });
+}
+
+/**
+ * 获取用户余额流水
+ */
+export function getUserBalanceLedger(id: number, params: BalanceLedgerParams = {}) {
+  return request.get<BalanceLedgerListResult>({
+    url: Api.GetBalanceLedger.replace(':id', String(id)),
+    params,
+  });
+}
+
+/**
+ * 为用户充值
+ */
+export function topUpBalance(id: number, data: BalanceChangeRequest) {
+  return request.post({
+    url: Api.TopUpBalance.replace(':id', String(id)),
+    data,
+  });
+}
+
+/**
+ * 调整用户余额
+ */
+export function adjustBalance(id: number, data: BalanceChangeRequest) {
+  return request.post({
+    url: Api.AdjustBalance.replace(':id', String(id)),
+    data,
+  });
+}
+
+/**
+ * 为用户退款
+ */
+export function refundBalance(id: number, data: BalanceRefundRequest) {
+  return request.post({
+    url: Api.RefundBalance.replace(':id', String(id)),
+    data,
+  });
+}
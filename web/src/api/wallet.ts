@@ -0,0 +1,44 @@
+import type { BalanceLedgerListResult, BalanceLedgerParams, UserBalance } from '@/api/users';
+import { request } from '@/utils/request';
+
+const Api = {
+  GetBalance: '/api/v1/user/balance',
+  GetBalanceLedger: '/api/v1/user/balance/ledger',
+  Redeem: '/api/v1/user/redeem',
+};
+
+// 兑换结果
+export interface RedeemResult {
+  amount: number; // 兑换金额(USD)
+  balance: number; // 兑换后余额(USD)
+  duplicate: boolean; // 是否为重复兑换（未再次充值）
+}
+
+/**
+ * 获取当前用户余额
+ */
+export function getMyBalance() {
+  return request.get<UserBalance>({
+    url: Api.GetBalance,
+  });
+}
+
+/**
+ * 获取当前用户余额流水
+ */
+export function getMyBalanceLedger(params: BalanceLedgerParams = {}) {
+  return request.get<BalanceLedgerListResult>({
+    url: Api.GetBalanceLedger,
+    params,
+  });
+}
+
+/**
+ * 兑换兑换码
+ */
+export function redeemCode(code: string) {
+  return request.post<RedeemResult>({
+    url: Api.Redeem,
+    data: { code },
+  });
+}